@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/redpwn/rvpn/daemon"
+)
+
+// account.go holds functions which manage the control plane accounts saved in the rVPN daemon state
+
+// AccountAdd adds or replaces an account with its own control plane and login token, the first account which is
+// added becomes the active account
+func AccountAdd(name string, controlPlaneURL string, token string) {
+	client := dialDaemon()
+	defer client.Close()
+
+	var addAccountSuccess bool
+	err := client.Call("RVPNDaemon.AddAccount", daemon.AddAccountRequest{
+		Name:            name,
+		ControlPlaneURL: controlPlaneURL,
+		Token:           token,
+	}, &addAccountSuccess)
+	if err != nil {
+		failRPC("failed to add rVPN account", err)
+	}
+
+	succeed(fmt.Sprintf("successfully added rVPN account %s!", name), nil)
+}
+
+// AccountUse sets the account which is used when a command does not name an account
+func AccountUse(name string) {
+	client := dialDaemon()
+	defer client.Close()
+
+	var useAccountSuccess bool
+	err := client.Call("RVPNDaemon.UseAccount", name, &useAccountSuccess)
+	if err != nil {
+		failRPC("failed to use rVPN account", err)
+	}
+
+	succeed(fmt.Sprintf("now using rVPN account %s", name), nil)
+}
+
+// accountResult is an account in the JSON output of "account ls"
+type accountResult struct {
+	Name              string   `json:"name"`
+	ControlPlaneURL   string   `json:"controlPlaneUrl"`
+	Active            bool     `json:"active"`
+	LoggedIn          bool     `json:"loggedIn"`
+	User              string   `json:"user,omitempty"`
+	RegisteredTargets []string `json:"registeredTargets"`
+}
+
+// AccountList lists the saved accounts, the active account is marked with "*"
+func AccountList() {
+	client := dialDaemon()
+	defer client.Close()
+
+	// NOTE: the daemon does not return the tokens of the accounts
+	rVPNState, err := GetRVpnState(client)
+	if err != nil {
+		failRPC("failed to get rVPN state", err)
+	}
+
+	config := getDaemonConfig(client)
+
+	accountNames := make([]string, 0, len(rVPNState.Accounts))
+	for name := range rVPNState.Accounts {
+		accountNames = append(accountNames, name)
+	}
+	sort.Strings(accountNames)
+
+	activeAccountName := rVPNState.ActiveAccountName()
+	accountResults := make([]accountResult, 0, len(accountNames))
+	for _, name := range accountNames {
+		account := rVPNState.Accounts[name]
+
+		registeredTargets := make([]string, 0, len(account.DeviceTokens))
+		for target := range account.DeviceTokens {
+			registeredTargets = append(registeredTargets, target)
+		}
+		sort.Strings(registeredTargets)
+
+		accountResults = append(accountResults, accountResult{
+			Name:              name,
+			ControlPlaneURL:   config.ForAccount(account).ControlPlaneURL,
+			Active:            name == activeAccountName,
+			LoggedIn:          account.LoggedIn,
+			User:              account.User,
+			RegisteredTargets: registeredTargets,
+		})
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(commandResult{
+			Success: true,
+			Data:    accountResults,
+		})
+		return
+	}
+
+	if len(accountResults) == 0 {
+		fmt.Println(`no rVPN accounts, add one using "rvpn login [token]" or "rvpn account add"`)
+		return
+	}
+
+	for _, account := range accountResults {
+		marker := " "
+		if account.Active {
+			marker = "*"
+		}
+
+		loginStatus := "logged out"
+		if account.LoggedIn && account.User != "" {
+			loginStatus = "logged in as " + account.User
+		} else if account.LoggedIn {
+			loginStatus = "logged in"
+		}
+
+		fmt.Printf("%s %s\t%s\t%s\n", marker, account.Name, account.ControlPlaneURL, loginStatus)
+
+		if len(account.RegisteredTargets) > 0 {
+			fmt.Printf("    registered targets: %s\n", strings.Join(account.RegisteredTargets, ", "))
+		}
+	}
+}
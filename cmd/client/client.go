@@ -1,15 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"net/rpc"
-	"os"
+	"strings"
+	"time"
 
-	"github.com/denisbrodbeck/machineid"
 	"github.com/redpwn/rvpn/common"
 	"github.com/redpwn/rvpn/daemon"
 )
@@ -17,257 +14,321 @@ import (
 // client.go holds functions which interact (connect, disconnect, status) with the client daemon via rpc
 // functions in this file assume the daemon is running otherwise they will error
 
-// getControlPanelAuthToken gets the control panel auth token from state
-func getControlPanelAuthToken(client *rpc.Client) string {
-	rVPNState, err := GetRVpnState(client)
+// getDaemonConfig gets the effective config of the rVPN daemon
+func getDaemonConfig(client *rpc.Client) daemon.Config {
+	config, err := GetDaemonConfig(client)
 	if err != nil {
-		fmt.Printf("failed to get rVPN state: %v\n", err)
-		os.Exit(1)
+		failRPC("failed to get rVPN daemon config", err)
 	}
 
-	return rVPNState.ControlPlaneAuth
+	return config
 }
 
-// ControlPanelAuthLogin saves the given token as the login token
+// loginResult is the data of the JSON output of login
+type loginResult struct {
+	Account string `json:"account"`
+}
+
+// ControlPanelAuthLogin saves the given token as the login token of the active account
 func ControlPanelAuthLogin(token string) {
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
-	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon", err)
-		os.Exit(1)
-	}
+	client := dialDaemon()
 	defer client.Close()
 
-	rVPNState, err := GetRVpnState(client)
-	if err != nil {
-		fmt.Printf("failed to get rVPN state: %v\n", err)
-		os.Exit(1)
-	}
-
-	rVPNState.ControlPlaneAuth = token
-	err = SetRVpnState(client, rVPNState)
+	// NOTE: the default account is created on first login
+	var accountName string
+	err := client.Call("RVPNDaemon.Login", daemon.LoginRequest{Token: token}, &accountName)
 	if err != nil {
-		fmt.Println("failed to save rVPN state")
-		os.Exit(1)
+		failRPC("failed to save rVPN login token", err)
 	}
 
-	fmt.Println("successfully set rVPN login token!")
+	succeed(fmt.Sprintf("successfully set rVPN login token of account %s!", accountName), loginResult{
+		Account: accountName,
+	})
 }
 
-// ClientConnectProfile instructs the rVPN daemon to connect to a target via rpc
-func ClientConnectProfile(profile string, opts common.ClientOptions) {
-	// connect to rVPN daemon
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
-	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon", err)
-		os.Exit(1)
-	}
-	defer client.Close()
+// targetResult is the data of the JSON output of connect and serve
+type targetResult struct {
+	Account string `json:"account,omitempty"` // empty if the active account was used
+	Profile string `json:"profile"`
+}
 
-	// ensure device is not already connected
+// ensureDisconnected fails if the device is already connected to or serving a target
+func ensureDisconnected(client *rpc.Client) {
 	var connectionStatus daemon.RVPNStatus
-	err = client.Call("RVPNDaemon.Status", "", &connectionStatus)
+	err := client.Call("RVPNDaemon.Status", "", &connectionStatus)
 	if err != nil {
-		fmt.Println("failed to get rVPN status", err)
-		os.Exit(1)
+		failRPC("failed to get rVPN status", err)
 	}
 
 	if connectionStatus != daemon.StatusDisconnected {
 		// device is already connected, early exit
-		fmt.Println("device is already connected to a rVPN target, disconnect and try again")
-		os.Exit(1)
+		fail(daemon.ErrorUnknown, "device is already connected to a rVPN target, disconnect and try again")
 	}
+}
+
+// ClientConnectProfile instructs the rVPN daemon to connect to a target via rpc, the target may be prefixed by an
+// account as "account/target"
+func ClientConnectProfile(accountProfile string, opts common.ClientOptions) {
+	// connect to rVPN daemon
+	client := dialDaemon()
+	defer client.Close()
+
+	ensureDisconnected(client)
 
-	// ensure device is registered for target
-	controlPanelAuthToken := getControlPanelAuthToken(client)
-	if controlPanelAuthToken == "" {
-		fmt.Println(`not logged into rVPN, login first using "rvpn login [token]"`)
-		os.Exit(1)
+	// start connection by issuing request to rVPN daemon, the daemon registers the device for the target
+	accountName, profile := daemon.SplitAccountTarget(accountProfile)
+	connectionRequest := daemon.ConnectRequest{
+		Account: accountName,
+		Profile: profile,
+		Opts:    opts,
 	}
 
-	machineId, err := machineid.ID()
+	// NOTE: the daemon replies once the tunnel is verified to be healthy, every failure is returned as an error
+	var connectionSuccess bool
+	err := client.Call("RVPNDaemon.Connect", connectionRequest, &connectionSuccess)
 	if err != nil {
-		fmt.Println("failed to get machine id", err)
-		os.Exit(1)
+		failRPC("failed to connect rVPN target", err)
 	}
 
-	controlPlaneURL := RVPN_CONTROL_PLANE + "/api/v1/target/" + profile + "/register_device"
-	jsonStr := []byte(fmt.Sprintf(`{"hardwareId":"%s"}`, machineId))
+	succeed(fmt.Sprintf("rVPN successfully connected to profile %s", profile), targetResult{
+		Account: accountName,
+		Profile: profile,
+	})
+}
+
+// ClientDisconnectProfile instructs the rVPN daemon to disconnect from the current target via rpc
+func ClientDisconnectProfile() {
+	client := dialDaemon()
+	defer client.Close()
 
-	req, err := http.NewRequest("POST", controlPlaneURL, bytes.NewBuffer(jsonStr))
+	// NOTE: disconnecting also removes a kill switch left behind while not connected
+	var disconnectSuccess bool
+	err := client.Call("RVPNDaemon.Disconnect", "", &disconnectSuccess)
 	if err != nil {
-		fmt.Println("register device request failed", err)
-		os.Exit(1)
+		failRPC("failed to disconnect from rVPN connection", err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", controlPanelAuthToken))
-	req.Header.Set("Content-Type", "application/json")
+	if !disconnectSuccess {
+		fail(daemon.ErrorUnknown, "device is not connected to a rVPN target")
+	}
+
+	succeed("successfully disconnected rVPN", nil)
+}
+
+// ClientStatus gets the status report of the rVPN daemon via rpc and prints it
+func ClientStatus() {
+	client := dialDaemon()
+	defer client.Close()
 
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
+	var statusReport daemon.StatusReport
+	err := client.Call("RVPNDaemon.StatusReport", "", &statusReport)
 	if err != nil {
-		fmt.Println("failed to send device registration request", err)
-		os.Exit(1)
+		failRPC("failed to get status from rVPN daemon", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 401 {
-		fmt.Println("invalid rVPN login token, please check target / login token and try again")
-		os.Exit(1)
+	if outputFormat == outputJSON {
+		printJSON(commandResult{
+			Success: true,
+			Message: statusMessage(statusReport.Status),
+			Data:    statusReport,
+		})
+		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("failed to read device registration response", err)
-		os.Exit(1)
+	printStatusReport(statusReport)
+}
+
+// statusMessage returns a sentence describing the status
+func statusMessage(status daemon.RVPNStatus) string {
+	if status == daemon.StatusConnected {
+		return "rVPN is currently connected to a profile"
+	} else if status == daemon.StatusDisconnected {
+		return "rVPN is not currently connected to a profile"
+	} else if status == daemon.StatusServing {
+		return "rVPN is currently serving as a target VPN server"
+	} else if status == daemon.StatusReconnecting {
+		return "rVPN lost its connection to the control plane and is reconnecting"
+	} else if status == daemon.StatusConnecting {
+		return "rVPN is currently connecting to a profile"
+	} else {
+		return "something went wrong, rVPN status is unrecognized"
 	}
+}
 
-	deviceRegistrationResp := common.RegisterDeviceResponse{}
-	err = json.Unmarshal(body, &deviceRegistrationResp)
-	if err != nil {
-		fmt.Println("failed to unmarshal device registration response", err)
-		os.Exit(1)
+// printStatusReport prints the status report in a human readable form
+func printStatusReport(statusReport daemon.StatusReport) {
+	fmt.Println(statusMessage(statusReport.Status))
+	if statusReport.Status == daemon.StatusDisconnected {
+		return
 	}
 
-	// start connection by issuing request to rVPN daemon
-	connectionRequest := daemon.ConnectRequest{
-		Profile:        profile,
-		DeviceToken:    deviceRegistrationResp.DeviceToken,
-		ControlPlaneWS: RVPN_CONTROL_PLANE_WS,
-		Opts:           opts,
+	fmt.Println()
+	if statusReport.Profile != "" {
+		fmt.Printf("  profile:          %s/%s\n", statusReport.Account, statusReport.Profile)
+	}
+	if statusReport.TunnelAddress != "" {
+		fmt.Printf("  tunnel address:   %s\n", statusReport.TunnelAddress)
 	}
 
-	var connectionSuccess bool
-	err = client.Call("RVPNDaemon.Connect", connectionRequest, &connectionSuccess)
-	if err != nil {
-		fmt.Printf("failed to connect rVPN target: %v", err)
-		os.Exit(1)
+	if server := statusReport.Server; server != nil {
+		fmt.Printf("  server endpoint:  %s\n", server.Endpoint)
+		fmt.Printf("  server key:       %s\n", server.PublicKey)
+		fmt.Printf("  last handshake:   %s\n", formatHandshake(server.LastHandshake))
+		fmt.Printf("  transfer:         %s received, %s sent\n", formatBytes(server.ReceiveBytes),
+			formatBytes(server.TransmitBytes))
 	}
 
-	fmt.Printf("rVPN successfully connected to profile %s\n", profile)
-}
+	if len(statusReport.Routes) > 0 {
+		fmt.Printf("  routes:           %s\n", strings.Join(statusReport.Routes, ", "))
+	}
 
-// ClientDisconnectProfile instructs the rVPN daemon to disconnect from the current target via rpc
-func ClientDisconnectProfile() {
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
-	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon", err)
-		os.Exit(1)
+	if dns := statusReport.DNS; dns != nil {
+		dnsState := "not applied"
+		if dns.Applied {
+			dnsState = "applied"
+		}
+		fmt.Printf("  dns:              %s (%s)\n", dns.Server, dnsState)
 	}
-	defer client.Close()
 
-	// ensure device is connected
-	var connectionStatus daemon.RVPNStatus
-	err = client.Call("RVPNDaemon.Status", "", &connectionStatus)
-	if err != nil {
-		fmt.Println("failed to get rVPN status", err)
-		os.Exit(1)
+	if statusReport.KillSwitch {
+		fmt.Println("  kill switch:      enabled")
 	}
 
-	if connectionStatus == daemon.StatusDisconnected {
-		// device is already connected, early exit
-		fmt.Println("device is not connected to a rVPN target, disconnect and try again")
-		os.Exit(1)
+	if statusReport.UptimeSeconds > 0 {
+		fmt.Printf("  uptime:           %s\n", time.Duration(statusReport.UptimeSeconds)*time.Second)
 	}
 
-	var disconnectSuccess bool
-	err = client.Call("RVPNDaemon.Disconnect", "", &disconnectSuccess)
-	if err != nil {
-		fmt.Println("failed to disconnect from rVPN connection", err)
-		os.Exit(1)
+	fmt.Printf("  control channel:  %s", statusReport.ControlChannel)
+	if statusReport.ControlPlane != "" {
+		fmt.Printf(" (%s)", statusReport.ControlPlane)
 	}
+	fmt.Println()
 
-	fmt.Println("successfully disconnected rVPN")
+	if len(statusReport.Peers) > 0 {
+		fmt.Printf("\n  peers:\n")
+		for _, peer := range statusReport.Peers {
+			fmt.Printf("    %s\n", peer.PublicKey)
+			if peer.Endpoint != "" {
+				fmt.Printf("      endpoint:       %s\n", peer.Endpoint)
+			}
+			fmt.Printf("      last handshake: %s\n", formatHandshake(peer.LastHandshake))
+			fmt.Printf("      transfer:       %s received, %s sent\n", formatBytes(peer.ReceiveBytes),
+				formatBytes(peer.TransmitBytes))
+		}
+	}
 }
 
-// ClientStatus gets the status of the rVPN daemon via rpc
-func ClientStatus() {
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
-	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon")
-		os.Exit(1)
+// formatHandshake formats the time of the last handshake relative to now
+func formatHandshake(lastHandshake time.Time) string {
+	if lastHandshake.IsZero() {
+		return "never"
 	}
-	defer client.Close()
 
-	var rVPNState daemon.RVPNStatus
-	err = client.Call("RVPNDaemon.Status", "", &rVPNState)
-	if err != nil {
-		fmt.Println("failed to get status from rVPN daemon", err)
-		os.Exit(1)
+	return time.Since(lastHandshake).Round(time.Second).String() + " ago"
+}
+
+// formatBytes formats a byte count with a binary unit, i.e "1.5 MiB"
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
 	}
 
-	if rVPNState == daemon.StatusConnected {
-		fmt.Println("rVPN is currently connected to a profile")
-	} else if rVPNState == daemon.StatusDisconnected {
-		fmt.Println("rVPN is not currently connected to a profile")
-	} else if rVPNState == daemon.StatusServing {
-		fmt.Println("rVPN is currently serving as a target VPN server")
-	} else {
-		fmt.Println("something went wrong, rVPN status is unrecognized")
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
-}
 
-type targetProfileInfo struct {
-	Name string `json:"name"`
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-// ListTargetProfiles lists the available targets for the logged in user to connect to
-func ListTargetProfiles() {
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
-	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon")
-		os.Exit(1)
-	}
+// ClientWatchStatus prints the status of the rVPN daemon and then every status, handshake and peer change until the
+// daemon stops, every event is printed as a line of JSON in JSON output
+func ClientWatchStatus() {
+	client := dialDaemon()
 	defer client.Close()
 
-	// get control plane authentication token
-	rVPNState, err := GetRVpnState(client)
-	if err != nil {
-		fmt.Println("failed to get rVPN state")
-		os.Exit(1)
-	}
-
-	controlPanelAuthToken := rVPNState.ControlPlaneAuth
-	if controlPanelAuthToken == "" {
-		fmt.Println(`not logged into rVPN, login first"`)
-		os.Exit(1)
-	}
+	var cursor uint64
+	for {
+		var watchEventsReply daemon.WatchEventsReply
+		err := client.Call("RVPNDaemon.WatchEvents", daemon.WatchEventsRequest{Cursor: cursor}, &watchEventsReply)
+		if err != nil {
+			failRPC("failed to watch rVPN daemon events", err)
+		}
 
-	controlPlaneURL := RVPN_CONTROL_PLANE + "/api/v1/target/"
+		for _, event := range watchEventsReply.Events {
+			if outputFormat == outputJSON {
+				eventJson, err := json.Marshal(event)
+				if err != nil {
+					fail(daemon.ErrorUnknown, fmt.Sprintf("failed to marshal rVPN daemon event: %v", err))
+				}
 
-	req, err := http.NewRequest("GET", controlPlaneURL, nil)
-	if err != nil {
-		fmt.Println("failed to create request to list targets")
-		os.Exit(1)
-	}
+				fmt.Println(string(eventJson))
+				continue
+			}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", controlPanelAuthToken))
-	req.Header.Set("Content-Type", "application/json")
+			fmt.Printf("[%s] %s\n", event.Time.Format("15:04:05"), formatEvent(event))
+		}
 
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		fmt.Println("failed to send request to list targets")
-		os.Exit(1)
+		cursor = watchEventsReply.Cursor
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode == 401 {
-		fmt.Println("invalid rVPN login token, please check target / login token and try again")
-		os.Exit(1)
-	}
+// formatEvent returns a line describing the event
+func formatEvent(event daemon.Event) string {
+	var line string
+	switch event.Type {
+	case daemon.EventStatus:
+		line = "status " + event.Status.String()
+	case daemon.EventError:
+		line = "error"
+	case daemon.EventHandshake:
+		if event.PeerActive {
+			line = "handshake with peer " + event.Peer
+		} else {
+			line = "no recent handshake with peer " + event.Peer
+		}
+	case daemon.EventPeerAdded:
+		line = "peer added " + event.Peer
+	case daemon.EventPeerRemoved:
+		line = "peer removed " + event.Peer
+	default:
+		line = string(event.Type)
+	}
+
+	if event.Endpoint != "" {
+		line += " (" + event.Endpoint + ")"
+	}
+
+	if event.Reason != "" {
+		line += ": " + event.Reason
+	}
+
+	return line
+}
+
+// ListTargetProfiles lists the available targets of the named account or the active account if name is empty
+func ListTargetProfiles(accountName string) {
+	client := dialDaemon()
+	defer client.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	var profileList []daemon.TargetInfo
+	err := client.Call("RVPNDaemon.ListTargets", accountName, &profileList)
 	if err != nil {
-		fmt.Println("failed to read user targets response: %w", err)
-		os.Exit(1)
+		failRPC("failed to list targets", err)
 	}
 
-	var profileList []targetProfileInfo
+	if outputFormat == outputJSON {
+		if profileList == nil {
+			profileList = []daemon.TargetInfo{}
+		}
 
-	err = json.Unmarshal(body, &profileList)
-	if err != nil {
-		fmt.Println("failed to read message from control plane")
-		os.Exit(1)
+		printJSON(commandResult{
+			Success: true,
+			Data:    profileList,
+		})
+		return
 	}
 
 	// print resulting target list
@@ -280,23 +341,16 @@ func ListTargetProfiles() {
 // EnsureDaemonStarted checks if the daemon is started, if not it prompts to start the daemon
 func EnsureDaemonStarted() {
 	// TODO: determine if elevating and running the command is neccesary per UX
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon, ensure the rvpn service is running")
-		os.Exit(1)
+		fail(daemon.ErrorDaemonNotRunning, "failed to connect to rVPN daemon, ensure the rvpn service is running")
 	}
 	defer client.Close()
 
 	// ping to ensure daemon is alive
 	var pingStatus bool
 	err = client.Call("RVPNDaemon.Ping", "", &pingStatus)
-	if err != nil {
-		fmt.Println("failed to connect to rVPN daemon, ensure the rvpn service is running")
-		os.Exit(1)
-	}
-
-	if !pingStatus {
-		fmt.Println("failed to connect to rVPN daemon, ensure the rvpn service is running")
-		os.Exit(1)
+	if err != nil || !pingStatus {
+		fail(daemon.ErrorDaemonNotRunning, "failed to connect to rVPN daemon, ensure the rvpn service is running")
 	}
 }
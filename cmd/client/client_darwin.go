@@ -2,10 +2,13 @@
 
 package main
 
-import "fmt"
+import (
+	"github.com/redpwn/rvpn/common"
+	"github.com/redpwn/rvpn/daemon"
+)
 
 // ClientServeProfile instructs the rVPN daemon to serve as a VPN server for a target via rpc
-func ClientServeProfile(profile string) {
-	// NOTE: it is currently not supported for the windows client to serve as a VPN server
-	fmt.Println("ERROR: darwin rVPN client is not supported to serve as a VPN server")
+func ClientServeProfile(profile string, opts common.ServeOptions) {
+	// NOTE: it is currently not supported for the darwin client to serve as a VPN server
+	fail(daemon.ErrorUnknown, "ERROR: darwin rVPN client is not supported to serve as a VPN server")
 }
@@ -7,6 +7,7 @@ const helpMsg = `Usage: rvpn <command> [arguments]
 Available commands are:
 
 	login      - login and authenticate client
+	account    - manage rVPN accounts on multiple control planes
 	ls         - list available rVPN profiles
 	status     - show current status of rVPN
 	connect    - connect to a rVPN profile
@@ -15,6 +16,13 @@ Available commands are:
 	daemon     - start the rVPN daemon (windows only)
 	version    - display rVPN version
 
+Global flags are:
+	-o, --output - output format, "text" (default) or "json" which prints a result object for scripts
+	--json       - shorthand for --output json
+
+Commands exit with 0 on success, 2 on invalid arguments, 3 if the daemon is not running, 4 if the account is not
+logged in, 5 if the control plane rejects the login token, 6 if the target server is offline and 1 on other errors
+
 Use "rvpn help <command>" for more information about a command
 `
 
@@ -22,22 +30,64 @@ func displayHelp() {
 	fmt.Print(helpMsg)
 }
 
-const connectHelpMsg = `Usage: rvpn connect [profile]
+const loginHelpMsg = `Usage: rvpn login [token]
+
+Saves the login token of the active rVPN account, the "default" account on the control plane from the daemon
+config is created on first login
+`
+
+const accountHelpMsg = `Usage: rvpn account <command> [arguments]
+
+Available commands are:
+
+	add [name] [control plane url] [token] - add an account on the control plane (i.e https://rvpn.example.com)
+	use [name]                             - use the account when a command does not name an account
+	ls                                     - list accounts, the active account is marked with "*"
+
+Targets of an account other than the active account are named as "account/target", i.e "rvpn connect work/prod"
+`
+
+const lsHelpMsg = `Usage: rvpn ls [account]
+
+Lists the rVPN profiles of the account, by default of the active account
+`
+
+const statusHelpMsg = `Usage: rvpn status
+
+Available flags are:
+	--watch - keep printing status, handshake and peer changes until interrupted, one JSON event per line with
+	          --output json
+`
+
+const connectHelpMsg = `Usage: rvpn connect [account/][profile]
 
 Available flags are:
+	--kill-switch - block all traffic outside of the tunnel until "rvpn disconnect", also if the tunnel drops
+	                or the daemon stops unexpectedly (Linux only)
 
 Advanced flags (only use if you know what you are doing!):
-	--subnets - comma separated list of subnets to connect to (will override served subnets)
+	--subnets           - comma separated list of subnets to connect to (will override served subnets)
+	--advertise-subnets - comma separated list of subnets behind this device to route for other peers
+	                      (i.e 192.168.1.0/24); must be approved by a target admin
 `
 
-const serveHelpMsg = `Usage: rvpn serve [profile]
+const serveHelpMsg = `Usage: rvpn serve [account/][profile]
 
 Available flags are:
-	--subnets - comma separated list of subnets to serve; by default will be all traffic
+	--subnets    - comma separated list of subnets to serve (i.e 192.168.5.0/24); by default will be all traffic
+	--masquerade - masquerade client traffic forwarded to the served subnets; by default true
 `
 
 func displayCmdHelp(command string) {
 	switch command {
+	case "login":
+		fmt.Print(loginHelpMsg)
+	case "account":
+		fmt.Print(accountHelpMsg)
+	case "ls", "list":
+		fmt.Print(lsHelpMsg)
+	case "status":
+		fmt.Print(statusHelpMsg)
 	case "connect":
 		fmt.Print(connectHelpMsg)
 	case "serve":
@@ -11,18 +11,36 @@ import (
 )
 
 const (
-	RVPN_CONTROL_PLANE    = "http://rvpn.jimmyli.us"
-	RVPN_CONTROL_PLANE_WS = "ws://rvpn.jimmyli.us"
-	RVPN_VERSION          = "0.0.1"
+	RVPN_VERSION = "0.0.1"
 )
 
+// versionResult is the data of the JSON output of version
+type versionResult struct {
+	Version string `json:"version"`
+}
+
 func main() {
 	// define flags
-	subnets := flag.StringSlice("subnets", []string{"0.0.0.0/0"}, "comma separated list of subnets to serve")
+	subnets := flag.StringSlice("subnets", []string{}, "comma separated list of subnets to connect to or serve")
+	advertiseSubnets := flag.StringSlice("advertise-subnets", []string{}, "comma separated list of subnets to route for other peers")
+	masquerade := flag.Bool("masquerade", true, "masquerade client traffic forwarded to served subnets")
+	killSwitch := flag.Bool("kill-switch", false, "block traffic outside of the tunnel until disconnected")
+	watch := flag.Bool("watch", false, "keep printing status changes")
+	output := flag.StringP("output", "o", outputText, "output format of the command, text or json")
+	jsonOutput := flag.Bool("json", false, "shorthand for --output json")
 
 	// begin main cli parsing
 	flag.Parse()
 
+	switch {
+	case *jsonOutput || *output == outputJSON:
+		outputFormat = outputJSON
+	case *output == outputText:
+		outputFormat = outputText
+	default:
+		fail(errorUsage, fmt.Sprintf("unknown output format %s, use text or json", *output))
+	}
+
 	// TODO: replace with cobra
 	if command := flag.Arg(0); command != "" {
 		switch command {
@@ -37,37 +55,78 @@ func main() {
 		case "login":
 			if token := flag.Arg(1); token == "" {
 				// no token was provided
-				fmt.Println("missing required token, rvpn login [token]")
+				fail(errorUsage, "missing required token, rvpn login [token]")
 			} else {
 				// token was provided
 				EnsureDaemonStarted()
 				ControlPanelAuthLogin(token)
 			}
-		case "list":
+		case "list", "ls":
 			EnsureDaemonStarted()
-			ListTargetProfiles()
+			ListTargetProfiles(flag.Arg(1))
+		case "account":
+			switch flag.Arg(1) {
+			case "add":
+				if name, controlPlaneURL, token := flag.Arg(2), flag.Arg(3), flag.Arg(4); token == "" {
+					fail(errorUsage, "missing required argument, rvpn account add [name] [control plane url] [token]")
+				} else {
+					EnsureDaemonStarted()
+					AccountAdd(name, controlPlaneURL, token)
+				}
+			case "use":
+				if name := flag.Arg(2); name == "" {
+					fail(errorUsage, "missing required account, rvpn account use [name]")
+				} else {
+					EnsureDaemonStarted()
+					AccountUse(name)
+				}
+			case "ls", "list":
+				EnsureDaemonStarted()
+				AccountList()
+			default:
+				fail(errorUsage, "account command not found, run 'rvpn help account' for help")
+			}
 		case "connect":
 			if profile := flag.Arg(1); profile != "" {
+				opts := common.ClientOptions{
+					Subnets:          *subnets,
+					AdvertiseSubnets: *advertiseSubnets,
+					KillSwitch:       *killSwitch,
+				}
+				if err := opts.Validate(); err != nil {
+					fail(errorUsage, err.Error())
+				}
+
 				EnsureDaemonStarted()
-				ClientConnectProfile(profile, common.ClientOptions{
-					Subnets: *subnets,
-				})
+				ClientConnectProfile(profile, opts)
 			} else {
-				fmt.Println("missing required profile, rvpn connect [profile]")
+				fail(errorUsage, "missing required profile, rvpn connect [account/][profile]")
 			}
 		case "serve":
 			if profile := flag.Arg(1); profile != "" {
+				opts := common.ServeOptions{
+					Subnets:    *subnets,
+					Masquerade: *masquerade,
+				}
+				if err := opts.Validate(); err != nil {
+					fail(errorUsage, err.Error())
+				}
+
 				EnsureDaemonStarted()
-				ClientServeProfile(profile)
+				ClientServeProfile(profile, opts)
 			} else {
-				fmt.Println("missing required profile, rvpn serve [profile]")
+				fail(errorUsage, "missing required profile, rvpn serve [account/][profile]")
 			}
 		case "disconnect":
 			EnsureDaemonStarted()
 			ClientDisconnectProfile()
 		case "status":
 			EnsureDaemonStarted()
-			ClientStatus()
+			if *watch {
+				ClientWatchStatus()
+			} else {
+				ClientStatus()
+			}
 		case "daemon":
 			// start the rVPN daemon which is different based on user operating system
 			debug := false
@@ -83,11 +142,13 @@ func main() {
 				service.StartRVPNDaemon(exePath)
 			}
 		case "version":
-			fmt.Println("rVPN version: " + RVPN_VERSION)
+			succeed("rVPN version: "+RVPN_VERSION, versionResult{
+				Version: RVPN_VERSION,
+			})
 		default:
-			fmt.Println("command not found, run 'rvpn help' for help")
+			fail(errorUsage, "command not found, run 'rvpn help' for help")
 		}
 	} else {
-		fmt.Println("missing required argument, run 'rvpn help' for help")
+		fail(errorUsage, "missing required argument, run 'rvpn help' for help")
 	}
 }
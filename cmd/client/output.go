@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/rpc"
+	"os"
+
+	"github.com/redpwn/rvpn/daemon"
+)
+
+// output.go holds the output of the cli commands, commands print human readable text by default and a JSON result
+// with "--output json" so they can be scripted
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// errorUsage is the error code of commands with missing or unknown arguments
+const errorUsage daemon.ErrorCode = "usage"
+
+// exit codes of the cli, scripts tell errors apart by them
+const (
+	exitError            = 1
+	exitUsage            = 2
+	exitDaemonNotRunning = 3
+	exitNotLoggedIn      = 4
+	exitUnauthorized     = 5
+	exitTargetOffline    = 6
+	exitPermissionDenied = 7
+)
+
+var exitCodes = map[daemon.ErrorCode]int{
+	errorUsage:                   exitUsage,
+	daemon.ErrorDaemonNotRunning: exitDaemonNotRunning,
+	daemon.ErrorNotLoggedIn:      exitNotLoggedIn,
+	daemon.ErrorUnauthorized:     exitUnauthorized,
+	daemon.ErrorTargetOffline:    exitTargetOffline,
+	daemon.ErrorPermissionDenied: exitPermissionDenied,
+}
+
+// outputFormat is the format of the output of the command, set by the --output flag
+var outputFormat = outputText
+
+// commandResult is the result of a command in JSON output
+type commandResult struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message,omitempty"`
+	Data    interface{}   `json:"data,omitempty"`
+	Error   *commandError `json:"error,omitempty"`
+}
+
+type commandError struct {
+	Code    daemon.ErrorCode `json:"code"`
+	Message string           `json:"message"`
+}
+
+// printJSON prints the value as indented JSON
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal output: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	fmt.Println(string(out))
+}
+
+// succeed prints the message of a successful command, the data is only part of the JSON output
+func succeed(message string, data interface{}) {
+	if outputFormat == outputJSON {
+		printJSON(commandResult{
+			Success: true,
+			Message: message,
+			Data:    data,
+		})
+		return
+	}
+
+	fmt.Println(message)
+}
+
+// fail prints the error of a failed command and exits with the exit code of its error code
+func fail(code daemon.ErrorCode, message string) {
+	if outputFormat == outputJSON {
+		printJSON(commandResult{
+			Success: false,
+			Error: &commandError{
+				Code:    code,
+				Message: message,
+			},
+		})
+	} else {
+		fmt.Println(message)
+	}
+
+	exitCode, ok := exitCodes[code]
+	if !ok {
+		exitCode = exitError
+	}
+
+	os.Exit(exitCode)
+}
+
+// failRPC fails with the error of an RPC of the rVPN daemon, the message describes the failed operation
+func failRPC(message string, err error) {
+	code, errMessage := daemon.ParseError(err)
+	fail(code, fmt.Sprintf("%s: %s", message, errMessage))
+}
+
+// dialDaemon connects to the rVPN daemon and fails if it cannot be reached
+func dialDaemon() *rpc.Client {
+	client, err := daemon.DialDaemon()
+	if err != nil {
+		fail(daemon.ErrorDaemonNotRunning, fmt.Sprintf("failed to connect to rVPN daemon: %v", err))
+	}
+
+	return client
+}
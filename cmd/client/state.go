@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"net/rpc"
 
 	"github.com/redpwn/rvpn/daemon"
@@ -18,17 +17,13 @@ func GetRVpnState(client *rpc.Client) (daemon.RVpnState, error) {
 	return rVPNState, nil
 }
 
-func SetRVpnState(client *rpc.Client, rVPNState daemon.RVpnState) error {
-	// get state from rVPN daemon
-	var rVPNSetStateSuccess bool
-	err := client.Call("RVPNDaemon.SetState", rVPNState, &rVPNSetStateSuccess)
+func GetDaemonConfig(client *rpc.Client) (daemon.Config, error) {
+	// get effective config from rVPN daemon
+	var config daemon.Config
+	err := client.Call("RVPNDaemon.GetConfig", "", &config)
 	if err != nil {
-		return err
+		return daemon.Config{}, err
 	}
 
-	if rVPNSetStateSuccess {
-		return nil
-	} else {
-		return errors.New("failed to set rVPN state")
-	}
+	return config, nil
 }
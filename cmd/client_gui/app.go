@@ -1,28 +1,26 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"net/rpc"
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
-	"github.com/denisbrodbeck/machineid"
 	"github.com/redpwn/rvpn/common"
 	"github.com/redpwn/rvpn/daemon"
 	"github.com/redpwn/rvpn/service"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 const (
-	RVPN_CONTROL_PLANE    = "http://rvpn.jimmyli.us"
-	RVPN_CONTROL_PLANE_WS = "ws://rvpn.jimmyli.us"
-	RVPN_VERSION          = "0.0.1"
+	RVPN_VERSION = "0.0.1"
+
+	eventsRetryInterval = 5 * time.Second
 )
 
 // App struct
@@ -53,6 +51,43 @@ func (a *App) startup(ctx context.Context) {
 
 		service.StartRVPNDaemon(cliClientPath)
 	}
+
+	go a.forwardEvents()
+}
+
+// forwardEvents forwards the events of the rVPN daemon to the frontend as "rvpn:event", it reconnects to the daemon
+// if it is not running yet or restarts
+func (a *App) forwardEvents() {
+	for {
+		err := a.watchEvents()
+		log.Printf("stopped watching rVPN daemon events: %v", err)
+
+		time.Sleep(eventsRetryInterval)
+	}
+}
+
+// watchEvents emits the events of the rVPN daemon until the daemon cannot be reached
+func (a *App) watchEvents() error {
+	client, err := daemon.DialDaemon()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var cursor uint64
+	for {
+		var watchEventsReply daemon.WatchEventsReply
+		err = client.Call("RVPNDaemon.WatchEvents", daemon.WatchEventsRequest{Cursor: cursor}, &watchEventsReply)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range watchEventsReply.Events {
+			runtime.EventsEmit(a.ctx, "rvpn:event", event)
+		}
+
+		cursor = watchEventsReply.Cursor
+	}
 }
 
 // similar to the rVPN cli client, we create bindings to the rVPN daemon
@@ -93,25 +128,22 @@ func GetRVpnState(client *rpc.Client) (daemon.RVpnState, error) {
 	return rVPNState, nil
 }
 
-// Set RVpnState sets the rVPN state using the rpc client
-func SetRVpnState(client *rpc.Client, rVPNState daemon.RVpnState) error {
-	// get state from rVPN daemon
-	var rVPNSetStateSuccess bool
-	err := client.Call("RVPNDaemon.SetState", rVPNState, &rVPNSetStateSuccess)
+// GetDaemonConfig gets the effective config of the rVPN daemon using the rpc client
+func GetDaemonConfig(client *rpc.Client) (daemon.Config, error) {
+	var config daemon.Config
+	err := client.Call("RVPNDaemon.GetConfig", "", &config)
 	if err != nil {
-		return err
+		return daemon.Config{}, err
 	}
 
-	if rVPNSetStateSuccess {
-		return nil
-	} else {
-		return errors.New("failed to set rVPN state")
-	}
+	return config, nil
 }
 
-func (a *App) GetControlPlaneAuth() WrappedReturn {
+// GetAccountUser returns the user the active account is logged in as, it is empty if the account is logged out
+// NOTE: the daemon never returns the login token itself
+func (a *App) GetAccountUser() WrappedReturn {
 	// connect to rVPN daemon
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
@@ -122,27 +154,28 @@ func (a *App) GetControlPlaneAuth() WrappedReturn {
 		return wrappedError(fmt.Errorf("failed to get rPVN state: %w", err))
 	}
 
-	return wrappedSuccess(rVPNState.ControlPlaneAuth)
+	_, account, _ := rVPNState.GetAccount("")
+	if !account.LoggedIn {
+		return wrappedSuccess("")
+	}
+
+	return wrappedSuccess(account.User)
 }
 
-// Login will log the user in with the specified token
+// Login will log the user into the active account with the specified token
 func (a *App) Login(token string) WrappedReturn {
 	// connect to rVPN daemon
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
 	defer client.Close()
 
-	rVPNState, err := GetRVpnState(client)
+	// NOTE: the default account is created on first login
+	var accountName string
+	err = client.Call("RVPNDaemon.Login", daemon.LoginRequest{Token: token}, &accountName)
 	if err != nil {
-		return wrappedError(fmt.Errorf("failed to get rVPN state: %w", err))
-	}
-
-	rVPNState.ControlPlaneAuth = token
-	err = SetRVpnState(client, rVPNState)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to set rVPN state: %w", err))
+		return wrappedError(fmt.Errorf("failed to set rVPN login token: %w", err))
 	}
 
 	return wrappedSuccess("successfully logged into rVPN!")
@@ -150,7 +183,7 @@ func (a *App) Login(token string) WrappedReturn {
 
 func (a *App) Logout() WrappedReturn {
 	// connect to rVPN daemon
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
@@ -161,10 +194,15 @@ func (a *App) Logout() WrappedReturn {
 		return wrappedError(fmt.Errorf("failed to get rVPN state: %w", err))
 	}
 
-	rVPNState.ControlPlaneAuth = ""
-	err = SetRVpnState(client, rVPNState)
+	if _, _, err = rVPNState.GetAccount(""); err != nil {
+		// there is no account to log out of
+		return wrappedSuccess("successfully logged out of rVPN!")
+	}
+
+	var accountName string
+	err = client.Call("RVPNDaemon.Login", daemon.LoginRequest{Token: ""}, &accountName)
 	if err != nil {
-		return wrappedError(fmt.Errorf("failed to set rVPN state: %w", err))
+		return wrappedError(fmt.Errorf("failed to remove rVPN login token: %w", err))
 	}
 
 	return wrappedSuccess("successfully logged out of rVPN!")
@@ -174,55 +212,31 @@ func (a *App) Logout() WrappedReturn {
 // NOTE: data is returned as JSON string
 func (a *App) ListTargets() WrappedReturn {
 	// connect to rVPN daemon
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
 	defer client.Close()
 
-	// get control plane authentication token
-	rVPNState, err := GetRVpnState(client)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to get rVPN state: %w", err))
-	}
-
-	controlPanelAuthToken := rVPNState.ControlPlaneAuth
-	if controlPanelAuthToken == "" {
-		return wrappedError(fmt.Errorf(`not logged into rVPN, login first"`))
-	}
-
-	controlPlaneURL := RVPN_CONTROL_PLANE + "/api/v1/target/"
-
-	req, err := http.NewRequest("GET", controlPlaneURL, nil)
+	var targetList []daemon.TargetInfo
+	err = client.Call("RVPNDaemon.ListTargets", "", &targetList)
 	if err != nil {
-		return wrappedError(fmt.Errorf("list target profiles request failed: %w", err))
+		_, message := daemon.ParseError(err)
+		return wrappedError(fmt.Errorf("failed to list target profiles: %s", message))
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", controlPanelAuthToken))
-	req.Header.Set("Content-Type", "application/json")
-
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
+	targetListJson, err := json.Marshal(targetList)
 	if err != nil {
-		return wrappedError(fmt.Errorf("failed to send list target profiles request: %w", err))
+		return wrappedError(fmt.Errorf("failed to marshal target profiles: %w", err))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 401 {
-		return wrappedError(fmt.Errorf("invalid rVPN login token, please check target / login token and try again"))
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to read user targets response: %w", err))
-	}
-
-	return wrappedSuccess(string(body))
+	return wrappedSuccess(string(targetListJson))
 }
 
-func (a *App) Connect(profile string, opts common.ClientOptions) WrappedReturn {
+// Connect connects to the target, the target may be prefixed by an account as "account/target"
+func (a *App) Connect(accountProfile string, opts common.ClientOptions) WrappedReturn {
 	// connect to rVPN daemon
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
@@ -240,67 +254,20 @@ func (a *App) Connect(profile string, opts common.ClientOptions) WrappedReturn {
 		return wrappedError(fmt.Errorf("device is already connected to a rVPN target, disconnect and try again"))
 	}
 
-	// ensure device is registered for target
-	rVPNState, err := GetRVpnState(client)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to get rVPN state: %w", err))
-	}
-
-	controlPanelAuthToken := rVPNState.ControlPlaneAuth
-	if controlPanelAuthToken == "" {
-		return wrappedError(fmt.Errorf(`not logged into rVPN, login first"`))
-	}
-
-	machineId, err := machineid.ID()
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to get machine id: %w", err))
-	}
-
-	controlPlaneURL := RVPN_CONTROL_PLANE + "/api/v1/target/" + profile + "/register_device"
-	jsonStr := []byte(fmt.Sprintf(`{"hardwareId":"%s"}`, machineId))
-
-	req, err := http.NewRequest("POST", controlPlaneURL, bytes.NewBuffer(jsonStr))
-	if err != nil {
-		return wrappedError(fmt.Errorf("register device request failed: %w", err))
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", controlPanelAuthToken))
-	req.Header.Set("Content-Type", "application/json")
-
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to send device registration request: %w", err))
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 401 {
-		return wrappedError(fmt.Errorf("invalid rVPN login token, please check target / login token and try again"))
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to read device registration response: %w", err))
-	}
-
-	deviceRegistrationResp := common.RegisterDeviceResponse{}
-	err = json.Unmarshal(body, &deviceRegistrationResp)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to unmarshal device registration response: %w", err))
-	}
-
-	// start connection by issuing request to rVPN daemon
+	// start connection by issuing request to rVPN daemon, the daemon registers the device for the target
+	accountName, profile := daemon.SplitAccountTarget(accountProfile)
 	connectionRequest := daemon.ConnectRequest{
-		Profile:        profile,
-		DeviceToken:    deviceRegistrationResp.DeviceToken,
-		ControlPlaneWS: RVPN_CONTROL_PLANE_WS,
-		Opts:           opts,
+		Account: accountName,
+		Profile: profile,
+		Opts:    opts,
 	}
 
+	// NOTE: the daemon replies once the tunnel is verified to be healthy, every failure is returned as an error
 	var connectionSuccess bool
 	err = client.Call("RVPNDaemon.Connect", connectionRequest, &connectionSuccess)
 	if err != nil {
-		return wrappedError(fmt.Errorf("failed to connect rVPN target: %w", err))
+		_, message := daemon.ParseError(err)
+		return wrappedError(fmt.Errorf("failed to connect rVPN target: %s", message))
 	}
 
 	return wrappedSuccess("successfully connected to rVPN target")
@@ -308,36 +275,29 @@ func (a *App) Connect(profile string, opts common.ClientOptions) WrappedReturn {
 
 // Disconnect disconnects the rVPN client from the current connected rVPN server
 func (a *App) Disconnect() WrappedReturn {
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
 	defer client.Close()
 
-	// ensure device is connected
-	var connectionStatus daemon.RVPNStatus
-	err = client.Call("RVPNDaemon.Status", "", &connectionStatus)
-	if err != nil {
-		return wrappedError(fmt.Errorf("failed to get rVPN status: %w", err))
-	}
-
-	if connectionStatus == daemon.StatusDisconnected {
-		// device is already connected, early exit
-		return wrappedError(fmt.Errorf("device is not connected to a rVPN target, disconnect and try again"))
-	}
-
+	// NOTE: disconnecting also removes a kill switch left behind while not connected
 	var disconnectSuccess bool
 	err = client.Call("RVPNDaemon.Disconnect", "", &disconnectSuccess)
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to disconnect from rVPN connection: %w", err))
 	}
 
+	if !disconnectSuccess {
+		return wrappedError(fmt.Errorf("device is not connected to a rVPN target"))
+	}
+
 	return wrappedSuccess("successfully disconnected from rVPN")
 }
 
 // Status gets the current status of the rVPN daemon
 func (a *App) Status() WrappedReturn {
-	client, err := rpc.Dial("tcp", "127.0.0.1:52370")
+	client, err := daemon.DialDaemon()
 	if err != nil {
 		return wrappedError(fmt.Errorf("failed to connect to rVPN daemon: %w", err))
 	}
@@ -355,6 +315,10 @@ func (a *App) Status() WrappedReturn {
 		return wrappedSuccess("rVPN is not currently connected to a profile")
 	} else if rVPNState == daemon.StatusServing {
 		return wrappedSuccess("rVPN is currently serving as a target VPN server")
+	} else if rVPNState == daemon.StatusReconnecting {
+		return wrappedSuccess("rVPN lost its connection to the control plane and is reconnecting")
+	} else if rVPNState == daemon.StatusConnecting {
+		return wrappedSuccess("rVPN is currently connecting to a profile")
 	} else {
 		return wrappedSuccess("something went wrong, rVPN status is unrecognized")
 	}
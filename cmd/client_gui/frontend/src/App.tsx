@@ -1,5 +1,5 @@
 import { useState, useEffect } from "react";
-import { GetControlPlaneAuth } from "../wailsjs/go/main/App";
+import { GetAccountUser } from "../wailsjs/go/main/App";
 import Loading from "./views/Loading";
 import Login from "./views/Login";
 import Home from "./views/Home";
@@ -10,13 +10,13 @@ const App = () => {
 
   useEffect(() => {
     const fetchAuth = async () => {
-      const controlPlaneAuth = await GetControlPlaneAuth();
-      if (controlPlaneAuth.success) {
-        setAuth(controlPlaneAuth.data);
+      const accountUser = await GetAccountUser();
+      if (accountUser.success) {
+        setAuth(accountUser.data);
         setLoading(false);
       } else {
         // something went wrong
-        throw new Error(controlPlaneAuth.error);
+        throw new Error(accountUser.error);
       }
     };
 
@@ -2,12 +2,13 @@ import { Dispatch, SetStateAction, useEffect, useState } from "react";
 import {
   Connect,
   Disconnect,
-  GetControlPlaneAuth,
+  GetAccountUser,
   ListTargets,
   Logout,
   Status,
 } from "../../../wailsjs/go/main/App";
 import { common } from "../../../wailsjs/go/models";
+import { EventsOn } from "../../../wailsjs/runtime/runtime";
 
 import logo from "../../assets/images/logo_w.svg";
 import Button from "../../components/Button";
@@ -56,9 +57,16 @@ const StatusMessage = (props: StatusMessageProps) => {
   }
 };
 
+// RVPNEvent is an event streamed by the rVPN daemon
+interface RVPNEvent {
+  type: string;
+  status: string;
+  reason?: string;
+  peer?: string;
+}
+
 const Home = (props: HomeProps) => {
   const [loading, setLoading] = useState(true);
-  const [authToken, setAuthToken] = useState("");
   const [userId, setUserId] = useState("");
   const [rVPNStatus, setRVPNStatus] = useState("");
   const [targetList, setTargetList] = useState(new Array<TargetInfo>());
@@ -68,31 +76,6 @@ const Home = (props: HomeProps) => {
     new Array<TargetInfo>()
   );
 
-  const parseAuthToken = (token: string) => {
-    // helper to do initial validation; this is NOT for security
-    let splits = token.split(".");
-    if (splits.length != 3) {
-      return "";
-    }
-
-    let body = splits[1];
-    console.log(body);
-    let decodedBody = atob(body);
-
-    let parsedBody;
-    try {
-      parsedBody = JSON.parse(decodedBody);
-    } catch {
-      return "";
-    }
-
-    if (parsedBody["user"] === undefined) {
-      return "";
-    }
-
-    return parsedBody["user"];
-  };
-
   const filterTargetList = (search: string) => {
     // helper to filter the target list
     if (search == "") {
@@ -148,20 +131,17 @@ const Home = (props: HomeProps) => {
   };
 
   const fetchAllData = async () => {
-    const authTokenResp = await GetControlPlaneAuth();
-    if (authTokenResp.success) {
-      setAuthToken(authTokenResp.data);
+    const accountUserResp = await GetAccountUser();
+    if (accountUserResp.success) {
+      setUserId(accountUserResp.data);
     } else {
-      darkToast(ToastType.Error, "unable to get auth token");
-      console.error(authTokenResp.error);
+      darkToast(ToastType.Error, "unable to get account user");
+      console.error(accountUserResp.error);
       setLoading(false);
       return;
     }
 
-    const userId = parseAuthToken(authTokenResp.data);
-    setUserId(userId);
-
-    // if getting auth token succeeds then continue fetching
+    // if getting account user succeeds then continue fetching
     await fetchStatus();
     await fetchTargets();
 
@@ -173,6 +153,21 @@ const Home = (props: HomeProps) => {
     fetchAllData().catch(console.error);
   }, []);
 
+  useEffect(() => {
+    // the daemon streams status changes, refresh the status instead of polling
+    const cancelEvents = EventsOn("rvpn:event", (event: RVPNEvent) => {
+      if (event.type === "error" && event.reason) {
+        darkToast(ToastType.Error, event.reason);
+      }
+
+      if (event.type === "status") {
+        fetchStatus().catch(console.error);
+      }
+    });
+
+    return cancelEvents;
+  }, []);
+
   const handleLogout = async () => {
     const logoutResp = await Logout();
     if (logoutResp.success) {
@@ -202,8 +197,9 @@ const Home = (props: HomeProps) => {
       darkToast(ToastType.Blank, "connecting to " + profile);
 
       // issue connect command to rVPN daemon
+      // NOTE: no subnets are passed so the subnets advertised by the target are used
       const opts: common.ClientOptions = {
-        subnets: ["0.0.0.0/0"],
+        subnets: [],
       };
       const connectResp = await Connect(profile, opts);
       if (connectResp.success) {
@@ -7,6 +7,42 @@ const (
 	BearerAuthScopes = "bearerAuth.Scopes"
 )
 
+// ApproveRoutesRequest defines model for ApproveRoutesRequest.
+type ApproveRoutesRequest struct {
+	// advertised subnets to approve, subnets which are not listed are revoked
+	Subnets []string `json:"subnets"`
+}
+
+// Connection defines model for Connection.
+type Connection struct {
+	// tunnel ip of the connection
+	ClientIp string `json:"clientIp"`
+
+	// id of the connection
+	ConnectionId string `json:"connectionId"`
+
+	// id of the device of the connection
+	DeviceId string `json:"deviceId"`
+
+	// bytes the serving node sent to the device
+	DownloadBytes int64 `json:"downloadBytes"`
+
+	// endpoint of the device as seen by the serving node, empty if unknown
+	Endpoint string `json:"endpoint"`
+
+	// unix time of the last handshake with the serving node in seconds, zero if none
+	LastHandshake int64 `json:"lastHandshake"`
+
+	// traffic statistics of the peers of the device as reported by the device
+	Peers []PeerStats `json:"peers"`
+
+	// unix time the serving node reported the statistics in seconds, zero if never reported
+	ReportedAt int64 `json:"reportedAt"`
+
+	// bytes the serving node received from the device
+	UploadBytes int64 `json:"uploadBytes"`
+}
+
 // Error defines model for Error.
 type Error struct {
 	Error struct {
@@ -15,11 +51,104 @@ type Error struct {
 	} `json:"error"`
 }
 
+// Group defines model for Group.
+type Group struct {
+	// device ids which are members of the group
+	Devices []string `json:"devices"`
+
+	// id of the group
+	Id string `json:"id"`
+
+	// users whose devices are members of the group
+	Users []string `json:"users"`
+}
+
+// ListConnectionsResponse defines model for ListConnectionsResponse.
+type ListConnectionsResponse = []Connection
+
+// ListGroupsResponse defines model for ListGroupsResponse.
+type ListGroupsResponse = []Group
+
+// ListPoliciesResponse defines model for ListPoliciesResponse.
+type ListPoliciesResponse = []Policy
+
+// ListRateLimitsResponse defines model for ListRateLimitsResponse.
+type ListRateLimitsResponse = []RateLimit
+
+// ListRoutesResponse defines model for ListRoutesResponse.
+type ListRoutesResponse = []Route
+
 // ListTargetsResponse defines model for ListTargetsResponse.
 type ListTargetsResponse = []struct {
 	Name string `json:"name"`
 }
 
+// PeerStats defines model for PeerStats.
+type PeerStats struct {
+	// last endpoint of the peer, empty if unknown
+	Endpoint string `json:"endpoint"`
+
+	// unix time of the last handshake in seconds, zero if none
+	LastHandshake int64 `json:"lastHandshake"`
+
+	// public key of the peer
+	PublicKey string `json:"publicKey"`
+
+	// bytes the device received from the peer
+	ReceiveBytes int64 `json:"receiveBytes"`
+
+	// unix time the device reported the statistics in seconds
+	ReportedAt int64 `json:"reportedAt"`
+
+	// bytes the device sent to the peer
+	TransmitBytes int64 `json:"transmitBytes"`
+}
+
+// Policy defines model for Policy.
+type Policy struct {
+	// connection ids which may be reached
+	DestinationPeers []string `json:"destinationPeers"`
+
+	// subnets which may be reached
+	DestinationSubnets []string `json:"destinationSubnets"`
+
+	// id of the policy
+	Id string `json:"id"`
+
+	// destination ports or port ranges for tcp and udp, i.e 8000-8100
+	Ports []string `json:"ports"`
+
+	// tcp, udp or icmp, empty for all protocols
+	Protocol string `json:"protocol"`
+
+	// device ids the policy applies to
+	SourceDevices []string `json:"sourceDevices"`
+
+	// users whose devices the policy applies to
+	SourceUsers []string `json:"sourceUsers"`
+}
+
+// RateLimit defines model for RateLimit.
+type RateLimit struct {
+	// device ids the limit applies to, limits of devices take precedence over limits of groups and users
+	Devices []string `json:"devices"`
+
+	// bandwidth limit of traffic to each device in Mbps, zero means unlimited
+	DownloadMbps int `json:"downloadMbps"`
+
+	// ids of the groups whose member devices the limit applies to, limits of groups take precedence over limits of users
+	Groups []string `json:"groups"`
+
+	// id of the rate limit
+	Id string `json:"id"`
+
+	// bandwidth limit of traffic from each device in Mbps, zero means unlimited
+	UploadMbps int `json:"uploadMbps"`
+
+	// users whose devices the limit applies to
+	Users []string `json:"users"`
+}
+
 // RegisterDeviceRequest defines model for RegisterDeviceRequest.
 type RegisterDeviceRequest struct {
 	// hardware id of the device which wishes to connect
@@ -35,6 +164,78 @@ type RegisterDeviceResponse struct {
 	DeviceToken *string `json:"deviceToken,omitempty"`
 }
 
+// Route defines model for Route.
+type Route struct {
+	// subnets the connection advertises to route for other peers
+	AdvertisedSubnets []string `json:"advertisedSubnets"`
+
+	// subset of the advertised subnets which are approved by a target admin
+	ApprovedSubnets []string `json:"approvedSubnets"`
+
+	// tunnel ip of the connection
+	ClientIp string `json:"clientIp"`
+
+	// id of the connection which advertises the subnets
+	ConnectionId string `json:"connectionId"`
+
+	// id of the device of the connection
+	DeviceId string `json:"deviceId"`
+}
+
+// SetGroupRequest defines model for SetGroupRequest.
+type SetGroupRequest struct {
+	// device ids which are members of the group
+	Devices []string `json:"devices"`
+
+	// users whose devices are members of the group
+	Users []string `json:"users"`
+}
+
+// SetMeshRequest defines model for SetMeshRequest.
+type SetMeshRequest struct {
+	// whether clients of the target connect directly to each other
+	Enabled bool `json:"enabled"`
+}
+
+// SetPolicyRequest defines model for SetPolicyRequest.
+type SetPolicyRequest struct {
+	// connection ids which may be reached
+	DestinationPeers []string `json:"destinationPeers"`
+
+	// subnets which may be reached
+	DestinationSubnets []string `json:"destinationSubnets"`
+
+	// destination ports or port ranges for tcp and udp, i.e 8000-8100
+	Ports []string `json:"ports"`
+
+	// tcp, udp or icmp, empty for all protocols
+	Protocol string `json:"protocol"`
+
+	// device ids the policy applies to
+	SourceDevices []string `json:"sourceDevices"`
+
+	// users whose devices the policy applies to
+	SourceUsers []string `json:"sourceUsers"`
+}
+
+// SetRateLimitRequest defines model for SetRateLimitRequest.
+type SetRateLimitRequest struct {
+	// device ids the limit applies to, limits of devices take precedence over limits of groups and users
+	Devices []string `json:"devices"`
+
+	// bandwidth limit of traffic to each device in Mbps, zero means unlimited
+	DownloadMbps int `json:"downloadMbps"`
+
+	// ids of the groups whose member devices the limit applies to, limits of groups take precedence over limits of users
+	Groups []string `json:"groups"`
+
+	// bandwidth limit of traffic from each device in Mbps, zero means unlimited
+	UploadMbps int `json:"uploadMbps"`
+
+	// users whose devices the limit applies to
+	Users []string `json:"users"`
+}
+
 // UpdateTarget defines model for UpdateTarget.
 type UpdateTarget struct {
 	// action to complete for user (modify / delete)
@@ -47,6 +248,9 @@ type UpdateTarget struct {
 	UserType *string `json:"userType,omitempty"`
 }
 
+// Id defines model for id.
+type Id = string
+
 // Target defines model for target.
 type Target = string
 
@@ -65,8 +269,38 @@ type PatchTargetTargetJSONBody = UpdateTarget
 // PostTargetTargetRegisterDeviceJSONBody defines parameters for PostTargetTargetRegisterDevice.
 type PostTargetTargetRegisterDeviceJSONBody = RegisterDeviceRequest
 
+// PutTargetTargetGroupsIdJSONBody defines parameters for PutTargetTargetGroupsId.
+type PutTargetTargetGroupsIdJSONBody = SetGroupRequest
+
+// PutTargetTargetMeshJSONBody defines parameters for PutTargetTargetMesh.
+type PutTargetTargetMeshJSONBody = SetMeshRequest
+
+// PutTargetTargetPoliciesIdJSONBody defines parameters for PutTargetTargetPoliciesId.
+type PutTargetTargetPoliciesIdJSONBody = SetPolicyRequest
+
+// PutTargetTargetRateLimitsIdJSONBody defines parameters for PutTargetTargetRateLimitsId.
+type PutTargetTargetRateLimitsIdJSONBody = SetRateLimitRequest
+
+// PutTargetTargetRoutesIdJSONBody defines parameters for PutTargetTargetRoutesId.
+type PutTargetTargetRoutesIdJSONBody = ApproveRoutesRequest
+
 // PatchTargetTargetJSONRequestBody defines body for PatchTargetTarget for application/json ContentType.
 type PatchTargetTargetJSONRequestBody = PatchTargetTargetJSONBody
 
 // PostTargetTargetRegisterDeviceJSONRequestBody defines body for PostTargetTargetRegisterDevice for application/json ContentType.
 type PostTargetTargetRegisterDeviceJSONRequestBody = PostTargetTargetRegisterDeviceJSONBody
+
+// PutTargetTargetGroupsIdJSONRequestBody defines body for PutTargetTargetGroupsId for application/json ContentType.
+type PutTargetTargetGroupsIdJSONRequestBody = PutTargetTargetGroupsIdJSONBody
+
+// PutTargetTargetMeshJSONRequestBody defines body for PutTargetTargetMesh for application/json ContentType.
+type PutTargetTargetMeshJSONRequestBody = PutTargetTargetMeshJSONBody
+
+// PutTargetTargetPoliciesIdJSONRequestBody defines body for PutTargetTargetPoliciesId for application/json ContentType.
+type PutTargetTargetPoliciesIdJSONRequestBody = PutTargetTargetPoliciesIdJSONBody
+
+// PutTargetTargetRateLimitsIdJSONRequestBody defines body for PutTargetTargetRateLimitsId for application/json ContentType.
+type PutTargetTargetRateLimitsIdJSONRequestBody = PutTargetTargetRateLimitsIdJSONBody
+
+// PutTargetTargetRoutesIdJSONRequestBody defines body for PutTargetTargetRoutesId for application/json ContentType.
+type PutTargetTargetRoutesIdJSONRequestBody = PutTargetTargetRoutesIdJSONBody
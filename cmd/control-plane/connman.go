@@ -1,24 +1,36 @@
 package main
 
-import "github.com/sourcegraph/jsonrpc2"
+import (
+	"sync"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
 
 type ConnectionManager struct {
-	vpnServerConnections map[string]*jsonrpc2.Conn   // targetName : jrpc connection
-	vpnClientConnections map[string][]*jsonrpc2.Conn // targetName : [] jrpc connection for all targets
-	// TODO: determine if vpnClientConnections needs more identifiers
-	// TODO: synchronization / locking for connections maps
+	vpnServerConnections map[string]*jsonrpc2.Conn            // targetName : jrpc connection
+	vpnClientConnections map[string]map[string]*jsonrpc2.Conn // targetName : connection id : jrpc connection
+	relayTickets         map[string]relayTicket               // relay token : relay ticket
+	relayServerLegs      map[string]*relayLeg                 // targetName : relay leg
+	relayClientLegs      map[string]map[string]*relayLeg      // targetName : connection id : relay leg
+	lock                 sync.RWMutex
 }
 
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{
 		vpnServerConnections: make(map[string]*jsonrpc2.Conn),
-		vpnClientConnections: make(map[string][]*jsonrpc2.Conn),
+		vpnClientConnections: make(map[string]map[string]*jsonrpc2.Conn),
+		relayTickets:         make(map[string]relayTicket),
+		relayServerLegs:      make(map[string]*relayLeg),
+		relayClientLegs:      make(map[string]map[string]*relayLeg),
 	}
 }
 
 // setVPNServerConn sets the given connection as the serving connection for the target
 // returns true if old target connection was overwritten, else false
 func (c *ConnectionManager) setVPNServerConn(targetName string, conn *jsonrpc2.Conn) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	_, exists := c.vpnServerConnections[targetName]
 
 	c.vpnServerConnections[targetName] = conn
@@ -26,21 +38,26 @@ func (c *ConnectionManager) setVPNServerConn(targetName string, conn *jsonrpc2.C
 	return exists
 }
 
-// setVPNClientConn appends the given connection as a client for the target
-func (c *ConnectionManager) setVPNClientConn(targetName string, conn *jsonrpc2.Conn) {
+// setVPNClientConn sets the given connection as the client connection for the rVPN connection id on the target
+func (c *ConnectionManager) setVPNClientConn(targetName, connectionId string, conn *jsonrpc2.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	_, exists := c.vpnClientConnections[targetName]
 
-	if exists {
-		// there is already a list of targets for the connection
-		c.vpnClientConnections[targetName] = append(c.vpnClientConnections[targetName], conn)
-	} else {
-		// list of targets does not yet exist for the connection
-		c.vpnClientConnections[targetName] = []*jsonrpc2.Conn{conn}
+	if !exists {
+		// map of connections does not yet exist for the target
+		c.vpnClientConnections[targetName] = make(map[string]*jsonrpc2.Conn)
 	}
+
+	c.vpnClientConnections[targetName][connectionId] = conn
 }
 
 // getVPNServerConn gets the server jrpc connection for the target
 func (c *ConnectionManager) getVPNServerConn(targetName string) *jsonrpc2.Conn {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
 	retConn, exists := c.vpnServerConnections[targetName]
 	if exists {
 		return retConn
@@ -49,12 +66,97 @@ func (c *ConnectionManager) getVPNServerConn(targetName string) *jsonrpc2.Conn {
 	}
 }
 
-// getVPNClientConn gets all client jrpc connections for a target
-func (c *ConnectionManager) getVPNClientConn(targetName string) []*jsonrpc2.Conn {
-	retConns, exists := c.vpnClientConnections[targetName]
-	if exists {
-		return retConns
-	} else {
-		return []*jsonrpc2.Conn{}
+// getVPNClientConn gets all client jrpc connections for a target keyed by rVPN connection id
+func (c *ConnectionManager) getVPNClientConn(targetName string) map[string]*jsonrpc2.Conn {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	retConns := make(map[string]*jsonrpc2.Conn)
+	for connectionId, conn := range c.vpnClientConnections[targetName] {
+		retConns[connectionId] = conn
+	}
+
+	return retConns
+}
+
+// removeVPNClientConn removes the client connection for the rVPN connection id if it is still the given connection
+func (c *ConnectionManager) removeVPNClientConn(targetName, connectionId string, conn *jsonrpc2.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.vpnClientConnections[targetName][connectionId] == conn {
+		delete(c.vpnClientConnections[targetName], connectionId)
+	}
+}
+
+// setRelayTicket sets the relay ticket which is granted by the relay token
+func (c *ConnectionManager) setRelayTicket(relayToken string, ticket relayTicket) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.relayTickets[relayToken] = ticket
+}
+
+// getRelayTicket gets the relay ticket which is granted by the relay token, returns false if it does not exist
+func (c *ConnectionManager) getRelayTicket(relayToken string) (relayTicket, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	ticket, exists := c.relayTickets[relayToken]
+	return ticket, exists
+}
+
+// removeRelayTicket removes the relay ticket which is granted by the relay token
+func (c *ConnectionManager) removeRelayTicket(relayToken string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.relayTickets, relayToken)
+}
+
+// setRelayLeg sets the relay leg of the target server if connectionId is empty, otherwise of the client connection
+func (c *ConnectionManager) setRelayLeg(targetName, connectionId string, leg *relayLeg) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if connectionId == "" {
+		c.relayServerLegs[targetName] = leg
+		return
+	}
+
+	if _, exists := c.relayClientLegs[targetName]; !exists {
+		// map of relay legs does not yet exist for the target
+		c.relayClientLegs[targetName] = make(map[string]*relayLeg)
+	}
+
+	c.relayClientLegs[targetName][connectionId] = leg
+}
+
+// getRelayLeg gets the relay leg of the target server if connectionId is empty, otherwise of the client connection
+func (c *ConnectionManager) getRelayLeg(targetName, connectionId string) *relayLeg {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if connectionId == "" {
+		return c.relayServerLegs[targetName]
+	}
+
+	return c.relayClientLegs[targetName][connectionId]
+}
+
+// removeRelayLeg removes the relay leg of the target server or client connection if it is still the given leg
+func (c *ConnectionManager) removeRelayLeg(targetName, connectionId string, leg *relayLeg) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if connectionId == "" {
+		if c.relayServerLegs[targetName] == leg {
+			delete(c.relayServerLegs, targetName)
+		}
+		return
+	}
+
+	if c.relayClientLegs[targetName][connectionId] == leg {
+		delete(c.relayClientLegs[targetName], connectionId)
 	}
 }
@@ -23,16 +23,65 @@ type RVPNTarget struct {
 	serverInternalIp    string
 	serverInternalCidr  string
 	serverHeartbeat     string
+	subnets             string // comma separated routable subnets
+	mesh                bool   // whether clients connect directly to each other
 }
 
 // RVPNConnection represents a connect to the rVPN control plane
 type RVPNConnection struct {
-	id         string
-	target     string
-	deviceId   string
-	pubkey     string
-	clientIp   string
-	clientCidr string
+	id                string
+	target            string
+	deviceId          string
+	pubkey            string
+	clientIp          string
+	clientCidr        string
+	advertisedSubnets string // comma separated subnets the connection routes for other peers
+	approvedSubnets   string // comma separated subset of advertised subnets approved by a target admin
+	endpoint          string // observed public endpoint of the client for mesh mode, i.e "1.2.3.4:51720"
+	presharedKey      string // wireguard preshared key between the client and the target server
+}
+
+// RVPNPolicy represents a firewall policy of a rVPN target, empty lists match any
+type RVPNPolicy struct {
+	id                 string
+	target             string
+	sourceUsers        string // comma separated principals whose devices the policy applies to
+	sourceDevices      string // comma separated device ids the policy applies to
+	destinationSubnets string // comma separated subnets which may be reached
+	destinationPeers   string // comma separated connection ids which may be reached
+	protocol           string // tcp, udp, icmp or empty for all protocols
+	ports              string // comma separated ports or port ranges, i.e "443,8000-8100"
+}
+
+// RVPNRateLimit represents a bandwidth limit of a rVPN target which applies to each matching device
+type RVPNRateLimit struct {
+	id           string
+	target       string
+	users        string // comma separated principals whose devices the limit applies to
+	groups       string // comma separated ids of the groups whose member devices the limit applies to
+	devices      string // comma separated device ids the limit applies to
+	uploadMbps   int    // traffic from the device, zero means unlimited
+	downloadMbps int    // traffic to the device, zero means unlimited
+}
+
+// RVPNGroup represents a group of devices of a rVPN target which bandwidth limits apply to
+type RVPNGroup struct {
+	id      string
+	target  string
+	users   string // comma separated principals whose devices are members of the group
+	devices string // comma separated device ids which are members of the group
+}
+
+// RVPNPeerStats represents traffic statistics of a wireguard peer reported by a device of a rVPN target
+type RVPNPeerStats struct {
+	target        string
+	reporter      string // "server" for the serving node, connection id for clients
+	pubkey        string
+	endpoint      string
+	lastHandshake int64 // unix time of the last handshake in seconds, zero if none
+	receiveBytes  int64 // bytes the reporter received from the peer
+	transmitBytes int64 // bytes the reporter sent to the peer
+	reportedAt    int64 // unix time of the report in seconds
 }
 
 func NewRVPNDatabase(postgresURL string) (*RVPNDatabase, error) {
@@ -47,9 +96,9 @@ func NewRVPNDatabase(postgresURL string) (*RVPNDatabase, error) {
 }
 
 // createTarget creates a target, returns whether it was created or not
-func (d *RVPNDatabase) createTarget(ctx context.Context, name, owner, networkIp, networkCidr, dnsIp, serverPubkey, serverPublicIp, serverPublicVpnPort, serverInternalIp, serverInternalCidr, serverHeartbeat string) (bool, error) {
-	res, err := d.db.ExecContext(ctx, "INSERT INTO targets (name, owner, network_ip, network_cidr, dns_ip, server_pubkey, server_public_ip, server_public_vpn_port, server_internal_ip, server_internal_cidr, server_heartbeat) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT DO NOTHING",
-		name, owner, networkIp, networkCidr, dnsIp, serverPubkey, serverPublicIp, serverPublicVpnPort, serverInternalIp, serverInternalCidr, serverHeartbeat)
+func (d *RVPNDatabase) createTarget(ctx context.Context, name, owner, networkIp, networkCidr, dnsIp, serverPubkey, serverPublicIp, serverPublicVpnPort, serverInternalIp, serverInternalCidr, serverHeartbeat, subnets string) (bool, error) {
+	res, err := d.db.ExecContext(ctx, "INSERT INTO targets (name, owner, network_ip, network_cidr, dns_ip, server_pubkey, server_public_ip, server_public_vpn_port, server_internal_ip, server_internal_cidr, server_heartbeat, subnets) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT DO NOTHING",
+		name, owner, networkIp, networkCidr, dnsIp, serverPubkey, serverPublicIp, serverPublicVpnPort, serverInternalIp, serverInternalCidr, serverHeartbeat, subnets)
 	if err != nil {
 		return false, err
 	}
@@ -65,10 +114,10 @@ func (d *RVPNDatabase) createTarget(ctx context.Context, name, owner, networkIp,
 func (d *RVPNDatabase) updateTarget(ctx context.Context, name string, rVPNTarget *RVPNTarget) (bool, error) {
 	res, err := d.db.ExecContext(ctx, `
 		UPDATE targets
-		SET owner=$2, network_ip=$3, network_cidr=$4, dns_ip=$5, server_pubkey=$6, server_public_ip=$7, server_public_vpn_port=$8, server_internal_ip=$9, server_internal_cidr=$10, server_heartbeat=$11
+		SET owner=$2, network_ip=$3, network_cidr=$4, dns_ip=$5, server_pubkey=$6, server_public_ip=$7, server_public_vpn_port=$8, server_internal_ip=$9, server_internal_cidr=$10, server_heartbeat=$11, subnets=$12, mesh=$13
 		WHERE name=$1
 	`, name, rVPNTarget.owner, rVPNTarget.networkIp, rVPNTarget.networkCidr, rVPNTarget.dnsIp, rVPNTarget.serverPubkey, rVPNTarget.serverPublicIp,
-		rVPNTarget.serverPublicVpnPort, rVPNTarget.serverInternalIp, rVPNTarget.serverInternalCidr, rVPNTarget.serverHeartbeat)
+		rVPNTarget.serverPublicVpnPort, rVPNTarget.serverInternalIp, rVPNTarget.serverInternalCidr, rVPNTarget.serverHeartbeat, rVPNTarget.subnets, rVPNTarget.mesh)
 	if err != nil {
 		return false, err
 	}
@@ -166,14 +215,14 @@ func (d *RVPNDatabase) getDeviceId(ctx context.Context, principal, hardwareId st
 func (d *RVPNDatabase) getTargetByName(ctx context.Context, target string) (*RVPNTarget, error) {
 	row := d.db.QueryRowContext(ctx, `
 		SELECT 
-			name, owner, network_ip, network_cidr, dns_ip, server_pubkey, server_public_ip, server_public_vpn_port, server_internal_ip, server_internal_cidr, server_heartbeat
+			name, owner, network_ip, network_cidr, dns_ip, server_pubkey, server_public_ip, server_public_vpn_port, server_internal_ip, server_internal_cidr, server_heartbeat, subnets, mesh
 		FROM targets
 		WHERE name=$1
 	`, target)
 
 	retRVPNTarget := RVPNTarget{}
 	err := row.Scan(&retRVPNTarget.name, &retRVPNTarget.owner, &retRVPNTarget.networkIp, &retRVPNTarget.networkCidr, &retRVPNTarget.dnsIp, &retRVPNTarget.serverPubkey,
-		&retRVPNTarget.serverPublicIp, &retRVPNTarget.serverPublicVpnPort, &retRVPNTarget.serverInternalIp, &retRVPNTarget.serverInternalCidr, &retRVPNTarget.serverHeartbeat)
+		&retRVPNTarget.serverPublicIp, &retRVPNTarget.serverPublicVpnPort, &retRVPNTarget.serverInternalIp, &retRVPNTarget.serverInternalCidr, &retRVPNTarget.serverHeartbeat, &retRVPNTarget.subnets, &retRVPNTarget.mesh)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// no rows, return nil
@@ -213,14 +262,42 @@ func (d *RVPNDatabase) getTargetClientIps(ctx context.Context, target string) (m
 func (d *RVPNDatabase) getConnection(ctx context.Context, targetName, deviceId string) (RVPNConnection, error) {
 	row := d.db.QueryRowContext(ctx, `
 		SELECT
-			id, target, device_id, pubkey, client_ip, client_cidr 
+			id, target, device_id, pubkey, client_ip, client_cidr, advertised_subnets, approved_subnets, endpoint, preshared_key
 		FROM connections
 		WHERE target=$1 AND device_id=$2
 	`, targetName, deviceId)
 
 	retRVPNConnection := RVPNConnection{}
 	err := row.Scan(&retRVPNConnection.id, &retRVPNConnection.target, &retRVPNConnection.deviceId,
-		&retRVPNConnection.pubkey, &retRVPNConnection.clientIp, &retRVPNConnection.clientCidr)
+		&retRVPNConnection.pubkey, &retRVPNConnection.clientIp, &retRVPNConnection.clientCidr,
+		&retRVPNConnection.advertisedSubnets, &retRVPNConnection.approvedSubnets, &retRVPNConnection.endpoint,
+		&retRVPNConnection.presharedKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// no rows, return default RVPNConnection struct
+			return retRVPNConnection, nil
+		} else {
+			return retRVPNConnection, err
+		}
+	}
+
+	return retRVPNConnection, nil
+}
+
+// getConnectionById gets a connection of a target by id, if it does not exist it returns the default struct
+func (d *RVPNDatabase) getConnectionById(ctx context.Context, targetName, id string) (RVPNConnection, error) {
+	row := d.db.QueryRowContext(ctx, `
+		SELECT
+			id, target, device_id, pubkey, client_ip, client_cidr, advertised_subnets, approved_subnets, endpoint, preshared_key
+		FROM connections
+		WHERE target=$1 AND id=$2
+	`, targetName, id)
+
+	retRVPNConnection := RVPNConnection{}
+	err := row.Scan(&retRVPNConnection.id, &retRVPNConnection.target, &retRVPNConnection.deviceId,
+		&retRVPNConnection.pubkey, &retRVPNConnection.clientIp, &retRVPNConnection.clientCidr,
+		&retRVPNConnection.advertisedSubnets, &retRVPNConnection.approvedSubnets, &retRVPNConnection.endpoint,
+		&retRVPNConnection.presharedKey)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// no rows, return default RVPNConnection struct
@@ -237,7 +314,7 @@ func (d *RVPNDatabase) getConnection(ctx context.Context, targetName, deviceId s
 func (d *RVPNDatabase) getConnectionsByTarget(ctx context.Context, targetName string) ([]RVPNConnection, error) {
 	rows, err := d.db.QueryContext(ctx, `
 		SELECT
-			id, target, device_id, pubkey, client_ip, client_cidr
+			id, target, device_id, pubkey, client_ip, client_cidr, advertised_subnets, approved_subnets, endpoint, preshared_key
 		FROM connections
 		WHERE target=$1
 	`, targetName)
@@ -250,7 +327,8 @@ func (d *RVPNDatabase) getConnectionsByTarget(ctx context.Context, targetName st
 	for rows.Next() {
 		rVPNConnection := RVPNConnection{}
 		err := rows.Scan(&rVPNConnection.id, &rVPNConnection.target, &rVPNConnection.deviceId, &rVPNConnection.pubkey,
-			&rVPNConnection.clientIp, &rVPNConnection.clientCidr)
+			&rVPNConnection.clientIp, &rVPNConnection.clientCidr, &rVPNConnection.advertisedSubnets, &rVPNConnection.approvedSubnets,
+			&rVPNConnection.endpoint, &rVPNConnection.presharedKey)
 		if err != nil {
 			return nil, err
 		}
@@ -262,12 +340,12 @@ func (d *RVPNDatabase) getConnectionsByTarget(ctx context.Context, targetName st
 }
 
 // createConnection creates a a connection from rVPN client to rVPN server and returns whether it was created or already existed
-func (d *RVPNDatabase) createConnection(ctx context.Context, id, target, deviceId, pubkey, clientIp, clientCidr string) (bool, error) {
+func (d *RVPNDatabase) createConnection(ctx context.Context, id, target, deviceId, pubkey, clientIp, clientCidr, advertisedSubnets, approvedSubnets, endpoint, presharedKey string) (bool, error) {
 	res, err := d.db.ExecContext(ctx, `
-		INSERT INTO connections (id, target, device_id, pubkey, client_ip, client_cidr) 
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO connections (id, target, device_id, pubkey, client_ip, client_cidr, advertised_subnets, approved_subnets, endpoint, preshared_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		ON CONFLICT DO NOTHING
-	`, id, target, deviceId, pubkey, clientIp, clientCidr)
+	`, id, target, deviceId, pubkey, clientIp, clientCidr, advertisedSubnets, approvedSubnets, endpoint, presharedKey)
 	if err != nil {
 		return false, err
 	}
@@ -281,12 +359,224 @@ func (d *RVPNDatabase) createConnection(ctx context.Context, id, target, deviceI
 }
 
 // updateConnection updates a a connection from rVPN client to rVPN server and returns whether it was a row was affected
-func (d *RVPNDatabase) updateConnection(ctx context.Context, id, target, deviceId, pubkey, clientIp, clientCidr string) (bool, error) {
+func (d *RVPNDatabase) updateConnection(ctx context.Context, id, target, deviceId, pubkey, clientIp, clientCidr, advertisedSubnets, approvedSubnets, endpoint, presharedKey string) (bool, error) {
 	res, err := d.db.ExecContext(ctx, `
 		UPDATE connections
-		SET target=$2, device_id=$3, pubkey=$4, client_ip=$5, client_cidr=$6
+		SET target=$2, device_id=$3, pubkey=$4, client_ip=$5, client_cidr=$6, advertised_subnets=$7, approved_subnets=$8, endpoint=$9, preshared_key=$10
 		WHERE id=$1
-	`, id, target, deviceId, pubkey, clientIp, clientCidr)
+	`, id, target, deviceId, pubkey, clientIp, clientCidr, advertisedSubnets, approvedSubnets, endpoint, presharedKey)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected == 1, nil
+}
+
+// getDeviceIdsByPrincipal gets the ids of all devices of a principal
+func (d *RVPNDatabase) getDeviceIdsByPrincipal(ctx context.Context, principal string) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT device_id FROM devices WHERE principal=$1", principal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deviceId string
+	ret := []string{}
+	for rows.Next() {
+		err := rows.Scan(&deviceId)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, deviceId)
+	}
+
+	return ret, nil
+}
+
+// getPoliciesByTarget gets all firewall policies for a specific target
+func (d *RVPNDatabase) getPoliciesByTarget(ctx context.Context, targetName string) ([]RVPNPolicy, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT
+			id, target, source_users, source_devices, destination_subnets, destination_peers, protocol, ports
+		FROM target_policies
+		WHERE target=$1
+		ORDER BY id
+	`, targetName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retRVPNPolicies := []RVPNPolicy{}
+	for rows.Next() {
+		rVPNPolicy := RVPNPolicy{}
+		err := rows.Scan(&rVPNPolicy.id, &rVPNPolicy.target, &rVPNPolicy.sourceUsers, &rVPNPolicy.sourceDevices,
+			&rVPNPolicy.destinationSubnets, &rVPNPolicy.destinationPeers, &rVPNPolicy.protocol, &rVPNPolicy.ports)
+		if err != nil {
+			return nil, err
+		}
+
+		retRVPNPolicies = append(retRVPNPolicies, rVPNPolicy)
+	}
+
+	return retRVPNPolicies, nil
+}
+
+// setPolicy creates or replaces a firewall policy of a target and returns whether a row was affected
+func (d *RVPNDatabase) setPolicy(ctx context.Context, id, target, sourceUsers, sourceDevices, destinationSubnets, destinationPeers, protocol, ports string) (bool, error) {
+	res, err := d.db.ExecContext(ctx, `
+		INSERT INTO target_policies (id, target, source_users, source_devices, destination_subnets, destination_peers, protocol, ports)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (target, id) DO UPDATE
+		SET source_users=$3, source_devices=$4, destination_subnets=$5, destination_peers=$6, protocol=$7, ports=$8
+	`, id, target, sourceUsers, sourceDevices, destinationSubnets, destinationPeers, protocol, ports)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected == 1, nil
+}
+
+// deletePolicy deletes a firewall policy of a target and returns whether it existed
+func (d *RVPNDatabase) deletePolicy(ctx context.Context, target, id string) (bool, error) {
+	res, err := d.db.ExecContext(ctx, "DELETE FROM target_policies WHERE target=$1 AND id=$2", target, id)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected == 1, nil
+}
+
+// getRateLimitsByTarget gets all bandwidth limits for a specific target
+func (d *RVPNDatabase) getRateLimitsByTarget(ctx context.Context, targetName string) ([]RVPNRateLimit, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT
+			id, target, users, groups, devices, upload_mbps, download_mbps
+		FROM target_rate_limits
+		WHERE target=$1
+		ORDER BY id
+	`, targetName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retRVPNRateLimits := []RVPNRateLimit{}
+	for rows.Next() {
+		rVPNRateLimit := RVPNRateLimit{}
+		err := rows.Scan(&rVPNRateLimit.id, &rVPNRateLimit.target, &rVPNRateLimit.users, &rVPNRateLimit.groups,
+			&rVPNRateLimit.devices, &rVPNRateLimit.uploadMbps, &rVPNRateLimit.downloadMbps)
+		if err != nil {
+			return nil, err
+		}
+
+		retRVPNRateLimits = append(retRVPNRateLimits, rVPNRateLimit)
+	}
+
+	return retRVPNRateLimits, nil
+}
+
+// setRateLimit creates or replaces a bandwidth limit of a target and returns whether a row was affected
+func (d *RVPNDatabase) setRateLimit(ctx context.Context, id, target, users, groups, devices string, uploadMbps, downloadMbps int) (bool, error) {
+	res, err := d.db.ExecContext(ctx, `
+		INSERT INTO target_rate_limits (id, target, users, groups, devices, upload_mbps, download_mbps)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (target, id) DO UPDATE
+		SET users=$3, groups=$4, devices=$5, upload_mbps=$6, download_mbps=$7
+	`, id, target, users, groups, devices, uploadMbps, downloadMbps)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected == 1, nil
+}
+
+// deleteRateLimit deletes a bandwidth limit of a target and returns whether it existed
+func (d *RVPNDatabase) deleteRateLimit(ctx context.Context, target, id string) (bool, error) {
+	res, err := d.db.ExecContext(ctx, "DELETE FROM target_rate_limits WHERE target=$1 AND id=$2", target, id)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected == 1, nil
+}
+
+// getGroupsByTarget gets all groups for a specific target
+func (d *RVPNDatabase) getGroupsByTarget(ctx context.Context, targetName string) ([]RVPNGroup, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT
+			id, target, users, devices
+		FROM target_groups
+		WHERE target=$1
+		ORDER BY id
+	`, targetName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retRVPNGroups := []RVPNGroup{}
+	for rows.Next() {
+		rVPNGroup := RVPNGroup{}
+		err := rows.Scan(&rVPNGroup.id, &rVPNGroup.target, &rVPNGroup.users, &rVPNGroup.devices)
+		if err != nil {
+			return nil, err
+		}
+
+		retRVPNGroups = append(retRVPNGroups, rVPNGroup)
+	}
+
+	return retRVPNGroups, nil
+}
+
+// setGroup creates or replaces a group of a target and returns whether a row was affected
+func (d *RVPNDatabase) setGroup(ctx context.Context, id, target, users, devices string) (bool, error) {
+	res, err := d.db.ExecContext(ctx, `
+		INSERT INTO target_groups (id, target, users, devices)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (target, id) DO UPDATE
+		SET users=$3, devices=$4
+	`, id, target, users, devices)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected == 1, nil
+}
+
+// deleteGroup deletes a group of a target and returns whether it existed
+func (d *RVPNDatabase) deleteGroup(ctx context.Context, target, id string) (bool, error) {
+	res, err := d.db.ExecContext(ctx, "DELETE FROM target_groups WHERE target=$1 AND id=$2", target, id)
 	if err != nil {
 		return false, err
 	}
@@ -298,3 +588,72 @@ func (d *RVPNDatabase) updateConnection(ctx context.Context, id, target, deviceI
 
 	return numRowsAffected == 1, nil
 }
+
+// getPeerStatsByTarget gets all reported peer traffic statistics for a specific target
+func (d *RVPNDatabase) getPeerStatsByTarget(ctx context.Context, targetName string) ([]RVPNPeerStats, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT
+			target, reporter, pubkey, endpoint, last_handshake, receive_bytes, transmit_bytes, reported_at
+		FROM peer_stats
+		WHERE target=$1
+		ORDER BY reporter, pubkey
+	`, targetName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retRVPNPeerStats := []RVPNPeerStats{}
+	for rows.Next() {
+		rVPNPeerStats := RVPNPeerStats{}
+		err := rows.Scan(&rVPNPeerStats.target, &rVPNPeerStats.reporter, &rVPNPeerStats.pubkey, &rVPNPeerStats.endpoint,
+			&rVPNPeerStats.lastHandshake, &rVPNPeerStats.receiveBytes, &rVPNPeerStats.transmitBytes, &rVPNPeerStats.reportedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		retRVPNPeerStats = append(retRVPNPeerStats, rVPNPeerStats)
+	}
+
+	return retRVPNPeerStats, nil
+}
+
+// replacePeerStats replaces all peer traffic statistics of a reporter of a target
+func (d *RVPNDatabase) replacePeerStats(ctx context.Context, target, reporter string, peerStats []RVPNPeerStats) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM peer_stats WHERE target=$1 AND reporter=$2", target, reporter)
+	if err != nil {
+		return err
+	}
+
+	for _, peerStat := range peerStats {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO peer_stats (target, reporter, pubkey, endpoint, last_handshake, receive_bytes, transmit_bytes, reported_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (target, reporter, pubkey) DO NOTHING
+		`, target, reporter, peerStat.pubkey, peerStat.endpoint, peerStat.lastHandshake, peerStat.receiveBytes,
+			peerStat.transmitBytes, peerStat.reportedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deletePeerStatsByReporter deletes all peer traffic statistics a reporter of a target reported
+func (d *RVPNDatabase) deletePeerStatsByReporter(ctx context.Context, target, reporter string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM peer_stats WHERE target=$1 AND reporter=$2", target, reporter)
+	return err
+}
+
+// deletePeerStatsByPubkey deletes all peer traffic statistics reported about a peer of a target
+func (d *RVPNDatabase) deletePeerStatsByPubkey(ctx context.Context, target, pubkey string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM peer_stats WHERE target=$1 AND pubkey=$2", target, pubkey)
+	return err
+}
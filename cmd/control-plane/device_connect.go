@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"net"
 	"strconv"
 	"time"
 
@@ -18,8 +19,11 @@ import (
 // jsonRPC handler for client devices
 type jrpcClientHandler struct {
 	heartbeatChan chan int
+	target        string
+	rotation      *keyRotation
 
 	// internal constructs
+	app *app
 	log *zap.Logger
 }
 
@@ -31,6 +35,57 @@ func (h jrpcClientHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req
 		conn.Reply(ctx, req.ID, common.DeviceHeartbeatResponse{
 			Success: true,
 		})
+
+		// record the statistics of the peers of the client, older daemons send heartbeats without them
+		connectionId := h.rotation.getConnection()
+		if req.Params != nil && connectionId != "" {
+			var deviceHeartbeatRequest common.DeviceHeartbeatRequest
+			err := json.Unmarshal(*req.Params, &deviceHeartbeatRequest)
+			if err != nil {
+				h.log.Error("failed to unmarshal deviceheartbeat request params", zap.Error(err))
+				return
+			}
+
+			h.app.recordPeerStats(ctx, h.target, connectionId, deviceHeartbeatRequest.Peers)
+		}
+	case common.RotateKeyMethod:
+		// client reported a new key, prepare the target VPN server before the client switches to it
+		var rotateKeyRequest common.RotateKeyRequest
+		err := json.Unmarshal(*req.Params, &rotateKeyRequest)
+		if err != nil {
+			h.log.Error("failed to unmarshal rotatekey request params", zap.Error(err))
+			conn.Reply(ctx, req.ID, common.RotateKeyResponse{
+				Success: false,
+			})
+			return
+		}
+
+		err = h.app.rotateClientKey(ctx, h.rotation, h.target, rotateKeyRequest.PublicKey)
+		if err != nil {
+			h.log.Error("failed to rotate client key", zap.Error(err))
+			conn.Reply(ctx, req.ID, common.RotateKeyResponse{
+				Success: false,
+			})
+			return
+		}
+
+		conn.Reply(ctx, req.ID, common.RotateKeyResponse{
+			Success: true,
+		})
+	case common.CompleteKeyRotationMethod:
+		// client switched to its new key, remove the old key from the target VPN server
+		err := h.app.completeClientKeyRotation(ctx, h.rotation, h.target)
+		if err != nil {
+			h.log.Error("failed to complete client key rotation", zap.Error(err))
+			conn.Reply(ctx, req.ID, common.CompleteKeyRotationResponse{
+				Success: false,
+			})
+			return
+		}
+
+		conn.Reply(ctx, req.ID, common.CompleteKeyRotationResponse{
+			Success: true,
+		})
 	default:
 		h.log.Info("received unknown jrpc command")
 	}
@@ -43,6 +98,9 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
 	}
 
+	// observed public ip of the client, used as the client endpoint in mesh mode
+	clientPublicIp := requestPublicIp(c)
+
 	handler := websocket.New(func(wc *websocket.Conn) {
 		// TODO: verify that this is the correct way to maintain context in a websocket
 		ctx, cancelFunc := context.WithCancel(context.Background())
@@ -53,8 +111,12 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 
 		// create jrpc connection on top of websocket stream; each connection has its own handler instance
 		heartbeatChan := make(chan int, 2) // buffer 2 heartbeats
+		rotation := &keyRotation{}
 		jrpcConn := jsonrpc2.NewConn(c.Context(), jrpc.NewObjectStream(wc), jrpcClientHandler{
 			heartbeatChan: heartbeatChan,
+			target:        target,
+			rotation:      rotation,
+			app:           a,
 			log:           a.log,
 		})
 
@@ -101,8 +163,22 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 			return
 		}
 
+		// subnets advertised by the client must be approved by a target admin before they are routed
+		advertisedSubnets, err := formatSubnets(clientInformationResponse.Subnets)
+		if err != nil {
+			a.log.Error("failed to parse client advertised subnets", zap.Error(err))
+			return
+		}
+
+		// clients which do not report a listen port cannot be connected to directly in mesh mode
+		clientEndpoint := ""
+		if clientInformationResponse.ListenPort != 0 && clientPublicIp != "" {
+			clientEndpoint = net.JoinHostPort(clientPublicIp, strconv.Itoa(clientInformationResponse.ListenPort))
+		}
+
 		// we must ensure there is a connection for the device
 		appendPeerToVPNServer := false
+		stalePubkey := "" // pubkey of the connection which is no longer used by the device
 		deviceConnection, err := a.db.getConnection(ctx, target, deviceId)
 		if err != nil {
 			a.log.Error("failed to check if connection exists", zap.Error(err))
@@ -114,6 +190,7 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 				// rVPN control plane pubkey is out of sync with client, re-sync control plane (clientResponseOverrides)
 				a.log.Info("device connection pubkey out of sync")
 
+				stalePubkey = deviceConnection.pubkey
 				err = syncConnectionPubkey(ctx, a.db, deviceConnection, clientInformationResponse.PublicKey)
 				if err != nil {
 					a.log.Error("failed to sync connection and device pubkey", zap.Error(err))
@@ -124,8 +201,45 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 
 				// re-sync client to target VPN server by appending new peer
 				appendPeerToVPNServer = true
+			}
+
+			if deviceConnection.advertisedSubnets != advertisedSubnets {
+				// client advertises different subnets, revoke approved subnets which are no longer advertised
+				a.log.Info("device connection advertised subnets changed")
+
+				prevApprovedSubnets := deviceConnection.approvedSubnets
+				deviceConnection, err = syncConnectionSubnets(ctx, a.db, deviceConnection, advertisedSubnets)
+				if err != nil {
+					a.log.Error("failed to sync connection advertised subnets", zap.Error(err))
+					return
+				}
+
+				// re-sync client subnets to target VPN server if approved subnets were revoked
+				if deviceConnection.approvedSubnets != prevApprovedSubnets {
+					appendPeerToVPNServer = true
+				}
+			}
+
+			if deviceConnection.presharedKey == "" {
+				// connection was created before preshared keys, generate one and re-sync the target VPN server
+				deviceConnection, err = syncConnectionPresharedKey(ctx, a.db, deviceConnection)
+				if err != nil {
+					a.log.Error("failed to sync connection preshared key", zap.Error(err))
+					return
+				}
+
+				appendPeerToVPNServer = true
+			}
+
+			if deviceConnection.endpoint != clientEndpoint {
+				// client connected from a different public endpoint, update it for mesh peers
+				err = syncConnectionEndpoint(ctx, a.db, deviceConnection, clientEndpoint)
+				if err != nil {
+					a.log.Error("failed to sync connection endpoint", zap.Error(err))
+					return
+				}
 
-				// TODO: instruct rVPN server to remove old peer
+				deviceConnection.endpoint = clientEndpoint
 			}
 		} else {
 			// device connection does not exist yet, create connection using information from jrpc
@@ -137,15 +251,25 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 				return
 			}
 
+			// each connection has its own preshared key with the target VPN server
+			presharedKey, err := generatePresharedKey()
+			if err != nil {
+				a.log.Error("failed to generate preshared key", zap.Error(err))
+				return
+			}
+
 			// create connection in database
 			newUUID := uuid.New().String()
 			deviceConnection = RVPNConnection{
-				id:         newUUID,
-				target:     target,
-				deviceId:   deviceId,
-				pubkey:     clientInformationResponse.PublicKey,
-				clientIp:   clientIp,
-				clientCidr: clientCidr,
+				id:                newUUID,
+				target:            target,
+				deviceId:          deviceId,
+				pubkey:            clientInformationResponse.PublicKey,
+				clientIp:          clientIp,
+				clientCidr:        clientCidr,
+				advertisedSubnets: advertisedSubnets,
+				endpoint:          clientEndpoint,
+				presharedKey:      presharedKey,
 			}
 			err = createConnection(ctx, a.db, deviceConnection)
 			if err != nil {
@@ -155,6 +279,9 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 
 			// append new client to target VPN server so VPN server knows about client
 			appendPeerToVPNServer = true
+
+			// firewall policies which refer to the device or its user now apply to the new connection
+			a.pushFirewall(target)
 		}
 
 		if appendPeerToVPNServer {
@@ -163,23 +290,50 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 			vpnServerConn := a.connMan.getVPNServerConn(target)
 			if vpnServerConn == nil {
 				a.log.Error("vpn server connection is not alive, cannot add new peer")
-			}
+			} else {
+				connectionRateLimit, err := getConnectionRateLimit(ctx, a.db, deviceConnection)
+				if err != nil {
+					a.log.Error("failed to get connection rate limit", zap.Error(err))
+					return
+				}
 
-			appendVPNPeersRequest := common.AppendVPNPeersRequest{
-				Peers: []common.WireGuardPeer{{
-					PublicKey:   deviceConnection.pubkey,
-					AllowedIP:   deviceConnection.clientIp,
-					AllowedCidr: deviceConnection.clientCidr,
-				}},
-			}
+				appendVPNPeersRequest := common.AppendVPNPeersRequest{
+					Peers: []common.WireGuardPeer{connectionWireGuardPeer(deviceConnection, connectionRateLimit)},
+				}
 
-			var appendVPNPeersResponse common.AppendVPNPeersResponse
-			err = vpnServerConn.Call(ctx, common.AppendVPNPeersMethod, appendVPNPeersRequest, &appendVPNPeersResponse)
-			if err != nil {
-				a.log.Error("failed to call appendvpnpeers via jrpc for new device connect", zap.Error(err))
+				var appendVPNPeersResponse common.AppendVPNPeersResponse
+				err = vpnServerConn.Call(ctx, common.AppendVPNPeersMethod, appendVPNPeersRequest, &appendVPNPeersResponse)
+				if err != nil {
+					a.log.Error("failed to call appendvpnpeers via jrpc for new device connect", zap.Error(err))
+				}
+
+				if stalePubkey != "" {
+					// remove the peer for the pubkey the device no longer uses
+					deleteVPNPeersRequest := common.DeleteVPNPeersRequest{
+						Peers: []common.WireGuardPeer{{PublicKey: stalePubkey}},
+					}
+
+					var deleteVPNPeersResponse common.DeleteVPNPeersResponse
+					err = vpnServerConn.Call(ctx, common.DeleteVPNPeersMethod, deleteVPNPeersRequest, &deleteVPNPeersResponse)
+					if err != nil {
+						a.log.Error("failed to call deletevpnpeers via jrpc for stale device pubkey", zap.Error(err))
+					}
+				}
 			}
 		}
 
+		if stalePubkey != "" {
+			// statistics of the peer for the stale pubkey no longer describe the device
+			a.removePubkeyPeerStats(ctx, target, stalePubkey)
+		}
+
+		// client routes the subnets of the target and the approved subnets of other connections
+		routableSubnets, err := getClientRoutableSubnets(ctx, a.db, rVPNTarget, deviceConnection.id)
+		if err != nil {
+			a.log.Error("failed to get client routable subnets", zap.Error(err))
+			return
+		}
+
 		// device connection is complete, jrpc client to connect to rVPN server
 		intServerVpnPort, err := strconv.Atoi(rVPNTarget.serverPublicVpnPort)
 		if err != nil {
@@ -187,27 +341,89 @@ func (a *app) clientConnect(c *fiber.Ctx) error {
 			return
 		}
 
+		// the client relays packets through the control plane if UDP to the target server is blocked
+		relayToken := a.grantRelayTicket(target, deviceConnection.id)
+		defer a.connMan.removeRelayTicket(relayToken)
+
 		connectServerRequest := common.ConnectServerRequest{
-			ServerPublicKey: rVPNTarget.serverPubkey,
-			ClientPublicKey: deviceConnection.pubkey,
-			ClientIp:        deviceConnection.clientIp,
-			ClientCidr:      deviceConnection.clientCidr,
-			ServerIp:        rVPNTarget.serverPublicIp,
-			ServerPort:      intServerVpnPort,
-			DnsIp:           rVPNTarget.dnsIp,
+			ServerPublicKey:  rVPNTarget.serverPubkey,
+			ClientPublicKey:  deviceConnection.pubkey,
+			PresharedKey:     deviceConnection.presharedKey,
+			ClientIp:         deviceConnection.clientIp,
+			ClientCidr:       deviceConnection.clientCidr,
+			ServerIp:         rVPNTarget.serverPublicIp,
+			ServerPort:       intServerVpnPort,
+			ServerInternalIp: rVPNTarget.serverInternalIp,
+			DnsIp:            rVPNTarget.dnsIp,
+			Subnets:          routableSubnets,
+			RelayToken:       relayToken,
+		}
+
+		// NOTE: the client handles requests in order and its wireguard socket listens on the client port once
+		// connectserver is handled, so the endpoint is discovered and punched while the client waits for a handshake
+		connectServerWaiter, err := jrpcConn.DispatchCall(ctx, common.ConnectServerMethod, connectServerRequest)
+		if err != nil {
+			a.log.Error("failed to call connectserver via jrpc", zap.Error(err))
+			return
+		}
+
+		// discover the public mapping of the client wireguard socket now that it is listening
+		discoveredEndpoint, err := discoverDeviceEndpoint(ctx, jrpcConn, a.stunPort)
+		if err != nil {
+			// the observed public ip and listen port of the client are used instead
+			a.log.Info("failed to discover client endpoint", zap.Error(err))
+		} else if discoveredEndpoint != deviceConnection.endpoint {
+			err = syncConnectionEndpoint(ctx, a.db, deviceConnection, discoveredEndpoint)
+			if err != nil {
+				a.log.Error("failed to sync connection endpoint", zap.Error(err))
+				return
+			}
+
+			deviceConnection.endpoint = discoveredEndpoint
+		}
+
+		if deviceConnection.endpoint != "" {
+			// the client handshake is dropped by NAT in front of the server until the server sends to the client,
+			// the client retries its handshake so the server punching concurrently completes the connection
+			vpnServerConn := a.connMan.getVPNServerConn(target)
+			if vpnServerConn != nil {
+				var punchHoleResponse common.PunchHoleResponse
+				err = vpnServerConn.Call(ctx, common.PunchHoleMethod, common.PunchHoleRequest{Endpoint: deviceConnection.endpoint}, &punchHoleResponse)
+				if err != nil {
+					a.log.Error("failed to call punchhole via jrpc", zap.Error(err))
+				}
+			}
 		}
 
 		var connectServerResponse common.ConnectServerResponse
-		err = jrpcConn.Call(ctx, common.ConnectServerMethod, connectServerRequest, &connectServerResponse)
+		err = connectServerWaiter.Wait(ctx, &connectServerResponse)
 		if err != nil {
 			a.log.Error("failed to call connectserver via jrpc", zap.Error(err))
+			return
 		}
 
-		// TODO: remove debug
-		fmt.Println("issued connect server with following info", connectServerRequest.ServerIp, connectServerRequest.ServerPublicKey, connectServerRequest.ClientPublicKey)
+		if !connectServerResponse.Success {
+			// the client rolled back its tunnel and closes the connection
+			a.log.Info("client failed to connect to target VPN server")
+			return
+		}
+
+		// the client may rotate its key now that its connection is known
+		rotation.setConnection(deviceConnection.id)
 
 		// save the jrpc connection for the rvpn client to the connection manager
-		a.connMan.setVPNClientConn(target, jrpcConn)
+		a.connMan.setVPNClientConn(target, deviceConnection.id, jrpcConn)
+		if rVPNTarget.mesh {
+			// client has left the mesh once the connection is removed, update the remaining mesh peers
+			defer a.pushMeshPeers(target)
+		}
+		defer a.connMan.removeVPNClientConn(target, deviceConnection.id, jrpcConn)
+		defer a.removePeerStats(target, deviceConnection.id)
+
+		if rVPNTarget.mesh {
+			// inform all clients of the target, including this one, of the current mesh peers
+			a.pushMeshPeers(target)
+		}
 
 		// block to keep WebSocket alive (stale timeout of 3 minutes)
 		blockUntilStale(ctx, heartbeatChan, 3*time.Minute)
@@ -229,7 +445,8 @@ func createConnection(ctx context.Context, db *RVPNDatabase, rVPNConnection RVPN
 	}
 
 	// create new connection and save it to the database
-	_, err = db.createConnection(ctx, rVPNConnection.id, rVPNConnection.target, rVPNConnection.deviceId, rVPNConnection.pubkey, rVPNConnection.clientIp, rVPNConnection.clientCidr)
+	_, err = db.createConnection(ctx, rVPNConnection.id, rVPNConnection.target, rVPNConnection.deviceId, rVPNConnection.pubkey,
+		rVPNConnection.clientIp, rVPNConnection.clientCidr, rVPNConnection.advertisedSubnets, rVPNConnection.approvedSubnets, rVPNConnection.endpoint, rVPNConnection.presharedKey)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"net"
 	"strconv"
 	"time"
 
@@ -16,8 +18,11 @@ import (
 // jsonRPC handler for serving devices
 type jrpcServeHandler struct {
 	heartbeatChan chan int
+	target        string
+	rotation      *keyRotation
 
 	// internal constructs
+	app *app
 	log *zap.Logger
 }
 
@@ -29,6 +34,58 @@ func (h jrpcServeHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *
 		conn.Reply(ctx, req.ID, common.DeviceHeartbeatResponse{
 			Success: true,
 		})
+
+		// record the statistics of the peers of the serving node, older daemons send heartbeats without them
+		if req.Params != nil {
+			var deviceHeartbeatRequest common.DeviceHeartbeatRequest
+			err := json.Unmarshal(*req.Params, &deviceHeartbeatRequest)
+			if err != nil {
+				h.log.Error("failed to unmarshal deviceheartbeat request params", zap.Error(err))
+				return
+			}
+
+			h.app.recordPeerStats(ctx, h.target, serverStatsReporter, deviceHeartbeatRequest.Peers)
+		}
+	case common.RotateKeyMethod:
+		// server reported a new key, stage it on the connected clients before the server switches to it
+		var rotateKeyRequest common.RotateKeyRequest
+		err := json.Unmarshal(*req.Params, &rotateKeyRequest)
+		if err != nil {
+			h.log.Error("failed to unmarshal rotatekey request params", zap.Error(err))
+			conn.Reply(ctx, req.ID, common.RotateKeyResponse{
+				Success: false,
+			})
+			return
+		}
+
+		err = h.app.rotateServerKey(ctx, h.rotation, h.target, rotateKeyRequest.PublicKey)
+		if err != nil {
+			h.log.Error("failed to rotate server key", zap.Error(err))
+			conn.Reply(ctx, req.ID, common.RotateKeyResponse{
+				Success: false,
+			})
+			return
+		}
+
+		conn.Reply(ctx, req.ID, common.RotateKeyResponse{
+			Success: true,
+		})
+	case common.CompleteKeyRotationMethod:
+		// server switched to its new key, instruct connected clients to switch to it
+		err := h.app.completeServerKeyRotation(ctx, h.rotation, h.target)
+		if err != nil {
+			h.log.Error("failed to complete server key rotation", zap.Error(err))
+			conn.Reply(ctx, req.ID, common.CompleteKeyRotationResponse{
+				Success: false,
+			})
+			return
+		}
+
+		go h.app.pushServerKey(h.target)
+
+		conn.Reply(ctx, req.ID, common.CompleteKeyRotationResponse{
+			Success: true,
+		})
 	default:
 		h.log.Info("received unknown jrpc command")
 	}
@@ -41,7 +98,7 @@ func (a *app) clientServe(c *fiber.Ctx) error {
 		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
 	}
 
-	clientPublicIP := c.IPs()[0]
+	clientPublicIP := requestPublicIp(c)
 
 	handler := websocket.New(func(wc *websocket.Conn) {
 		// TODO: verify that this is the correct way to maintain context in a websocket
@@ -57,6 +114,9 @@ func (a *app) clientServe(c *fiber.Ctx) error {
 		heartbeatChan := make(chan int)
 		jrpcConn := jsonrpc2.NewConn(c.Context(), jrpc.NewObjectStream(wc), jrpcServeHandler{
 			heartbeatChan: heartbeatChan,
+			target:        target,
+			rotation:      &keyRotation{},
+			app:           a,
 			log:           a.log,
 		})
 
@@ -105,6 +165,17 @@ func (a *app) clientServe(c *fiber.Ctx) error {
 
 		a.log.Info("serve client ip: " + clientPublicIP)
 
+		// the subnets served by the target are advertised to connecting clients, no subnets means all traffic
+		servedSubnets, err := formatSubnets(serveInformationResponse.Subnets)
+		if err != nil {
+			a.log.Error("failed to parse served subnets", zap.Error(err))
+			return
+		}
+
+		if servedSubnets == "" {
+			servedSubnets = "0.0.0.0/0"
+		}
+
 		// TODO: data architecture decision, does VPN server count as a connection? this *could*
 		// simplify distribution of IPs but may introduce other problems
 
@@ -112,6 +183,7 @@ func (a *app) clientServe(c *fiber.Ctx) error {
 		rVPNTarget.serverPubkey = serveInformationResponse.PublicKey
 		rVPNTarget.serverPublicIp = clientPublicIP
 		rVPNTarget.serverPublicVpnPort = serveInformationResponse.PublicVpnPort
+		rVPNTarget.subnets = servedSubnets
 
 		a.db.updateTarget(ctx, target, rVPNTarget)
 
@@ -130,22 +202,28 @@ func (a *app) clientServe(c *fiber.Ctx) error {
 			a.log.Error("failed to get target connections", zap.Error(err))
 		}
 
-		for _, targetConnection := range targetConnections {
-			wireguardPeer := common.WireGuardPeer{
-				PublicKey:   targetConnection.pubkey,
-				AllowedIP:   targetConnection.clientIp,
-				AllowedCidr: targetConnection.clientCidr,
-			}
+		// each connection is limited to the bandwidth of its device
+		rateLimits, err := compileRateLimits(ctx, a.db, target)
+		if err != nil {
+			a.log.Error("failed to compile rate limits", zap.Error(err))
+			return
+		}
 
-			rVPNPeers = append(rVPNPeers, wireguardPeer)
+		for _, targetConnection := range targetConnections {
+			rVPNPeers = append(rVPNPeers, connectionWireGuardPeer(targetConnection, rateLimits[targetConnection.deviceId]))
 		}
 
+		// the server keeps a relay leg open for clients which cannot use UDP
+		relayToken := a.grantRelayTicket(target, "")
+		defer a.connMan.removeRelayTicket(relayToken)
+
 		serveVPNRequest := common.ServeVPNRequest{
 			ServerPublicKey:     rVPNTarget.serverPubkey,
 			ServerInternalIp:    rVPNTarget.serverInternalIp,
 			ServerInternalCidr:  rVPNTarget.serverInternalCidr,
 			ServerPublicVPNPort: intServerVpnPort,
 			Peers:               rVPNPeers,
+			RelayToken:          relayToken,
 		}
 
 		var serveVPNResponse common.ServeVPNResponse
@@ -156,6 +234,30 @@ func (a *app) clientServe(c *fiber.Ctx) error {
 
 		a.log.Info("successfully issued jrpc command to client to serve as VPN server")
 
+		// restrict forwarded traffic with the firewall policies of the target
+		err = updateFirewall(ctx, a.db, jrpcConn, target)
+		if err != nil {
+			a.log.Error("failed to update firewall of serving node", zap.Error(err))
+		}
+
+		// discover the public mapping of the server wireguard socket so servers behind NAT are reachable
+		discoveredEndpoint, err := discoverDeviceEndpoint(ctx, jrpcConn, a.stunPort)
+		if err != nil {
+			// the observed public ip and vpn port of the server are used instead
+			a.log.Info("failed to discover server endpoint", zap.Error(err))
+		} else {
+			serverPublicIp, serverPublicVpnPort, err := net.SplitHostPort(discoveredEndpoint)
+			if err != nil {
+				a.log.Error("failed to parse discovered server endpoint", zap.Error(err))
+			} else {
+				a.log.Info("discovered server endpoint: " + discoveredEndpoint)
+
+				rVPNTarget.serverPublicIp = serverPublicIp
+				rVPNTarget.serverPublicVpnPort = serverPublicVpnPort
+				a.db.updateTarget(ctx, target, rVPNTarget)
+			}
+		}
+
 		// save the jrpc connection for the rvpn server to the connection manager
 		a.connMan.setVPNServerConn(target, jrpcConn)
 
@@ -3,8 +3,15 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/netip"
+	"strings"
 	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redpwn/rvpn/common"
+	"github.com/sourcegraph/jsonrpc2"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // syncConnectionPubkey syncs so that the specified rVPN connection is updated in the database
@@ -12,7 +19,50 @@ func syncConnectionPubkey(ctx context.Context, db *RVPNDatabase, rVPNConnection
 	rVPNConnection.pubkey = pubkey
 
 	_, err := db.updateConnection(ctx, rVPNConnection.id, rVPNConnection.target, rVPNConnection.deviceId,
-		rVPNConnection.pubkey, rVPNConnection.clientIp, rVPNConnection.clientCidr)
+		rVPNConnection.pubkey, rVPNConnection.clientIp, rVPNConnection.clientCidr, rVPNConnection.advertisedSubnets,
+		rVPNConnection.approvedSubnets, rVPNConnection.endpoint, rVPNConnection.presharedKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// syncConnectionSubnets syncs the advertised subnets of a rVPN connection, approved subnets which are no longer
+// advertised are revoked; returns the updated connection
+func syncConnectionSubnets(ctx context.Context, db *RVPNDatabase, rVPNConnection RVPNConnection, advertisedSubnets string) (RVPNConnection, error) {
+	advertisedSubnetSet := make(map[string]struct{})
+	for _, advertisedSubnet := range parseSubnets(advertisedSubnets) {
+		advertisedSubnetSet[advertisedSubnet] = struct{}{}
+	}
+
+	approvedSubnets := []string{}
+	for _, approvedSubnet := range parseSubnets(rVPNConnection.approvedSubnets) {
+		if _, exists := advertisedSubnetSet[approvedSubnet]; exists {
+			approvedSubnets = append(approvedSubnets, approvedSubnet)
+		}
+	}
+
+	rVPNConnection.advertisedSubnets = advertisedSubnets
+	rVPNConnection.approvedSubnets = strings.Join(approvedSubnets, ",")
+
+	_, err := db.updateConnection(ctx, rVPNConnection.id, rVPNConnection.target, rVPNConnection.deviceId,
+		rVPNConnection.pubkey, rVPNConnection.clientIp, rVPNConnection.clientCidr, rVPNConnection.advertisedSubnets,
+		rVPNConnection.approvedSubnets, rVPNConnection.endpoint, rVPNConnection.presharedKey)
+	if err != nil {
+		return rVPNConnection, err
+	}
+
+	return rVPNConnection, nil
+}
+
+// syncConnectionEndpoint syncs the public endpoint of the specified rVPN connection in the database
+func syncConnectionEndpoint(ctx context.Context, db *RVPNDatabase, rVPNConnection RVPNConnection, endpoint string) error {
+	rVPNConnection.endpoint = endpoint
+
+	_, err := db.updateConnection(ctx, rVPNConnection.id, rVPNConnection.target, rVPNConnection.deviceId,
+		rVPNConnection.pubkey, rVPNConnection.clientIp, rVPNConnection.clientCidr, rVPNConnection.advertisedSubnets,
+		rVPNConnection.approvedSubnets, rVPNConnection.endpoint, rVPNConnection.presharedKey)
 	if err != nil {
 		return err
 	}
@@ -20,6 +70,114 @@ func syncConnectionPubkey(ctx context.Context, db *RVPNDatabase, rVPNConnection
 	return nil
 }
 
+// syncConnectionPresharedKey generates a new preshared key for the specified rVPN connection and syncs it in the
+// database, returns the updated connection
+func syncConnectionPresharedKey(ctx context.Context, db *RVPNDatabase, rVPNConnection RVPNConnection) (RVPNConnection, error) {
+	presharedKey, err := generatePresharedKey()
+	if err != nil {
+		return rVPNConnection, err
+	}
+
+	rVPNConnection.presharedKey = presharedKey
+
+	_, err = db.updateConnection(ctx, rVPNConnection.id, rVPNConnection.target, rVPNConnection.deviceId,
+		rVPNConnection.pubkey, rVPNConnection.clientIp, rVPNConnection.clientCidr, rVPNConnection.advertisedSubnets,
+		rVPNConnection.approvedSubnets, rVPNConnection.endpoint, rVPNConnection.presharedKey)
+	if err != nil {
+		return rVPNConnection, err
+	}
+
+	return rVPNConnection, nil
+}
+
+// generatePresharedKey returns a random wireguard preshared key
+func generatePresharedKey() (string, error) {
+	presharedKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+
+	return presharedKey.String(), nil
+}
+
+// discoverDeviceEndpoint instructs a device to discover the public endpoint of its wireguard socket using the
+// STUN responder of the control plane, returns the public endpoint
+func discoverDeviceEndpoint(ctx context.Context, jrpcConn *jsonrpc2.Conn, stunPort int) (string, error) {
+	var discoverEndpointResponse common.DiscoverEndpointResponse
+	err := jrpcConn.Call(ctx, common.DiscoverEndpointMethod, common.DiscoverEndpointRequest{StunPort: stunPort}, &discoverEndpointResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if !discoverEndpointResponse.Success || len(discoverEndpointResponse.Candidates) == 0 {
+		return "", errors.New("device failed to discover endpoint")
+	}
+
+	// the first candidate is the public endpoint learned from the STUN responder
+	publicEndpoint, err := netip.ParseAddrPort(discoverEndpointResponse.Candidates[0])
+	if err != nil {
+		return "", fmt.Errorf("device returned invalid endpoint: %w", err)
+	}
+
+	return publicEndpoint.String(), nil
+}
+
+// connectionWireGuardPeer returns the WireGuard peer for the target VPN server which represents a rVPN connection
+// with the bandwidth limit of its device
+func connectionWireGuardPeer(rVPNConnection RVPNConnection, limit rateLimit) common.WireGuardPeer {
+	return common.WireGuardPeer{
+		PublicKey:    rVPNConnection.pubkey,
+		PresharedKey: rVPNConnection.presharedKey,
+		AllowedIP:    rVPNConnection.clientIp,
+		AllowedCidr:  "/32",
+		Subnets:      parseSubnets(rVPNConnection.approvedSubnets),
+		UploadMbps:   limit.uploadMbps,
+		DownloadMbps: limit.downloadMbps,
+	}
+}
+
+// getConnectionRateLimit returns the compiled bandwidth limit of the device of a rVPN connection
+func getConnectionRateLimit(ctx context.Context, db *RVPNDatabase, rVPNConnection RVPNConnection) (rateLimit, error) {
+	rateLimits, err := compileRateLimits(ctx, db, rVPNConnection.target)
+	if err != nil {
+		return rateLimit{}, err
+	}
+
+	return rateLimits[rVPNConnection.deviceId], nil
+}
+
+// getClientRoutableSubnets returns the subnets a client connection should route through the target VPN server,
+// which are the subnets served by the target and the approved subnets of all other connections
+func getClientRoutableSubnets(ctx context.Context, db *RVPNDatabase, rVPNTarget *RVPNTarget, connectionId string) ([]string, error) {
+	routableSubnets := parseSubnets(rVPNTarget.subnets)
+
+	targetConnections, err := db.getConnectionsByTarget(ctx, rVPNTarget.name)
+	if err != nil {
+		return nil, err
+	}
+
+	routableSubnetSet := make(map[string]struct{})
+	for _, routableSubnet := range routableSubnets {
+		routableSubnetSet[routableSubnet] = struct{}{}
+	}
+
+	for _, targetConnection := range targetConnections {
+		if targetConnection.id == connectionId {
+			// a client does not route its own subnets through the target VPN server
+			continue
+		}
+
+		for _, approvedSubnet := range parseSubnets(targetConnection.approvedSubnets) {
+			if _, exists := routableSubnetSet[approvedSubnet]; !exists {
+				routableSubnetSet[approvedSubnet] = struct{}{}
+				routableSubnets = append(routableSubnets, approvedSubnet)
+			}
+		}
+	}
+
+	return routableSubnets, nil
+}
+
 // targetServerAlive returns if the target server a device is connecting to is alive
 func targetServerAlive(rVPNTarget *RVPNTarget, connMan *ConnectionManager) bool {
 	if rVPNTarget == nil {
@@ -112,3 +270,52 @@ func blockUntilStale(ctx context.Context, heartbeatChan chan int, staleTimeout t
 		}
 	}
 }
+
+// parseSubnets parses a comma separated list of subnets as stored in the database
+func parseSubnets(subnets string) []string {
+	parsedSubnets := []string{}
+	for _, subnet := range strings.Split(subnets, ",") {
+		subnet = strings.TrimSpace(subnet)
+		if subnet != "" {
+			parsedSubnets = append(parsedSubnets, subnet)
+		}
+	}
+
+	return parsedSubnets
+}
+
+// formatSubnets validates subnets and formats them to be stored in the database
+func formatSubnets(subnets []string) (string, error) {
+	formattedSubnets := []string{}
+	for _, subnet := range subnets {
+		subnetPrefix, err := netip.ParsePrefix(strings.TrimSpace(subnet))
+		if err != nil {
+			return "", fmt.Errorf("invalid subnet %s: %w", subnet, err)
+		}
+
+		formattedSubnets = append(formattedSubnets, subnetPrefix.Masked().String())
+	}
+
+	return strings.Join(formattedSubnets, ","), nil
+}
+
+// requestPublicIp returns the public ip of the requester, the forwarded ip is only used if the request comes from a
+// trusted proxy since anyone else can set X-Forwarded-For
+// NOTE: the last forwarded ip is the one the trusted proxy saw, earlier ips are sent by the requester and may be forged
+func requestPublicIp(c *fiber.Ctx) string {
+	if !c.IsProxyTrusted() {
+		return c.IP()
+	}
+
+	forwardedIps := c.IPs()
+	if len(forwardedIps) == 0 {
+		return c.IP()
+	}
+
+	forwardedIp, err := netip.ParseAddr(forwardedIps[len(forwardedIps)-1])
+	if err == nil {
+		return forwardedIp.String()
+	}
+
+	return c.IP()
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redpwn/rvpn/common"
+	"github.com/sourcegraph/jsonrpc2"
+	"go.uber.org/zap"
+)
+
+/* Returns firewall policies of a target */
+func (a *app) getPolicies(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	targetPolicies, err := a.db.getPoliciesByTarget(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get policies database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	ret := make(ListPoliciesResponse, 0, len(targetPolicies))
+	for _, targetPolicy := range targetPolicies {
+		ret = append(ret, Policy{
+			Id:                 targetPolicy.id,
+			SourceUsers:        parseList(targetPolicy.sourceUsers),
+			SourceDevices:      parseList(targetPolicy.sourceDevices),
+			DestinationSubnets: parseSubnets(targetPolicy.destinationSubnets),
+			DestinationPeers:   parseList(targetPolicy.destinationPeers),
+			Protocol:           targetPolicy.protocol,
+			Ports:              parseList(targetPolicy.ports),
+		})
+	}
+
+	return c.Status(200).JSON(ret)
+}
+
+/* Creates or replaces a firewall policy of a target */
+func (a *app) setPolicy(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	policyId := c.Params("id")
+	if policyId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	var setPolicyInfo SetPolicyRequest
+	if err := c.BodyParser(&setPolicyInfo); err != nil {
+		return c.Status(400).JSON(ErrorResponse("invalid request body"))
+	}
+
+	rVPNTarget, err := a.db.getTargetByName(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get target database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if rVPNTarget == nil {
+		return c.Status(404).JSON(ErrorResponse("target does not exist"))
+	}
+
+	if rVPNTarget.mesh {
+		// traffic between mesh peers does not pass the firewall of the serving node
+		return c.Status(409).JSON(ErrorResponse("firewall policies cannot be set on a target in mesh mode, " +
+			"disable mesh mode first"))
+	}
+
+	destinationSubnets, err := formatSubnets(setPolicyInfo.DestinationSubnets)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse(err.Error()))
+	}
+
+	protocol, ports, err := formatPorts(setPolicyInfo.Protocol, setPolicyInfo.Ports)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse(err.Error()))
+	}
+
+	_, err = a.db.setPolicy(c.Context(), policyId, target, formatList(setPolicyInfo.SourceUsers), formatList(setPolicyInfo.SourceDevices),
+		destinationSubnets, formatList(setPolicyInfo.DestinationPeers), protocol, ports)
+	if err != nil {
+		a.log.Error("something went wrong with set policy database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	// enforce the updated policies on the serving node
+	a.pushFirewall(target)
+
+	return c.Status(200).SendString("successfully set policy")
+}
+
+/* Deletes a firewall policy of a target */
+func (a *app) deletePolicy(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	policyId := c.Params("id")
+	if policyId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	deleted, err := a.db.deletePolicy(c.Context(), target, policyId)
+	if err != nil {
+		a.log.Error("something went wrong with delete policy database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !deleted {
+		return c.Status(404).JSON(ErrorResponse("policy does not exist"))
+	}
+
+	// enforce the remaining policies on the serving node
+	a.pushFirewall(target)
+
+	return c.Status(200).SendString("successfully deleted policy")
+}
+
+// compileFirewall compiles the firewall policies of a target into rules of tunnel ips and subnets, returns whether the
+// firewall is enabled which is the case if the target has any policies
+func compileFirewall(ctx context.Context, db *RVPNDatabase, target string) (bool, []common.FirewallRule, error) {
+	targetPolicies, err := db.getPoliciesByTarget(ctx, target)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if len(targetPolicies) == 0 {
+		// targets without policies allow all traffic
+		return false, nil, nil
+	}
+
+	targetConnections, err := db.getConnectionsByTarget(ctx, target)
+	if err != nil {
+		return false, nil, err
+	}
+
+	connectionIpsByDevice := make(map[string]string)
+	connectionIpsById := make(map[string]string)
+	for _, targetConnection := range targetConnections {
+		connectionIpsByDevice[targetConnection.deviceId] = targetConnection.clientIp + "/32"
+		connectionIpsById[targetConnection.id] = targetConnection.clientIp + "/32"
+	}
+
+	firewallRules := []common.FirewallRule{}
+	for _, targetPolicy := range targetPolicies {
+		sourceDevices := parseList(targetPolicy.sourceDevices)
+		for _, sourceUser := range parseList(targetPolicy.sourceUsers) {
+			userDevices, err := db.getDeviceIdsByPrincipal(ctx, sourceUser)
+			if err != nil {
+				return false, nil, err
+			}
+
+			sourceDevices = append(sourceDevices, userDevices...)
+		}
+
+		sources := []string{}
+		for _, sourceDevice := range sourceDevices {
+			if connectionIp, exists := connectionIpsByDevice[sourceDevice]; exists {
+				sources = append(sources, connectionIp)
+			}
+		}
+
+		destinations := parseSubnets(targetPolicy.destinationSubnets)
+		for _, destinationPeer := range parseList(targetPolicy.destinationPeers) {
+			if connectionIp, exists := connectionIpsById[destinationPeer]; exists {
+				destinations = append(destinations, connectionIp)
+			}
+		}
+
+		// NOTE: an empty list matches any, so a policy whose sources or destinations have no connections matches nothing
+		if len(sources) == 0 && targetPolicy.sourceUsers+targetPolicy.sourceDevices != "" {
+			continue
+		}
+
+		if len(destinations) == 0 && targetPolicy.destinationSubnets+targetPolicy.destinationPeers != "" {
+			continue
+		}
+
+		firewallRules = append(firewallRules, common.FirewallRule{
+			Sources:      sources,
+			Destinations: destinations,
+			Protocol:     targetPolicy.protocol,
+			Ports:        parseList(targetPolicy.ports),
+		})
+	}
+
+	return true, firewallRules, nil
+}
+
+// pushFirewall pushes the compiled firewall policies of a target to the serving node of the target
+func (a *app) pushFirewall(target string) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	vpnServerConn := a.connMan.getVPNServerConn(target)
+	if vpnServerConn == nil {
+		// the policies are pushed once the target is served
+		return
+	}
+
+	err := updateFirewall(ctx, a.db, vpnServerConn, target)
+	if err != nil {
+		a.log.Error("failed to update firewall of serving node", zap.Error(err))
+	}
+}
+
+// updateFirewall instructs a serving node to enforce the compiled firewall policies of the target
+func updateFirewall(ctx context.Context, db *RVPNDatabase, jrpcConn *jsonrpc2.Conn, target string) error {
+	enabled, firewallRules, err := compileFirewall(ctx, db, target)
+	if err != nil {
+		return fmt.Errorf("failed to compile firewall policies: %w", err)
+	}
+
+	updateFirewallRequest := common.UpdateFirewallRequest{
+		Enabled: enabled,
+		Rules:   firewallRules,
+	}
+
+	var updateFirewallResponse common.UpdateFirewallResponse
+	err = jrpcConn.Call(ctx, common.UpdateFirewallMethod, updateFirewallRequest, &updateFirewallResponse)
+	if err != nil {
+		return fmt.Errorf("failed to call updatefirewall via jrpc: %w", err)
+	}
+
+	if !updateFirewallResponse.Success {
+		return errors.New("serving node failed to update firewall")
+	}
+
+	return nil
+}
+
+// formatPorts validates the protocol and ports of a firewall policy and formats them to be stored in the database
+func formatPorts(protocol string, ports []string) (string, string, error) {
+	protocol = strings.ToLower(strings.TrimSpace(protocol))
+	switch protocol {
+	case "", "tcp", "udp", "icmp":
+	default:
+		return "", "", fmt.Errorf("invalid protocol %s", protocol)
+	}
+
+	if len(ports) > 0 && protocol != "tcp" && protocol != "udp" {
+		return "", "", errors.New("ports require protocol tcp or udp")
+	}
+
+	formattedPorts := []string{}
+	for _, port := range ports {
+		port = strings.TrimSpace(port)
+
+		portRange := strings.SplitN(port, "-", 2)
+		portRangeStart, err := parsePort(portRange[0])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid port %s: %w", port, err)
+		}
+
+		portRangeEnd := portRangeStart
+		if len(portRange) == 2 {
+			portRangeEnd, err = parsePort(portRange[1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid port %s: %w", port, err)
+			}
+		}
+
+		if portRangeEnd < portRangeStart {
+			return "", "", fmt.Errorf("invalid port range %s", port)
+		}
+
+		if portRangeStart == portRangeEnd {
+			formattedPorts = append(formattedPorts, strconv.Itoa(portRangeStart))
+		} else {
+			formattedPorts = append(formattedPorts, strconv.Itoa(portRangeStart)+"-"+strconv.Itoa(portRangeEnd))
+		}
+	}
+
+	return protocol, strings.Join(formattedPorts, ","), nil
+}
+
+// parsePort parses a port number
+func parsePort(port string) (int, error) {
+	parsedPort, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, err
+	}
+
+	if parsedPort < 1 || parsedPort > 65535 {
+		return 0, errors.New("port out of range")
+	}
+
+	return parsedPort, nil
+}
+
+// parseList parses a comma separated list as stored in the database
+func parseList(list string) []string {
+	return parseSubnets(list)
+}
+
+// formatList formats a list to be stored in the database comma separated
+func formatList(list []string) string {
+	formattedList := []string{}
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item != "" && !strings.Contains(item, ",") {
+			formattedList = append(formattedList, item)
+		}
+	}
+
+	return strings.Join(formattedList, ",")
+}
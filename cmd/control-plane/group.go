@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+/* Returns groups of a target */
+func (a *app) getGroups(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	targetGroups, err := a.db.getGroupsByTarget(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get groups database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	ret := make(ListGroupsResponse, 0, len(targetGroups))
+	for _, targetGroup := range targetGroups {
+		ret = append(ret, Group{
+			Id:      targetGroup.id,
+			Users:   parseList(targetGroup.users),
+			Devices: parseList(targetGroup.devices),
+		})
+	}
+
+	return c.Status(200).JSON(ret)
+}
+
+/* Creates or replaces a group of a target */
+func (a *app) setGroup(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	groupId := c.Params("id")
+	if groupId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	var setGroupInfo SetGroupRequest
+	if err := c.BodyParser(&setGroupInfo); err != nil {
+		return c.Status(400).JSON(ErrorResponse("invalid request body"))
+	}
+
+	_, err = a.db.setGroup(c.Context(), groupId, target, formatList(setGroupInfo.Users), formatList(setGroupInfo.Devices))
+	if err != nil {
+		a.log.Error("something went wrong with set group database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	// limits of the group apply to its new members
+	a.pushRateLimits(target)
+
+	return c.Status(200).SendString("successfully set group")
+}
+
+/* Deletes a group of a target */
+func (a *app) deleteGroup(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	groupId := c.Params("id")
+	if groupId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	deleted, err := a.db.deleteGroup(c.Context(), target, groupId)
+	if err != nil {
+		a.log.Error("something went wrong with delete group database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !deleted {
+		return c.Status(404).JSON(ErrorResponse("group does not exist"))
+	}
+
+	// limits of the group no longer apply to its members
+	a.pushRateLimits(target)
+
+	return c.Status(200).SendString("successfully deleted group")
+}
@@ -18,6 +18,11 @@ type config struct {
 	BaseURL     string `env:"BASE_URL"`
 	OauthId     string `env:"OAUTH_ID"`
 	OauthSecret string `env:"OAUTH_SECRET"`
+	StunPort    int    `env:"STUN_PORT" envDefault:"3478"`
+
+	// TrustedProxies are the ips or cidrs of the reverse proxies in front of the control plane, X-Forwarded-For is
+	// ignored for requests from anyone else
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
 }
 
 type app struct {
@@ -29,6 +34,7 @@ type app struct {
 	baseURL     string
 	oauthId     string
 	oauthSecret string
+	stunPort    int
 }
 
 func upgradeWsMiddlware(c *fiber.Ctx) error {
@@ -73,9 +79,13 @@ func main() {
 		baseURL:     cfg.BaseURL,
 		oauthId:     cfg.OauthId,
 		oauthSecret: cfg.OauthSecret,
+		stunPort:    cfg.StunPort,
 	}
 
-	r := fiber.New()
+	r := fiber.New(fiber.Config{
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.TrustedProxies,
+	})
 
 	r.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("will send web client from here")
@@ -88,14 +98,31 @@ func main() {
 	v1.Get("/target", a.AuthUserMiddleware, a.getTargets)
 	v1.Put("/target/:target", a.AuthUserMiddleware, a.createTarget)
 	v1.Post("/target/:target/register_device", a.AuthUserMiddleware, a.registerDevice)
+	v1.Get("/target/:target/connections", a.AuthUserMiddleware, a.getConnections)
+	v1.Get("/target/:target/routes", a.AuthUserMiddleware, a.getRoutes)
+	v1.Put("/target/:target/routes/:id", a.AuthUserMiddleware, a.approveRoutes)
+	v1.Put("/target/:target/mesh", a.AuthUserMiddleware, a.setMesh)
+	v1.Get("/target/:target/policies", a.AuthUserMiddleware, a.getPolicies)
+	v1.Put("/target/:target/policies/:id", a.AuthUserMiddleware, a.setPolicy)
+	v1.Delete("/target/:target/policies/:id", a.AuthUserMiddleware, a.deletePolicy)
+	v1.Get("/target/:target/rate_limits", a.AuthUserMiddleware, a.getRateLimits)
+	v1.Put("/target/:target/rate_limits/:id", a.AuthUserMiddleware, a.setRateLimit)
+	v1.Delete("/target/:target/rate_limits/:id", a.AuthUserMiddleware, a.deleteRateLimit)
+	v1.Get("/target/:target/groups", a.AuthUserMiddleware, a.getGroups)
+	v1.Put("/target/:target/groups/:id", a.AuthUserMiddleware, a.setGroup)
+	v1.Delete("/target/:target/groups/:id", a.AuthUserMiddleware, a.deleteGroup)
 
 	// websocket routes
 	v1.Get("/target/:target/serve", upgradeWsMiddlware, a.clientServe)
 	v1.Get("/target/:target/connect", upgradeWsMiddlware, a.clientConnect)
+	v1.Get("/target/:target/relay", upgradeWsMiddlware, a.clientRelay)
 
 	// webapp login route
 	v1.Get("/auth/login", a.oauthLogin)
 
+	// STUN responder for endpoint discovery of devices behind NAT
+	go a.serveStun(a.stunPort)
+
 	log.Info("control-plane started")
 	r.Listen(":8080")
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redpwn/rvpn/common"
+	"go.uber.org/zap"
+)
+
+/* Enables or disables mesh mode on a target */
+func (a *app) setMesh(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	var setMeshInfo SetMeshRequest
+	if err := c.BodyParser(&setMeshInfo); err != nil {
+		return c.Status(400).JSON(ErrorResponse("invalid request body"))
+	}
+
+	rVPNTarget, err := a.db.getTargetByName(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get target database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if rVPNTarget == nil {
+		return c.Status(404).JSON(ErrorResponse("target does not exist"))
+	}
+
+	if setMeshInfo.Enabled {
+		// NOTE: traffic between mesh peers does not pass the firewall of the serving node, so mesh would bypass the
+		// policies of the target
+		targetPolicies, err := a.db.getPoliciesByTarget(c.Context(), target)
+		if err != nil {
+			a.log.Error("something went wrong with get policies database query", zap.Error(err))
+			return c.Status(500).JSON(ErrorResponse("something went wrong"))
+		}
+
+		if len(targetPolicies) > 0 {
+			return c.Status(409).JSON(ErrorResponse("mesh mode cannot be enabled on a target with firewall policies, " +
+				"traffic between mesh peers bypasses the firewall"))
+		}
+	}
+
+	rVPNTarget.mesh = setMeshInfo.Enabled
+	_, err = a.db.updateTarget(c.Context(), target, rVPNTarget)
+	if err != nil {
+		a.log.Error("something went wrong with update target database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	// push mesh peers to connected clients, clients remove their mesh peers if mesh was disabled
+	a.pushMeshPeers(target)
+
+	return c.Status(200).SendString("successfully updated mesh mode")
+}
+
+// pushMeshPeers pushes the other online clients of a target to each connected client as mesh peers
+// NOTE: if mesh mode is disabled on the target, clients are sent no mesh peers
+func (a *app) pushMeshPeers(target string) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	rVPNTarget, err := a.db.getTargetByName(ctx, target)
+	if err != nil || rVPNTarget == nil {
+		a.log.Error("failed to get target by name for mesh peers", zap.Error(err))
+		return
+	}
+
+	vpnClientConns := a.connMan.getVPNClientConn(target)
+
+	// NOTE: targets which got firewall policies before mesh was refused for them keep routing through the serving node
+	targetPolicies, err := a.db.getPoliciesByTarget(ctx, target)
+	if err != nil {
+		a.log.Error("failed to get policies by target for mesh peers", zap.Error(err))
+		return
+	}
+
+	meshPeers := make(map[string]common.WireGuardPeer)
+	if rVPNTarget.mesh && len(targetPolicies) == 0 {
+		targetConnections, err := a.db.getConnectionsByTarget(ctx, target)
+		if err != nil {
+			a.log.Error("failed to get connections by target for mesh peers", zap.Error(err))
+			return
+		}
+
+		for _, targetConnection := range targetConnections {
+			if _, online := vpnClientConns[targetConnection.id]; !online || targetConnection.endpoint == "" {
+				// only clients which are connected and have a known endpoint are mesh peers
+				continue
+			}
+
+			meshPeers[targetConnection.id] = common.WireGuardPeer{
+				PublicKey:   targetConnection.pubkey,
+				AllowedIP:   targetConnection.clientIp,
+				AllowedCidr: "/32",
+				Endpoint:    targetConnection.endpoint,
+			}
+		}
+	}
+
+	for connectionId, vpnClientConn := range vpnClientConns {
+		updateMeshPeersRequest := common.UpdateMeshPeersRequest{
+			Peers: []common.WireGuardPeer{},
+		}
+
+		for meshConnectionId, meshPeer := range meshPeers {
+			if meshConnectionId == connectionId {
+				// a client is not a mesh peer of itself
+				continue
+			}
+
+			updateMeshPeersRequest.Peers = append(updateMeshPeersRequest.Peers, meshPeer)
+		}
+
+		var updateMeshPeersResponse common.UpdateMeshPeersResponse
+		err = vpnClientConn.Call(ctx, common.UpdateMeshPeersMethod, updateMeshPeersRequest, &updateMeshPeersResponse)
+		if err != nil {
+			a.log.Error("failed to call updatemeshpeers via jrpc", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redpwn/rvpn/common"
+	"github.com/sourcegraph/jsonrpc2"
+	"go.uber.org/zap"
+)
+
+// rateLimit is the compiled bandwidth limit of a device, zero means unlimited
+type rateLimit struct {
+	uploadMbps   int
+	downloadMbps int
+}
+
+/* Returns bandwidth limits of a target */
+func (a *app) getRateLimits(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	targetRateLimits, err := a.db.getRateLimitsByTarget(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get rate limits database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	ret := make(ListRateLimitsResponse, 0, len(targetRateLimits))
+	for _, targetRateLimit := range targetRateLimits {
+		ret = append(ret, RateLimit{
+			Id:           targetRateLimit.id,
+			Users:        parseList(targetRateLimit.users),
+			Groups:       parseList(targetRateLimit.groups),
+			Devices:      parseList(targetRateLimit.devices),
+			UploadMbps:   targetRateLimit.uploadMbps,
+			DownloadMbps: targetRateLimit.downloadMbps,
+		})
+	}
+
+	return c.Status(200).JSON(ret)
+}
+
+/* Creates or replaces a bandwidth limit of a target */
+func (a *app) setRateLimit(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	rateLimitId := c.Params("id")
+	if rateLimitId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	var setRateLimitInfo SetRateLimitRequest
+	if err := c.BodyParser(&setRateLimitInfo); err != nil {
+		return c.Status(400).JSON(ErrorResponse("invalid request body"))
+	}
+
+	if setRateLimitInfo.UploadMbps < 0 || setRateLimitInfo.DownloadMbps < 0 {
+		return c.Status(400).JSON(ErrorResponse("rates must not be negative"))
+	}
+
+	if len(setRateLimitInfo.Users) == 0 && len(setRateLimitInfo.Groups) == 0 && len(setRateLimitInfo.Devices) == 0 {
+		return c.Status(400).JSON(ErrorResponse("users, groups or devices must not be empty"))
+	}
+
+	_, err = a.db.setRateLimit(c.Context(), rateLimitId, target, formatList(setRateLimitInfo.Users), formatList(setRateLimitInfo.Groups),
+		formatList(setRateLimitInfo.Devices), setRateLimitInfo.UploadMbps, setRateLimitInfo.DownloadMbps)
+	if err != nil {
+		a.log.Error("something went wrong with set rate limit database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	// enforce the updated limits on the serving node
+	a.pushRateLimits(target)
+
+	return c.Status(200).SendString("successfully set rate limit")
+}
+
+/* Deletes a bandwidth limit of a target */
+func (a *app) deleteRateLimit(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	rateLimitId := c.Params("id")
+	if rateLimitId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	deleted, err := a.db.deleteRateLimit(c.Context(), target, rateLimitId)
+	if err != nil {
+		a.log.Error("something went wrong with delete rate limit database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !deleted {
+		return c.Status(404).JSON(ErrorResponse("rate limit does not exist"))
+	}
+
+	// enforce the remaining limits on the serving node
+	a.pushRateLimits(target)
+
+	return c.Status(200).SendString("successfully deleted rate limit")
+}
+
+// compileRateLimits compiles the bandwidth limits of a target into limits by device id, see compileTargetRateLimits
+func compileRateLimits(ctx context.Context, db *RVPNDatabase, target string) (map[string]rateLimit, error) {
+	targetRateLimits, err := db.getRateLimitsByTarget(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	targetGroups, err := db.getGroupsByTarget(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the devices of every user a limit or group lists
+	users := []string{}
+	for _, targetRateLimit := range targetRateLimits {
+		users = append(users, parseList(targetRateLimit.users)...)
+	}
+	for _, targetGroup := range targetGroups {
+		users = append(users, parseList(targetGroup.users)...)
+	}
+
+	userDevices := make(map[string][]string)
+	for _, user := range users {
+		if _, ok := userDevices[user]; ok {
+			continue
+		}
+
+		userDevices[user], err = db.getDeviceIdsByPrincipal(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return compileTargetRateLimits(targetRateLimits, targetGroups, userDevices), nil
+}
+
+// compileTargetRateLimits compiles bandwidth limits into limits by device id, limits which list a device take
+// precedence over limits of its groups, which take precedence over limits of its user; the lowest rate wins if several
+// limits of the same kind apply
+func compileTargetRateLimits(targetRateLimits []RVPNRateLimit, targetGroups []RVPNGroup, userDevices map[string][]string) map[string]rateLimit {
+	groupDevices := make(map[string][]string)
+	for _, targetGroup := range targetGroups {
+		members := parseList(targetGroup.devices)
+		for _, user := range parseList(targetGroup.users) {
+			members = append(members, userDevices[user]...)
+		}
+
+		groupDevices[targetGroup.id] = members
+	}
+
+	userRateLimits := make(map[string]rateLimit)
+	groupRateLimits := make(map[string]rateLimit)
+	deviceRateLimits := make(map[string]rateLimit)
+	for _, targetRateLimit := range targetRateLimits {
+		limit := rateLimit{
+			uploadMbps:   targetRateLimit.uploadMbps,
+			downloadMbps: targetRateLimit.downloadMbps,
+		}
+
+		for _, user := range parseList(targetRateLimit.users) {
+			for _, userDevice := range userDevices[user] {
+				userRateLimits[userDevice] = lowerRateLimit(userRateLimits[userDevice], limit)
+			}
+		}
+
+		for _, group := range parseList(targetRateLimit.groups) {
+			for _, groupDevice := range groupDevices[group] {
+				groupRateLimits[groupDevice] = lowerRateLimit(groupRateLimits[groupDevice], limit)
+			}
+		}
+
+		for _, device := range parseList(targetRateLimit.devices) {
+			deviceRateLimits[device] = lowerRateLimit(deviceRateLimits[device], limit)
+		}
+	}
+
+	for device, limit := range groupRateLimits {
+		userRateLimits[device] = limit
+	}
+
+	for device, limit := range deviceRateLimits {
+		userRateLimits[device] = limit
+	}
+
+	return userRateLimits
+}
+
+// lowerRateLimit returns the lower rate of both limits in each direction, zero is unlimited
+func lowerRateLimit(a, b rateLimit) rateLimit {
+	lowerRate := func(x, y int) int {
+		if x == 0 || (y != 0 && y < x) {
+			return y
+		}
+
+		return x
+	}
+
+	return rateLimit{
+		uploadMbps:   lowerRate(a.uploadMbps, b.uploadMbps),
+		downloadMbps: lowerRate(a.downloadMbps, b.downloadMbps),
+	}
+}
+
+// pushRateLimits pushes the compiled bandwidth limits of all connections of a target to the serving node of the target
+func (a *app) pushRateLimits(target string) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	vpnServerConn := a.connMan.getVPNServerConn(target)
+	if vpnServerConn == nil {
+		// the limits are pushed with the peers once the target is served
+		return
+	}
+
+	err := updateRateLimits(ctx, a.db, vpnServerConn, target)
+	if err != nil {
+		a.log.Error("failed to update rate limits of serving node", zap.Error(err))
+	}
+}
+
+// updateRateLimits instructs a serving node to enforce the compiled bandwidth limits on all connections of the target
+func updateRateLimits(ctx context.Context, db *RVPNDatabase, jrpcConn *jsonrpc2.Conn, target string) error {
+	rateLimits, err := compileRateLimits(ctx, db, target)
+	if err != nil {
+		return fmt.Errorf("failed to compile rate limits: %w", err)
+	}
+
+	targetConnections, err := db.getConnectionsByTarget(ctx, target)
+	if err != nil {
+		return fmt.Errorf("failed to get target connections: %w", err)
+	}
+
+	// NOTE: appending existing peers replaces their limits
+	appendVPNPeersRequest := common.AppendVPNPeersRequest{
+		Peers: []common.WireGuardPeer{},
+	}
+
+	for _, targetConnection := range targetConnections {
+		appendVPNPeersRequest.Peers = append(appendVPNPeersRequest.Peers,
+			connectionWireGuardPeer(targetConnection, rateLimits[targetConnection.deviceId]))
+	}
+
+	var appendVPNPeersResponse common.AppendVPNPeersResponse
+	err = jrpcConn.Call(ctx, common.AppendVPNPeersMethod, appendVPNPeersRequest, &appendVPNPeersResponse)
+	if err != nil {
+		return fmt.Errorf("failed to call appendvpnpeers via jrpc: %w", err)
+	}
+
+	if !appendVPNPeersResponse.Success {
+		return errors.New("serving node failed to append peers")
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLowerRateLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b rateLimit
+		want rateLimit
+	}{
+		{
+			name: "both unlimited",
+			want: rateLimit{},
+		},
+		{
+			name: "unlimited and limited",
+			a:    rateLimit{},
+			b:    rateLimit{uploadMbps: 10, downloadMbps: 20},
+			want: rateLimit{uploadMbps: 10, downloadMbps: 20},
+		},
+		{
+			name: "limited and unlimited",
+			a:    rateLimit{uploadMbps: 10, downloadMbps: 20},
+			b:    rateLimit{},
+			want: rateLimit{uploadMbps: 10, downloadMbps: 20},
+		},
+		{
+			name: "lower rate in each direction",
+			a:    rateLimit{uploadMbps: 10, downloadMbps: 50},
+			b:    rateLimit{uploadMbps: 30, downloadMbps: 20},
+			want: rateLimit{uploadMbps: 10, downloadMbps: 20},
+		},
+		{
+			name: "one direction unlimited",
+			a:    rateLimit{uploadMbps: 10},
+			b:    rateLimit{downloadMbps: 20},
+			want: rateLimit{uploadMbps: 10, downloadMbps: 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lowerRateLimit(tt.a, tt.b); got != tt.want {
+				t.Errorf("lowerRateLimit(%+v, %+v) = %+v, want %+v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileTargetRateLimits(t *testing.T) {
+	userDevices := map[string][]string{
+		"alice": {"alice-laptop", "alice-phone"},
+		"bob":   {"bob-laptop"},
+	}
+
+	tests := []struct {
+		name       string
+		rateLimits []RVPNRateLimit
+		groups     []RVPNGroup
+		want       map[string]rateLimit
+	}{
+		{
+			name: "no limits",
+			want: map[string]rateLimit{},
+		},
+		{
+			name: "user limit applies to all devices of the user",
+			rateLimits: []RVPNRateLimit{
+				{id: "users", users: "alice", uploadMbps: 10, downloadMbps: 20},
+			},
+			want: map[string]rateLimit{
+				"alice-laptop": {uploadMbps: 10, downloadMbps: 20},
+				"alice-phone":  {uploadMbps: 10, downloadMbps: 20},
+			},
+		},
+		{
+			name: "group limit applies to member users and devices",
+			rateLimits: []RVPNRateLimit{
+				{id: "guests", groups: "guests", uploadMbps: 5, downloadMbps: 5},
+			},
+			groups: []RVPNGroup{
+				{id: "guests", users: "bob", devices: "printer"},
+			},
+			want: map[string]rateLimit{
+				"bob-laptop": {uploadMbps: 5, downloadMbps: 5},
+				"printer":    {uploadMbps: 5, downloadMbps: 5},
+			},
+		},
+		{
+			name: "device limit takes precedence over group and user limits",
+			rateLimits: []RVPNRateLimit{
+				{id: "users", users: "alice", uploadMbps: 10, downloadMbps: 10},
+				{id: "phones", groups: "phones", uploadMbps: 2, downloadMbps: 2},
+				{id: "laptop", devices: "alice-laptop", uploadMbps: 100, downloadMbps: 100},
+			},
+			groups: []RVPNGroup{
+				{id: "phones", devices: "alice-phone,alice-laptop"},
+			},
+			want: map[string]rateLimit{
+				"alice-laptop": {uploadMbps: 100, downloadMbps: 100},
+				"alice-phone":  {uploadMbps: 2, downloadMbps: 2},
+			},
+		},
+		{
+			name: "group limit takes precedence over user limit",
+			rateLimits: []RVPNRateLimit{
+				{id: "users", users: "bob", uploadMbps: 10, downloadMbps: 10},
+				{id: "staff", groups: "staff", uploadMbps: 50, downloadMbps: 50},
+			},
+			groups: []RVPNGroup{
+				{id: "staff", users: "bob"},
+			},
+			want: map[string]rateLimit{
+				"bob-laptop": {uploadMbps: 50, downloadMbps: 50},
+			},
+		},
+		{
+			name: "lowest rate wins between limits of the same kind",
+			rateLimits: []RVPNRateLimit{
+				{id: "a", groups: "a", uploadMbps: 10, downloadMbps: 40},
+				{id: "b", groups: "b", uploadMbps: 30, downloadMbps: 20},
+			},
+			groups: []RVPNGroup{
+				{id: "a", devices: "nas"},
+				{id: "b", devices: "nas"},
+			},
+			want: map[string]rateLimit{
+				"nas": {uploadMbps: 10, downloadMbps: 20},
+			},
+		},
+		{
+			name: "unknown groups and users match nothing",
+			rateLimits: []RVPNRateLimit{
+				{id: "missing", users: "carol", groups: "deleted", uploadMbps: 1, downloadMbps: 1},
+			},
+			want: map[string]rateLimit{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compileTargetRateLimits(tt.rateLimits, tt.groups, userDevices)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compileTargetRateLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
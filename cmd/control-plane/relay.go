@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+	"github.com/redpwn/rvpn/common"
+	"go.uber.org/zap"
+)
+
+// relayTicket is granted by a relay token and identifies the relay leg of a device
+type relayTicket struct {
+	target       string
+	connectionId string // id of the client connection, empty for the target server
+}
+
+// relayLeg is the WebSocket relay connection of a device
+type relayLeg struct {
+	conn *websocket.Conn
+	lock sync.Mutex // websocket writes must not be concurrent
+}
+
+// writeFrame writes a wireguard packet from the peer to the relay leg
+func (l *relayLeg) writeFrame(peerId string, packet []byte) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.conn.WriteMessage(websocket.BinaryMessage, common.MarshalRelayFrame(peerId, packet))
+}
+
+// grantRelayTicket returns a new relay token for the relay leg of a device, the token is valid until it is revoked
+func (a *app) grantRelayTicket(target, connectionId string) string {
+	relayToken := uuid.New().String()
+	a.connMan.setRelayTicket(relayToken, relayTicket{
+		target:       target,
+		connectionId: connectionId,
+	})
+
+	return relayToken
+}
+
+// WebSocket entry point for relaying wireguard packets between rVPN clients which cannot use UDP and the target server
+func (a *app) clientRelay(c *fiber.Ctx) error {
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	authHeader := c.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	ticket, exists := a.connMan.getRelayTicket(authHeader[7:])
+	if !exists || ticket.target != target {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	handler := websocket.New(func(wc *websocket.Conn) {
+		defer wc.Close()
+
+		leg := &relayLeg{
+			conn: wc,
+		}
+
+		a.connMan.setRelayLeg(target, ticket.connectionId, leg)
+		defer a.connMan.removeRelayLeg(target, ticket.connectionId, leg)
+
+		for {
+			msgType, frame, err := wc.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if msgType != websocket.BinaryMessage {
+				continue
+			}
+
+			peerId, packet, err := common.ParseRelayFrame(frame)
+			if err != nil {
+				a.log.Info("received invalid relay frame", zap.Error(err))
+				continue
+			}
+
+			if ticket.connectionId == "" {
+				// packet from the target server to a client
+				clientLeg := a.connMan.getRelayLeg(target, peerId)
+				if clientLeg != nil {
+					clientLeg.writeFrame("", packet)
+				}
+			} else {
+				// packet from a client to the target server, the server tells clients apart by connection id
+				serverLeg := a.connMan.getRelayLeg(target, "")
+				if serverLeg != nil {
+					serverLeg.writeFrame(ticket.connectionId, packet)
+				}
+			}
+		}
+	})
+
+	return handler(c)
+}
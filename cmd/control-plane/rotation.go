@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redpwn/rvpn/common"
+	"go.uber.org/zap"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// keyRotation tracks a wireguard key rotation of a device which has not completed yet
+type keyRotation struct {
+	lock          sync.Mutex
+	connectionId  string // connection of the client device, empty for serving devices or before the client connected
+	pendingPubkey string // key the device reported and switches to, staged on its peers until the rotation completes
+}
+
+// setConnection sets the connection of the client device once it has connected
+func (k *keyRotation) setConnection(connectionId string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.connectionId = connectionId
+}
+
+// getConnection returns the connection of the client device, empty before the client connected
+func (k *keyRotation) getConnection() string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.connectionId
+}
+
+// rotateClientKey stages a peer for the new key of the client on the target VPN server, the staged peer has no
+// allowed ips so the tunnel ip of the client stays on the peer for the current key until the client has switched
+func (a *app) rotateClientKey(ctx context.Context, rotation *keyRotation, target, pubkey string) error {
+	if _, err := wgtypes.ParseKey(pubkey); err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+
+	rotation.lock.Lock()
+	defer rotation.lock.Unlock()
+
+	if rotation.connectionId == "" {
+		return errors.New("client has not connected")
+	}
+
+	rVPNConnection, err := a.db.getConnectionById(ctx, target, rotation.connectionId)
+	if err != nil {
+		return err
+	}
+
+	vpnServerConn := a.connMan.getVPNServerConn(target)
+	if vpnServerConn != nil {
+		if rotation.pendingPubkey != "" && rotation.pendingPubkey != pubkey {
+			// remove the staged peer of a previous rotation which did not complete
+			deleteVPNPeersRequest := common.DeleteVPNPeersRequest{
+				Peers: []common.WireGuardPeer{{PublicKey: rotation.pendingPubkey}},
+			}
+
+			var deleteVPNPeersResponse common.DeleteVPNPeersResponse
+			err = vpnServerConn.Call(ctx, common.DeleteVPNPeersMethod, deleteVPNPeersRequest, &deleteVPNPeersResponse)
+			if err != nil {
+				return fmt.Errorf("failed to call deletevpnpeers via jrpc: %w", err)
+			}
+		}
+
+		appendVPNPeersRequest := common.AppendVPNPeersRequest{
+			Peers: []common.WireGuardPeer{{
+				PublicKey:    pubkey,
+				PresharedKey: rVPNConnection.presharedKey,
+				Staged:       true,
+			}},
+		}
+
+		var appendVPNPeersResponse common.AppendVPNPeersResponse
+		err = vpnServerConn.Call(ctx, common.AppendVPNPeersMethod, appendVPNPeersRequest, &appendVPNPeersResponse)
+		if err != nil {
+			return fmt.Errorf("failed to call appendvpnpeers via jrpc: %w", err)
+		}
+	}
+
+	rotation.pendingPubkey = pubkey
+
+	return nil
+}
+
+// completeClientKeyRotation updates the pubkey of the client connection now that the client has switched to its new
+// key, the tunnel ip and subnets of the client move to the staged peer and the peer for the old key is deleted
+func (a *app) completeClientKeyRotation(ctx context.Context, rotation *keyRotation, target string) error {
+	rotation.lock.Lock()
+	defer rotation.lock.Unlock()
+
+	if rotation.pendingPubkey == "" || rotation.connectionId == "" {
+		return errors.New("no key rotation in progress")
+	}
+
+	rVPNConnection, err := a.db.getConnectionById(ctx, target, rotation.connectionId)
+	if err != nil {
+		return err
+	}
+
+	oldPubkey := rVPNConnection.pubkey
+	err = syncConnectionPubkey(ctx, a.db, rVPNConnection, rotation.pendingPubkey)
+	if err != nil {
+		return err
+	}
+
+	rVPNConnection.pubkey = rotation.pendingPubkey
+	rotation.pendingPubkey = ""
+
+	vpnServerConn := a.connMan.getVPNServerConn(target)
+	if vpnServerConn != nil {
+		connectionRateLimit, err := getConnectionRateLimit(ctx, a.db, rVPNConnection)
+		if err != nil {
+			return err
+		}
+
+		// NOTE: wireguard moves allowed ips which are assigned to another peer, this switches the tunnel ip of the
+		// client to the peer for its new key
+		appendVPNPeersRequest := common.AppendVPNPeersRequest{
+			Peers: []common.WireGuardPeer{connectionWireGuardPeer(rVPNConnection, connectionRateLimit)},
+		}
+
+		var appendVPNPeersResponse common.AppendVPNPeersResponse
+		err = vpnServerConn.Call(ctx, common.AppendVPNPeersMethod, appendVPNPeersRequest, &appendVPNPeersResponse)
+		if err != nil {
+			return fmt.Errorf("failed to call appendvpnpeers via jrpc: %w", err)
+		}
+
+		if oldPubkey != rVPNConnection.pubkey {
+			deleteVPNPeersRequest := common.DeleteVPNPeersRequest{
+				Peers: []common.WireGuardPeer{{PublicKey: oldPubkey}},
+			}
+
+			var deleteVPNPeersResponse common.DeleteVPNPeersResponse
+			err = vpnServerConn.Call(ctx, common.DeleteVPNPeersMethod, deleteVPNPeersRequest, &deleteVPNPeersResponse)
+			if err != nil {
+				return fmt.Errorf("failed to call deletevpnpeers via jrpc: %w", err)
+			}
+		}
+	}
+
+	if oldPubkey != rVPNConnection.pubkey {
+		a.removePubkeyPeerStats(ctx, target, oldPubkey)
+	}
+
+	rVPNTarget, err := a.db.getTargetByName(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if rVPNTarget != nil && rVPNTarget.mesh {
+		// mesh peers of the client must switch to the new key, this calls the rotating client so it must not block
+		go a.pushMeshPeers(target)
+	}
+
+	return nil
+}
+
+// rotateServerKey stages the new key of the target VPN server on its connected clients, the clients keep using the
+// current key of the server until the server has switched and the rotation completes
+func (a *app) rotateServerKey(ctx context.Context, rotation *keyRotation, target, pubkey string) error {
+	if _, err := wgtypes.ParseKey(pubkey); err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+
+	rotation.lock.Lock()
+	defer rotation.lock.Unlock()
+
+	rVPNTarget, err := a.db.getTargetByName(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if rVPNTarget == nil {
+		return errors.New("target does not exist")
+	}
+
+	ctx, cancelFunc := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelFunc()
+
+	updateServerKeyRequest := common.UpdateServerKeyRequest{
+		ServerPublicKey: pubkey,
+		Staged:          true,
+	}
+
+	// NOTE: clients which fail to stage the key still switch to it once the rotation completes, see pushServerKey
+	for _, vpnClientConn := range a.connMan.getVPNClientConn(target) {
+		var updateServerKeyResponse common.UpdateServerKeyResponse
+		err = vpnClientConn.Call(ctx, common.UpdateServerKeyMethod, updateServerKeyRequest, &updateServerKeyResponse)
+		if err != nil {
+			a.log.Error("failed to call updateserverkey via jrpc", zap.Error(err))
+		}
+	}
+
+	rotation.pendingPubkey = pubkey
+
+	return nil
+}
+
+// completeServerKeyRotation updates the pubkey of the target VPN server now that the server has switched to its new
+// key, connected clients are switched to it with pushServerKey
+func (a *app) completeServerKeyRotation(ctx context.Context, rotation *keyRotation, target string) error {
+	rotation.lock.Lock()
+	defer rotation.lock.Unlock()
+
+	if rotation.pendingPubkey == "" {
+		return errors.New("no key rotation in progress")
+	}
+
+	rVPNTarget, err := a.db.getTargetByName(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if rVPNTarget == nil {
+		return errors.New("target does not exist")
+	}
+
+	rVPNTarget.serverPubkey = rotation.pendingPubkey
+	_, err = a.db.updateTarget(ctx, target, rVPNTarget)
+	if err != nil {
+		return err
+	}
+
+	rotation.pendingPubkey = ""
+
+	return nil
+}
+
+// pushServerKey instructs all connected clients of a target to switch to the current key of the target VPN server
+func (a *app) pushServerKey(target string) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	rVPNTarget, err := a.db.getTargetByName(ctx, target)
+	if err != nil || rVPNTarget == nil {
+		a.log.Error("failed to get target by name for server key", zap.Error(err))
+		return
+	}
+
+	updateServerKeyRequest := common.UpdateServerKeyRequest{
+		ServerPublicKey: rVPNTarget.serverPubkey,
+	}
+
+	for _, vpnClientConn := range a.connMan.getVPNClientConn(target) {
+		var updateServerKeyResponse common.UpdateServerKeyResponse
+		err = vpnClientConn.Call(ctx, common.UpdateServerKeyMethod, updateServerKeyRequest, &updateServerKeyResponse)
+		if err != nil {
+			a.log.Error("failed to call updateserverkey via jrpc", zap.Error(err))
+		}
+	}
+}
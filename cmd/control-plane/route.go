@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redpwn/rvpn/common"
+	"go.uber.org/zap"
+)
+
+/* Returns subnets advertised by connections on a target */
+func (a *app) getRoutes(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	targetConnections, err := a.db.getConnectionsByTarget(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get connections database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	ret := make(ListRoutesResponse, 0, len(targetConnections))
+	for _, targetConnection := range targetConnections {
+		if targetConnection.advertisedSubnets == "" {
+			// connection does not advertise any subnets
+			continue
+		}
+
+		ret = append(ret, Route{
+			ConnectionId:      targetConnection.id,
+			DeviceId:          targetConnection.deviceId,
+			ClientIp:          targetConnection.clientIp,
+			AdvertisedSubnets: parseSubnets(targetConnection.advertisedSubnets),
+			ApprovedSubnets:   parseSubnets(targetConnection.approvedSubnets),
+		})
+	}
+
+	return c.Status(200).JSON(ret)
+}
+
+/* Approves subnets advertised by a connection on a target */
+func (a *app) approveRoutes(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	connectionId := c.Params("id")
+	if connectionId == "" {
+		return c.Status(400).JSON(ErrorResponse("id must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	// below this point the user is authorized to administer the target
+
+	var approveRoutesInfo ApproveRoutesRequest
+	if err := c.BodyParser(&approveRoutesInfo); err != nil {
+		return c.Status(400).JSON(ErrorResponse("invalid request body"))
+	}
+
+	approvedSubnets, err := formatSubnets(approveRoutesInfo.Subnets)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse(err.Error()))
+	}
+
+	targetConnection, err := a.db.getConnectionById(c.Context(), target, connectionId)
+	if err != nil {
+		a.log.Error("something went wrong with get connection database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if targetConnection.id == "" {
+		return c.Status(404).JSON(ErrorResponse("connection does not exist"))
+	}
+
+	// only subnets which are advertised by the connection may be approved
+	advertisedSubnetSet := make(map[string]struct{})
+	for _, advertisedSubnet := range parseSubnets(targetConnection.advertisedSubnets) {
+		advertisedSubnetSet[advertisedSubnet] = struct{}{}
+	}
+
+	for _, approvedSubnet := range parseSubnets(approvedSubnets) {
+		if _, exists := advertisedSubnetSet[approvedSubnet]; !exists {
+			return c.Status(400).JSON(ErrorResponse("subnet " + approvedSubnet + " is not advertised by the connection"))
+		}
+	}
+
+	targetConnection.approvedSubnets = approvedSubnets
+	_, err = a.db.updateConnection(c.Context(), targetConnection.id, targetConnection.target, targetConnection.deviceId,
+		targetConnection.pubkey, targetConnection.clientIp, targetConnection.clientCidr, targetConnection.advertisedSubnets,
+		targetConnection.approvedSubnets, targetConnection.endpoint, targetConnection.presharedKey)
+	if err != nil {
+		a.log.Error("something went wrong with update connection database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	// push approved subnets to the target VPN server and connected clients
+	a.pushConnectionSubnets(target, targetConnection)
+
+	return c.Status(200).SendString("successfully approved routes")
+}
+
+// pushConnectionSubnets pushes the approved subnets of a connection to the target VPN server as allowed IPs
+// and to all other connected clients as routes
+func (a *app) pushConnectionSubnets(target string, rVPNConnection RVPNConnection) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	vpnServerConn := a.connMan.getVPNServerConn(target)
+	if vpnServerConn != nil {
+		connectionRateLimit, err := getConnectionRateLimit(ctx, a.db, rVPNConnection)
+		if err != nil {
+			a.log.Error("failed to get connection rate limit for approved routes", zap.Error(err))
+		} else {
+			appendVPNPeersRequest := common.AppendVPNPeersRequest{
+				Peers: []common.WireGuardPeer{connectionWireGuardPeer(rVPNConnection, connectionRateLimit)},
+			}
+
+			var appendVPNPeersResponse common.AppendVPNPeersResponse
+			err = vpnServerConn.Call(ctx, common.AppendVPNPeersMethod, appendVPNPeersRequest, &appendVPNPeersResponse)
+			if err != nil {
+				a.log.Error("failed to call appendvpnpeers via jrpc for approved routes", zap.Error(err))
+			}
+		}
+	}
+
+	rVPNTarget, err := a.db.getTargetByName(ctx, target)
+	if err != nil || rVPNTarget == nil {
+		a.log.Error("failed to get target by name for approved routes", zap.Error(err))
+		return
+	}
+
+	for connectionId, vpnClientConn := range a.connMan.getVPNClientConn(target) {
+		if connectionId == rVPNConnection.id {
+			// a client does not route its own subnets through the target VPN server
+			continue
+		}
+
+		routableSubnets, err := getClientRoutableSubnets(ctx, a.db, rVPNTarget, connectionId)
+		if err != nil {
+			a.log.Error("failed to get client routable subnets", zap.Error(err))
+			continue
+		}
+
+		var updateRoutesResponse common.UpdateRoutesResponse
+		err = vpnClientConn.Call(ctx, common.UpdateRoutesMethod, common.UpdateRoutesRequest{Subnets: routableSubnets}, &updateRoutesResponse)
+		if err != nil {
+			a.log.Error("failed to call updateroutes via jrpc for approved routes", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redpwn/rvpn/common"
+	"go.uber.org/zap"
+)
+
+// serverStatsReporter is the reporter of the peer traffic statistics sent by the serving node of a target
+const serverStatsReporter = "server"
+
+/* Returns connections of a target with the traffic statistics of their devices */
+func (a *app) getConnections(c *fiber.Ctx) error {
+	authUser := c.Locals("user")
+	if authUser == nil {
+		return c.Status(401).JSON(ErrorResponse("unauthorized"))
+	}
+
+	target := c.Params("target")
+	if target == "" {
+		return c.Status(400).JSON(ErrorResponse("target must not be empty"))
+	}
+
+	targetAdmin, err := TargetAdminAuthorized(c.Context(), a.db, target, authUser.(string))
+	if err != nil {
+		a.log.Error("something went wrong with authorizing target admin", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	if !targetAdmin {
+		return c.Status(401).JSON(ErrorResponse("user is not an admin of this target"))
+	}
+
+	targetConnections, err := a.db.getConnectionsByTarget(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get connections database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	targetPeerStats, err := a.db.getPeerStatsByTarget(c.Context(), target)
+	if err != nil {
+		a.log.Error("something went wrong with get peer stats database query", zap.Error(err))
+		return c.Status(500).JSON(ErrorResponse("something went wrong"))
+	}
+
+	// the serving node reports a peer for each connection, clients report their own peers
+	serverPeerStats := make(map[string]RVPNPeerStats)
+	clientPeerStats := make(map[string][]PeerStats)
+	for _, targetPeerStat := range targetPeerStats {
+		if targetPeerStat.reporter == serverStatsReporter {
+			serverPeerStats[targetPeerStat.pubkey] = targetPeerStat
+			continue
+		}
+
+		clientPeerStats[targetPeerStat.reporter] = append(clientPeerStats[targetPeerStat.reporter], PeerStats{
+			PublicKey:     targetPeerStat.pubkey,
+			Endpoint:      targetPeerStat.endpoint,
+			LastHandshake: targetPeerStat.lastHandshake,
+			ReceiveBytes:  targetPeerStat.receiveBytes,
+			TransmitBytes: targetPeerStat.transmitBytes,
+			ReportedAt:    targetPeerStat.reportedAt,
+		})
+	}
+
+	ret := make(ListConnectionsResponse, 0, len(targetConnections))
+	for _, targetConnection := range targetConnections {
+		connection := Connection{
+			ConnectionId: targetConnection.id,
+			DeviceId:     targetConnection.deviceId,
+			ClientIp:     targetConnection.clientIp,
+			Peers:        clientPeerStats[targetConnection.id],
+		}
+
+		if connection.Peers == nil {
+			connection.Peers = []PeerStats{}
+		}
+
+		if serverPeerStat, exists := serverPeerStats[targetConnection.pubkey]; exists {
+			// NOTE: bytes received by the serving node are uploaded by the device
+			connection.Endpoint = serverPeerStat.endpoint
+			connection.LastHandshake = serverPeerStat.lastHandshake
+			connection.UploadBytes = serverPeerStat.receiveBytes
+			connection.DownloadBytes = serverPeerStat.transmitBytes
+			connection.ReportedAt = serverPeerStat.reportedAt
+		}
+
+		ret = append(ret, connection)
+	}
+
+	return c.Status(200).JSON(ret)
+}
+
+// recordPeerStats stores the peer traffic statistics a device reported with its heartbeat, replacing its previous report
+func (a *app) recordPeerStats(ctx context.Context, target, reporter string, peerStats []common.PeerStats) {
+	reportedAt := time.Now().Unix()
+
+	rVPNPeerStats := []RVPNPeerStats{}
+	for _, peerStat := range peerStats {
+		rVPNPeerStats = append(rVPNPeerStats, RVPNPeerStats{
+			target:        target,
+			reporter:      reporter,
+			pubkey:        peerStat.PublicKey,
+			endpoint:      peerStat.Endpoint,
+			lastHandshake: peerStat.LastHandshake,
+			receiveBytes:  peerStat.ReceiveBytes,
+			transmitBytes: peerStat.TransmitBytes,
+			reportedAt:    reportedAt,
+		})
+	}
+
+	err := a.db.replacePeerStats(ctx, target, reporter, rVPNPeerStats)
+	if err != nil {
+		a.log.Error("failed to record peer stats", zap.Error(err))
+	}
+}
+
+// removePeerStats deletes the peer traffic statistics a client connection reported once the connection is removed
+func (a *app) removePeerStats(target, connectionId string) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	err := a.db.deletePeerStatsByReporter(ctx, target, connectionId)
+	if err != nil {
+		a.log.Error("failed to delete peer stats of connection", zap.Error(err))
+	}
+}
+
+// removePubkeyPeerStats deletes the peer traffic statistics reported about a pubkey a device no longer uses
+func (a *app) removePubkeyPeerStats(ctx context.Context, target, pubkey string) {
+	err := a.db.deletePeerStatsByPubkey(ctx, target, pubkey)
+	if err != nil {
+		a.log.Error("failed to delete peer stats of stale pubkey", zap.Error(err))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/redpwn/rvpn/common"
+	"go.uber.org/zap"
+)
+
+// serveStun answers STUN binding requests so rVPN devices can discover the public mapping of their wireguard socket
+func (a *app) serveStun(port int) {
+	stunConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		a.log.Error("failed to listen for stun requests", zap.Error(err))
+		return
+	}
+	defer stunConn.Close()
+
+	a.log.Info("stun responder started", zap.Int("port", port))
+
+	packet := make([]byte, 1500)
+	for {
+		n, remoteAddr, err := stunConn.ReadFromUDPAddrPort(packet)
+		if err != nil {
+			a.log.Error("failed to read stun request", zap.Error(err))
+			return
+		}
+
+		txID, err := common.ParseStunBindingRequest(packet[:n])
+		if err != nil {
+			// not a stun binding request, ignore packet
+			continue
+		}
+
+		mappedAddr := netip.AddrPortFrom(remoteAddr.Addr().Unmap(), remoteAddr.Port())
+		_, err = stunConn.WriteToUDPAddrPort(common.MarshalStunBindingResponse(txID, mappedAddr), remoteAddr)
+		if err != nil {
+			a.log.Error("failed to write stun response", zap.Error(err))
+		}
+	}
+}
@@ -29,3 +29,18 @@ func GetAuthTargetsByPrincipal(ctx context.Context, db *RVPNDatabase, principal
 	allowedTargets = append(allowedTargets, ownedTargets...)
 	return allowedTargets, nil
 }
+
+// TargetAdminAuthorized returns whether principal is authorized to administer a target
+func TargetAdminAuthorized(ctx context.Context, db *RVPNDatabase, target, principal string) (bool, error) {
+	// NOTE: the owner of the target is currently the only target admin
+	rVPNTarget, err := db.getTargetByName(ctx, target)
+	if err != nil {
+		return false, err
+	}
+
+	if rVPNTarget == nil {
+		return false, nil
+	}
+
+	return rVPNTarget.owner == principal, nil
+}
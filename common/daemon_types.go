@@ -1,6 +1,45 @@
 package common
 
+import (
+	"fmt"
+	"net"
+)
+
 // ClientOptions holds the options for the client
 type ClientOptions struct {
-	Subnets []string `json:"subnets"` // subnets to connect to which overrides instructions from server
+	Subnets          []string `json:"subnets"`          // subnets to connect to which overrides instructions from server
+	AdvertiseSubnets []string `json:"advertisesubnets"` // subnets behind the client which are routed for other peers once approved
+	KillSwitch       bool     `json:"killswitch"`       // block traffic outside of the tunnel until explicitly disconnected
+}
+
+// ServeOptions holds the options for serving as a target VPN server
+type ServeOptions struct {
+	Subnets    []string `json:"subnets"`    // subnets behind the serving node which are advertised to clients
+	Masquerade bool     `json:"masquerade"` // masquerade traffic from clients which is forwarded to the served subnets
+}
+
+// ValidateSubnets returns an error if a subnet is not in CIDR notation, i.e "192.168.1.0/24"
+func ValidateSubnets(subnets []string) error {
+	for _, subnet := range subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return fmt.Errorf("invalid subnet %s, subnets must be in CIDR notation (i.e 192.168.1.0/24)", subnet)
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error if the options are invalid
+func (o ClientOptions) Validate() error {
+	err := ValidateSubnets(o.Subnets)
+	if err != nil {
+		return err
+	}
+
+	return ValidateSubnets(o.AdvertiseSubnets)
+}
+
+// Validate returns an error if the options are invalid
+func (o ServeOptions) Validate() error {
+	return ValidateSubnets(o.Subnets)
 }
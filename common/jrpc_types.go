@@ -10,15 +10,38 @@ const (
 	ServeVPNMethod             = "serve_vpn"
 	AppendVPNPeersMethod       = "append_vpn_peers"
 	DeleteVPNPeersMethod       = "delete_vpn_peers"
+	UpdateRoutesMethod         = "update_routes"
+	UpdateMeshPeersMethod      = "update_mesh_peers"
+	DiscoverEndpointMethod     = "discover_endpoint"
+	PunchHoleMethod            = "punch_hole"
+	UpdateServerKeyMethod      = "update_server_key"
+	UpdateFirewallMethod       = "update_firewall"
 
 	// jRPC commands from client to server
-	DeviceHeartbeatMethod = "device_heartbeat"
+	DeviceHeartbeatMethod     = "device_heartbeat"
+	RotateKeyMethod           = "rotate_key"
+	CompleteKeyRotationMethod = "complete_key_rotation"
 )
 
 type WireGuardPeer struct {
-	PublicKey   string `json:"publickey"`
-	AllowedIP   string `json:"allowedip"`
-	AllowedCidr string `json:"allowedcidr"`
+	PublicKey    string   `json:"publickey"`
+	PresharedKey string   `json:"presharedkey"` // preshared key of the connection, empty if none
+	AllowedIP    string   `json:"allowedip"`
+	AllowedCidr  string   `json:"allowedcidr"`  // cidr of the peer tunnel ip, i.e "/32"
+	Subnets      []string `json:"subnets"`      // approved subnets routed through the peer
+	Endpoint     string   `json:"endpoint"`     // public endpoint of the peer for mesh mode, i.e "1.2.3.4:51720"
+	UploadMbps   int      `json:"uploadmbps"`   // bandwidth limit of traffic from the peer, zero means unlimited
+	DownloadMbps int      `json:"downloadmbps"` // bandwidth limit of traffic to the peer, zero means unlimited
+	Staged       bool     `json:"staged"`       // peer for a rotated key, it has no allowed ips until the rotation completes
+}
+
+// FirewallRule is a compiled firewall policy which allows traffic from the sources to the destinations over the
+// protocol and ports, empty lists and an empty protocol match any
+type FirewallRule struct {
+	Sources      []string `json:"sources"`      // source cidrs, i.e "10.8.0.2/32"
+	Destinations []string `json:"destinations"` // destination cidrs, i.e "192.168.1.0/24"
+	Protocol     string   `json:"protocol"`     // tcp, udp or icmp
+	Ports        []string `json:"ports"`        // destination ports or port ranges for tcp and udp, i.e "8000-8100"
 }
 
 // GetDeviceAuthRequest holds the arguments for get_device_auth request
@@ -35,8 +58,10 @@ type GetClientInformationRequest struct{}
 
 // GetClientInformationResponse holds the response for get_client_information request
 type GetClientInformationResponse struct {
-	Success   bool   `json:"success"`
-	PublicKey string `json:"publickey"`
+	Success    bool     `json:"success"`
+	PublicKey  string   `json:"publickey"`
+	Subnets    []string `json:"subnets"`    // subnets the client advertises to route for other peers
+	ListenPort int      `json:"listenport"` // wireguard listen port of the client, used for mesh mode
 }
 
 // GetServeInformationRequest holds the arguments for get_serve_information request
@@ -44,20 +69,25 @@ type GetServeInformationRequest struct{}
 
 // GetServeInformationResponse holds the response for get_serve_information request
 type GetServeInformationResponse struct {
-	Success       bool   `json:"success"`
-	PublicKey     string `json:"publickey"`
-	PublicVpnPort string `json:"publicvpnport"`
+	Success       bool     `json:"success"`
+	PublicKey     string   `json:"publickey"`
+	PublicVpnPort string   `json:"publicvpnport"`
+	Subnets       []string `json:"subnets"` // subnets the serving node routes, empty means all traffic
 }
 
 // ConnectServerRequest holds the arguments for connect_server request
 type ConnectServerRequest struct {
-	ServerPublicKey string `json:"serverpublickey"` // this is the public key the client uses to connect
-	ClientPublicKey string `json:"clientpublickey"` // we send this to verify that the rVPN state key is correct / synced
-	ClientIp        string `json:"clientip"`
-	ClientCidr      string `json:"clientcidr"`
-	ServerIp        string `json:"serverip"`
-	ServerPort      int    `json:"serverport"`
-	DnsIp           string `json:"dnsip"`
+	ServerPublicKey  string   `json:"serverpublickey"` // this is the public key the client uses to connect
+	ClientPublicKey  string   `json:"clientpublickey"` // we send this to verify that the rVPN state key is correct / synced
+	PresharedKey     string   `json:"presharedkey"`    // preshared key of the connection
+	ClientIp         string   `json:"clientip"`
+	ClientCidr       string   `json:"clientcidr"`
+	ServerIp         string   `json:"serverip"`
+	ServerPort       int      `json:"serverport"`
+	ServerInternalIp string   `json:"serverinternalip"` // internal ip of the rVPN server which is probed through the tunnel
+	DnsIp            string   `json:"dnsip"`
+	Subnets          []string `json:"subnets"`    // routable subnets advertised by the target
+	RelayToken       string   `json:"relaytoken"` // token for the WebSocket relay which is used if UDP is blocked
 }
 
 // ConnectServerResponse holds the response for connect_server request
@@ -72,6 +102,7 @@ type ServeVPNRequest struct {
 	ServerInternalCidr  string          `json:"serverinternalcidr"`
 	ServerPublicVPNPort int             `json:"serverpublicvpnport"`
 	Peers               []WireGuardPeer `json:"peers"`
+	RelayToken          string          `json:"relaytoken"` // token for the WebSocket relay for clients which cannot use UDP
 }
 
 // ServeVPNResponse holds the response for the serve_vpn request
@@ -99,10 +130,110 @@ type DeleteVPNPeersResponse struct {
 	Success bool `json:"success"`
 }
 
+// UpdateRoutesRequest holds the arguments for the update_routes request to update client routable subnets
+type UpdateRoutesRequest struct {
+	Subnets []string `json:"subnets"`
+}
+
+// UpdateRoutesResponse holds the response for the update_routes request to update client routable subnets
+type UpdateRoutesResponse struct {
+	Success bool `json:"success"`
+}
+
+// UpdateMeshPeersRequest holds the arguments for the update_mesh_peers request to update client mesh peers
+type UpdateMeshPeersRequest struct {
+	Peers []WireGuardPeer `json:"peers"`
+}
+
+// UpdateMeshPeersResponse holds the response for the update_mesh_peers request to update client mesh peers
+type UpdateMeshPeersResponse struct {
+	Success bool `json:"success"`
+}
+
+// DiscoverEndpointRequest holds the arguments for the discover_endpoint request to discover the public endpoint
+// of the wireguard socket, the STUN server listens on the given port of the control plane address
+type DiscoverEndpointRequest struct {
+	StunPort int `json:"stunport"`
+}
+
+// DiscoverEndpointResponse holds the response for the discover_endpoint request to discover the public endpoint
+// of the wireguard socket, the public endpoint is the first candidate
+type DiscoverEndpointResponse struct {
+	Success    bool     `json:"success"`
+	Candidates []string `json:"candidates"`
+}
+
+// PunchHoleRequest holds the arguments for the punch_hole request to open NAT for a peer endpoint
+type PunchHoleRequest struct {
+	Endpoint string `json:"endpoint"`
+}
+
+// PunchHoleResponse holds the response for the punch_hole request to open NAT for a peer endpoint
+type PunchHoleResponse struct {
+	Success bool `json:"success"`
+}
+
+// UpdateServerKeyRequest holds the arguments for the update_server_key request to switch to the rotated key of the
+// rVPN server
+type UpdateServerKeyRequest struct {
+	ServerPublicKey string `json:"serverpublickey"`
+	Staged          bool   `json:"staged"` // add a peer for the key next to the current server peer without switching to it
+}
+
+// UpdateServerKeyResponse holds the response for the update_server_key request to switch to the rotated key of the
+// rVPN server
+type UpdateServerKeyResponse struct {
+	Success bool `json:"success"`
+}
+
+// UpdateFirewallRequest holds the arguments for the update_firewall request to update the firewall of the rVPN
+// server, if enabled only traffic matching a rule is forwarded
+type UpdateFirewallRequest struct {
+	Enabled bool           `json:"enabled"`
+	Rules   []FirewallRule `json:"rules"`
+}
+
+// UpdateFirewallResponse holds the response for the update_firewall request to update the firewall of the rVPN server
+type UpdateFirewallResponse struct {
+	Success bool `json:"success"`
+}
+
+// PeerStats holds traffic statistics of a wireguard peer as seen by the reporting device
+type PeerStats struct {
+	PublicKey     string `json:"publickey"`
+	Endpoint      string `json:"endpoint"`      // last endpoint of the peer, i.e "1.2.3.4:51720", empty if unknown
+	LastHandshake int64  `json:"lasthandshake"` // unix time of the last handshake in seconds, zero if none
+	ReceiveBytes  int64  `json:"receivebytes"`  // bytes received from the peer
+	TransmitBytes int64  `json:"transmitbytes"` // bytes sent to the peer
+}
+
 // DeviceHeartbeatRequest holds the arguments for the device_heartbeat request to indicate aliveness of the device
-type DeviceHeartbeatRequest struct{}
+type DeviceHeartbeatRequest struct {
+	Peers []PeerStats `json:"peers"` // traffic statistics of the wireguard peers of the device
+}
 
 // DeviceHeartbeatResponse holds the response for the device_heartbeat request to indicate aliveness of the device
 type DeviceHeartbeatResponse struct {
 	Success bool `json:"success"`
 }
+
+// RotateKeyRequest holds the arguments for the rotate_key request to report a new public key of the device, the
+// device switches to the new key once the control plane has prepared peers for it
+type RotateKeyRequest struct {
+	PublicKey string `json:"publickey"`
+}
+
+// RotateKeyResponse holds the response for the rotate_key request to report a new public key of the device
+type RotateKeyResponse struct {
+	Success bool `json:"success"`
+}
+
+// CompleteKeyRotationRequest holds the arguments for the complete_key_rotation request to indicate that the device
+// has switched to its new key so the old key can be removed
+type CompleteKeyRotationRequest struct{}
+
+// CompleteKeyRotationResponse holds the response for the complete_key_rotation request to indicate that the device
+// has switched to its new key
+type CompleteKeyRotationResponse struct {
+	Success bool `json:"success"`
+}
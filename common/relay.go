@@ -0,0 +1,25 @@
+package common
+
+import "errors"
+
+// RelayFrame format used on the WebSocket relay between rVPN devices and the control plane:
+// 1 byte length of the peer id, the peer id, the wireguard packet
+// NOTE: frames from clients have an empty peer id as clients only relay to the target VPN server
+
+// MarshalRelayFrame returns a relay frame for a wireguard packet to or from the given peer
+func MarshalRelayFrame(peerId string, packet []byte) []byte {
+	frame := make([]byte, 0, 1+len(peerId)+len(packet))
+	frame = append(frame, byte(len(peerId)))
+	frame = append(frame, peerId...)
+	return append(frame, packet...)
+}
+
+// ParseRelayFrame parses a relay frame and returns the peer id and wireguard packet
+func ParseRelayFrame(frame []byte) (string, []byte, error) {
+	if len(frame) < 1 || len(frame) < 1+int(frame[0]) {
+		return "", nil, errors.New("relay frame is truncated")
+	}
+
+	peerIdLen := int(frame[0])
+	return string(frame[1 : 1+peerIdLen]), frame[1+peerIdLen:], nil
+}
@@ -0,0 +1,203 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// minimal STUN (RFC 5389) binding messages used for endpoint discovery of rVPN devices behind NAT
+
+const (
+	StunMagicCookie uint32 = 0x2112A442
+	StunHeaderSize         = 20
+
+	stunBindingRequest         uint16 = 0x0001
+	stunBindingSuccessResponse uint16 = 0x0101
+
+	stunAttrMappedAddress    uint16 = 0x0001
+	stunAttrXorMappedAddress uint16 = 0x0020
+
+	stunFamilyIPv4 = 0x01
+	stunFamilyIPv6 = 0x02
+)
+
+// StunTransactionID is the transaction id which matches a STUN response to its request
+type StunTransactionID [12]byte
+
+// NewStunTransactionID returns a random STUN transaction id
+func NewStunTransactionID() (StunTransactionID, error) {
+	var txID StunTransactionID
+	_, err := rand.Read(txID[:])
+	return txID, err
+}
+
+// IsStunMessage returns whether the packet is a STUN message, this never matches a WireGuard message
+func IsStunMessage(b []byte) bool {
+	if len(b) < StunHeaderSize {
+		return false
+	}
+
+	// the first two bits of a STUN message are always zero and the length excludes the header
+	return b[0]&0xC0 == 0 &&
+		binary.BigEndian.Uint32(b[4:8]) == StunMagicCookie &&
+		int(binary.BigEndian.Uint16(b[2:4]))+StunHeaderSize == len(b)
+}
+
+// MarshalStunBindingRequest returns a STUN binding request
+func MarshalStunBindingRequest(txID StunTransactionID) []byte {
+	return marshalStunHeader(stunBindingRequest, txID, 0)
+}
+
+// MarshalStunBindingResponse returns a STUN binding success response with the mapped address of the requester
+func MarshalStunBindingResponse(txID StunTransactionID, mappedAddr netip.AddrPort) []byte {
+	addr := mappedAddr.Addr().Unmap()
+	addrBytes := addr.AsSlice()
+
+	family := byte(stunFamilyIPv4)
+	if addr.Is6() {
+		family = stunFamilyIPv6
+	}
+
+	// XOR-MAPPED-ADDRESS is xored with the magic cookie and the transaction id
+	xorKey := make([]byte, 16)
+	binary.BigEndian.PutUint32(xorKey[0:4], StunMagicCookie)
+	copy(xorKey[4:], txID[:])
+
+	attrValue := make([]byte, 4+len(addrBytes))
+	attrValue[1] = family
+	binary.BigEndian.PutUint16(attrValue[2:4], mappedAddr.Port()^uint16(StunMagicCookie>>16))
+	for i := range addrBytes {
+		attrValue[4+i] = addrBytes[i] ^ xorKey[i]
+	}
+
+	attrHeader := make([]byte, 4)
+	binary.BigEndian.PutUint16(attrHeader[0:2], stunAttrXorMappedAddress)
+	binary.BigEndian.PutUint16(attrHeader[2:4], uint16(len(attrValue)))
+
+	b := marshalStunHeader(stunBindingSuccessResponse, txID, len(attrHeader)+len(attrValue))
+	b = append(b, attrHeader...)
+	return append(b, attrValue...)
+}
+
+// ParseStunBindingRequest parses a STUN binding request and returns its transaction id
+func ParseStunBindingRequest(b []byte) (StunTransactionID, error) {
+	msgType, txID, err := parseStunHeader(b)
+	if err != nil {
+		return txID, err
+	}
+
+	if msgType != stunBindingRequest {
+		return txID, errors.New("stun message is not a binding request")
+	}
+
+	return txID, nil
+}
+
+// ParseStunBindingResponse parses a STUN binding success response and returns its transaction id and mapped address
+func ParseStunBindingResponse(b []byte) (StunTransactionID, netip.AddrPort, error) {
+	msgType, txID, err := parseStunHeader(b)
+	if err != nil {
+		return txID, netip.AddrPort{}, err
+	}
+
+	if msgType != stunBindingSuccessResponse {
+		return txID, netip.AddrPort{}, errors.New("stun message is not a binding success response")
+	}
+
+	var mappedAddr netip.AddrPort
+	attrs := b[StunHeaderSize:]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+attrLen {
+			return txID, netip.AddrPort{}, errors.New("stun attribute is truncated")
+		}
+
+		attrValue := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunAttrXorMappedAddress:
+			// XOR-MAPPED-ADDRESS takes precedence over MAPPED-ADDRESS
+			if xorMappedAddr := parseStunAddress(attrValue, txID, true); xorMappedAddr.IsValid() {
+				return txID, xorMappedAddr, nil
+			}
+		case stunAttrMappedAddress:
+			mappedAddr = parseStunAddress(attrValue, txID, false)
+		}
+
+		// attributes are padded to a multiple of 4 bytes
+		padded := 4 + (attrLen+3)&^3
+		if padded > len(attrs) {
+			break
+		}
+		attrs = attrs[padded:]
+	}
+
+	if !mappedAddr.IsValid() {
+		return txID, netip.AddrPort{}, errors.New("stun response does not contain a mapped address")
+	}
+
+	return txID, mappedAddr, nil
+}
+
+// marshalStunHeader returns a STUN header for a message with attributes of the given length
+func marshalStunHeader(msgType uint16, txID StunTransactionID, attrsLen int) []byte {
+	b := make([]byte, StunHeaderSize, StunHeaderSize+attrsLen)
+	binary.BigEndian.PutUint16(b[0:2], msgType)
+	binary.BigEndian.PutUint16(b[2:4], uint16(attrsLen))
+	binary.BigEndian.PutUint32(b[4:8], StunMagicCookie)
+	copy(b[8:20], txID[:])
+	return b
+}
+
+// parseStunHeader parses the header of a STUN message
+func parseStunHeader(b []byte) (uint16, StunTransactionID, error) {
+	var txID StunTransactionID
+	if !IsStunMessage(b) {
+		return 0, txID, errors.New("packet is not a stun message")
+	}
+
+	copy(txID[:], b[8:20])
+	return binary.BigEndian.Uint16(b[0:2]), txID, nil
+}
+
+// parseStunAddress parses the value of a (XOR-)MAPPED-ADDRESS attribute, returns the zero value if invalid
+func parseStunAddress(attrValue []byte, txID StunTransactionID, xored bool) netip.AddrPort {
+	if len(attrValue) < 4 {
+		return netip.AddrPort{}
+	}
+
+	addrLen := 4
+	if attrValue[1] == stunFamilyIPv6 {
+		addrLen = 16
+	} else if attrValue[1] != stunFamilyIPv4 {
+		return netip.AddrPort{}
+	}
+
+	if len(attrValue) < 4+addrLen {
+		return netip.AddrPort{}
+	}
+
+	port := binary.BigEndian.Uint16(attrValue[2:4])
+	addrBytes := make([]byte, addrLen)
+	copy(addrBytes, attrValue[4:4+addrLen])
+
+	if xored {
+		xorKey := make([]byte, 16)
+		binary.BigEndian.PutUint32(xorKey[0:4], StunMagicCookie)
+		copy(xorKey[4:], txID[:])
+
+		port ^= uint16(StunMagicCookie >> 16)
+		for i := range addrBytes {
+			addrBytes[i] ^= xorKey[i]
+		}
+	}
+
+	addr, ok := netip.AddrFromSlice(addrBytes)
+	if !ok {
+		return netip.AddrPort{}
+	}
+
+	return netip.AddrPortFrom(addr, port)
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultAccountName is the name of the account which is used when no account was added or selected, tokens saved by
+// "rvpn login" and by older versions of rVPN belong to it
+const DefaultAccountName = "default"
+
+// Account is a control plane account of the device
+type Account struct {
+	ControlPlaneURL  string            `json:"controlplaneurl"`  // empty uses the control plane url of the daemon config
+	ControlPlaneAuth string            `json:"controlplaneauth"` // token which is used to authenticate to the control plane
+	DeviceTokens     map[string]string `json:"devicetokens"`     // device token of each target the device is registered for
+
+	// set in place of the secrets when the state is shown to the client
+	LoggedIn bool   `json:"-"`
+	User     string `json:"-"` // user the login token is signed for
+}
+
+// ForAccount returns the config with the control plane of the account, pins of the configured control plane are
+// dropped if the account is on another control plane
+func (c Config) ForAccount(account Account) Config {
+	if account.ControlPlaneURL == "" {
+		return c
+	}
+
+	if !samePinnedHost(c.ControlPlaneURL, account.ControlPlaneURL) {
+		c.ControlPlaneCert = ""
+		c.ControlPlanePublicKey = ""
+	}
+	c.ControlPlaneURL = account.ControlPlaneURL
+
+	return c
+}
+
+// SplitAccountTarget splits "account/target" into the account and target name, the account is empty if the argument
+// names only a target
+func SplitAccountTarget(arg string) (string, string) {
+	if account, target, found := strings.Cut(arg, "/"); found {
+		return account, target
+	}
+
+	return "", arg
+}
+
+// ActiveAccountName returns the name of the account which is used when no account is named
+func (s RVpnState) ActiveAccountName() string {
+	if s.ActiveAccount == "" {
+		return DefaultAccountName
+	}
+
+	return s.ActiveAccount
+}
+
+// GetAccount returns the named account or the active account if name is empty
+func (s RVpnState) GetAccount(name string) (string, Account, error) {
+	if name == "" {
+		name = s.ActiveAccountName()
+	}
+
+	account, ok := s.Accounts[name]
+	if !ok {
+		return name, Account{}, fmt.Errorf("rVPN account %s does not exist", name)
+	}
+
+	return name, account, nil
+}
+
+// SetAccount adds or replaces the named account
+func (s *RVpnState) SetAccount(name string, account Account) {
+	if s.Accounts == nil {
+		s.Accounts = map[string]Account{}
+	}
+
+	s.Accounts[name] = account
+}
+
+// migrateAccounts moves the control plane auth saved by older versions of rVPN into the default account
+func (s *RVpnState) migrateAccounts() {
+	if s.ControlPlaneAuth == "" {
+		return
+	}
+
+	if _, ok := s.Accounts[DefaultAccountName]; !ok {
+		s.SetAccount(DefaultAccountName, Account{
+			ControlPlaneAuth: s.ControlPlaneAuth,
+		})
+	}
+
+	s.ControlPlaneAuth = ""
+}
+
+// redacted returns a copy of the state without secrets, it is returned to every local user
+func (s RVpnState) redacted() RVpnState {
+	redactedAccounts := map[string]Account{}
+	for name, account := range s.Accounts {
+		deviceTokens := map[string]string{}
+		for target := range account.DeviceTokens {
+			deviceTokens[target] = ""
+		}
+
+		redactedAccounts[name] = Account{
+			ControlPlaneURL: account.ControlPlaneURL,
+			DeviceTokens:    deviceTokens,
+			LoggedIn:        account.ControlPlaneAuth != "",
+			User:            tokenUser(account.ControlPlaneAuth),
+		}
+	}
+
+	return RVpnState{
+		Accounts:      redactedAccounts,
+		ActiveAccount: s.ActiveAccount,
+		PublicKey:     s.PublicKey,
+		ActiveProfile: s.ActiveProfile,
+	}
+}
+
+// tokenUser returns the user claim of a login token, the token is not verified so the user is only for display
+func tokenUser(token string) string {
+	splits := strings.Split(token, ".")
+	if len(splits) != 3 {
+		return ""
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(splits[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		User string `json:"user"`
+	}
+	if json.Unmarshal(body, &claims) != nil {
+		return ""
+	}
+
+	return claims.User
+}
+
+// sealAccount returns a copy of the account with its secrets sealed to the machine
+func sealAccount(account Account) (Account, error) {
+	controlPlaneAuth, err := sealSecret(account.ControlPlaneAuth)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to seal control plane auth: %w", err)
+	}
+
+	deviceTokens := map[string]string{}
+	for target, deviceToken := range account.DeviceTokens {
+		deviceTokens[target], err = sealSecret(deviceToken)
+		if err != nil {
+			return Account{}, fmt.Errorf("failed to seal device token of target %s: %w", target, err)
+		}
+	}
+
+	account.ControlPlaneAuth = controlPlaneAuth
+	account.DeviceTokens = deviceTokens
+
+	return account, nil
+}
+
+// unsealAccount unseals the secrets of an account sealed by sealAccount
+func unsealAccount(account Account) (Account, error) {
+	controlPlaneAuth, err := unsealSecret(account.ControlPlaneAuth)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to unseal control plane auth: %w", err)
+	}
+
+	deviceTokens := map[string]string{}
+	for target, deviceToken := range account.DeviceTokens {
+		deviceTokens[target], err = unsealSecret(deviceToken)
+		if err != nil {
+			return Account{}, fmt.Errorf("failed to unseal device token of target %s: %w", target, err)
+		}
+	}
+
+	account.ControlPlaneAuth = controlPlaneAuth
+	account.DeviceTokens = deviceTokens
+
+	return account, nil
+}
@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caarlos0/env/v6"
+	"github.com/redpwn/rvpn/daemon/wg"
+)
+
+// Config is the configuration of the rVPN daemon, it is read from the config file and every option can be
+// overridden by an environment variable
+type Config struct {
+	ControlPlaneURL  string `toml:"control_plane_url" env:"RVPN_CONTROL_PLANE_URL"` // i.e "https://rvpn.example.com"
+	RPCAddr          string `toml:"rpc_addr" env:"RVPN_RPC_ADDR"`                   // address the daemon RPC server listens on
+	RPCSocket        string `toml:"rpc_socket" env:"RVPN_RPC_SOCKET"`               // unix socket the daemon RPC server listens on (Linux)
+	InterfaceName    string `toml:"interface_name" env:"RVPN_INTERFACE_NAME"`
+	MTU              int    `toml:"mtu" env:"RVPN_MTU"`
+	ClientListenPort int    `toml:"client_listen_port" env:"RVPN_CLIENT_LISTEN_PORT"` // wireguard listen port in client mode
+	ServePort        int    `toml:"serve_port" env:"RVPN_SERVE_PORT"`                 // wireguard listen port in server mode
+
+	// pin the control plane to the certificate in the PEM file or to its public key as "sha256/<base64 hash>"
+	ControlPlaneCert      string `toml:"control_plane_cert" env:"RVPN_CONTROL_PLANE_CERT"`
+	ControlPlanePublicKey string `toml:"control_plane_public_key" env:"RVPN_CONTROL_PLANE_PUBLIC_KEY"`
+
+	// Insecure allows control planes without TLS, tokens are then sent in plaintext
+	Insecure bool `toml:"insecure" env:"RVPN_INSECURE"`
+}
+
+// DefaultConfig returns the configuration which is used for options missing from the config file
+func DefaultConfig() Config {
+	return Config{
+		ControlPlaneURL:  "https://rvpn.jimmyli.us",
+		RPCAddr:          "127.0.0.1:52370",
+		RPCSocket:        defaultRPCSocket,
+		InterfaceName:    defaultInterfaceName,
+		MTU:              1420,
+		ClientListenPort: 51720,
+		ServePort:        21820,
+	}
+}
+
+// LoadConfig reads the config file and applies environment overrides on top of it, the config file is optional
+func LoadConfig() (Config, error) {
+	config := DefaultConfig()
+
+	configPath, err := getRVpnConfigPath()
+	if err != nil {
+		return Config{}, err
+	}
+
+	_, err = toml.DecodeFile(configPath, &config)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+	}
+
+	err = env.Parse(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse config environment variables: %w", err)
+	}
+
+	err = config.validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// validate returns an error if an option of the config is invalid
+func (c Config) validate() error {
+	if err := c.ValidateControlPlaneURL(c.ControlPlaneURL); err != nil {
+		return err
+	}
+
+	if err := c.validatePins(); err != nil {
+		return err
+	}
+
+	if _, _, err := net.SplitHostPort(c.RPCAddr); err != nil {
+		return fmt.Errorf("invalid rpc address %s: %w", c.RPCAddr, err)
+	}
+
+	if c.InterfaceName == "" {
+		return errors.New("interface name must not be empty")
+	}
+
+	if c.MTU < 576 || c.MTU > 65535 {
+		return fmt.Errorf("invalid mtu %d", c.MTU)
+	}
+
+	for _, port := range []int{c.ClientListenPort, c.ServePort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+
+	return nil
+}
+
+// ValidateControlPlaneURL returns an error if the control plane url is not a https url, http urls are only valid if
+// the config is insecure
+func (c Config) ValidateControlPlaneURL(controlPlaneURL string) error {
+	if strings.HasPrefix(controlPlaneURL, "http://") {
+		if !c.Insecure {
+			return fmt.Errorf("control plane url %s does not use TLS, set insecure in the daemon config to allow it",
+				controlPlaneURL)
+		}
+
+		return nil
+	}
+
+	if !strings.HasPrefix(controlPlaneURL, "https://") {
+		return fmt.Errorf("invalid control plane url %s, must start with https://", controlPlaneURL)
+	}
+
+	return nil
+}
+
+// DialDaemon connects to the RPC server of the rVPN daemon at the address from the config
+func DialDaemon() (*rpc.Client, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return dialRPC(config)
+}
+
+// ControlPlaneWS returns the WebSocket url of the control plane
+func (c Config) ControlPlaneWS() string {
+	// NOTE: control plane urls are validated to start with http:// or https://
+	return "ws" + strings.TrimPrefix(c.ControlPlaneURL, "http")
+}
+
+// deviceConfig returns the configuration of the wireguard device
+func (c Config) deviceConfig() wg.DeviceConfig {
+	return wg.DeviceConfig{
+		InterfaceName:    c.InterfaceName,
+		MTU:              c.MTU,
+		ClientListenPort: c.ClientListenPort,
+	}
+}
@@ -0,0 +1,17 @@
+//go:build darwin
+
+package daemon
+
+import (
+	"path"
+)
+
+const (
+	configDir            = "/etc/rvpn"
+	defaultInterfaceName = "utun140" // NOTE: macOS requires utun interface names
+)
+
+// getRVpnConfigPath gets the rVPN config file path from the system
+func getRVpnConfigPath() (string, error) {
+	return path.Join(configDir, "config.toml"), nil
+}
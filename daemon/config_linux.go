@@ -0,0 +1,17 @@
+//go:build linux
+
+package daemon
+
+import (
+	"path"
+)
+
+const (
+	configDir            = "/etc/rvpn"
+	defaultInterfaceName = "rvpn0"
+)
+
+// getRVpnConfigPath gets the rVPN config file path from the system
+func getRVpnConfigPath() (string, error) {
+	return path.Join(configDir, "config.toml"), nil
+}
@@ -0,0 +1,66 @@
+package daemon
+
+import (
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{name: "default", modify: func(c *Config) {}},
+		{name: "minimum mtu", modify: func(c *Config) { c.MTU = 576 }},
+		{name: "mtu too small", modify: func(c *Config) { c.MTU = 575 }, wantErr: true},
+		{name: "mtu too large", modify: func(c *Config) { c.MTU = 65536 }, wantErr: true},
+		{name: "empty interface name", modify: func(c *Config) { c.InterfaceName = "" }, wantErr: true},
+		{name: "rpc address without port", modify: func(c *Config) { c.RPCAddr = "127.0.0.1" }, wantErr: true},
+		{name: "client listen port zero", modify: func(c *Config) { c.ClientListenPort = 0 }, wantErr: true},
+		{name: "serve port too large", modify: func(c *Config) { c.ServePort = 65536 }, wantErr: true},
+		{name: "invalid control plane url", modify: func(c *Config) { c.ControlPlaneURL = "rvpn.example.com" }, wantErr: true},
+		{name: "invalid pinned public key", modify: func(c *Config) { c.ControlPlanePublicKey = "sha256/abc" }, wantErr: true},
+		{name: "missing pinned certificate", modify: func(c *Config) { c.ControlPlaneCert = "/nonexistent/cert.pem" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(&config)
+
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateControlPlaneURL(t *testing.T) {
+	tests := []struct {
+		name            string
+		controlPlaneURL string
+		insecure        bool
+		wantErr         bool
+	}{
+		{name: "https", controlPlaneURL: "https://rvpn.example.com"},
+		{name: "https insecure", controlPlaneURL: "https://rvpn.example.com", insecure: true},
+		{name: "http", controlPlaneURL: "http://rvpn.example.com", wantErr: true},
+		{name: "http insecure", controlPlaneURL: "http://rvpn.example.com", insecure: true},
+		{name: "no scheme", controlPlaneURL: "rvpn.example.com", wantErr: true},
+		{name: "no scheme insecure", controlPlaneURL: "rvpn.example.com", insecure: true, wantErr: true},
+		{name: "websocket", controlPlaneURL: "wss://rvpn.example.com", wantErr: true},
+		{name: "empty", controlPlaneURL: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Insecure: tt.insecure}
+
+			err := config.ValidateControlPlaneURL(tt.controlPlaneURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateControlPlaneURL(%q) error = %v, wantErr %v", tt.controlPlaneURL, err, tt.wantErr)
+			}
+		})
+	}
+}
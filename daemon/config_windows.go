@@ -0,0 +1,18 @@
+//go:build windows
+
+package daemon
+
+import (
+	"path"
+
+	"github.com/kirsle/configdir"
+)
+
+const defaultInterfaceName = "rvpn0"
+
+// getRVpnConfigPath gets the rVPN config file path from the system
+func getRVpnConfigPath() (string, error) {
+	configPaths := configdir.SystemConfig("rvpn")
+
+	return path.Join(configPaths[0], "config.toml"), nil
+}
@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/denisbrodbeck/machineid"
+	"github.com/redpwn/rvpn/common"
+)
+
+// controlplane.go holds the requests the daemon sends to the control plane on behalf of the client, the client never
+// sees the tokens of an account
+
+// TargetInfo is a target of an account which the device can connect to
+type TargetInfo struct {
+	Name string `json:"name"`
+}
+
+var (
+	// errInvalidLoginToken is returned if the control plane rejects the login token of an account
+	errInvalidLoginToken = errors.New("invalid rVPN login token, please check target / login token and try again")
+
+	// errForbidden is returned if the control plane denies the account access to a resource, i.e a target
+	errForbidden = errors.New("rVPN control plane denied access")
+)
+
+// controlPlaneError is the body of an error response of the control plane
+type controlPlaneError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// loggedInAccount returns the named account or the active account if name is empty together with the config for
+// its control plane, it returns an error if the account is not logged in
+func (r *RVPNDaemon) loggedInAccount(rVPNState RVpnState, name string) (string, Account, Config, error) {
+	accountName, account, err := rVPNState.GetAccount(name)
+	if err != nil || account.ControlPlaneAuth == "" {
+		return "", Account{}, Config{}, fmt.Errorf(`%w %s, login first using "rvpn login [token]" or "rvpn account `+
+			`add"`, errNotLoggedIn, accountName)
+	}
+
+	return accountName, account, r.config.ForAccount(account), nil
+}
+
+// newControlSession registers the device for a target of the account and returns the control session for it, the
+// device token is saved to the account
+func (r *RVPNDaemon) newControlSession(endpoint string, accountName string, profile string) (controlSession, error) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	rVPNState, err := GetRVpnState()
+	if err != nil {
+		return controlSession{}, fmt.Errorf("failed to get rVPN state: %w", err)
+	}
+
+	accountName, account, config, err := r.loggedInAccount(rVPNState, accountName)
+	if err != nil {
+		return controlSession{}, err
+	}
+
+	deviceToken, err := registerDevice(config, account, profile)
+	if err != nil {
+		return controlSession{}, err
+	}
+
+	// remember the registration with the account
+	if account.DeviceTokens == nil {
+		account.DeviceTokens = map[string]string{}
+	}
+	account.DeviceTokens[profile] = deviceToken
+	rVPNState.SetAccount(accountName, account)
+
+	err = SetRVpnState(rVPNState)
+	if err != nil {
+		return controlSession{}, fmt.Errorf("failed to save rVPN state: %w", err)
+	}
+
+	return controlSession{
+		endpoint:       endpoint,
+		account:        accountName,
+		profile:        profile,
+		deviceToken:    deviceToken,
+		controlPlaneWS: config.ControlPlaneWS(),
+	}, nil
+}
+
+// registerDevice registers the device for a target of the account and returns the device token
+func registerDevice(config Config, account Account, profile string) (string, error) {
+	machineId, err := machineid.ID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get machine id: %w", err)
+	}
+
+	controlPlaneURL := config.ControlPlaneURL + "/api/v1/target/" + profile + "/register_device"
+	jsonStr := []byte(fmt.Sprintf(`{"hardwareId":"%s"}`, machineId))
+
+	req, err := http.NewRequest("POST", controlPlaneURL, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return "", fmt.Errorf("register device request failed: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", account.ControlPlaneAuth))
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := doControlPlaneRequest(config, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send device registration request: %w", err)
+	}
+
+	deviceRegistrationResp := common.RegisterDeviceResponse{}
+	err = json.Unmarshal(body, &deviceRegistrationResp)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal device registration response: %w", err)
+	}
+
+	return deviceRegistrationResp.DeviceToken, nil
+}
+
+// listTargets lists the targets of the account
+func listTargets(config Config, account Account) ([]TargetInfo, error) {
+	controlPlaneURL := config.ControlPlaneURL + "/api/v1/target/"
+
+	req, err := http.NewRequest("GET", controlPlaneURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list target profiles request failed: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", account.ControlPlaneAuth))
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := doControlPlaneRequest(config, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send list target profiles request: %w", err)
+	}
+
+	var targetList []TargetInfo
+	err = json.Unmarshal(body, &targetList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user targets response: %w", err)
+	}
+
+	return targetList, nil
+}
+
+// doControlPlaneRequest sends the request to the control plane of the config and returns the response body
+func doControlPlaneRequest(config Config, req *http.Request) ([]byte, error) {
+	httpClient, err := config.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read control plane response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, errInvalidLoginToken
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %s", errForbidden, controlPlaneErrorMessage(resp.Status, body))
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("control plane request failed: %s", controlPlaneErrorMessage(resp.Status, body))
+	}
+
+	return body, nil
+}
+
+// controlPlaneErrorMessage returns the message of an error response of the control plane, the status is returned if
+// the body has no message
+func controlPlaneErrorMessage(status string, body []byte) string {
+	var errorResp controlPlaneError
+	err := json.Unmarshal(body, &errorResp)
+	if err != nil || errorResp.Error.Message == "" {
+		return status
+	}
+
+	return errorResp.Error.Message
+}
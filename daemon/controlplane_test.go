@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDoControlPlaneRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		want     string
+		wantErr  bool
+		wantCode ErrorCode
+	}{
+		{name: "ok", status: http.StatusOK, body: `{"deviceToken":"token"}`, want: `{"deviceToken":"token"}`},
+		{name: "created", status: http.StatusCreated, body: `{}`, want: `{}`},
+		{
+			name:     "unauthorized",
+			status:   http.StatusUnauthorized,
+			body:     `{"error":{"message":"unauthorized"}}`,
+			wantErr:  true,
+			wantCode: ErrorUnauthorized,
+		},
+		{
+			name:     "forbidden",
+			status:   http.StatusForbidden,
+			body:     `{"error":{"message":"user is not authorized for this target"}}`,
+			wantErr:  true,
+			wantCode: ErrorUnauthorized,
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			body:     `{"error":{"message":"something went wrong"}}`,
+			wantErr:  true,
+			wantCode: ErrorUnknown,
+		},
+		{
+			name:     "not found without error body",
+			status:   http.StatusNotFound,
+			body:     "Cannot GET /api/v1/target/",
+			wantErr:  true,
+			wantCode: ErrorUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest("GET", server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := doControlPlaneRequest(Config{ControlPlaneURL: server.URL, Insecure: true}, req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("doControlPlaneRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				if code := errorCode(err); code != tt.wantCode {
+					t.Errorf("doControlPlaneRequest() error code = %s, want %s", code, tt.wantCode)
+				}
+				return
+			}
+
+			if string(body) != tt.want {
+				t.Errorf("doControlPlaneRequest() = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlPlaneErrorMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		body   string
+		want   string
+	}{
+		{
+			name:   "error body",
+			status: "500 Internal Server Error",
+			body:   `{"error":{"message":"something went wrong"}}`,
+			want:   "something went wrong",
+		},
+		{name: "empty message", status: "400 Bad Request", body: `{"error":{}}`, want: "400 Bad Request"},
+		{name: "not json", status: "502 Bad Gateway", body: "<html>", want: "502 Bad Gateway"},
+		{name: "empty body", status: "404 Not Found", want: "404 Not Found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := controlPlaneErrorMessage(tt.status, []byte(tt.body)); got != tt.want {
+				t.Errorf("controlPlaneErrorMessage(%q, %q) = %q, want %q", tt.status, tt.body, got, tt.want)
+			}
+		})
+	}
+}
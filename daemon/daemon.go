@@ -2,14 +2,18 @@ package daemon
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"net/rpc"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -29,29 +33,65 @@ const (
 	StatusConnected RVPNStatus = iota
 	StatusDisconnected
 	StatusServing
+	StatusReconnecting // the control channel closed and is being reopened
+	StatusConnecting   // connecting to a target or starting to serve it
 )
 
+// String returns the name of the status
+func (s RVPNStatus) String() string {
+	switch s {
+	case StatusConnected:
+		return "connected"
+	case StatusDisconnected:
+		return "disconnected"
+	case StatusServing:
+		return "serving"
+	case StatusReconnecting:
+		return "reconnecting"
+	case StatusConnecting:
+		return "connecting"
+	default:
+		return "unknown"
+	}
+}
+
 type ConnectRequest struct {
-	Profile        string
-	DeviceToken    string
-	ControlPlaneWS string
-	Opts           common.ClientOptions
+	Account string // empty uses the active account
+	Profile string
+	Opts    common.ClientOptions
 }
 
 type ServeRequest struct {
-	Profile        string
-	DeviceToken    string
-	ControlPlaneWS string
+	Account string // empty uses the active account
+	Profile string
+	Opts    common.ServeOptions
+}
+
+type LoginRequest struct {
+	Account string // empty uses the active account
+	Token   string // empty logs out of the account
+}
+
+type AddAccountRequest struct {
+	Name            string
+	ControlPlaneURL string
+	Token           string
 }
 
 // RVPNDaemon represents a rVPN daemon instance
 type RVPNDaemon struct {
+	config               Config
 	status               RVPNStatus
 	activeControlPlaneWs *websocket.Conn
-	activeProfile        string
+	sessionInfo          sessionInfo // account, target and tunnel of the session
+	sessionStart         time.Time   // when the session connected or started serving, zero if disconnected
 	jrpcConn             *jsonrpc2.Conn
 	jrpcCtxCancel        context.CancelFunc // cancels the context for the jrpc ctx
-	opts                 common.ClientOptions
+	connectResult        chan error         // receives the outcome of a pending Connect
+	supervisorCancel     context.CancelFunc // stops reopening the control channel of the session
+	stateMu              sync.Mutex         // serializes changes to the accounts in rVPN state
+	sessionMu            sync.Mutex         // guards the session info, status, control channel, connect result, supervisor
+	events               *eventLog          // recent status, handshake and peer events for watchers
 
 	// internal variables used for underlying control
 	wireguardDaemon *wg.WireguardDaemon
@@ -61,6 +101,7 @@ type RVPNDaemon struct {
 func NewRVPNDaemon() *RVPNDaemon {
 	return &RVPNDaemon{
 		status:     StatusDisconnected,
+		events:     newEventLog(),
 		manualTerm: make(chan int),
 	}
 }
@@ -70,11 +111,24 @@ type jrpcHandler struct {
 	activeRVPNDaemon *RVPNDaemon // rVPN daemon for jrpcHandler to control
 	deviceToken      string      // deviceToken for jrpcHandler to AuthN
 	controlPlaneAddr string      // control plane address for the jrpc connection
+	controlPlaneWS   string      // control plane WebSocket url for the relay
+	tlsConfig        *tls.Config // TLS config which verifies the control plane for the relay
+	authenticated    bool        // whether the control plane of the jrpc connection is authenticated by TLS
 }
 
-// remoteAddressDialHook hooks DialContext of the http client and writes the remote ip to an outparam
-func remoteAddressDialHook(remoteAddressPtr *net.Addr) func(ctx context.Context, network string, address string) (net.Conn, error) {
+// remoteAddressDialHook hooks DialContext of the http client and writes the remote ip to an outparam, the host is
+// replaced with pinnedHost unless it is empty
+func remoteAddressDialHook(remoteAddressPtr *net.Addr, pinnedHost string) func(ctx context.Context, network string, address string) (net.Conn, error) {
 	hookedDialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if pinnedHost != "" {
+			_, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+
+			address = net.JoinHostPort(pinnedHost, port)
+		}
+
 		originalDialer := &net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -93,14 +147,81 @@ func remoteAddressDialHook(remoteAddressPtr *net.Addr) func(ctx context.Context,
 	return hookedDialContext
 }
 
-// heartbeatGenerator keeps sending heartbeats until context is cancelled every interval
-func heartbeatGenerator(ctx context.Context, interval time.Duration, conn *jsonrpc2.Conn) {
+// dialControlPlane opens the WebSocket of the session to the control plane and serves jrpc on top of it until the
+// context is cancelled
+func (r *RVPNDaemon) dialControlPlane(ctx context.Context, session controlSession) (*jsonrpc2.Conn, error) {
+	// the kill switch only allows the known control plane ip, name resolution may be blocked
+	pinnedHost := ""
+	if info := r.getSessionInfo(); session.endpoint == connectEndpoint && info.opts.KillSwitch {
+		pinnedHost = info.controlPlaneAddr
+	}
+
+	// tokens are only sent to control planes authenticated by TLS unless the config is insecure
+	authenticated := strings.HasPrefix(session.controlPlaneWS, "wss://")
+	if !authenticated && !r.config.Insecure {
+		return nil, errors.New("refusing to connect to rVPN control plane without TLS, set insecure in the daemon " +
+			"config to allow it")
+	}
+
+	tlsConfig, err := r.config.forControlPlaneWS(session.controlPlaneWS).TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var controlPlaneRemoteAddr net.Addr
+	customTransport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           remoteAddressDialHook(&controlPlaneRemoteAddr, pinnedHost),
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	customHttpClient := http.Client{
+		Transport: customTransport,
+	}
+
+	websocketURL := session.controlPlaneWS + "/api/v1/target/" + session.profile + "/" + session.endpoint
+	conn, _, err := websocket.Dial(ctx, websocketURL, &websocket.DialOptions{
+		HTTPClient: &customHttpClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.activeControlPlaneWs = conn
+
+	// parse out the remote address of control plane
+	// NOTE: we expect the net.addr to be of the form "192.168.1.1:80"
+	controlPlaneAddrStr := strings.Split(controlPlaneRemoteAddr.String(), ":")[0]
+	r.updateSessionInfo(func(info *sessionInfo) {
+		info.controlPlaneAddr = controlPlaneAddrStr
+	})
+
+	// now we are authenticated, create jrpc connection on top of websocket stream
+	jrpcConn := jsonrpc2.NewConn(ctx, jrpc.NewObjectStream(conn), jrpcHandler{
+		activeRVPNDaemon: r,
+		deviceToken:      session.deviceToken,
+		controlPlaneAddr: controlPlaneAddrStr,
+		controlPlaneWS:   session.controlPlaneWS,
+		tlsConfig:        tlsConfig,
+		authenticated:    authenticated,
+	})
+
+	return jrpcConn, nil
+}
+
+// heartbeatGenerator keeps sending heartbeats with the peer traffic statistics of the wireguard interface until
+// context is cancelled every interval
+func heartbeatGenerator(ctx context.Context, interval time.Duration, conn *jsonrpc2.Conn, wireguardDaemon *wg.WireguardDaemon) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	// ticker only ticks after interval, manually send a heartbeat first
 	var deviceHeartbeatResponse common.DeviceHeartbeatResponse
-	err := conn.Call(ctx, common.DeviceHeartbeatMethod, common.DeviceHeartbeatRequest{}, &deviceHeartbeatResponse)
+	err := conn.Call(ctx, common.DeviceHeartbeatMethod, deviceHeartbeatRequest(wireguardDaemon), &deviceHeartbeatResponse)
 	if err != nil {
 		log.Printf("failed to send device heartbeat: %v", err)
 	}
@@ -109,7 +230,7 @@ func heartbeatGenerator(ctx context.Context, interval time.Duration, conn *jsonr
 		select {
 		case <-ticker.C:
 			// it has reached the interval so send heartbeat
-			err = conn.Call(ctx, common.DeviceHeartbeatMethod, common.DeviceHeartbeatRequest{}, &deviceHeartbeatResponse)
+			err = conn.Call(ctx, common.DeviceHeartbeatMethod, deviceHeartbeatRequest(wireguardDaemon), &deviceHeartbeatResponse)
 			if err != nil {
 				log.Printf("failed to send device heartbeat: %v", err)
 			}
@@ -122,11 +243,96 @@ func heartbeatGenerator(ctx context.Context, interval time.Duration, conn *jsonr
 	}
 }
 
+// completeConnectServer verifies the tunnel to the rVPN server after it was configured, a healthy tunnel is reported as
+// connected and an unhealthy tunnel is rolled back, the kill switch is enabled once the tunnel is healthy
+func completeConnectServer(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, req *jsonrpc2.Request, serverInternalIp string) {
+	err := verifyTunnel(h.activeRVPNDaemon.wireguardDaemon, serverInternalIp)
+	if err != nil {
+		err = fmt.Errorf("tunnel health check failed, %v: %w", err, errTargetOffline)
+	} else if ctx.Err() != nil {
+		// Connect gave up waiting and closed the jrpc connection
+		err = errors.New("connection was closed while verifying tunnel")
+	} else if h.activeRVPNDaemon.getSessionInfo().opts.KillSwitch {
+		// block traffic outside of the tunnel until the user disconnects, also if the tunnel drops later on
+		err = h.activeRVPNDaemon.wireguardDaemon.EnableKillSwitch()
+		if err != nil {
+			err = fmt.Errorf("failed to enable kill switch: %w", err)
+		}
+	}
+
+	if err != nil {
+		// remove the routes and source rules of the unhealthy tunnel so traffic is not blackholed
+		log.Printf("failed to complete connection, rolling back: %v", err)
+		h.activeRVPNDaemon.wireguardDaemon.Disconnect()
+		h.activeRVPNDaemon.publishError(fmt.Sprintf("failed to complete connection: %v", err))
+
+		conn.Reply(ctx, req.ID, common.ConnectServerResponse{
+			Success: false,
+		})
+		h.activeRVPNDaemon.reportConnectResult(err)
+		return
+	}
+
+	h.activeRVPNDaemon.setStatus(StatusConnected, "")
+
+	log.Printf("daemon successfully connected to rVPN target server")
+	conn.Reply(ctx, req.ID, common.ConnectServerResponse{
+		Success: true,
+	})
+	h.activeRVPNDaemon.reportConnectResult(nil)
+
+	// launch goroutine to send heartbeat to keep WS alive
+	// NOTE: context is of the jrpc connection which should be kept alive
+	go heartbeatGenerator(ctx, 30*time.Second, conn, h.activeRVPNDaemon.wireguardDaemon)
+
+	// launch goroutine to rotate the wireguard key on a schedule
+	go keyRotationGenerator(ctx, keyRotationInterval, conn, h.activeRVPNDaemon.wireguardDaemon)
+}
+
+// deviceHeartbeatRequest returns a heartbeat with the peer traffic statistics of the wireguard interface, the
+// statistics are left out if they cannot be read
+func deviceHeartbeatRequest(wireguardDaemon *wg.WireguardDaemon) common.DeviceHeartbeatRequest {
+	wgPeerStats, err := wireguardDaemon.PeerStats()
+	if err != nil {
+		log.Printf("failed to get peer stats: %v", err)
+		return common.DeviceHeartbeatRequest{}
+	}
+
+	peerStats := []common.PeerStats{}
+	for _, wgPeerStat := range wgPeerStats {
+		lastHandshake := int64(0)
+		if !wgPeerStat.LastHandshake.IsZero() {
+			lastHandshake = wgPeerStat.LastHandshake.Unix()
+		}
+
+		peerStats = append(peerStats, common.PeerStats{
+			PublicKey:     wgPeerStat.PublicKey,
+			Endpoint:      wgPeerStat.Endpoint,
+			LastHandshake: lastHandshake,
+			ReceiveBytes:  wgPeerStat.ReceiveBytes,
+			TransmitBytes: wgPeerStat.TransmitBytes,
+		})
+	}
+
+	return common.DeviceHeartbeatRequest{
+		Peers: peerStats,
+	}
+}
+
 func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
 	switch req.Method {
 	case common.GetDeviceAuthMethod:
 		// return device AuthN information to rVPN control plane
 
+		if !h.authenticated && !h.activeRVPNDaemon.config.Insecure {
+			// NOTE: the device token would be readable by anyone on the path to the control plane
+			log.Printf("refusing to send device token to rVPN control plane without TLS")
+			conn.Reply(ctx, req.ID, common.GetDeviceAuthResponse{
+				Success: false,
+			})
+			return
+		}
+
 		conn.Reply(ctx, req.ID, common.GetDeviceAuthResponse{
 			Success:     true,
 			DeviceToken: h.deviceToken,
@@ -167,8 +373,10 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 
 		// we must have a saved public key in rVPN state, return information to control plane
 		clientInformationResponse := common.GetClientInformationResponse{
-			Success:   true,
-			PublicKey: rVPNState.PublicKey,
+			Success:    true,
+			PublicKey:  rVPNState.PublicKey,
+			Subnets:    h.activeRVPNDaemon.getSessionInfo().opts.AdvertiseSubnets,
+			ListenPort: h.activeRVPNDaemon.config.ClientListenPort,
 		}
 		conn.Reply(ctx, req.ID, clientInformationResponse)
 	case common.GetServeInformationMethod:
@@ -209,7 +417,8 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 		clientInformationResponse := common.GetServeInformationResponse{
 			Success:       true,
 			PublicKey:     rVPNState.PublicKey,
-			PublicVpnPort: "21820", // TODO: allow this to be overriden with config flags
+			PublicVpnPort: strconv.Itoa(h.activeRVPNDaemon.config.ServePort),
+			Subnets:       h.activeRVPNDaemon.getSessionInfo().serveOpts.Subnets,
 		}
 		conn.Reply(ctx, req.ID, clientInformationResponse)
 	case common.ConnectServerMethod:
@@ -222,6 +431,8 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			conn.Reply(ctx, req.ID, common.ConnectServerResponse{
 				Success: false,
 			})
+			h.activeRVPNDaemon.reportConnectResult(fmt.Errorf("failed to get rVPN state: %w", err))
+			return
 		}
 
 		if rVPNState.PublicKey == "" || rVPNState.PrivateKey == "" {
@@ -230,6 +441,8 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			conn.Reply(ctx, req.ID, common.ConnectServerResponse{
 				Success: false,
 			})
+			h.activeRVPNDaemon.reportConnectResult(errors.New("pubkey or privkey is not set"))
+			return
 		}
 
 		// parse information from jrpc request
@@ -240,6 +453,8 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			conn.Reply(ctx, req.ID, common.ConnectServerResponse{
 				Success: false,
 			})
+			h.activeRVPNDaemon.reportConnectResult(fmt.Errorf("invalid connect instructions from control plane: %w", err))
+			return
 		}
 
 		// validate pubkey from connectServerRequest matches local pubkey
@@ -248,38 +463,258 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			conn.Reply(ctx, req.ID, common.ConnectServerResponse{
 				Success: false,
 			})
+			h.activeRVPNDaemon.reportConnectResult(errors.New("pubkey has fallen out of sync between control plane and device, try again"))
+			return
+		}
+
+		// subnets provided by the client override the subnets advertised by the target
+		info := h.activeRVPNDaemon.getSessionInfo()
+		subnets := connectServerRequest.Subnets
+		if len(info.opts.Subnets) > 0 {
+			subnets = info.opts.Subnets
+		}
+
+		err = common.ValidateSubnets(subnets)
+		if err != nil {
+			log.Printf("invalid subnets from control plane: %v", err)
+			conn.Reply(ctx, req.ID, common.ConnectServerResponse{
+				Success: false,
+			})
+			h.activeRVPNDaemon.reportConnectResult(err)
+			return
 		}
 
 		// update rVPN wireguard config with instructions from rVPN control plane
 		userConfig := wg.ClientWgConfig{
-			ClientPrivateKey: rVPNState.PrivateKey,
-			ServerPublicKey:  connectServerRequest.ServerPublicKey,
-			ClientIp:         connectServerRequest.ClientIp,
-			ClientCidr:       connectServerRequest.ClientCidr,
-			ServerIp:         connectServerRequest.ServerIp,
-			ServerPort:       connectServerRequest.ServerPort,
-			DnsIp:            connectServerRequest.DnsIp,
+			ClientPrivateKey:  rVPNState.PrivateKey,
+			ServerPublicKey:   connectServerRequest.ServerPublicKey,
+			PresharedKey:      connectServerRequest.PresharedKey,
+			ClientIp:          connectServerRequest.ClientIp,
+			ClientCidr:        connectServerRequest.ClientCidr,
+			ServerIp:          connectServerRequest.ServerIp,
+			ServerPort:        connectServerRequest.ServerPort,
+			DnsIp:             connectServerRequest.DnsIp,
+			Subnets:           subnets,
+			AdvertisedSubnets: info.opts.AdvertiseSubnets,
 		}
 		h.activeRVPNDaemon.wireguardDaemon.UpdateClientConf(userConfig, h.controlPlaneAddr)
 
-		// TODO: wait then run a check to ensure connection is healthy, otherwise abort
+		routes := subnets
+		if len(routes) == 0 {
+			routes = []string{"0.0.0.0/0"}
+		}
+
+		h.activeRVPNDaemon.updateSessionInfo(func(info *sessionInfo) {
+			info.tunnel = tunnelInfo{
+				address:         connectServerRequest.ClientIp + connectServerRequest.ClientCidr,
+				serverEndpoint:  net.JoinHostPort(connectServerRequest.ServerIp, strconv.Itoa(connectServerRequest.ServerPort)),
+				serverPublicKey: connectServerRequest.ServerPublicKey,
+				routes:          routes,
+				dns:             connectServerRequest.DnsIp,
+			}
+		})
+
+		// relay packets through the control plane if UDP to the rVPN server is blocked
+		if connectServerRequest.RelayToken != "" {
+			go relayClientFallback(ctx, h.activeRVPNDaemon.wireguardDaemon, h.controlPlaneWS, h.tlsConfig,
+				info.profile, connectServerRequest.RelayToken, connectServerRequest.ServerIp, connectServerRequest.ServerPort)
+		}
 
-		h.activeRVPNDaemon.status = StatusConnected
+		// the tunnel is verified in the background so the jrpc connection is not blocked, the reply is sent once the
+		// tunnel is healthy
+		go completeConnectServer(ctx, h, conn, req, connectServerRequest.ServerInternalIp)
+	case common.UpdateRoutesMethod:
+		// update the subnets routed through the rVPN server with instructions from rVPN control plane
 
-		log.Printf("daemon successfully connected to rVPN target server")
-		conn.Reply(ctx, req.ID, common.ConnectServerResponse{
+		var updateRoutesRequest common.UpdateRoutesRequest
+		err := json.Unmarshal(*req.Params, &updateRoutesRequest)
+		if err != nil {
+			log.Printf("failed to unmarshal updateroutes request params: %v", err)
+			conn.Reply(ctx, req.ID, common.UpdateRoutesResponse{
+				Success: false,
+			})
+			return
+		}
+
+		if len(h.activeRVPNDaemon.getSessionInfo().opts.Subnets) > 0 {
+			// subnets provided by the client override the subnets advertised by the target
+			log.Printf("ignoring updated routes as subnets are overridden by client")
+			conn.Reply(ctx, req.ID, common.UpdateRoutesResponse{
+				Success: true,
+			})
+			return
+		}
+
+		err = h.activeRVPNDaemon.wireguardDaemon.UpdateClientRoutes(updateRoutesRequest.Subnets)
+		if err != nil {
+			log.Printf("failed to update client routes: %v", err)
+			conn.Reply(ctx, req.ID, common.UpdateRoutesResponse{
+				Success: false,
+			})
+			return
+		}
+
+		routes := updateRoutesRequest.Subnets
+		if len(routes) == 0 {
+			routes = []string{"0.0.0.0/0"}
+		}
+
+		h.activeRVPNDaemon.updateSessionInfo(func(info *sessionInfo) {
+			info.tunnel.routes = routes
+		})
+
+		log.Printf("daemon successfully updated routes to rVPN target server")
+		conn.Reply(ctx, req.ID, common.UpdateRoutesResponse{
 			Success: true,
 		})
+	case common.UpdateMeshPeersMethod:
+		// update the peers connected to directly with instructions from rVPN control plane in mesh mode
 
-		// launch goroutine to send heartbeat to keep WS alive
-		// NOTE: context is of the jrpc connection which should be kept alive
-		go heartbeatGenerator(ctx, 30*time.Second, conn)
+		var updateMeshPeersRequest common.UpdateMeshPeersRequest
+		err := json.Unmarshal(*req.Params, &updateMeshPeersRequest)
+		if err != nil {
+			log.Printf("failed to unmarshal updatemeshpeers request params: %v", err)
+			conn.Reply(ctx, req.ID, common.UpdateMeshPeersResponse{
+				Success: false,
+			})
+			return
+		}
+
+		wgPeers := []wg.WireGuardPeer{}
+		for _, requestPeer := range updateMeshPeersRequest.Peers {
+			wgPeers = append(wgPeers, wg.WireGuardPeer{
+				PublicKey:   requestPeer.PublicKey,
+				AllowedIP:   requestPeer.AllowedIP,
+				AllowedCidr: requestPeer.AllowedCidr,
+				Endpoint:    requestPeer.Endpoint,
+			})
+		}
+
+		err = h.activeRVPNDaemon.wireguardDaemon.UpdateMeshPeers(wgPeers)
+		if err != nil {
+			log.Printf("failed to update mesh peers: %v", err)
+			conn.Reply(ctx, req.ID, common.UpdateMeshPeersResponse{
+				Success: false,
+			})
+			return
+		}
+
+		log.Printf("daemon successfully updated mesh peers")
+		conn.Reply(ctx, req.ID, common.UpdateMeshPeersResponse{
+			Success: true,
+		})
+	case common.DiscoverEndpointMethod:
+		// discover the public endpoint of the wireguard socket using the STUN server of the rVPN control plane
+
+		var discoverEndpointRequest common.DiscoverEndpointRequest
+		err := json.Unmarshal(*req.Params, &discoverEndpointRequest)
+		if err != nil {
+			log.Printf("failed to unmarshal discoverendpoint request params: %v", err)
+			conn.Reply(ctx, req.ID, common.DiscoverEndpointResponse{
+				Success: false,
+			})
+			return
+		}
+
+		stunServer := net.JoinHostPort(h.controlPlaneAddr, strconv.Itoa(discoverEndpointRequest.StunPort))
+		candidates, err := h.activeRVPNDaemon.wireguardDaemon.DiscoverEndpoint(stunServer)
+		if err != nil {
+			log.Printf("failed to discover endpoint: %v", err)
+			conn.Reply(ctx, req.ID, common.DiscoverEndpointResponse{
+				Success: false,
+			})
+			return
+		}
+
+		conn.Reply(ctx, req.ID, common.DiscoverEndpointResponse{
+			Success:    true,
+			Candidates: candidates,
+		})
+	case common.PunchHoleMethod:
+		// open NAT for a peer endpoint so the peer can complete a handshake
+
+		var punchHoleRequest common.PunchHoleRequest
+		err := json.Unmarshal(*req.Params, &punchHoleRequest)
+		if err != nil {
+			log.Printf("failed to unmarshal punchhole request params: %v", err)
+			conn.Reply(ctx, req.ID, common.PunchHoleResponse{
+				Success: false,
+			})
+			return
+		}
+
+		err = h.activeRVPNDaemon.wireguardDaemon.PunchHole(punchHoleRequest.Endpoint)
+		if err != nil {
+			log.Printf("failed to punch hole: %v", err)
+			conn.Reply(ctx, req.ID, common.PunchHoleResponse{
+				Success: false,
+			})
+			return
+		}
+
+		conn.Reply(ctx, req.ID, common.PunchHoleResponse{
+			Success: true,
+		})
+	case common.UpdateServerKeyMethod:
+		// stage or switch to the rotated key of the rVPN server with instructions from rVPN control plane
+
+		var updateServerKeyRequest common.UpdateServerKeyRequest
+		err := json.Unmarshal(*req.Params, &updateServerKeyRequest)
+		if err != nil {
+			log.Printf("failed to unmarshal updateserverkey request params: %v", err)
+			conn.Reply(ctx, req.ID, common.UpdateServerKeyResponse{
+				Success: false,
+			})
+			return
+		}
+
+		if updateServerKeyRequest.Staged {
+			// the server has not switched yet, stage a peer for its key which handshakes once it does
+			err = h.activeRVPNDaemon.wireguardDaemon.StageServerPublicKey(updateServerKeyRequest.ServerPublicKey)
+			if err != nil {
+				log.Printf("failed to stage server key: %v", err)
+				conn.Reply(ctx, req.ID, common.UpdateServerKeyResponse{
+					Success: false,
+				})
+				return
+			}
+
+			log.Printf("daemon successfully staged rotated key of rVPN target server")
+			conn.Reply(ctx, req.ID, common.UpdateServerKeyResponse{
+				Success: true,
+			})
+			return
+		}
+
+		err = h.activeRVPNDaemon.wireguardDaemon.UpdateServerPublicKey(updateServerKeyRequest.ServerPublicKey)
+		if err != nil {
+			log.Printf("failed to update server key: %v", err)
+			conn.Reply(ctx, req.ID, common.UpdateServerKeyResponse{
+				Success: false,
+			})
+			return
+		}
+
+		h.activeRVPNDaemon.updateSessionInfo(func(info *sessionInfo) {
+			info.tunnel.serverPublicKey = updateServerKeyRequest.ServerPublicKey
+		})
+
+		log.Printf("daemon successfully switched to rotated key of rVPN target server")
+		conn.Reply(ctx, req.ID, common.UpdateServerKeyResponse{
+			Success: true,
+		})
 	case common.ServeVPNMethod:
 		// NOTE: the serve vpn code path should only be triggered on Linux devices
 		serveVPNHandler(ctx, h, conn, req)
 	case common.AppendVPNPeersMethod:
 		// NOTE: the append peer code path should only be triggered on Linux devices
 		appendVPNPeersHandler(ctx, h, conn, req)
+	case common.DeleteVPNPeersMethod:
+		// NOTE: the delete peer code path should only be triggered on Linux devices
+		deleteVPNPeersHandler(ctx, h, conn, req)
+	case common.UpdateFirewallMethod:
+		// NOTE: the firewall code path should only be triggered on Linux devices
+		updateFirewallHandler(ctx, h, conn, req)
 	default:
 		log.Printf("unknown jrpc request method: %s\n", req.Method)
 	}
@@ -287,18 +722,84 @@ func (h jrpcHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 
 /* rVPN daemon rpc handlers */
 
+// GetConfig returns the effective configuration of the rVPN daemon
+func (r *RVPNDaemon) GetConfig(args string, reply *Config) error {
+	*reply = r.config
+
+	return nil
+}
+
+// GetState returns the rVPN state without secrets
 func (r *RVPNDaemon) GetState(args string, reply *RVpnState) error {
 	rvpnState, err := GetRVpnState()
 	if err != nil {
 		return err
 	}
 
-	*reply = rvpnState
+	*reply = rvpnState.redacted()
 	return nil
 }
 
-func (r *RVPNDaemon) SetState(args RVpnState, reply *bool) error {
-	err := SetRVpnState(args)
+// Login saves the login token of the account, the default account is created on first login
+func (r *RVPNDaemon) Login(args LoginRequest, reply *string) error {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	rVPNState, err := GetRVpnState()
+	if err != nil {
+		return err
+	}
+
+	accountName, account, err := rVPNState.GetAccount(args.Account)
+	if err != nil && (args.Account != "" || args.Token == "") {
+		// only the default account is created on login
+		return err
+	}
+
+	account.ControlPlaneAuth = args.Token
+	rVPNState.SetAccount(accountName, account)
+
+	err = SetRVpnState(rVPNState)
+	if err != nil {
+		return err
+	}
+
+	*reply = accountName
+	return nil
+}
+
+// AddAccount adds or replaces an account with its own control plane and login token, the first account which is
+// added becomes the active account
+func (r *RVPNDaemon) AddAccount(args AddAccountRequest, reply *bool) error {
+	if args.Name == "" || strings.Contains(args.Name, "/") {
+		return errors.New(`account name must not be empty or contain "/"`)
+	}
+
+	controlPlaneURL := strings.TrimSuffix(args.ControlPlaneURL, "/")
+	err := r.config.ValidateControlPlaneURL(controlPlaneURL)
+	if err != nil {
+		return err
+	}
+
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	rVPNState, err := GetRVpnState()
+	if err != nil {
+		return err
+	}
+
+	if len(rVPNState.Accounts) == 0 {
+		rVPNState.ActiveAccount = args.Name
+	}
+
+	// NOTE: device registrations belong to the control plane and are dropped when an account is replaced
+	rVPNState.SetAccount(args.Name, Account{
+		ControlPlaneURL:  controlPlaneURL,
+		ControlPlaneAuth: args.Token,
+	})
+
+	err = SetRVpnState(rVPNState)
 	if err != nil {
 		return err
 	}
@@ -307,72 +808,116 @@ func (r *RVPNDaemon) SetState(args RVpnState, reply *bool) error {
 	return nil
 }
 
+// UseAccount sets the account which is used when a request does not name an account
+func (r *RVPNDaemon) UseAccount(args string, reply *bool) error {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	rVPNState, err := GetRVpnState()
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = rVPNState.GetAccount(args); err != nil {
+		return err
+	}
+
+	rVPNState.ActiveAccount = args
+	err = SetRVpnState(rVPNState)
+	if err != nil {
+		return err
+	}
+
+	*reply = true
+	return nil
+}
+
+// ListTargets lists the targets of the named account or the active account if the name is empty
+func (r *RVPNDaemon) ListTargets(args string, reply *[]TargetInfo) error {
+	rVPNState, err := GetRVpnState()
+	if err != nil {
+		return err
+	}
+
+	_, account, config, err := r.loggedInAccount(rVPNState, args)
+	if err != nil {
+		return codedError(err)
+	}
+
+	targetList, err := listTargets(config, account)
+	if err != nil {
+		return codedError(err)
+	}
+
+	*reply = targetList
+	return nil
+}
+
 // Status returns the current status of the rVPN daemon
 func (r *RVPNDaemon) Status(args string, reply *RVPNStatus) error {
-	*reply = r.status
+	*reply = r.getStatus()
 
 	return nil
 }
 
 // Connect is responsible for creating WebSocket connection to control-plane
 func (r *RVPNDaemon) Connect(args ConnectRequest, reply *bool) error {
-	// create long-lived WebSocket connection acting as jrpc channel between client and control plane
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	var controlPlaneRemoteAddr net.Addr
-	customTransport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           remoteAddressDialHook(&controlPlaneRemoteAddr),
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-	customHttpClient := http.Client{
-		Transport: customTransport,
+	// NOTE: invalid subnets are rejected before the tunnel is configured with them
+	err := args.Opts.Validate()
+	if err != nil {
+		*reply = false
+		return err
 	}
 
-	websocketURL := args.ControlPlaneWS + "/api/v1/target/" + args.Profile + "/connect"
-	conn, _, err := websocket.Dial(ctx, websocketURL, &websocket.DialOptions{
-		HTTPClient: &customHttpClient,
-	})
+	// ensure device is registered for target
+	session, err := r.newControlSession(connectEndpoint, args.Account, args.Profile)
 	if err != nil {
-		log.Printf("failed to connect to rVPN control plane web socket: %v", err)
-		cancelFunc()
+		r.publishError(err.Error())
 
 		*reply = false
-		return nil
+		return codedError(err)
 	}
 
-	r.activeControlPlaneWs = conn
-	r.activeProfile = args.Profile
-
-	// parse out the remote address of control plane
-	// NOTE: we expect the net.addr to be of the form "192.168.1.1:80"
-	controlPlaneAddrStr := strings.Split(controlPlaneRemoteAddr.String(), ":")[0]
-
-	// now we are authenticated, create jrpc connection on top of websocket stream
-	jrpcConn := jsonrpc2.NewConn(ctx, jrpc.NewObjectStream(conn), jrpcHandler{
-		activeRVPNDaemon: r,
-		deviceToken:      args.DeviceToken,
-		controlPlaneAddr: controlPlaneAddrStr,
+	r.setSessionInfo(sessionInfo{
+		account: session.account,
+		profile: args.Profile,
+		opts:    args.Opts,
 	})
+	r.setStatus(StatusConnecting, "")
 
-	r.jrpcConn = jrpcConn
-	r.jrpcCtxCancel = cancelFunc
+	jrpcConn, err := r.openSession(session)
+	if err != nil {
+		log.Printf("failed to connect to rVPN target server: %v", err)
+		r.setStatus(StatusDisconnected, err.Error())
 
-	// TODO: get address on target vpn server to ensure connection is alive
+		*reply = false
+		return codedError(err)
+	}
+
+	// reopen the control channel if it drops while connected
+	r.startSupervisor(session, jrpcConn)
 
 	*reply = true
 	return nil
 }
 
+// Disconnect disconnects the active session and removes the kill switch, the reply is false if no session is active
+// NOTE: a kill switch left behind by a previous run of the daemon is removed as well
 func (r *RVPNDaemon) Disconnect(args string, reply *bool) error {
+	err := r.wireguardDaemon.DisableKillSwitch()
+	if err != nil {
+		log.Printf("failed to disable kill switch: %v", err)
+	}
+
+	if r.getStatus() == StatusDisconnected {
+		*reply = false
+		return nil
+	}
+
+	r.stopSupervisor()
 	r.wireguardDaemon.Disconnect()
-	r.jrpcConn.Close()
-	r.jrpcCtxCancel()
-	r.status = StatusDisconnected
+	r.closeControlChannel()
+	r.setStatus(StatusDisconnected, "disconnected on request")
 
 	*reply = true
 	return nil
@@ -386,16 +931,24 @@ func (r *RVPNDaemon) Ping(args string, reply *bool) error {
 func (r *RVPNDaemon) Start() {
 	log.Println("starting rVPN daemon")
 
+	// load the daemon configuration
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	r.config = config
+
 	// initialize rVPN state
 	log.Println("initializing rVPN state")
-	err := InitRVPNState()
+	err = InitRVPNState()
 	if err != nil {
 		log.Fatalf("failed to initialize state: %v", err)
 	}
 
 	// initialize rVPN wireguard daemon
 	log.Println("starting rVPN wireguard daemon...")
-	wireguardDaemon := wg.NewWireguardDaemon()
+	wireguardDaemon := wg.NewWireguardDaemon(config.deviceConfig())
 	r.wireguardDaemon = wireguardDaemon
 
 	errs := make(chan error)
@@ -407,29 +960,21 @@ func (r *RVPNDaemon) Start() {
 		log.Fatalf("failed to start daemon: %v", err)
 	}
 
-	// start RPC server; TODO: investigate if this is a good pattern
-	rpc.Register(r)
+	// publish peer and handshake changes to watchers, the first event is the initial status
+	r.setStatus(StatusDisconnected, "rVPN daemon started")
+	go r.watchPeers(context.Background())
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:52370")
+	// start RPC server
+	listener, err := listenRPC(config)
 	if err != nil {
-		log.Fatalf("failed to resolve tcp address: %v", err)
+		log.Fatalf("failed to start rpc listener: %v", err)
 	}
 
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	err = r.serveRPC(listener)
 	if err != nil {
-		log.Fatalf("failed to start tcp listener: %v", err)
+		log.Fatalf("failed to start rpc server: %v", err)
 	}
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				continue
-			}
-			rpc.ServeConn(conn)
-		}
-	}()
-
 	log.Println("started rVPN daemon RPC server")
 
 	log.Println("rVPN wireguard daemon is running")
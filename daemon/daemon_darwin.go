@@ -32,3 +32,19 @@ func appendVPNPeersHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Co
 		Success: false,
 	})
 }
+
+// deleteVPNPeersHandler is responsible for deleting peers from the Wireguard config
+func deleteVPNPeersHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	// NOTE: serving is not supported on Windows, this is just a stub
+	conn.Reply(ctx, req.ID, common.DeleteVPNPeersResponse{
+		Success: false,
+	})
+}
+
+// updateFirewallHandler is responsible for enforcing the firewall policies of the target on forwarded traffic
+func updateFirewallHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	// NOTE: serving is not supported on Windows, this is just a stub
+	conn.Reply(ctx, req.ID, common.UpdateFirewallResponse{
+		Success: false,
+	})
+}
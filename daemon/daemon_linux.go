@@ -6,65 +6,49 @@ import (
 	"context"
 	"encoding/json"
 	"log"
-	"net"
-	"net/http"
-	"strings"
 	"time"
 
 	"github.com/redpwn/rvpn/common"
-	"github.com/redpwn/rvpn/daemon/jrpc"
 	"github.com/redpwn/rvpn/daemon/wg"
 	"github.com/sourcegraph/jsonrpc2"
-	"nhooyr.io/websocket"
 )
 
 // Serve instructs the rVPN daemon to act as a target VPN server
 func (r *RVPNDaemon) Serve(args ServeRequest, reply *bool) error {
-	// create long-lived WebSocket connection acting as jrpc channel between client and control plane
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	var controlPlaneRemoteAddr net.Addr
-	customTransport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           remoteAddressDialHook(&controlPlaneRemoteAddr),
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-	customHttpClient := http.Client{
-		Transport: customTransport,
-	}
-
-	websocketURL := args.ControlPlaneWS + "/api/v1/target/" + args.Profile + "/serve"
-	conn, _, err := websocket.Dial(ctx, websocketURL, &websocket.DialOptions{
-		HTTPClient: &customHttpClient,
-	})
+	// NOTE: invalid subnets are rejected before forwarding is configured with them
+	err := args.Opts.Validate()
 	if err != nil {
-		log.Printf("failed to connect to rVPN control plane web socket: %v", err)
-		cancelFunc()
-
 		*reply = false
-		return nil
+		return err
 	}
 
-	r.activeControlPlaneWs = conn
-	r.activeProfile = args.Profile
+	// ensure device is registered for target
+	session, err := r.newControlSession(serveEndpoint, args.Account, args.Profile)
+	if err != nil {
+		r.publishError(err.Error())
 
-	// parse out the remote address of control plane
-	// NOTE: we expect the net.addr to be of the form "192.168.1.1:80"
-	controlPlaneAddrStr := strings.Split(controlPlaneRemoteAddr.String(), ":")[0]
+		*reply = false
+		return codedError(err)
+	}
 
-	// now we are authenticated, create jrpc connection on top of websocket stream
-	jrpcConn := jsonrpc2.NewConn(ctx, jrpc.NewObjectStream(conn), jrpcHandler{
-		activeRVPNDaemon: r,
-		deviceToken:      args.DeviceToken,
-		controlPlaneAddr: controlPlaneAddrStr,
+	r.setSessionInfo(sessionInfo{
+		account:   session.account,
+		profile:   args.Profile,
+		serveOpts: args.Opts,
 	})
+	r.setStatus(StatusConnecting, "")
 
-	r.jrpcConn = jrpcConn
-	r.jrpcCtxCancel = cancelFunc
+	jrpcConn, err := r.openSession(session)
+	if err != nil {
+		log.Printf("failed to serve rVPN target: %v", err)
+		r.setStatus(StatusDisconnected, err.Error())
+
+		*reply = false
+		return codedError(err)
+	}
+
+	// reopen the control channel if it drops while serving
+	r.startSupervisor(session, jrpcConn)
 
 	*reply = true
 	return nil
@@ -113,24 +97,36 @@ func serveVPNHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, re
 	wgPeers := []wg.WireGuardPeer{}
 	for _, clientPeer := range serveVPNRequest.Peers {
 		newPeer := wg.WireGuardPeer{
-			PublicKey:   clientPeer.PublicKey,
-			AllowedIP:   clientPeer.AllowedIP,
-			AllowedCidr: clientPeer.AllowedCidr,
+			PublicKey:    clientPeer.PublicKey,
+			PresharedKey: clientPeer.PresharedKey,
+			AllowedIP:    clientPeer.AllowedIP,
+			AllowedCidr:  clientPeer.AllowedCidr,
+			Subnets:      clientPeer.Subnets,
+			UploadMbps:   clientPeer.UploadMbps,
+			DownloadMbps: clientPeer.DownloadMbps,
 		}
 
 		wgPeers = append(wgPeers, newPeer)
 	}
 
+	info := h.activeRVPNDaemon.getSessionInfo()
 	serveConfig := wg.ServeWgConfig{
 		PrivateKey:   rVPNState.PrivateKey,
-		ListenPort:   21820, // TODO: allow this to be changed with config flags
+		ListenPort:   h.activeRVPNDaemon.config.ServePort,
 		InternalIp:   serveVPNRequest.ServerInternalIp,
 		InternalCidr: serveVPNRequest.ServerInternalCidr,
 		Peers:        wgPeers,
+		Subnets:      info.serveOpts.Subnets,
+		Masquerade:   info.serveOpts.Masquerade,
 	}
 
 	h.activeRVPNDaemon.wireguardDaemon.UpdateServeConf(serveConfig)
-	h.activeRVPNDaemon.status = StatusServing
+	h.activeRVPNDaemon.updateSessionInfo(func(info *sessionInfo) {
+		info.tunnel = tunnelInfo{
+			address: serveVPNRequest.ServerInternalIp + serveVPNRequest.ServerInternalCidr,
+		}
+	})
+	h.activeRVPNDaemon.setStatus(StatusServing, "")
 
 	log.Printf("daemon successfully serving as rVPN target VPN server")
 	conn.Reply(ctx, req.ID, common.ServeVPNResponse{
@@ -139,7 +135,16 @@ func serveVPNHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, re
 
 	// launch goroutine to send heartbeat to keep WS alive
 	// NOTE: context is of the jrpc connection which should be kept alive
-	go heartbeatGenerator(ctx, 30*time.Second, conn)
+	go heartbeatGenerator(ctx, 30*time.Second, conn, h.activeRVPNDaemon.wireguardDaemon)
+
+	// launch goroutine to rotate the wireguard key on a schedule
+	go keyRotationGenerator(ctx, keyRotationInterval, conn, h.activeRVPNDaemon.wireguardDaemon)
+
+	// keep a relay connection open for clients which cannot reach the server over UDP
+	if serveVPNRequest.RelayToken != "" {
+		go maintainRelay(ctx, h.activeRVPNDaemon.wireguardDaemon, h.controlPlaneWS, h.tlsConfig,
+			info.profile, serveVPNRequest.RelayToken, "")
+	}
 }
 
 // appendVPNPeersHandler is responsible for append peers to the Wireguard config
@@ -152,14 +157,20 @@ func appendVPNPeersHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Co
 		conn.Reply(ctx, req.ID, common.AppendVPNPeersResponse{
 			Success: false,
 		})
+		return
 	}
 
 	wgPeers := []wg.WireGuardPeer{}
 	for _, requestPeer := range appendVPNPeersRequest.Peers {
 		wgPeer := wg.WireGuardPeer{
-			PublicKey:   requestPeer.PublicKey,
-			AllowedIP:   requestPeer.AllowedIP,
-			AllowedCidr: requestPeer.AllowedCidr,
+			PublicKey:    requestPeer.PublicKey,
+			PresharedKey: requestPeer.PresharedKey,
+			AllowedIP:    requestPeer.AllowedIP,
+			AllowedCidr:  requestPeer.AllowedCidr,
+			Subnets:      requestPeer.Subnets,
+			UploadMbps:   requestPeer.UploadMbps,
+			DownloadMbps: requestPeer.DownloadMbps,
+			Staged:       requestPeer.Staged,
 		}
 
 		wgPeers = append(wgPeers, wgPeer)
@@ -171,3 +182,87 @@ func appendVPNPeersHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Co
 		Success: true,
 	})
 }
+
+// deleteVPNPeersHandler is responsible for deleting peers from the Wireguard config
+func deleteVPNPeersHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	// parse information from jrpc request
+	var deleteVPNPeersRequest common.DeleteVPNPeersRequest
+	err := json.Unmarshal(*req.Params, &deleteVPNPeersRequest)
+	if err != nil {
+		log.Printf("failed to unmarshal deletevpnpeers request params: %v", err)
+		conn.Reply(ctx, req.ID, common.DeleteVPNPeersResponse{
+			Success: false,
+		})
+		return
+	}
+
+	wgPeers := []wg.WireGuardPeer{}
+	for _, requestPeer := range deleteVPNPeersRequest.Peers {
+		wgPeers = append(wgPeers, wg.WireGuardPeer{
+			PublicKey: requestPeer.PublicKey,
+		})
+	}
+
+	err = h.activeRVPNDaemon.wireguardDaemon.DeletePeers(wgPeers)
+	if err != nil {
+		log.Printf("failed to delete peers: %v", err)
+		conn.Reply(ctx, req.ID, common.DeleteVPNPeersResponse{
+			Success: false,
+		})
+		return
+	}
+
+	err = h.activeRVPNDaemon.wireguardDaemon.DeleteRateLimits(wgPeers)
+	if err != nil {
+		// the peers are deleted so their limits no longer apply to traffic
+		log.Printf("failed to delete rate limits: %v", err)
+	}
+
+	err = h.activeRVPNDaemon.wireguardDaemon.DeletePeerRoutes(wgPeers)
+	if err != nil {
+		log.Printf("failed to delete peer subnet routes: %v", err)
+	}
+
+	log.Printf("daemon successfully deleted peers from rVPN target VPN server")
+	conn.Reply(ctx, req.ID, common.DeleteVPNPeersResponse{
+		Success: true,
+	})
+}
+
+// updateFirewallHandler is responsible for enforcing the firewall policies of the target on forwarded traffic
+func updateFirewallHandler(ctx context.Context, h jrpcHandler, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	// parse information from jrpc request
+	var updateFirewallRequest common.UpdateFirewallRequest
+	err := json.Unmarshal(*req.Params, &updateFirewallRequest)
+	if err != nil {
+		log.Printf("failed to unmarshal updatefirewall request params: %v", err)
+		conn.Reply(ctx, req.ID, common.UpdateFirewallResponse{
+			Success: false,
+		})
+		return
+	}
+
+	wgRules := []wg.FirewallRule{}
+	for _, requestRule := range updateFirewallRequest.Rules {
+		wgRules = append(wgRules, wg.FirewallRule{
+			Sources:      requestRule.Sources,
+			Destinations: requestRule.Destinations,
+			Protocol:     requestRule.Protocol,
+			Ports:        requestRule.Ports,
+		})
+	}
+
+	err = h.activeRVPNDaemon.wireguardDaemon.UpdateFirewall(updateFirewallRequest.Enabled, wgRules)
+	if err != nil {
+		log.Printf("failed to update firewall: %v", err)
+		conn.Reply(ctx, req.ID, common.UpdateFirewallResponse{
+			Success: false,
+		})
+		return
+	}
+
+	log.Printf("daemon successfully updated firewall of rVPN target VPN server")
+	conn.Reply(ctx, req.ID, common.UpdateFirewallResponse{
+		Success: true,
+	})
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errors.go holds the error codes of the rVPN daemon which clients handle differently, net/rpc only transports the
+// message of an error so the code is sent as a prefix of the message, see ParseError
+
+// ErrorCode identifies a class of errors of the rVPN daemon
+type ErrorCode string
+
+const (
+	ErrorUnknown          ErrorCode = "error"
+	ErrorNotLoggedIn      ErrorCode = "not_logged_in"      // the account has no login token
+	ErrorUnauthorized     ErrorCode = "unauthorized"       // the control plane rejected the login token or the account
+	ErrorDaemonNotRunning ErrorCode = "daemon_not_running" // the daemon cannot be reached, set by clients
+	ErrorTargetOffline    ErrorCode = "target_offline"     // the rVPN server of the target did not answer
+	ErrorPermissionDenied ErrorCode = "permission_denied"  // the caller may not control the daemon
+)
+
+var (
+	errNotLoggedIn      = errors.New("not logged into rVPN account")
+	errTargetOffline    = errors.New("rVPN target server is offline")
+	errPermissionDenied = errors.New("permission denied: join the rvpn group to control the rVPN daemon")
+)
+
+// errorCode returns the code of an error of the daemon
+func errorCode(err error) ErrorCode {
+	switch {
+	case errors.Is(err, errNotLoggedIn):
+		return ErrorNotLoggedIn
+	case errors.Is(err, errInvalidLoginToken), errors.Is(err, errForbidden):
+		return ErrorUnauthorized
+	case errors.Is(err, errTargetOffline):
+		return ErrorTargetOffline
+	case errors.Is(err, errPermissionDenied):
+		return ErrorPermissionDenied
+	default:
+		return ErrorUnknown
+	}
+}
+
+// codedError prefixes the message of the error with its code so clients can tell it apart, errors without a code are
+// returned as is
+func codedError(err error) error {
+	code := errorCode(err)
+	if err == nil || code == ErrorUnknown {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", code, err)
+}
+
+// ParseError returns the code and the message without the code of an error returned by an RPC of the rVPN daemon, the
+// code is ErrorUnknown if the error has none
+func ParseError(err error) (ErrorCode, string) {
+	message := err.Error()
+	for _, code := range []ErrorCode{
+		ErrorNotLoggedIn, ErrorUnauthorized, ErrorDaemonNotRunning, ErrorTargetOffline, ErrorPermissionDenied,
+	} {
+		if prefix := string(code) + ": "; strings.HasPrefix(message, prefix) {
+			return code, strings.TrimPrefix(message, prefix)
+		}
+	}
+
+	return ErrorUnknown, message
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCodedError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode ErrorCode
+	}{
+		{name: "not logged in", err: errNotLoggedIn, wantCode: ErrorNotLoggedIn},
+		{name: "invalid login token", err: errInvalidLoginToken, wantCode: ErrorUnauthorized},
+		{name: "forbidden", err: fmt.Errorf("%w: user is not authorized", errForbidden), wantCode: ErrorUnauthorized},
+		{name: "target offline", err: errTargetOffline, wantCode: ErrorTargetOffline},
+		{name: "permission denied", err: errPermissionDenied, wantCode: ErrorPermissionDenied},
+		{
+			name:     "wrapped",
+			err:      fmt.Errorf("failed to send device registration request: %w", errInvalidLoginToken),
+			wantCode: ErrorUnauthorized,
+		},
+		{name: "uncoded", err: errors.New("failed to get rVPN state"), wantCode: ErrorUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the coded error is sent to clients as its message only
+			rpcErr := errors.New(codedError(tt.err).Error())
+
+			code, message := ParseError(rpcErr)
+			if code != tt.wantCode {
+				t.Errorf("ParseError() code = %s, want %s", code, tt.wantCode)
+			}
+
+			if message != tt.err.Error() {
+				t.Errorf("ParseError() message = %q, want %q", message, tt.err.Error())
+			}
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		if err := codedError(nil); err != nil {
+			t.Errorf("codedError(nil) = %v, want nil", err)
+		}
+	})
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    ErrorCode
+		wantMessage string
+	}{
+		{
+			name:        "coded",
+			err:         errors.New("target_offline: rVPN target server is offline"),
+			wantCode:    ErrorTargetOffline,
+			wantMessage: "rVPN target server is offline",
+		},
+		{
+			name:        "daemon not running",
+			err:         errors.New("daemon_not_running: connection refused"),
+			wantCode:    ErrorDaemonNotRunning,
+			wantMessage: "connection refused",
+		},
+		{
+			name:        "unknown code",
+			err:         errors.New("rate_limited: slow down"),
+			wantCode:    ErrorUnknown,
+			wantMessage: "rate_limited: slow down",
+		},
+		{
+			name:        "code without separator",
+			err:         errors.New("unauthorized"),
+			wantCode:    ErrorUnknown,
+			wantMessage: "unauthorized",
+		},
+		{
+			name:        "code not at start",
+			err:         errors.New("failed: not_logged_in: login first"),
+			wantCode:    ErrorUnknown,
+			wantMessage: "failed: not_logged_in: login first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, message := ParseError(tt.err)
+			if code != tt.wantCode || message != tt.wantMessage {
+				t.Errorf("ParseError(%q) = %s, %q, want %s, %q", tt.err, code, message, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redpwn/rvpn/daemon/wg"
+)
+
+const (
+	eventLogSize      = 256              // number of past events kept for watchers which fall behind
+	eventWatchTimeout = 30 * time.Second // WatchEvents replies without events after this long so watchers notice a dead daemon
+	peerPollInterval  = 2 * time.Second
+
+	// peerActiveTime is how recent the last handshake of an active peer is, wireguard rejects sessions older than
+	// 180 seconds
+	peerActiveTime = 180 * time.Second
+)
+
+// EventType is the type of a daemon event
+type EventType string
+
+const (
+	EventStatus      EventType = "status"       // the status of the daemon changed, see Status
+	EventError       EventType = "error"        // an operation failed, see Reason
+	EventHandshake   EventType = "handshake"    // a peer became active or inactive, see PeerActive
+	EventPeerAdded   EventType = "peer_added"   // a peer was added to the wireguard interface
+	EventPeerRemoved EventType = "peer_removed" // a peer was removed from the wireguard interface
+)
+
+// Event is a change of the daemon which is streamed to watchers
+type Event struct {
+	Seq    uint64     `json:"seq"`
+	Time   time.Time  `json:"time"`
+	Type   EventType  `json:"type"`
+	Status RVPNStatus `json:"status"`
+	Reason string     `json:"reason,omitempty"`
+
+	// set for handshake and peer events
+	Peer          string    `json:"peer,omitempty"` // public key of the peer
+	Endpoint      string    `json:"endpoint,omitempty"`
+	PeerActive    bool      `json:"peerActive"`              // whether the peer completed a handshake recently
+	LastHandshake time.Time `json:"lastHandshake,omitempty"` // zero if the peer never completed a handshake
+}
+
+type WatchEventsRequest struct {
+	Cursor uint64 // Cursor of the previous reply, zero to start watching
+}
+
+type WatchEventsReply struct {
+	Events []Event
+	Cursor uint64 // Cursor to pass to the next WatchEvents
+}
+
+// eventLog keeps the recent events of the daemon and wakes up watchers when an event is published
+type eventLog struct {
+	mu      sync.Mutex
+	events  []Event
+	lastSeq uint64
+	notify  chan struct{} // closed and replaced whenever an event is published
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		notify: make(chan struct{}),
+	}
+}
+
+// publish appends the event to the log and wakes up watchers
+func (l *eventLog) publish(event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastSeq++
+	event.Seq = l.lastSeq
+	event.Time = time.Now()
+
+	l.events = append(l.events, event)
+	if len(l.events) > eventLogSize {
+		l.events = l.events[len(l.events)-eventLogSize:]
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// since returns the events after the cursor, the cursor for the next call and a channel which is closed once there
+// are newer events
+// NOTE: events which dropped out of the log are skipped
+func (l *eventLog) since(cursor uint64) ([]Event, uint64, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := []Event{}
+	for _, event := range l.events {
+		if event.Seq > cursor {
+			events = append(events, event)
+		}
+	}
+
+	return events, l.lastSeq, l.notify
+}
+
+// setStatus sets the status of the daemon and publishes it, reason explains the change and may be empty
+func (r *RVPNDaemon) setStatus(status RVPNStatus, reason string) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	r.status = status
+
+	// NOTE: reconnecting keeps the start of the session
+	switch status {
+	case StatusConnected, StatusServing:
+		if r.sessionStart.IsZero() {
+			r.sessionStart = time.Now()
+		}
+	case StatusDisconnected:
+		r.sessionStart = time.Time{}
+	}
+
+	r.events.publish(Event{
+		Type:   EventStatus,
+		Status: status,
+		Reason: reason,
+	})
+}
+
+// getStatus returns the status of the daemon
+func (r *RVPNDaemon) getStatus() RVPNStatus {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	return r.status
+}
+
+// getSessionStart returns when the session connected or started serving, zero if disconnected
+func (r *RVPNDaemon) getSessionStart() time.Time {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	return r.sessionStart
+}
+
+// getSessionInfo returns the account, target and tunnel of the session
+func (r *RVPNDaemon) getSessionInfo() sessionInfo {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	return r.sessionInfo
+}
+
+// setSessionInfo replaces the info of the session when a session starts
+func (r *RVPNDaemon) setSessionInfo(info sessionInfo) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	r.sessionInfo = info
+}
+
+// updateSessionInfo changes the info of the session while the session is locked
+func (r *RVPNDaemon) updateSessionInfo(update func(info *sessionInfo)) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	update(&r.sessionInfo)
+}
+
+// publishError publishes an error of an operation of the daemon
+func (r *RVPNDaemon) publishError(reason string) {
+	r.events.publish(Event{
+		Type:   EventError,
+		Status: r.getStatus(),
+		Reason: reason,
+	})
+}
+
+// WatchEvents replies with the events after the cursor of the request, it waits for an event if there are none
+// yet, the first call with a zero cursor replies immediately with the current status
+func (r *RVPNDaemon) WatchEvents(args WatchEventsRequest, reply *WatchEventsReply) error {
+	events, cursor, notify := r.events.since(args.Cursor)
+
+	if args.Cursor == 0 {
+		*reply = WatchEventsReply{
+			Events: []Event{{
+				Seq:    cursor,
+				Time:   time.Now(),
+				Type:   EventStatus,
+				Status: r.getStatus(),
+			}},
+			Cursor: cursor,
+		}
+		return nil
+	}
+
+	if len(events) == 0 {
+		select {
+		case <-notify:
+			events, cursor, _ = r.events.since(args.Cursor)
+		case <-time.After(eventWatchTimeout):
+		}
+	}
+
+	*reply = WatchEventsReply{
+		Events: events,
+		Cursor: cursor,
+	}
+	return nil
+}
+
+// watchPeers polls the peers of the wireguard interface and publishes added and removed peers and peers which
+// became active or inactive until the context is cancelled
+func (r *RVPNDaemon) watchPeers(ctx context.Context) {
+	ticker := time.NewTicker(peerPollInterval)
+	defer ticker.Stop()
+
+	knownPeers := map[string]wg.PeerStats{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		peerStats, err := r.wireguardDaemon.PeerStats()
+		if err != nil {
+			log.Printf("failed to get peer stats: %v", err)
+			continue
+		}
+
+		currPeers := map[string]wg.PeerStats{}
+		for _, peerStat := range peerStats {
+			currPeers[peerStat.PublicKey] = peerStat
+
+			knownPeer, known := knownPeers[peerStat.PublicKey]
+			if !known {
+				r.events.publish(peerEvent(EventPeerAdded, r.getStatus(), peerStat))
+			}
+
+			if peerActive(peerStat) != (known && peerActive(knownPeer)) {
+				r.events.publish(peerEvent(EventHandshake, r.getStatus(), peerStat))
+			}
+		}
+
+		for publicKey, knownPeer := range knownPeers {
+			if _, ok := currPeers[publicKey]; !ok {
+				r.events.publish(peerEvent(EventPeerRemoved, r.getStatus(), knownPeer))
+			}
+		}
+
+		knownPeers = currPeers
+	}
+}
+
+// peerEvent returns an event of the type for the peer
+func peerEvent(eventType EventType, status RVPNStatus, peerStat wg.PeerStats) Event {
+	return Event{
+		Type:          eventType,
+		Status:        status,
+		Peer:          peerStat.PublicKey,
+		Endpoint:      peerStat.Endpoint,
+		PeerActive:    peerActive(peerStat),
+		LastHandshake: peerStat.LastHandshake,
+	}
+}
+
+// peerActive returns whether the peer completed a handshake recently
+func peerActive(peerStat wg.PeerStats) bool {
+	return !peerStat.LastHandshake.IsZero() && time.Since(peerStat.LastHandshake) < peerActiveTime
+}
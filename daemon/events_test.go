@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/redpwn/rvpn/common"
+)
+
+// TestSessionStateConcurrentAccess changes and reads the session state from concurrent goroutines the way RPCs and the
+// jrpc handlers of the control channel do, run with -race to detect unguarded access
+func TestSessionStateConcurrentAccess(t *testing.T) {
+	r := NewRVPNDaemon()
+
+	const iterations = 100
+	var wg sync.WaitGroup
+
+	// Connect and Serve RPCs start sessions
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			r.setSessionInfo(sessionInfo{
+				account: "default",
+				profile: fmt.Sprintf("target-%d", i),
+				opts:    common.ClientOptions{KillSwitch: i%2 == 0},
+			})
+			r.setStatus(StatusConnecting, "")
+			r.setConnectResult(make(chan error, 1))
+		}
+	}()
+
+	// jrpc handlers configure the tunnel of the session
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			r.updateSessionInfo(func(info *sessionInfo) {
+				info.controlPlaneAddr = "192.0.2.1"
+				info.tunnel.routes = []string{fmt.Sprintf("10.%d.0.0/16", i)}
+				info.tunnel.serverPublicKey = fmt.Sprintf("key-%d", i)
+			})
+			r.setStatus(StatusConnected, "")
+			r.reportConnectResult(nil)
+			r.publishError("error")
+		}
+	}()
+
+	// status RPCs and watchers read the session
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			info := r.getSessionInfo()
+			_ = info.opts.KillSwitch
+			_ = info.tunnel.routes
+			_ = r.getStatus()
+			_ = r.getSessionStart()
+		}
+	}()
+
+	// Disconnect stops the session
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			r.stopSupervisor()
+			r.closeControlChannel()
+			r.setStatus(StatusDisconnected, "")
+		}
+	}()
+
+	wg.Wait()
+
+	r.setStatus(StatusConnected, "")
+	r.updateSessionInfo(func(info *sessionInfo) {
+		info.tunnel.serverPublicKey = "final"
+	})
+
+	if got := r.getSessionInfo().tunnel.serverPublicKey; got != "final" {
+		t.Errorf("session server public key = %s, want final", got)
+	}
+
+	if got := r.getStatus(); got != StatusConnected {
+		t.Errorf("status = %s, want %s", got, StatusConnected)
+	}
+}
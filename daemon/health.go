@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/redpwn/rvpn/daemon/wg"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	connectTimeout          = 90 * time.Second // time Connect waits for the tunnel to be configured and healthy
+	connectHandshakeTimeout = 30 * time.Second // time to wait for a handshake with the rVPN server, including the relay fallback
+	connectProbeTimeout     = 10 * time.Second // time to wait for the rVPN server to answer probes through the tunnel
+	protocolICMP            = 1                // IANA protocol number of ICMP
+)
+
+// reportConnectResult reports the outcome of connecting to the rVPN server to a pending Connect, nil on success
+func (r *RVPNDaemon) reportConnectResult(err error) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	select {
+	case r.connectResult <- err:
+	default:
+		// there is no pending Connect
+	}
+}
+
+// verifyTunnel checks that the tunnel to the rVPN server is healthy, there must be a handshake with the rVPN server
+// and the internal ip of the rVPN server must answer probes through the tunnel
+func verifyTunnel(wireguardDaemon *wg.WireguardDaemon, serverInternalIp string) error {
+	if !wireguardDaemon.WaitForServerHandshake(connectHandshakeTimeout) {
+		return errors.New("no handshake with rVPN server")
+	}
+
+	if serverInternalIp == "" {
+		// older control planes do not send the internal ip of the rVPN server
+		return nil
+	}
+
+	return probeHost(serverInternalIp, connectProbeTimeout)
+}
+
+// probeHost sends ICMP echo requests to the host every second until one is answered, returns an error if there
+// was no reply before the timeout
+func probeHost(host string, timeout time.Duration) error {
+	hostIP := net.ParseIP(host).To4()
+	if hostIP == nil {
+		return fmt.Errorf("invalid probe host %s", host)
+	}
+
+	icmpConn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return fmt.Errorf("failed to open icmp socket: %w", err)
+	}
+	defer icmpConn.Close()
+
+	echoId := os.Getpid() & 0xffff
+	replyBuf := make([]byte, 1500)
+	deadline := time.Now().Add(timeout)
+	for echoSeq := 1; time.Now().Before(deadline); echoSeq++ {
+		echoRequest := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Code: 0,
+			Body: &icmp.Echo{
+				ID:   echoId,
+				Seq:  echoSeq,
+				Data: []byte("rvpn"),
+			},
+		}
+
+		echoRequestBytes, err := echoRequest.Marshal(nil)
+		if err != nil {
+			return fmt.Errorf("failed to marshal icmp echo request: %w", err)
+		}
+
+		_, err = icmpConn.WriteTo(echoRequestBytes, &net.IPAddr{IP: hostIP})
+		if err != nil {
+			return fmt.Errorf("failed to send icmp echo request: %w", err)
+		}
+
+		// wait up to a second for the reply before sending the next echo request
+		readDeadline := time.Now().Add(1 * time.Second)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+
+		err = icmpConn.SetReadDeadline(readDeadline)
+		if err != nil {
+			return fmt.Errorf("failed to set icmp read deadline: %w", err)
+		}
+
+		for {
+			n, replyAddr, err := icmpConn.ReadFrom(replyBuf)
+			if err != nil {
+				// read deadline was reached, send the next echo request
+				break
+			}
+
+			if replyIPAddr, ok := replyAddr.(*net.IPAddr); !ok || !replyIPAddr.IP.Equal(hostIP) {
+				continue
+			}
+
+			reply, err := icmp.ParseMessage(protocolICMP, replyBuf[:n])
+			if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+				continue
+			}
+
+			if echoReply, ok := reply.Body.(*icmp.Echo); ok && echoReply.ID == echoId {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no reply from %s through the tunnel", host)
+}
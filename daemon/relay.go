@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redpwn/rvpn/common"
+	"github.com/redpwn/rvpn/daemon/wg"
+	"nhooyr.io/websocket"
+)
+
+const (
+	relayHandshakeTimeout = 15 * time.Second // time to wait for a direct handshake before relaying packets
+	relayRetryInterval    = 5 * time.Second
+)
+
+// relayConn is a WebSocket relay connection to the control plane which carries wireguard packets
+type relayConn struct {
+	ctx context.Context
+	ws  *websocket.Conn
+}
+
+// dialRelay opens a WebSocket relay connection to the control plane for the profile
+func dialRelay(ctx context.Context, controlPlaneWS string, tlsConfig *tls.Config, profile, relayToken string) (*relayConn, error) {
+	websocketURL := controlPlaneWS + "/api/v1/target/" + profile + "/relay"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	ws, _, err := websocket.Dial(ctx, websocketURL, &websocket.DialOptions{
+		HTTPClient: &http.Client{
+			Transport: transport,
+		},
+		HTTPHeader: http.Header{
+			"Authorization": []string{"Bearer " + relayToken},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &relayConn{
+		ctx: ctx,
+		ws:  ws,
+	}, nil
+}
+
+// ReadFrame reads a wireguard packet from the relay
+func (r *relayConn) ReadFrame() (string, []byte, error) {
+	for {
+		msgType, frame, err := r.ws.Read(r.ctx)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if msgType != websocket.MessageBinary {
+			continue
+		}
+
+		return common.ParseRelayFrame(frame)
+	}
+}
+
+// WriteFrame writes a wireguard packet to the relay
+func (r *relayConn) WriteFrame(peerId string, packet []byte) error {
+	return r.ws.Write(r.ctx, websocket.MessageBinary, common.MarshalRelayFrame(peerId, packet))
+}
+
+// Close closes the relay connection
+func (r *relayConn) Close() error {
+	return r.ws.Close(websocket.StatusNormalClosure, "")
+}
+
+// relayClientFallback relays packets to the rVPN server through the control plane if there is no direct handshake,
+// this keeps relaying until the context is cancelled
+func relayClientFallback(ctx context.Context, wireguardDaemon *wg.WireguardDaemon, controlPlaneWS string, tlsConfig *tls.Config, profile, relayToken, serverIp string, serverPort int) {
+	if wireguardDaemon.WaitForServerHandshake(relayHandshakeTimeout) {
+		// direct UDP works, there is no need for the relay
+		return
+	}
+
+	log.Printf("no handshake with rVPN server over UDP, falling back to relay")
+	serverEndpoint := net.JoinHostPort(serverIp, strconv.Itoa(serverPort))
+	maintainRelay(ctx, wireguardDaemon, controlPlaneWS, tlsConfig, profile, relayToken, serverEndpoint)
+}
+
+// maintainRelay keeps a relay connection open and relays packets until the context is cancelled, serverEndpoint
+// is the endpoint of the rVPN server in client mode and empty in server mode
+func maintainRelay(ctx context.Context, wireguardDaemon *wg.WireguardDaemon, controlPlaneWS string, tlsConfig *tls.Config, profile, relayToken, serverEndpoint string) {
+	for {
+		err := relayPackets(ctx, wireguardDaemon, controlPlaneWS, tlsConfig, profile, relayToken, serverEndpoint)
+		if err != nil {
+			log.Printf("relay failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relayRetryInterval):
+		}
+	}
+}
+
+// relayPackets opens a relay connection and relays packets until the relay fails
+func relayPackets(ctx context.Context, wireguardDaemon *wg.WireguardDaemon, controlPlaneWS string, tlsConfig *tls.Config, profile, relayToken, serverEndpoint string) error {
+	relay, err := dialRelay(ctx, controlPlaneWS, tlsConfig, profile, relayToken)
+	if err != nil {
+		return fmt.Errorf("failed to connect to relay: %w", err)
+	}
+	defer relay.Close()
+
+	return wireguardDaemon.RelayPackets(relay, serverEndpoint)
+}
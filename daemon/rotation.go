@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redpwn/rvpn/common"
+	"github.com/redpwn/rvpn/daemon/wg"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+const keyRotationInterval = 24 * time.Hour
+
+// keyRotationGenerator rotates the wireguard keypair of the device every interval until context is cancelled
+func keyRotationGenerator(ctx context.Context, interval time.Duration, conn *jsonrpc2.Conn, wireguardDaemon *wg.WireguardDaemon) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := rotateKey(ctx, conn, wireguardDaemon)
+			if err != nil {
+				log.Printf("failed to rotate wireguard key: %v", err)
+				continue
+			}
+
+			log.Printf("rotated wireguard key of device\n")
+		case <-ctx.Done():
+			// context has been cancelled
+			return
+		}
+	}
+}
+
+// rotateKey generates a new keypair, reports it to the control plane and switches the wireguard interface once
+// the control plane has prepared peers for the new key
+func rotateKey(ctx context.Context, conn *jsonrpc2.Conn, wireguardDaemon *wg.WireguardDaemon) error {
+	rVPNState, err := GetRVpnState()
+	if err != nil {
+		return fmt.Errorf("failed to get rVPN state: %w", err)
+	}
+
+	privateKey, publicKey, err := wg.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate keypair: %w", err)
+	}
+
+	// the control plane stages peers for the new key which handshake once the device switches to it, traffic keeps
+	// using the peers for the old key until the rotation completes
+	var rotateKeyResponse common.RotateKeyResponse
+	err = conn.Call(ctx, common.RotateKeyMethod, common.RotateKeyRequest{
+		PublicKey: publicKey,
+	}, &rotateKeyResponse)
+	if err != nil {
+		return fmt.Errorf("failed to report new key: %w", err)
+	}
+
+	if !rotateKeyResponse.Success {
+		return errors.New("control plane rejected new key")
+	}
+
+	err = wireguardDaemon.UpdatePrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	rVPNState.PrivateKey = privateKey
+	rVPNState.PublicKey = publicKey
+	err = SetRVpnState(rVPNState)
+	if err != nil {
+		return fmt.Errorf("failed to set rVPN state: %w", err)
+	}
+
+	// the control plane moves the allowed ips to the peers for the new key now that the device has switched and
+	// removes the peers for the old key
+	var completeKeyRotationResponse common.CompleteKeyRotationResponse
+	err = conn.Call(ctx, common.CompleteKeyRotationMethod, common.CompleteKeyRotationRequest{}, &completeKeyRotationResponse)
+	if err != nil {
+		return fmt.Errorf("failed to complete key rotation: %w", err)
+	}
+
+	if !completeKeyRotationResponse.Success {
+		return errors.New("control plane failed to complete key rotation")
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package daemon
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// rpcServiceName is the name the rVPN daemon RPC methods are served under
+const rpcServiceName = "RVPNDaemon"
+
+// rpcMaxAcceptDelay is the longest the RPC server waits before accepting again after accepting failed
+const rpcMaxAcceptDelay = time.Second
+
+// readOnlyDaemon serves the RPC methods of the rVPN daemon which every local user may call, they neither change
+// the daemon nor return secrets, the other methods fail with a permission denied error instead of being unknown
+type readOnlyDaemon struct {
+	daemon *RVPNDaemon
+}
+
+func (r readOnlyDaemon) Ping(args string, reply *bool) error {
+	return r.daemon.Ping(args, reply)
+}
+
+func (r readOnlyDaemon) Status(args string, reply *RVPNStatus) error {
+	return r.daemon.Status(args, reply)
+}
+
+func (r readOnlyDaemon) StatusReport(args string, reply *StatusReport) error {
+	return r.daemon.StatusReport(args, reply)
+}
+
+func (r readOnlyDaemon) GetState(args string, reply *RVpnState) error {
+	return r.daemon.GetState(args, reply)
+}
+
+func (r readOnlyDaemon) GetConfig(args string, reply *Config) error {
+	return r.daemon.GetConfig(args, reply)
+}
+
+func (r readOnlyDaemon) WatchEvents(args WatchEventsRequest, reply *WatchEventsReply) error {
+	return r.daemon.WatchEvents(args, reply)
+}
+
+func (r readOnlyDaemon) Login(args LoginRequest, reply *string) error {
+	return codedError(errPermissionDenied)
+}
+
+func (r readOnlyDaemon) AddAccount(args AddAccountRequest, reply *bool) error {
+	return codedError(errPermissionDenied)
+}
+
+func (r readOnlyDaemon) UseAccount(args string, reply *bool) error {
+	return codedError(errPermissionDenied)
+}
+
+func (r readOnlyDaemon) ListTargets(args string, reply *[]TargetInfo) error {
+	return codedError(errPermissionDenied)
+}
+
+func (r readOnlyDaemon) Connect(args ConnectRequest, reply *bool) error {
+	return codedError(errPermissionDenied)
+}
+
+func (r readOnlyDaemon) Disconnect(args string, reply *bool) error {
+	return codedError(errPermissionDenied)
+}
+
+func (r readOnlyDaemon) Serve(args ServeRequest, reply *bool) error {
+	return codedError(errPermissionDenied)
+}
+
+// serveRPC serves the RPC methods of the rVPN daemon on the listener, callers which are not privileged may only
+// call the read-only methods
+func (r *RVPNDaemon) serveRPC(listener net.Listener) error {
+	privilegedServer := rpc.NewServer()
+	err := privilegedServer.RegisterName(rpcServiceName, r)
+	if err != nil {
+		return err
+	}
+
+	readOnlyServer := rpc.NewServer()
+	err = readOnlyServer.RegisterName(rpcServiceName, readOnlyDaemon{daemon: r})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var acceptDelay time.Duration
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				// back off so errors such as running out of file descriptors do not spin the loop
+				acceptDelay *= 2
+				if acceptDelay == 0 {
+					acceptDelay = 5 * time.Millisecond
+				}
+				if acceptDelay > rpcMaxAcceptDelay {
+					acceptDelay = rpcMaxAcceptDelay
+				}
+
+				log.Printf("failed to accept rpc connection, retrying in %v: %v", acceptDelay, err)
+				time.Sleep(acceptDelay)
+				continue
+			}
+			acceptDelay = 0
+
+			privileged, err := rpcPeerPrivileged(conn)
+			if err != nil {
+				log.Printf("failed to get credentials of rpc peer: %v", err)
+				conn.Close()
+				continue
+			}
+
+			if privileged {
+				go privilegedServer.ServeConn(conn)
+			} else {
+				go readOnlyServer.ServeConn(conn)
+			}
+		}
+	}()
+
+	return nil
+}
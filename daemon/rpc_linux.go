@@ -0,0 +1,111 @@
+//go:build linux
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultRPCSocket = "/run/rvpn/rvpn.sock"
+	rpcGroupName     = "rvpn" // members of the group may control the daemon besides root
+)
+
+// listenRPC listens on the unix socket of the config, every local user may connect and is authorized by its peer
+// credentials
+func listenRPC(config Config) (net.Listener, error) {
+	err := os.MkdirAll(path.Dir(config.RPCSocket), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// remove the socket of a previous run of the daemon
+	err = os.Remove(config.RPCSocket)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", config.RPCSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(config.RPCSocket, 0666)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// dialRPC connects to the unix socket of the config
+func dialRPC(config Config) (*rpc.Client, error) {
+	return rpc.Dial("unix", config.RPCSocket)
+}
+
+// rpcPeerPrivileged returns whether the peer of the connection is root or a member of the rvpn group
+func rpcPeerPrivileged(conn net.Conn) (bool, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return false, errors.New("rpc connection is not a unix socket")
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+
+	var peerCred *unix.Ucred
+	var peerCredErr error
+	err = rawConn.Control(func(fd uintptr) {
+		peerCred, peerCredErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return false, err
+	}
+	if peerCredErr != nil {
+		return false, peerCredErr
+	}
+
+	if peerCred.Uid == 0 {
+		return true, nil
+	}
+
+	rpcGroup, err := user.LookupGroup(rpcGroupName)
+	if err != nil {
+		// without the group only root may control the daemon
+		return false, nil
+	}
+
+	if rpcGroup.Gid == strconv.FormatUint(uint64(peerCred.Gid), 10) {
+		return true, nil
+	}
+
+	peerUser, err := user.LookupId(strconv.FormatUint(uint64(peerCred.Uid), 10))
+	if err != nil {
+		return false, fmt.Errorf("failed to look up rpc peer user: %w", err)
+	}
+
+	groupIds, err := peerUser.GroupIds()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up groups of rpc peer user: %w", err)
+	}
+
+	for _, groupId := range groupIds {
+		if groupId == rpcGroup.Gid {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
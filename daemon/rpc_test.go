@@ -0,0 +1,43 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadOnlyDaemonMethods(t *testing.T) {
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+
+	daemonType := reflect.TypeOf(&RVPNDaemon{})
+	readOnlyType := reflect.TypeOf(readOnlyDaemon{})
+
+	// every RPC method of the daemon is served to unprivileged callers, if only to deny them
+	for i := 0; i < daemonType.NumMethod(); i++ {
+		method := daemonType.Method(i)
+		if method.Type.NumIn() != 3 || method.Type.NumOut() != 1 || method.Type.Out(0) != errorType {
+			continue
+		}
+
+		readOnlyMethod, ok := readOnlyType.MethodByName(method.Name)
+		if !ok {
+			t.Errorf("readOnlyDaemon is missing RPC method %s", method.Name)
+			continue
+		}
+
+		if readOnlyMethod.Type.In(1) != method.Type.In(1) || readOnlyMethod.Type.In(2) != method.Type.In(2) {
+			t.Errorf("readOnlyDaemon method %s has signature %v, want %v", method.Name, readOnlyMethod.Type, method.Type)
+		}
+	}
+}
+
+func TestReadOnlyDaemonPermissionDenied(t *testing.T) {
+	var reply bool
+	err := readOnlyDaemon{}.Connect(ConnectRequest{}, &reply)
+	if err == nil {
+		t.Fatal("Connect() of readOnlyDaemon succeeded")
+	}
+
+	if code, _ := ParseError(err); code != ErrorPermissionDenied {
+		t.Errorf("Connect() of readOnlyDaemon error code = %s, want %s", code, ErrorPermissionDenied)
+	}
+}
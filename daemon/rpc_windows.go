@@ -0,0 +1,27 @@
+//go:build windows
+
+package daemon
+
+import (
+	"net"
+	"net/rpc"
+)
+
+// defaultRPCSocket is unused, the RPC server listens on TCP
+const defaultRPCSocket = ""
+
+// listenRPC listens on the TCP address of the config
+func listenRPC(config Config) (net.Listener, error) {
+	return net.Listen("tcp", config.RPCAddr)
+}
+
+// dialRPC connects to the TCP address of the config
+func dialRPC(config Config) (*rpc.Client, error) {
+	return rpc.Dial("tcp", config.RPCAddr)
+}
+
+// rpcPeerPrivileged returns whether the peer of the connection may control the daemon
+// NOTE: peers of TCP connections cannot be identified, every local user may control the daemon
+func rpcPeerPrivileged(conn net.Conn) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,13 @@
+//go:build darwin
+
+package daemon
+
+import (
+	"github.com/denisbrodbeck/machineid"
+)
+
+// sealHardwareId returns the hardware id of the machine secrets are sealed to, the machine id on macOS is the
+// IOPlatformUUID of the firmware
+func sealHardwareId() (string, error) {
+	return machineid.ID()
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// hardwareIdPaths are the files the hardware id of the machine is read from, the DMI product uuid of the firmware is
+// only readable by root and boards without DMI expose the serial number of the device tree
+var hardwareIdPaths = []string{"/sys/class/dmi/id/product_uuid", "/proc/device-tree/serial-number"}
+
+// sealHardwareId returns the hardware id of the machine secrets are sealed to
+func sealHardwareId() (string, error) {
+	for _, hardwareIdPath := range hardwareIdPaths {
+		hardwareId, err := os.ReadFile(hardwareIdPath)
+		if err != nil {
+			continue
+		}
+
+		// NOTE: the device tree serial number is NUL terminated
+		if id := strings.TrimSpace(strings.Trim(string(hardwareId), "\x00")); id != "" {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("machine has no DMI product uuid or device tree serial number")
+}
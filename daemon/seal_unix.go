@@ -0,0 +1,159 @@
+//go:build linux || darwin
+
+package daemon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/denisbrodbeck/machineid"
+)
+
+// NOTE: secrets are sealed with a key derived from a random key in the state directory and the hardware id of the
+// machine, which is read from the firmware and not stored on disk. a copy of the disk or of the state directory taken
+// off the machine (a stolen disk, a backup, a cloned VM image) therefore cannot be unsealed. root on the running
+// machine can read the hardware id and unseal the secrets, the permissions of the state directory guard against that
+
+const sealKeySize = 32
+
+// sealKeyPath is the random key the seal key is derived from
+var sealKeyPath = path.Join(stateDir, "state.key")
+
+// unsealedWarning logs once that secrets are stored unsealed
+var unsealedWarning sync.Once
+
+// sealSecret seals a secret to the hardware of the machine so it is not stored in plaintext, machines without a
+// hardware id store secrets as is
+func sealSecret(secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+
+	hardwareId, err := sealHardwareId()
+	if err != nil {
+		unsealedWarning.Do(func() {
+			log.Printf("failed to get hardware id, secrets are stored unsealed: %v", err)
+		})
+		return secret, nil
+	}
+
+	sealKey, err := getSealKey(hardwareId, true)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := sealData(sealKey, []byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// getSealKey derives the seal key from the random key in the state directory and an id of the machine, the random key
+// is created if missing and create is set
+func getSealKey(id string, create bool) ([]byte, error) {
+	randomKey, err := os.ReadFile(sealKeyPath)
+	if errors.Is(err, os.ErrNotExist) && create {
+		randomKey = make([]byte, sealKeySize)
+		_, err = rand.Read(randomKey)
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeFileAtomic(sealKeyPath, randomKey, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seal key: %w", err)
+	}
+
+	if len(randomKey) != sealKeySize {
+		return nil, errors.New("seal key is corrupt")
+	}
+
+	return deriveSealKey(randomKey, id), nil
+}
+
+// deriveSealKey derives the seal key from the random key and an id of the machine
+func deriveSealKey(randomKey []byte, id string) []byte {
+	mac := hmac.New(sha256.New, randomKey)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+// sealAEAD returns the AES-GCM cipher of the seal key
+func sealAEAD(sealKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(sealKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealData encrypts data with the seal key, the nonce is prepended to the ciphertext
+func sealData(sealKey []byte, data []byte) ([]byte, error) {
+	aead, err := sealAEAD(sealKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// openData decrypts data sealed by sealData with the seal key
+func openData(sealKey []byte, sealed []byte) ([]byte, error) {
+	aead, err := sealAEAD(sealKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// unsealData decrypts sealed data, data sealed by earlier versions with a key derived from the machine id is unsealed
+// as well and sealed to the hardware once the state is written again
+func unsealData(sealed []byte) ([]byte, error) {
+	ids := []string{}
+	if hardwareId, err := sealHardwareId(); err == nil {
+		ids = append(ids, hardwareId)
+	}
+	if machineId, err := machineid.ID(); err == nil {
+		ids = append(ids, machineId)
+	}
+
+	for _, id := range ids {
+		sealKey, err := getSealKey(id, false)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := openData(sealKey, sealed)
+		if err == nil {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("secret was sealed on another machine, remove %s and log in again", stateDir)
+}
@@ -0,0 +1,107 @@
+//go:build linux || darwin
+
+package daemon
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealData(t *testing.T) {
+	randomKey := bytes.Repeat([]byte{1}, sealKeySize)
+	sealKey := deriveSealKey(randomKey, "4c4c4544-0042-3510-8052-b4c04f564433")
+
+	tests := []struct {
+		name    string
+		openKey []byte
+		wantErr bool
+	}{
+		{name: "same machine", openKey: sealKey},
+		{name: "other hardware id", openKey: deriveSealKey(randomKey, "00000000-0000-0000-0000-000000000000"), wantErr: true},
+		{name: "other random key", openKey: deriveSealKey(bytes.Repeat([]byte{2}, sealKeySize),
+			"4c4c4544-0042-3510-8052-b4c04f564433"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := []byte("wireguard private key")
+
+			sealed, err := sealData(sealKey, secret)
+			if err != nil {
+				t.Fatalf("sealData() error = %v", err)
+			}
+
+			if bytes.Contains(sealed, secret) {
+				t.Fatal("sealData() stored the secret in plaintext")
+			}
+
+			opened, err := openData(tt.openKey, sealed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !bytes.Equal(opened, secret) {
+				t.Errorf("openData() = %q, want %q", opened, secret)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		if _, err := openData(sealKey, []byte("short")); err == nil {
+			t.Error("openData() opened truncated data")
+		}
+	})
+}
+
+func TestGetSealKey(t *testing.T) {
+	prevSealKeyPath := sealKeyPath
+	defer func() { sealKeyPath = prevSealKeyPath }()
+	sealKeyPath = filepath.Join(t.TempDir(), "state.key")
+
+	if _, err := getSealKey("id", false); err == nil {
+		t.Fatal("getSealKey() created a missing random key without create")
+	}
+
+	sealKey, err := getSealKey("id", true)
+	if err != nil {
+		t.Fatalf("getSealKey() error = %v", err)
+	}
+
+	info, err := os.Stat(sealKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("random key has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	sameSealKey, err := getSealKey("id", false)
+	if err != nil {
+		t.Fatalf("getSealKey() error = %v", err)
+	}
+
+	if !bytes.Equal(sealKey, sameSealKey) {
+		t.Error("getSealKey() returned another key for the same id")
+	}
+
+	otherSealKey, err := getSealKey("other id", false)
+	if err != nil {
+		t.Fatalf("getSealKey() error = %v", err)
+	}
+
+	if bytes.Equal(sealKey, otherSealKey) {
+		t.Error("getSealKey() returned the same key for another id")
+	}
+
+	err = os.WriteFile(sealKeyPath, []byte("corrupt"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getSealKey("id", false); err == nil {
+		t.Error("getSealKey() accepted a corrupt random key")
+	}
+}
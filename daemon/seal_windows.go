@@ -0,0 +1,65 @@
+//go:build windows
+
+package daemon
+
+import (
+	"encoding/base64"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// sealSecret seals a secret to the machine with DPAPI so it is not stored in plaintext
+func sealSecret(secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+
+	sealed, err := sealData([]byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// dataBlob returns a DPAPI data blob for data
+func dataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+
+	return &windows.DataBlob{
+		Size: uint32(len(data)),
+		Data: &data[0],
+	}
+}
+
+// blobBytes copies the data of a DPAPI output blob and frees it
+func blobBytes(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+
+	return append([]byte{}, unsafe.Slice(blob.Data, blob.Size)...)
+}
+
+// sealData encrypts data with DPAPI so only the account of the daemon on this machine can decrypt it
+func sealData(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(dataBlob(data), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return blobBytes(&out), nil
+}
+
+// unsealData decrypts data sealed by sealData
+func unsealData(sealed []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(dataBlob(sealed), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return blobBytes(&out), nil
+}
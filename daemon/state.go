@@ -1,17 +1,45 @@
 package daemon
 
 import (
-	"bufio"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
+	"strings"
 )
 
+// sealedSecretPrefix marks secrets in the state file which are sealed to the machine
+const sealedSecretPrefix = "sealed:"
+
 type RVpnState struct {
-	ControlPlaneAuth string `json:"controlplaneauth"` // token which is used to authenticate to the control plane
-	PrivateKey       string `json:"privatekey"`
-	PublicKey        string `json:"publickey"`
-	ActiveProfile    string `json:"activeprofile"` // TODO: remove because deprecated, this logic is moved to the rVPN daemon
+	Accounts         map[string]Account `json:"accounts"`
+	ActiveAccount    string             `json:"activeaccount"`              // account which is used when no account is named
+	ControlPlaneAuth string             `json:"controlplaneauth,omitempty"` // TODO: remove because deprecated, moved to the default account
+	PrivateKey       string             `json:"privatekey"`
+	PublicKey        string             `json:"publickey"`
+	ActiveProfile    string             `json:"activeprofile"` // TODO: remove because deprecated, this logic is moved to the rVPN daemon
+}
+
+// unsealSecret unseals a secret sealed by sealSecret
+// NOTE: secrets without the sealed prefix were written before sealing or on platforms without sealing and are
+// returned as is
+func unsealSecret(secret string) (string, error) {
+	if !strings.HasPrefix(secret, sealedSecretPrefix) {
+		return secret, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, sealedSecretPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	unsealed, err := unsealData(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	return string(unsealed), nil
 }
 
 // GetRVpnState returns the parsed rVPN state from the system
@@ -27,34 +55,104 @@ func GetRVpnState() (RVpnState, error) {
 	}
 
 	var rVpnStateObj RVpnState
-	json.Unmarshal(rVpnStateData, &rVpnStateObj)
+	err = json.Unmarshal(rVpnStateData, &rVpnStateObj)
+	if err != nil {
+		return RVpnState{}, err
+	}
+
+	rVpnStateObj.ControlPlaneAuth, err = unsealSecret(rVpnStateObj.ControlPlaneAuth)
+	if err != nil {
+		return RVpnState{}, fmt.Errorf("failed to unseal control plane auth: %w", err)
+	}
+
+	rVpnStateObj.PrivateKey, err = unsealSecret(rVpnStateObj.PrivateKey)
+	if err != nil {
+		return RVpnState{}, fmt.Errorf("failed to unseal private key: %w", err)
+	}
+
+	for name, account := range rVpnStateObj.Accounts {
+		rVpnStateObj.Accounts[name], err = unsealAccount(account)
+		if err != nil {
+			return RVpnState{}, fmt.Errorf("failed to unseal account %s: %w", name, err)
+		}
+	}
+
+	rVpnStateObj.migrateAccounts()
+
 	return rVpnStateObj, nil
 }
 
-// SetRVpnState sets the rVPN state on the system
+// SetRVpnState sets the rVPN state on the system, secrets are sealed to the machine
 func SetRVpnState(rVpnStateData RVpnState) error {
 	rVpnStateFile, err := getRVpnStatePath()
 	if err != nil {
 		return err
 	}
 
+	rVpnStateData.ControlPlaneAuth, err = sealSecret(rVpnStateData.ControlPlaneAuth)
+	if err != nil {
+		return fmt.Errorf("failed to seal control plane auth: %w", err)
+	}
+
+	rVpnStateData.PrivateKey, err = sealSecret(rVpnStateData.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to seal private key: %w", err)
+	}
+
+	// NOTE: the accounts are copied so the accounts of the caller stay unsealed
+	sealedAccounts := map[string]Account{}
+	for name, account := range rVpnStateData.Accounts {
+		sealedAccounts[name], err = sealAccount(account)
+		if err != nil {
+			return fmt.Errorf("failed to seal account %s: %w", name, err)
+		}
+	}
+	rVpnStateData.Accounts = sealedAccounts
+
 	rVpnStateJson, err := json.Marshal(rVpnStateData)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(rVpnStateFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	return writeFileAtomic(rVpnStateFile, rVpnStateJson, 0600)
+}
+
+// writeFileAtomic writes data to a temp file next to the file and renames it over the file, so a crash mid-write
+// never leaves a truncated file behind
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(path.Dir(filename), "."+path.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpFilename := f.Name()
+
+	// the temp file is removed if it is not renamed over the file
+	defer os.Remove(tmpFilename)
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Chmod(perm)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Sync()
 	if err != nil {
+		f.Close()
 		return err
 	}
-	w := bufio.NewWriter(f)
-	_, err = w.Write(rVpnStateJson)
+
+	err = f.Close()
 	if err != nil {
 		return err
 	}
 
-	w.Flush()
-	return nil
+	return os.Rename(tmpFilename, filename)
 }
 
 // InitRVPNState initializes the WireGuard client and must be run before any operations
@@ -65,19 +163,29 @@ func InitRVPNState() error {
 		return err
 	}
 
-	// create rVPN state directory if not exists
+	// create rVPN state directory if not exists, only the daemon may access it
 	rVpnConfigDir := path.Dir(rVpnStateFile)
-	if _, err := os.Stat(rVpnConfigDir); os.IsNotExist(err) {
-		err = os.MkdirAll(rVpnConfigDir, 0600)
-		if err != nil {
-			return err
-		}
+	err = os.MkdirAll(rVpnConfigDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// directories created by older versions are not executable
+	err = os.Chmod(rVpnConfigDir, 0700)
+	if err != nil {
+		return err
 	}
 
 	if _, err := os.Stat(rVpnStateFile); os.IsNotExist(err) {
 		// if no rVpnState config then set it to be empty
-		SetRVpnState(RVpnState{})
+		return SetRVpnState(RVpnState{})
+	}
+
+	// rewrite the state so secrets written in plaintext by older versions are sealed
+	rVpnState, err := GetRVpnState()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return SetRVpnState(rVpnState)
 }
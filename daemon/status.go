@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redpwn/rvpn/common"
+)
+
+// control channel states of the status report
+const (
+	ControlChannelOpen         = "open"
+	ControlChannelReconnecting = "reconnecting"
+	ControlChannelClosed       = "closed"
+)
+
+// sessionInfo is the account, target and options of the session of the daemon and the tunnel it configured, it is
+// guarded by sessionMu as RPCs and the jrpc handlers of the control channel change it concurrently
+type sessionInfo struct {
+	account          string
+	profile          string
+	opts             common.ClientOptions
+	serveOpts        common.ServeOptions
+	controlPlaneAddr string     // ip of the control plane, reused while the kill switch blocks DNS
+	tunnel           tunnelInfo // tunnel of the session for the status report
+}
+
+// tunnelInfo describes the tunnel of the active session, it is set once the control plane configured the tunnel
+type tunnelInfo struct {
+	address         string   // ip and cidr of the wireguard interface, i.e "10.8.0.2/24"
+	serverEndpoint  string   // endpoint of the rVPN server in client mode
+	serverPublicKey string   // public key of the rVPN server in client mode
+	routes          []string // subnets routed through the tunnel in client mode
+	dns             string   // DNS server of the target, empty if the target has none
+}
+
+// PeerReport is a wireguard peer in the status report
+type PeerReport struct {
+	PublicKey     string    `json:"publicKey"`
+	Endpoint      string    `json:"endpoint,omitempty"`
+	LastHandshake time.Time `json:"lastHandshake"` // zero if the peer never completed a handshake
+	ReceiveBytes  int64     `json:"rxBytes"`
+	TransmitBytes int64     `json:"txBytes"`
+}
+
+// DNSReport is the DNS state in the status report
+type DNSReport struct {
+	Server  string `json:"server"`  // DNS server of the target
+	Applied bool   `json:"applied"` // whether the system resolver points at the DNS server
+}
+
+// StatusReport is a detailed status of the rVPN daemon
+type StatusReport struct {
+	Status         RVPNStatus   `json:"status"`
+	Account        string       `json:"account,omitempty"`
+	Profile        string       `json:"profile,omitempty"`
+	TunnelAddress  string       `json:"tunnelAddress,omitempty"`
+	Server         *PeerReport  `json:"server,omitempty"` // rVPN server in client mode
+	Peers          []PeerReport `json:"peers"`            // other peers, i.e clients in server mode
+	Routes         []string     `json:"routes"`
+	DNS            *DNSReport   `json:"dns,omitempty"`
+	KillSwitch     bool         `json:"killSwitch"`
+	UptimeSeconds  int64        `json:"uptimeSeconds"` // time since the session connected or started serving
+	ControlChannel string       `json:"controlChannel"`
+	ControlPlane   string       `json:"controlPlane,omitempty"` // address of the control plane
+}
+
+// MarshalJSON marshals the status as its name
+func (s RVPNStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON unmarshals a status marshalled by MarshalJSON
+func (s *RVPNStatus) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range []RVPNStatus{StatusConnected, StatusDisconnected, StatusServing, StatusReconnecting,
+		StatusConnecting} {
+		if status.String() == name {
+			*s = status
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown rVPN status %s", name)
+}
+
+// StatusReport returns a detailed status of the rVPN daemon
+func (r *RVPNDaemon) StatusReport(args string, reply *StatusReport) error {
+	status := r.getStatus()
+
+	report := StatusReport{
+		Status:         status,
+		Peers:          []PeerReport{},
+		Routes:         []string{},
+		ControlChannel: ControlChannelClosed,
+	}
+
+	switch status {
+	case StatusDisconnected:
+		*reply = report
+		return nil
+	case StatusReconnecting:
+		report.ControlChannel = ControlChannelReconnecting
+	case StatusConnected, StatusServing:
+		report.ControlChannel = ControlChannelOpen
+	}
+
+	info := r.getSessionInfo()
+	tunnel := info.tunnel
+
+	report.Account = info.account
+	report.Profile = info.profile
+	report.TunnelAddress = tunnel.address
+	report.ControlPlane = info.controlPlaneAddr
+	report.KillSwitch = info.opts.KillSwitch && status != StatusServing
+
+	if tunnel.routes != nil {
+		report.Routes = tunnel.routes
+	}
+
+	if sessionStart := r.getSessionStart(); !sessionStart.IsZero() {
+		report.UptimeSeconds = int64(time.Since(sessionStart).Seconds())
+	}
+
+	if tunnel.dns != "" {
+		report.DNS = &DNSReport{
+			Server:  tunnel.dns,
+			Applied: r.wireguardDaemon.DNSServer() == tunnel.dns,
+		}
+	}
+
+	peerStats, err := r.wireguardDaemon.PeerStats()
+	if err != nil {
+		log.Printf("failed to get peer stats: %v", err)
+	}
+
+	for _, peerStat := range peerStats {
+		peerReport := PeerReport{
+			PublicKey:     peerStat.PublicKey,
+			Endpoint:      peerStat.Endpoint,
+			LastHandshake: peerStat.LastHandshake,
+			ReceiveBytes:  peerStat.ReceiveBytes,
+			TransmitBytes: peerStat.TransmitBytes,
+		}
+
+		if status != StatusServing && peerStat.PublicKey == tunnel.serverPublicKey {
+			if tunnel.serverEndpoint != "" {
+				// NOTE: the endpoint of a relayed server is the relay, report the real endpoint of the server
+				peerReport.Endpoint = tunnel.serverEndpoint
+			}
+
+			report.Server = &peerReport
+			continue
+		}
+
+		report.Peers = append(report.Peers, peerReport)
+	}
+
+	*reply = report
+	return nil
+}
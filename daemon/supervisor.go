@@ -0,0 +1,208 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+const (
+	connectEndpoint = "connect"
+	serveEndpoint   = "serve"
+
+	reconnectBaseDelay  = 1 * time.Second
+	reconnectMaxDelay   = 2 * time.Minute
+	reconnectStableTime = 1 * time.Minute // a control channel which stays open this long resets the backoff
+)
+
+// reconnectJitter is seeded per daemon so devices pick different delays
+var reconnectJitter = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// controlSession holds what is needed to (re)open the control channel of a connect or serve session
+type controlSession struct {
+	endpoint       string // control plane WebSocket endpoint of the session, connect or serve
+	account        string
+	profile        string
+	deviceToken    string
+	controlPlaneWS string
+}
+
+// openSession opens the control channel of the session, a connect session is only open once the tunnel to the
+// target vpn server is healthy
+func (r *RVPNDaemon) openSession(session controlSession) (*jsonrpc2.Conn, error) {
+	// create long-lived WebSocket connection acting as jrpc channel between client and control plane
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	// the outcome is reported once the control plane configured the tunnel and the tunnel is verified
+	var connectResult chan error
+	if session.endpoint == connectEndpoint {
+		connectResult = make(chan error, 1)
+		r.setConnectResult(connectResult)
+	}
+
+	jrpcConn, err := r.dialControlPlane(ctx, session)
+	if err != nil {
+		r.setConnectResult(nil)
+		cancelFunc()
+
+		return nil, fmt.Errorf("failed to connect to rVPN control plane web socket: %w", err)
+	}
+
+	r.sessionMu.Lock()
+	r.jrpcConn = jrpcConn
+	r.jrpcCtxCancel = cancelFunc
+	r.sessionMu.Unlock()
+
+	if connectResult == nil {
+		// serve sessions are ready as soon as the control plane is reachable
+		return jrpcConn, nil
+	}
+
+	// wait until the tunnel to the target vpn server is healthy
+	select {
+	case err = <-connectResult:
+	case <-time.After(connectTimeout):
+		err = fmt.Errorf("timed out connecting to rVPN target server: %w", errTargetOffline)
+	}
+
+	r.setConnectResult(nil)
+
+	if err != nil {
+		// NOTE: an unhealthy tunnel is already rolled back
+		jrpcConn.Close()
+		cancelFunc()
+
+		return nil, err
+	}
+
+	return jrpcConn, nil
+}
+
+// setConnectResult sets the channel a pending Connect receives its outcome on, nil if no Connect is pending
+func (r *RVPNDaemon) setConnectResult(connectResult chan error) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	r.connectResult = connectResult
+}
+
+// closeControlChannel closes the control channel of the session and cancels its context, which stops the
+// heartbeat, key rotation and relay of the control channel
+// NOTE: the control channel is not open yet while connecting for the first time
+func (r *RVPNDaemon) closeControlChannel() {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	if r.jrpcConn != nil {
+		r.jrpcConn.Close()
+		r.jrpcConn = nil
+	}
+
+	if r.jrpcCtxCancel != nil {
+		r.jrpcCtxCancel()
+		r.jrpcCtxCancel = nil
+	}
+}
+
+// startSupervisor starts supervising the control channel of the session, the supervisor is stopped by
+// stopSupervisor
+func (r *RVPNDaemon) startSupervisor(session controlSession, jrpcConn *jsonrpc2.Conn) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	r.sessionMu.Lock()
+	r.supervisorCancel = cancelFunc
+	r.sessionMu.Unlock()
+
+	go r.superviseControlChannel(ctx, session, jrpcConn)
+}
+
+// stopSupervisor stops the supervisor of the control channel so a closed control channel is not reopened
+func (r *RVPNDaemon) stopSupervisor() {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+
+	if r.supervisorCancel != nil {
+		r.supervisorCancel()
+		r.supervisorCancel = nil
+	}
+}
+
+// superviseControlChannel reopens the control channel of the session with exponential backoff whenever it closes,
+// i.e when the control plane is redeployed or the network drops, until context is cancelled
+func (r *RVPNDaemon) superviseControlChannel(ctx context.Context, session controlSession, jrpcConn *jsonrpc2.Conn) {
+	attempt := 0
+	openedAt := time.Now()
+
+	for {
+		select {
+		case <-jrpcConn.DisconnectNotify():
+		case <-ctx.Done():
+			return
+		}
+
+		if ctx.Err() != nil {
+			// the session was closed on purpose
+			return
+		}
+
+		// stop the heartbeat, key rotation and relay of the closed control channel
+		r.closeControlChannel()
+		r.setStatus(StatusReconnecting, "control channel to rVPN control plane closed")
+		log.Printf("control channel to rVPN control plane closed, reconnecting")
+
+		if time.Since(openedAt) >= reconnectStableTime {
+			attempt = 0
+		}
+
+		for {
+			delay := reconnectDelay(attempt)
+			attempt++
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+
+			newJrpcConn, err := r.openSession(session)
+			if err != nil {
+				log.Printf("failed to reconnect to rVPN control plane (attempt %d): %v", attempt, err)
+				r.publishError(fmt.Sprintf("failed to reconnect to rVPN control plane: %v", err))
+				continue
+			}
+
+			if ctx.Err() != nil {
+				// the session was closed while reconnecting, undo the tunnel it configured
+				r.wireguardDaemon.Disconnect()
+				r.closeControlChannel()
+				return
+			}
+
+			jrpcConn = newJrpcConn
+			openedAt = time.Now()
+			break
+		}
+
+		// NOTE: the status is updated once the control plane configured the tunnel or instructed the daemon to serve
+		log.Printf("reconnected to rVPN control plane")
+	}
+}
+
+// reconnectDelay returns the delay before the given reconnect attempt, the delay doubles every attempt up to
+// reconnectMaxDelay and is jittered so devices do not reconnect in lockstep after a control plane deploy
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		delay = reconnectBaseDelay << attempt
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+
+	// jitter the delay between half and all of it
+	return delay/2 + time.Duration(reconnectJitter.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,159 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// publicKeyPinPrefix prefixes the base64 sha256 hash of the pinned public key, i.e the output of
+// "openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64"
+const publicKeyPinPrefix = "sha256/"
+
+// pinned returns whether the certificate or public key of the control plane is pinned
+func (c Config) pinned() bool {
+	return c.ControlPlaneCert != "" || c.ControlPlanePublicKey != ""
+}
+
+// validatePins returns an error if the pinned certificate or public key of the config is invalid
+func (c Config) validatePins() error {
+	if c.ControlPlaneCert != "" {
+		if _, err := c.pinnedCert(); err != nil {
+			return err
+		}
+	}
+
+	if c.ControlPlanePublicKey != "" {
+		if _, err := c.pinnedPublicKeyHash(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pinnedCert reads the DER bytes of the pinned certificate from its PEM file
+func (c Config) pinnedCert() ([]byte, error) {
+	certPEM, err := os.ReadFile(c.ControlPlaneCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pinned control plane certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("pinned control plane certificate %s is not a PEM certificate", c.ControlPlaneCert)
+	}
+
+	return block.Bytes, nil
+}
+
+// pinnedPublicKeyHash decodes the sha256 hash of the pinned public key
+func (c Config) pinnedPublicKeyHash() ([]byte, error) {
+	if !strings.HasPrefix(c.ControlPlanePublicKey, publicKeyPinPrefix) {
+		return nil, fmt.Errorf("invalid pinned control plane public key, must start with %s", publicKeyPinPrefix)
+	}
+
+	publicKeyHash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(c.ControlPlanePublicKey, publicKeyPinPrefix))
+	if err != nil || len(publicKeyHash) != sha256.Size {
+		return nil, errors.New("invalid pinned control plane public key, must be a base64 sha256 hash")
+	}
+
+	return publicKeyHash, nil
+}
+
+// TLSConfig returns the TLS config for connections to the control plane, a pinned certificate or public key
+// replaces verification against the system roots so control planes with self-signed certificates can be pinned
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if !c.pinned() {
+		return &tls.Config{}, nil
+	}
+
+	var pinnedCert, pinnedPublicKeyHash []byte
+	var err error
+
+	if c.ControlPlaneCert != "" {
+		pinnedCert, err = c.pinnedCert()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ControlPlanePublicKey != "" {
+		pinnedPublicKeyHash, err = c.pinnedPublicKeyHash()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Config{
+		// NOTE: the presented certificate is verified against the pins in VerifyConnection instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("control plane presented no certificate")
+			}
+			leaf := state.PeerCertificates[0]
+
+			if pinnedCert != nil && !bytes.Equal(leaf.Raw, pinnedCert) {
+				return errors.New("control plane certificate does not match the pinned certificate")
+			}
+
+			if pinnedPublicKeyHash != nil {
+				publicKeyHash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+				if !bytes.Equal(publicKeyHash[:], pinnedPublicKeyHash) {
+					return errors.New("control plane public key does not match the pinned public key")
+				}
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+// HTTPClient returns a http client for requests to the control plane which verifies the control plane against the
+// pins of the config
+func (c Config) HTTPClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// forControlPlaneWS returns the config with the control plane of the WebSocket url, i.e the control plane of an
+// account which was passed by the client
+func (c Config) forControlPlaneWS(controlPlaneWS string) Config {
+	return c.ForAccount(Account{
+		ControlPlaneURL: "http" + strings.TrimPrefix(controlPlaneWS, "ws"),
+	})
+}
+
+// samePinnedHost returns whether both control plane urls have the same host, pins only apply to the host of the
+// control plane they are configured for
+func samePinnedHost(controlPlaneURL string, otherControlPlaneURL string) bool {
+	parsedURL, err := url.Parse(controlPlaneURL)
+	if err != nil {
+		return false
+	}
+
+	otherParsedURL, err := url.Parse(otherControlPlaneURL)
+	if err != nil {
+		return false
+	}
+
+	return parsedURL.Host == otherParsedURL.Host
+}
@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate for the control plane
+func testCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rvpn.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// publicKeyPin returns the public key pin of the certificate
+func publicKeyPin(cert *x509.Certificate) string {
+	publicKeyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return publicKeyPinPrefix + base64.StdEncoding.EncodeToString(publicKeyHash[:])
+}
+
+func TestPinnedPublicKeyHash(t *testing.T) {
+	hash := sha256.Sum256([]byte("public key"))
+	encodedHash := base64.StdEncoding.EncodeToString(hash[:])
+
+	tests := []struct {
+		name      string
+		publicKey string
+		want      []byte
+		wantErr   bool
+	}{
+		{name: "valid", publicKey: publicKeyPinPrefix + encodedHash, want: hash[:]},
+		{name: "missing prefix", publicKey: encodedHash, wantErr: true},
+		{name: "other hash", publicKey: "sha1/" + encodedHash, wantErr: true},
+		{name: "not base64", publicKey: publicKeyPinPrefix + "not base64!", wantErr: true},
+		{name: "short hash", publicKey: publicKeyPinPrefix + base64.StdEncoding.EncodeToString(hash[:16]), wantErr: true},
+		{name: "empty hash", publicKey: publicKeyPinPrefix, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{ControlPlanePublicKey: tt.publicKey}
+
+			got, err := config.pinnedPublicKeyHash()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pinnedPublicKeyHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("pinnedPublicKeyHash() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSConfigPins(t *testing.T) {
+	cert := testCert(t)
+	otherCert := testCert(t)
+
+	certPath := filepath.Join(t.TempDir(), "control-plane.pem")
+	err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "pinned certificate", config: Config{ControlPlaneCert: certPath}},
+		{name: "pinned public key", config: Config{ControlPlanePublicKey: publicKeyPin(cert)}},
+		{name: "both pinned", config: Config{ControlPlaneCert: certPath, ControlPlanePublicKey: publicKeyPin(cert)}},
+		{name: "other public key", config: Config{ControlPlanePublicKey: publicKeyPin(otherCert)}, wantErr: true},
+		{
+			name:    "certificate matches but public key does not",
+			config:  Config{ControlPlaneCert: certPath, ControlPlanePublicKey: publicKeyPin(otherCert)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := tt.config.TLSConfig()
+			if err != nil {
+				t.Fatalf("TLSConfig() error = %v", err)
+			}
+
+			err = tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("other certificate", func(t *testing.T) {
+		tlsConfig, err := Config{ControlPlaneCert: certPath}.TLSConfig()
+		if err != nil {
+			t.Fatalf("TLSConfig() error = %v", err)
+		}
+
+		err = tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{otherCert}})
+		if err == nil {
+			t.Error("VerifyConnection() accepted a certificate which does not match the pinned certificate")
+		}
+	})
+
+	t.Run("not pinned", func(t *testing.T) {
+		tlsConfig, err := Config{}.TLSConfig()
+		if err != nil {
+			t.Fatalf("TLSConfig() error = %v", err)
+		}
+
+		if tlsConfig.InsecureSkipVerify || tlsConfig.VerifyConnection != nil {
+			t.Error("TLSConfig() skips verification against the system roots without pins")
+		}
+	})
+}
+
+func TestSamePinnedHost(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "same url", a: "https://rvpn.example.com", b: "https://rvpn.example.com", want: true},
+		{name: "different path", a: "https://rvpn.example.com", b: "https://rvpn.example.com/api", want: true},
+		{name: "different host", a: "https://rvpn.example.com", b: "https://evil.example.com"},
+		{name: "different port", a: "https://rvpn.example.com", b: "https://rvpn.example.com:8443"},
+		{name: "invalid url", a: "https://rvpn.example.com", b: "https://%zz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := samePinnedHost(tt.a, tt.b); got != tt.want {
+				t.Errorf("samePinnedHost(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,20 +6,36 @@ const (
 	IpSourceRouteTableBaseIdx = 130
 )
 
+// DeviceConfig holds the configuration of the wireguard device
+type DeviceConfig struct {
+	InterfaceName    string
+	MTU              int
+	ClientListenPort int // wireguard listen port in client mode
+}
+
 type ClientWgConfig struct {
-	ClientPrivateKey string // client private key
-	ServerPublicKey  string // server public key
-	ClientIp         string
-	ClientCidr       string
-	ServerIp         string
-	ServerPort       int
-	DnsIp            string
+	ClientPrivateKey  string // client private key
+	ServerPublicKey   string // server public key
+	PresharedKey      string // preshared key of the connection, empty if none
+	ClientIp          string
+	ClientCidr        string
+	ServerIp          string
+	ServerPort        int
+	DnsIp             string
+	Subnets           []string // subnets routed through the tunnel, defaults to all traffic
+	AdvertisedSubnets []string // subnets the client routes for other peers
 }
 
 type WireGuardPeer struct {
-	PublicKey   string
-	AllowedIP   string
-	AllowedCidr string
+	PublicKey    string
+	PresharedKey string // preshared key of the connection, empty if none
+	AllowedIP    string
+	AllowedCidr  string   // cidr of the peer tunnel ip, defaults to /32
+	Subnets      []string // subnets routed through the peer
+	Endpoint     string   // public endpoint of the peer, i.e "1.2.3.4:51720"
+	UploadMbps   int      // bandwidth limit of traffic from the peer in server mode, zero means unlimited
+	DownloadMbps int      // bandwidth limit of traffic to the peer in server mode, zero means unlimited
+	Staged       bool     // peer for a rotated key in server mode, it has no allowed ips until the rotation completes
 	// TODO: consider adding device id or some identify for indexing to remove peers down the line
 }
 
@@ -29,6 +45,17 @@ type ServeWgConfig struct {
 	InternalIp   string
 	InternalCidr string
 	Peers        []WireGuardPeer
+	Subnets      []string // subnets which clients are allowed to reach, defaults to all traffic
+	Masquerade   bool     // masquerade forwarded client traffic
+}
+
+// FirewallRule allows forwarded traffic from the sources to the destinations over the protocol and ports in server
+// mode, empty lists and an empty protocol match any
+type FirewallRule struct {
+	Sources      []string // source cidrs
+	Destinations []string // destination cidrs
+	Protocol     string   // tcp, udp or icmp
+	Ports        []string // destination ports or port ranges for tcp and udp, i.e "8000-8100"
 }
 
 // GenerateKeyPair returns a new private key, public key, and optionally error
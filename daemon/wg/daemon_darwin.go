@@ -34,19 +34,28 @@ type WireguardDaemon struct {
 	InterfaceName    string
 
 	// internal variables used for managing the daemon
-	appendedRoutes []routeInfo
+	deviceConf            DeviceConfig // configuration the wireguard device is created with
+	appendedRoutes        []routeInfo
+	serverPublicKey       wgtypes.Key // public key of the rVPN server peer
+	stagedServerPublicKey wgtypes.Key // rotated key of the rVPN server peer which has not been switched to yet
+	tunnelNet             net.IPNet   // network of the rvpn wireguard interface
+	mesh                  meshState   // peers connected to directly in mesh mode
+	bind                  *stunBind   // wireguard socket which is also used for endpoint discovery
+	relay                 *relayBind  // wireguard socket which relays packets over WebSocket if UDP is blocked
 }
 
-// NewWireguardDaemon returns a new WireguardDaemon NOTE: this is uninitialized
-func NewWireguardDaemon() *WireguardDaemon {
-	return &WireguardDaemon{}
+// NewWireguardDaemon returns a new WireguardDaemon for the device config NOTE: this is uninitialized
+func NewWireguardDaemon(deviceConf DeviceConfig) *WireguardDaemon {
+	return &WireguardDaemon{
+		deviceConf: deviceConf,
+	}
 }
 
 // StartDevice starts the wireguard networking interface used by rVPN
 func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	// use wireguard to create a new device
-	interfaceName := "utun140"
-	deviceMTU := 1420
+	interfaceName := d.deviceConf.InterfaceName
+	deviceMTU := d.deviceConf.MTU
 
 	// open TUN device
 	tun, err := tun.CreateTUN(interfaceName, deviceMTU)
@@ -61,7 +70,9 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	)
 
 	// create wireguard device from TUN device
-	device := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	bind := newStunBind(conn.NewDefaultBind())
+	relay := newRelayBind(bind)
+	device := device.NewDevice(tun, relay, logger)
 	logger.Verbosef("created wireguard network interface")
 
 	// start wireguard interface now that routes and interface are set
@@ -99,6 +110,8 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	logger.Verbosef("UAPI listener started")
 
 	d.Device = device
+	d.bind = bind
+	d.relay = relay
 	d.Uapi = uapi
 	d.InterfaceName = interfaceName
 
@@ -200,8 +213,33 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 		log.Fatalf("failed to parse public key: %v", err)
 	}
 
+	psk, err := parsePresharedKey(wgConf.PresharedKey)
+	if err != nil {
+		log.Fatalf("failed to parse preshared key: %v", err)
+	}
+
+	// parse routable subnets which become the peer allowed IPs
+	subnets, err := parseSubnets(wgConf.Subnets)
+	if err != nil {
+		log.Fatalf("failed to parse routable subnets: %v", err)
+	}
+
+	_, tunnelNet, err := net.ParseCIDR(interfaceAddressPrefix)
+	if err != nil {
+		log.Fatalf("failed to parse tunnel network: %v", err)
+	}
+
+	d.serverPublicKey = pub
+	d.stagedServerPublicKey = wgtypes.Key{}
+	d.tunnelNet = *tunnelNet
+
+	if len(wgConf.AdvertisedSubnets) > 0 {
+		// NOTE: forwarding to advertised subnets is only supported on Linux devices
+		log.Println("warn: advertising subnets is not supported on darwin, ignoring advertised subnets")
+	}
+
 	// configure wireguard interface with peer information
-	port := 51720
+	port := d.deviceConf.ClientListenPort
 	ka := 20 * time.Second
 
 	conf := wgtypes.Config{
@@ -212,17 +250,14 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 			PublicKey:    pub,
 			Remove:       false,
 			UpdateOnly:   false,
-			PresharedKey: nil,
+			PresharedKey: psk,
 			Endpoint: &net.UDPAddr{
 				IP:   net.ParseIP(wgConf.ServerIp),
 				Port: wgConf.ServerPort,
 			},
 			PersistentKeepaliveInterval: &ka,
 			ReplaceAllowedIPs:           true,
-			AllowedIPs: []net.IPNet{{
-				IP:   net.ParseIP("0.0.0.0"),
-				Mask: net.IPv4Mask(0, 0, 0, 0),
-			}},
+			AllowedIPs:                  clientAllowedIPs(subnets, d.tunnelNet),
 		}},
 	}
 
@@ -235,29 +270,74 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 	}
 
 	// now that wireguard tunnel is fully up, we add the routes to the system to redirect traffic there
+	// a default route is split to be slightly more specific than default
+	err = d.replaceRoutes(subnetRoutes(subnets))
+	if err != nil {
+		log.Fatalf("something went wrong with route add: %v", err)
+	}
+}
 
-	// set routes to be the de-duped peer allowed IPs (routable subnets)
-	// TODO: enable this to be overridden for client via cli flag
-	// TODO: this needs to actually be the de-duped peer allowed IPs
+// UpdateClientRoutes replaces the subnets routed through the rVPN server peer
+func (d *WireguardDaemon) UpdateClientRoutes(routableSubnets []string) error {
+	log.Println("updating routable subnets for rVPN server peer")
+
+	subnets, err := parseSubnets(routableSubnets)
+	if err != nil {
+		return err
+	}
 
-	// we create a slightly more specific than default route
-	routes := []routeInfo{{
-		ipAddressPrefix: "0.0.0.0/1",
-		interfaceName:   d.InterfaceName,
-	}, {
-		ipAddressPrefix: "128.0.0.0/1",
-		interfaceName:   d.InterfaceName,
-	}}
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	conf := wgtypes.Config{
+		ReplacePeers: false,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         d.serverPublicKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        clientAllowedIPs(subnets, d.tunnelNet),
+		}},
+	}
+
+	if err := client.ConfigureDevice(d.InterfaceName, conf); err != nil {
+		return fmt.Errorf("failed to configure rVPN server peer: %w", err)
+	}
+
+	return d.replaceRoutes(subnetRoutes(subnets))
+}
+
+// replaceRoutes replaces the routes appended to the rvpn wireguard interface with routes to the destinations
+func (d *WireguardDaemon) replaceRoutes(routeDsts []net.IPNet) error {
+	for _, appendedRoute := range d.appendedRoutes {
+		err := routeDelIFace(appendedRoute.ipAddressPrefix, appendedRoute.interfaceName)
+		if err != nil {
+			// this should not fatal as route may already be deleted
+			log.Printf("warn: failed to delete appended route: %v", err)
+		}
+	}
+
+	d.appendedRoutes = []routeInfo{}
 
 	// add peer routes to the rvpn wireguard interface
-	for _, newRoute := range routes {
-		err = routeAddIFace(newRoute.ipAddressPrefix, newRoute.interfaceName)
+	for _, routeDst := range routeDsts {
+		newRoute := routeInfo{
+			ipAddressPrefix: routeDst.String(),
+			interfaceName:   d.InterfaceName,
+		}
+
+		err := routeAddIFace(newRoute.ipAddressPrefix, newRoute.interfaceName)
 		if err != nil {
-			log.Fatalf("something went wrong with route add: %v", err)
+			return err
 		}
+
+		d.appendedRoutes = append(d.appendedRoutes, newRoute)
 	}
 
-	d.appendedRoutes = routes
+	return nil
 }
 
 // Disconnect instructs the wireguard daemon to disconnect from current connection
@@ -280,6 +360,9 @@ func (d *WireguardDaemon) Disconnect() {
 	}
 
 	d.appendedRoutes = []routeInfo{}
+
+	// forget mesh peers, they are replaced on the next connect
+	d.mesh.reset()
 }
 
 // ShutdownDevice shuts down the wireguard device
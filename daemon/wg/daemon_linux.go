@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/vishvananda/netlink"
@@ -29,22 +30,43 @@ type WireguardDaemon struct {
 	InterfaceName    string
 
 	// internal variables used for managing the daemon
-	appendedRoutes    []netlink.Route // routes stashed outside of rvpn
-	appendedSrcRules  []*netlink.Rule // rules for source routing
-	appendedSrcRoutes []netlink.Route // routes for source routing
-	vpnServerMode     bool
+	deviceConf            DeviceConfig             // configuration the wireguard device is created with
+	appendedRoutes        []netlink.Route          // routes stashed outside of rvpn
+	hostRoutes            []netlink.Route          // routes of the server and control plane ips through the default interface
+	defaultGateway        net.IP                   // gateway of the default interface
+	appendedSrcRules      []*netlink.Rule          // rules for source routing
+	appendedSrcRoutes     []netlink.Route          // routes for source routing
+	sourceRouting         bool                     // whether replies are source routed through the default interface
+	netfilter             netfilterBackend         // netfilter backend for forwarding, NAT and firewall rules
+	forwardingConf        *forwardingConf          // configured forwarding, re-applied when the default interface changes
+	dns                   dnsBackend               // DNS backend which points the system resolver at the target
+	dnsIp                 string                   // DNS server ip of the target, kept so route updates can reapply it
+	dnsServer             net.IP                   // DNS server of the target the system resolver points at, nil if none
+	rateLimits            map[string]peerRateLimit // bandwidth limits of peers in server mode by public key
+	peerRoutes            map[string][]net.IPNet   // subnets routed through peers in server mode by public key
+	vpnServerMode         bool
+	serverPublicKey       wgtypes.Key // public key of the rVPN server peer in client mode
+	stagedServerPublicKey wgtypes.Key // rotated key of the rVPN server peer which has not been switched to yet
+	tunnelNet             net.IPNet   // network of the rvpn wireguard interface
+	mesh                  meshState   // peers connected to directly in mesh mode
+	bind                  *stunBind   // wireguard socket which is also used for endpoint discovery
+	relay                 *relayBind  // wireguard socket which relays packets over WebSocket if UDP is blocked
+	networkMu             sync.Mutex  // serializes reconfiguration with handling of network changes
+	netWatchDone          chan struct{}
 }
 
-// NewWireguardDaemon returns a new WireguardDaemon NOTE: this is uninitialized
-func NewWireguardDaemon() *WireguardDaemon {
-	return &WireguardDaemon{}
+// NewWireguardDaemon returns a new WireguardDaemon for the device config NOTE: this is uninitialized
+func NewWireguardDaemon(deviceConf DeviceConfig) *WireguardDaemon {
+	return &WireguardDaemon{
+		deviceConf: deviceConf,
+	}
 }
 
 // StartDevice starts the wireguard networking interface used by rVPN
 func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	// use wireguard to create a new device
-	interfaceName := "rvpn0"
-	deviceMTU := 1420
+	interfaceName := d.deviceConf.InterfaceName
+	deviceMTU := d.deviceConf.MTU
 
 	// open TUN device
 	tun, err := tun.CreateTUN(interfaceName, deviceMTU)
@@ -59,7 +81,9 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	)
 
 	// create wireguard device from TUN device
-	device := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	bind := newStunBind(conn.NewDefaultBind())
+	relay := newRelayBind(bind)
+	device := device.NewDevice(tun, relay, logger)
 	logger.Verbosef("created wireguard network interface")
 
 	// start wireguard interface now that routes and interface are set
@@ -96,10 +120,33 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 
 	logger.Verbosef("UAPI listener started")
 
+	// pick the netfilter backend for forwarding, NAT and firewall rules
+	netfilter, err := newNetfilterBackend(interfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to create netfilter backend: %w", err)
+	}
+
+	// pick the DNS backend for the DNS server of the target
+	dns, err := newDnsBackend(interfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to create DNS backend: %w", err)
+	}
+
 	d.Device = device
+	d.bind = bind
+	d.netfilter = netfilter
+	d.dns = dns
+	d.relay = relay
 	d.Uapi = uapi
 	d.InterfaceName = interfaceName
 
+	// follow the default route so switching networks does not break the tunnel
+	d.netWatchDone = make(chan struct{})
+	err = d.watchNetwork(d.netWatchDone)
+	if err != nil {
+		return fmt.Errorf("failed to watch for network changes: %w", err)
+	}
+
 	return nil
 }
 
@@ -108,6 +155,9 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAddr string) {
 	log.Println("starting wireguard network interface configuration for clients")
 
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
+
 	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
 	if err != nil {
 		log.Fatalf("failed to get rvpn wireguard interface link: %v", err)
@@ -146,46 +196,17 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 
 	if d.DefaultIFaceLink == nil {
 		log.Println("updating default interface...")
-		// default interface has not yet been defined
-		d.DefaultIFaceLink = currDefaultIFace
-
-		// set target server route on default interface as it has not been set yet
-		_, parsedIPNet, err := net.ParseCIDR(d.ServerIP.String())
-		if err != nil {
-			log.Fatalf("failed to parse server IP into net.IPNet")
-		}
-
-		route := netlink.Route{
-			LinkIndex: d.DefaultIFaceLink.Attrs().Index,
-			Dst:       parsedIPNet,
-			Gw:        currDefaultGateway,
-		}
-
-		if err := netlink.RouteAdd(&route); err != nil {
-			log.Fatalf("failed to add server IP to default interface: %v", err)
-		}
-
-		// set control plane route on default interface
-		_, parsedIPNet, err = net.ParseCIDR(d.ControlPlaneIP.String())
-		if err != nil {
-			log.Fatalf("failed to parse control plane IP into net.IPNet")
-		}
+	} else if d.DefaultIFaceLink.Attrs().Name != currDefaultIFace.Attrs().Name {
+		log.Printf("default interface has changed to %s", currDefaultIFace.Attrs().Name)
+	}
 
-		route = netlink.Route{
-			LinkIndex: d.DefaultIFaceLink.Attrs().Index,
-			Dst:       parsedIPNet,
-			Gw:        currDefaultGateway,
-		}
+	d.DefaultIFaceLink = currDefaultIFace
+	d.defaultGateway = currDefaultGateway
 
-		if err := netlink.RouteAdd(&route); err != nil {
-			log.Fatalf("failed to add control plane IP to default interface: %v", err)
-		}
-	} else {
-		// default interface has already been set
-		if d.DefaultIFaceLink.Attrs().Name != currDefaultIFace.Attrs().Name {
-			// default interface has changed, remove route and update WireguardDaemon
-			fmt.Println("default interface has changed; behavior TODO")
-		}
+	// NOTE: host routes from a previous connection are replaced
+	err = d.replaceHostRoutes()
+	if err != nil {
+		log.Fatalf("failed to route server and control plane IPs through default interface: %v", err)
 	}
 
 	// flush routes on the rvpn wireguard interface
@@ -203,6 +224,12 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 	// enable routing by source ip for default interface
 	// NOTE: this is so reply traffic to defualt interface exits directly through default interface
 	// even if there is a new default route which the traffic should go through
+	// NOTE: source routing from a previous connection is replaced
+	err = d.stopSourceRouting()
+	if err != nil {
+		log.Printf("failed to stop previous source routing: %v", err)
+	}
+
 	err = d.enableSourceRouting(currDefaultIFace, currDefaultGateway)
 	if err != nil {
 		log.Fatalf("something went wrong when enabling source routing: %v", err)
@@ -241,8 +268,28 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 		log.Fatalf("failed to parse public key: %v", err)
 	}
 
+	psk, err := parsePresharedKey(wgConf.PresharedKey)
+	if err != nil {
+		log.Fatalf("failed to parse preshared key: %v", err)
+	}
+
+	// parse routable subnets which become the peer allowed IPs
+	subnets, err := parseSubnets(wgConf.Subnets)
+	if err != nil {
+		log.Fatalf("failed to parse routable subnets: %v", err)
+	}
+
+	_, tunnelNet, err := net.ParseCIDR(interfaceAddressPrefix)
+	if err != nil {
+		log.Fatalf("failed to parse tunnel network: %v", err)
+	}
+
+	d.serverPublicKey = pub
+	d.stagedServerPublicKey = wgtypes.Key{}
+	d.tunnelNet = *tunnelNet
+
 	// configure wireguard interface with peer information
-	port := 51720
+	port := d.deviceConf.ClientListenPort
 	ka := 20 * time.Second
 
 	conf := wgtypes.Config{
@@ -253,17 +300,14 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 			PublicKey:    pub,
 			Remove:       false,
 			UpdateOnly:   false,
-			PresharedKey: nil,
+			PresharedKey: psk,
 			Endpoint: &net.UDPAddr{
 				IP:   net.ParseIP(wgConf.ServerIp),
 				Port: wgConf.ServerPort,
 			},
 			PersistentKeepaliveInterval: &ka,
 			ReplaceAllowedIPs:           true,
-			AllowedIPs: []net.IPNet{{
-				IP:   net.ParseIP("0.0.0.0"),
-				Mask: net.IPv4Mask(0, 0, 0, 0),
-			}},
+			AllowedIPs:                  clientAllowedIPs(subnets, d.tunnelNet),
 		}},
 	}
 
@@ -276,47 +320,143 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 	}
 
 	// now that wireguard tunnel is fully up, we add the routes to the system to redirect traffic there
-
-	// set routes to be the de-duped peer allowed IPs (routable subnets)
-	// TODO: enable this to be overridden for client via cli flag
-	// TODO: this needs to actually be the de-duped peer allowed IPs
-
-	// we create a slightly more specific than default route
-	_, parsedPeerAllowedIP, err := net.ParseCIDR("0.0.0.0/1")
+	err = d.replaceRoutes(interfaceLink, subnetRoutes(subnets))
 	if err != nil {
-		log.Fatalf("failed to parse peer allowed IP into net.IPNet")
+		log.Fatalf("failed to add new route to rvpn wireguard interface: %v", err)
 	}
 
-	_, otherParsedPeerAllowedIP, err := net.ParseCIDR("128.0.0.0/1")
+	// resolve names through the DNS server of the target while connected
+	err = d.setDNS(wgConf.DnsIp, clientAllowedIPs(subnets, d.tunnelNet))
 	if err != nil {
-		log.Fatalf("failed to parse other peer allowed IP into net.IPNet")
+		log.Printf("failed to set DNS server of target: %v", err)
 	}
-	routes := []netlink.Route{{
-		LinkIndex: interfaceLink.Attrs().Index,
-		Dst:       parsedPeerAllowedIP,
-	}, {
-		LinkIndex: interfaceLink.Attrs().Index,
-		Dst:       otherParsedPeerAllowedIP,
-	}}
 
-	// add peer routes to the rvpn wireguard interface
-	for _, newRoute := range routes {
-		if err := netlink.RouteAdd(&newRoute); err != nil {
-			log.Fatalf("failed to add new route to rvpn wireguard interface: %v", err)
+	// forward traffic from other peers to the subnets advertised by this client
+	if d.netfilter.forwarding() {
+		err = d.disableForwarding()
+		if err != nil {
+			log.Printf("failed to disable previous forwarding: %v", err)
 		}
 	}
 
-	d.appendedRoutes = routes
+	if len(wgConf.AdvertisedSubnets) > 0 {
+		advertisedSubnets, err := parseSubnets(wgConf.AdvertisedSubnets)
+		if err != nil {
+			log.Fatalf("failed to parse advertised subnets: %v", err)
+		}
+
+		err = d.enableForwarding(advertisedSubnets, tunnelNet, true)
+		if err != nil {
+			log.Printf("failed to enable forwarding for advertised subnets: %v", err)
+		}
+	}
 
 	// tell the daemon that we are in client mode
 	d.vpnServerMode = false
 }
 
+// UpdateClientRoutes replaces the subnets routed through the rVPN server peer in client mode
+func (d *WireguardDaemon) UpdateClientRoutes(routableSubnets []string) error {
+	log.Println("updating routable subnets for rVPN server peer")
+
+	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get rvpn wireguard interface link: %w", err)
+	}
+
+	subnets, err := parseSubnets(routableSubnets)
+	if err != nil {
+		return err
+	}
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	conf := wgtypes.Config{
+		ReplacePeers: false,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         d.serverPublicKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        clientAllowedIPs(subnets, d.tunnelNet),
+		}},
+	}
+
+	if err := client.ConfigureDevice(d.InterfaceName, conf); err != nil {
+		return fmt.Errorf("failed to configure rVPN server peer: %w", err)
+	}
+
+	err = d.replaceRoutes(interfaceLink, subnetRoutes(subnets))
+	if err != nil {
+		return err
+	}
+
+	// the DNS server may have moved into or out of the routed subnets
+	if d.dnsIp != "" {
+		err = d.setDNS(d.dnsIp, clientAllowedIPs(subnets, d.tunnelNet))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaceRoutes replaces the routes appended to the rvpn wireguard interface with routes to the destinations, routes
+// which are kept are not deleted so their traffic is not interrupted
+func (d *WireguardDaemon) replaceRoutes(interfaceLink netlink.Link, routeDsts []net.IPNet) error {
+	keptDsts := map[string]bool{}
+	for _, routeDst := range routeDsts {
+		keptDsts[routeDst.String()] = true
+	}
+
+	for _, appendedRoute := range d.appendedRoutes {
+		if appendedRoute.LinkIndex == interfaceLink.Attrs().Index && keptDsts[appendedRoute.Dst.String()] {
+			// replaced in place by appendRoutes
+			continue
+		}
+
+		if err := netlink.RouteDel(&appendedRoute); err != nil {
+			log.Printf("warn: failed to delete appended route: %v", err)
+		}
+	}
+
+	d.appendedRoutes = []netlink.Route{}
+
+	return d.appendRoutes(interfaceLink, routeDsts)
+}
+
+// appendRoutes appends routes to the destinations on the rvpn wireguard interface
+func (d *WireguardDaemon) appendRoutes(interfaceLink netlink.Link, routeDsts []net.IPNet) error {
+	for _, routeDst := range routeDsts {
+		routeDst := routeDst
+		route := netlink.Route{
+			LinkIndex: interfaceLink.Attrs().Index,
+			Dst:       &routeDst,
+		}
+
+		if err := netlink.RouteReplace(&route); err != nil {
+			return err
+		}
+
+		d.appendedRoutes = append(d.appendedRoutes, route)
+	}
+
+	return nil
+}
+
 // UpdateServeConf updates the configuration of a WireguardDaemon with the provided config for rVPN VPN serving clients
 func (d *WireguardDaemon) UpdateServeConf(wgConf ServeWgConfig) {
 	log.Println("starting wireguard network interface configuration for serving")
 
-	interfaceLink, err := netlink.LinkByName("rvpn0")
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
+
+	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
 	if err != nil {
 		log.Fatalf("failed to get rvpn wireguard interface link: %v", err)
 	}
@@ -331,24 +471,21 @@ func (d *WireguardDaemon) UpdateServeConf(wgConf ServeWgConfig) {
 		log.Fatalf("failed to bring up device: %v", err)
 	}
 
-	// find default adapater
-	currDefaultIFace, _, err := findDefaultInterface()
+	// find default adapater, masqueraded traffic leaves through it
+	currDefaultIFace, currDefaultGateway, err := findDefaultInterface()
 	if err != nil {
 		log.Fatalf("failed to find default interface: %v", err)
 	}
 
 	if d.DefaultIFaceLink == nil {
 		log.Println("updating default interface...")
-		// default interface has not yet been defined
-		d.DefaultIFaceLink = currDefaultIFace
-	} else {
-		// default interface has already been set
-		if d.DefaultIFaceLink.Attrs().Name != currDefaultIFace.Attrs().Name {
-			// default interface has changed, remove route and update WireguardDaemon
-			fmt.Println("default interface has changed; behavior TODO")
-		}
+	} else if d.DefaultIFaceLink.Attrs().Name != currDefaultIFace.Attrs().Name {
+		log.Printf("default interface has changed to %s", currDefaultIFace.Attrs().Name)
 	}
 
+	d.DefaultIFaceLink = currDefaultIFace
+	d.defaultGateway = currDefaultGateway
+
 	// set ip addresses on the wireguard network interface
 	interfaceAddressPrefix := wgConf.InternalIp + wgConf.InternalCidr
 	assignInterfaceAddr(d.InterfaceName, interfaceAddressPrefix)
@@ -380,28 +517,20 @@ func (d *WireguardDaemon) UpdateServeConf(wgConf ServeWgConfig) {
 	// configure wireguard interface with peer information
 	port := wgConf.ListenPort
 	peers := []wgtypes.PeerConfig{}
+	peerRoutes := map[string][]net.IPNet{}
 
 	for _, clientPeer := range wgConf.Peers {
-		parsedPubkey, err := wgtypes.ParseKey(clientPeer.PublicKey)
+		wgPeer, routeDsts, err := serverPeerConfig(clientPeer)
 		if err != nil {
 			// log failure but continue
-			log.Printf("failed to parse peer pubkey: %v", err)
-		}
-
-		wgPeer := wgtypes.PeerConfig{
-			PublicKey:         parsedPubkey,
-			Remove:            false,
-			UpdateOnly:        false,
-			PresharedKey:      nil,
-			Endpoint:          nil,
-			ReplaceAllowedIPs: true,
-			AllowedIPs: []net.IPNet{{
-				IP:   net.ParseIP(clientPeer.AllowedIP),
-				Mask: net.IPv4Mask(255, 255, 255, 255), // TODO: actually use clientPeer.AllowedCidr
-			}},
+			log.Printf("failed to configure peer: %v", err)
+			continue
 		}
 
 		peers = append(peers, wgPeer)
+
+		// subnets routed through the peer need a route to the rvpn wireguard interface
+		peerRoutes[clientPeer.PublicKey] = routeDsts
 	}
 
 	conf := wgtypes.Config{
@@ -419,12 +548,44 @@ func (d *WireguardDaemon) UpdateServeConf(wgConf ServeWgConfig) {
 		}
 	}
 
-	// configure forwarding rules (via iptables for now but consider netfilter)
-	err = d.enableForwarding()
+	d.peerRoutes = peerRoutes
+	err = d.replaceRoutes(interfaceLink, d.peerSubnetRoutes())
+	if err != nil {
+		log.Printf("failed to add peer subnet routes: %v", err)
+	}
+
+	// configure forwarding rules
+	servedSubnets, err := parseSubnets(wgConf.Subnets)
+	if err != nil {
+		log.Fatalf("failed to parse served subnets: %v", err)
+	}
+
+	_, tunnelNet, err := net.ParseCIDR(interfaceAddressPrefix)
+	if err != nil {
+		log.Fatalf("failed to parse internal network: %v", err)
+	}
+
+	d.tunnelNet = *tunnelNet
+
+	// NOTE: forwarding rules from a previous serve are replaced
+	err = d.disableForwarding()
+	if err != nil {
+		log.Printf("failed to disable previous forwarding: %v", err)
+	}
+
+	err = d.enableForwarding(servedSubnets, tunnelNet, wgConf.Masquerade)
 	if err != nil {
 		log.Printf("failed to enable forwarding: %v", err)
 	}
 
+	// NOTE: bandwidth limits from a previous serve are replaced
+	d.rateLimits = nil
+	d.setPeerRateLimits(wgConf.Peers)
+	err = d.applyRateLimits()
+	if err != nil {
+		log.Printf("failed to apply rate limits: %v", err)
+	}
+
 	d.vpnServerMode = true
 }
 
@@ -432,6 +593,9 @@ func (d *WireguardDaemon) UpdateServeConf(wgConf ServeWgConfig) {
 func (d *WireguardDaemon) AppendPeers(toAppendPeers []WireGuardPeer) {
 	log.Printf("appending new peers to Wireguard Daemon")
 
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
+
 	// create wgctrl client to control wireguard device
 	client, err := wgctrl.New()
 	if err != nil {
@@ -441,28 +605,18 @@ func (d *WireguardDaemon) AppendPeers(toAppendPeers []WireGuardPeer) {
 
 	// configure wireguard interface with peer information
 	peers := []wgtypes.PeerConfig{}
+	peerRoutes := map[string][]net.IPNet{}
 
 	for _, clientPeer := range toAppendPeers {
-		parsedPubkey, err := wgtypes.ParseKey(clientPeer.PublicKey)
+		wgPeer, routeDsts, err := serverPeerConfig(clientPeer)
 		if err != nil {
 			// log failure but continue
-			log.Printf("failed to parse peer pubkey: %v", err)
-		}
-
-		wgPeer := wgtypes.PeerConfig{
-			PublicKey:         parsedPubkey,
-			Remove:            false,
-			UpdateOnly:        false,
-			PresharedKey:      nil,
-			Endpoint:          nil,
-			ReplaceAllowedIPs: false, // TODO: investigate more about this setting
-			AllowedIPs: []net.IPNet{{
-				IP:   net.ParseIP(clientPeer.AllowedIP),
-				Mask: net.IPv4Mask(255, 255, 255, 255), // TODO: actually use clientPeer.AllowedCidr
-			}},
+			log.Printf("failed to configure peer: %v", err)
+			continue
 		}
 
 		peers = append(peers, wgPeer)
+		peerRoutes[clientPeer.PublicKey] = routeDsts
 	}
 
 	conf := wgtypes.Config{
@@ -477,25 +631,100 @@ func (d *WireguardDaemon) AppendPeers(toAppendPeers []WireGuardPeer) {
 			log.Fatalf("Unknown config error: %v", err)
 		}
 	}
+
+	// subnets routed through the peers need a route to the rvpn wireguard interface
+	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
+	if err != nil {
+		log.Printf("failed to get rvpn wireguard interface link: %v", err)
+		return
+	}
+
+	// NOTE: the subnets of an appended peer replace its previous subnets, routes of revoked subnets are deleted
+	if d.peerRoutes == nil {
+		d.peerRoutes = map[string][]net.IPNet{}
+	}
+	for publicKey, routeDsts := range peerRoutes {
+		d.peerRoutes[publicKey] = routeDsts
+	}
+
+	err = d.replaceRoutes(interfaceLink, d.peerSubnetRoutes())
+	if err != nil {
+		log.Printf("failed to add peer subnet routes: %v", err)
+	}
+
+	// bandwidth limits of appended peers replace their previous limits
+	d.setPeerRateLimits(toAppendPeers)
+	err = d.applyRateLimits()
+	if err != nil {
+		log.Printf("failed to apply rate limits: %v", err)
+	}
+}
+
+// DeletePeerRoutes deletes the routes of the subnets routed through deleted peers in server mode, subnets which are
+// still routed through other peers keep their route
+func (d *WireguardDaemon) DeletePeerRoutes(peers []WireGuardPeer) error {
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
+
+	for _, peer := range peers {
+		delete(d.peerRoutes, peer.PublicKey)
+	}
+
+	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get rvpn wireguard interface link: %w", err)
+	}
+
+	return d.replaceRoutes(interfaceLink, d.peerSubnetRoutes())
+}
+
+// peerSubnetRoutes returns the destinations of the routes of the subnets routed through peers in server mode
+func (d *WireguardDaemon) peerSubnetRoutes() []net.IPNet {
+	routeDsts := []net.IPNet{}
+	seenDsts := map[string]bool{}
+	for _, peerRouteDsts := range d.peerRoutes {
+		for _, routeDst := range peerRouteDsts {
+			if !seenDsts[routeDst.String()] {
+				seenDsts[routeDst.String()] = true
+				routeDsts = append(routeDsts, routeDst)
+			}
+		}
+	}
+
+	return routeDsts
 }
 
 // TODO: function to remove peer from Wireguard configuration
 
 // Disconnect instructs the wireguard daemon to disconnect from current connection
 func (d *WireguardDaemon) Disconnect() {
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
+
 	err := d.Device.Down()
 	if err != nil {
 		log.Fatalf("failed to shut down device")
 	}
 
-	// if vpnServerMode is true, delete iptables rules for forwarding
-	if d.vpnServerMode {
+	// delete firewall rules from server mode
+	err = d.netfilter.disableFirewall()
+	if err != nil {
+		log.Printf("failed to disable firewall: %v", err)
+	}
+
+	// delete rules for forwarding from server mode or advertised subnets
+	if d.netfilter.forwarding() {
 		err = d.disableForwarding()
 		if err != nil {
 			log.Printf("failed to disable forwarding: %v", err)
 		}
 	}
 
+	// delete bandwidth limits from server mode
+	if d.rateLimits != nil {
+		d.resetRateLimits()
+	}
+
 	// cleanup any routing rules
 	for _, appendedRoute := range d.appendedRoutes {
 		if err := netlink.RouteDel(&appendedRoute); err != nil {
@@ -504,53 +733,51 @@ func (d *WireguardDaemon) Disconnect() {
 	}
 
 	d.appendedRoutes = []netlink.Route{}
+	d.peerRoutes = nil
 
 	// cleanup source routing rules and routes
 	d.stopSourceRouting()
+
+	// restore the resolver from before connecting
+	err = d.revertDNS()
+	if err != nil {
+		log.Printf("failed to revert DNS: %v", err)
+	}
+
+	// forget mesh peers, they are replaced on the next connect
+	d.mesh.reset()
 }
 
 // ShutdownDevice shuts down the wireguard device
 func (d *WireguardDaemon) ShutdownDevice() {
 	log.Println("shutting down rVPN daemon...")
 
-	// clean up listener and device
-	d.Uapi.Close()
-	d.Device.Close()
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
 
-	// clean up routes on default interface - remove server ip from default interface
-	// TODO: investigate if these should exit with fatal
-	// TODO(bug): only run if d.DefaultIFaceLink is not nil
-	_, parsedIPNet, err := net.ParseCIDR(d.ServerIP.String())
-	if err != nil {
-		log.Fatalf("failed to parse server IP into net.IPNet")
-	}
+	// stop following the default route before routes are cleaned up
+	close(d.netWatchDone)
 
-	route := netlink.Route{
-		LinkIndex: d.DefaultIFaceLink.Attrs().Index,
-		Dst:       parsedIPNet,
+	// delete bandwidth limits from server mode, the ifb interface outlives the wireguard interface
+	if d.rateLimits != nil {
+		d.resetRateLimits()
 	}
 
-	if err := netlink.RouteDel(&route); err != nil {
-		log.Fatalf("failed to delete server IP from default interface: %v", err)
-	}
+	// clean up listener and device
+	d.Uapi.Close()
+	d.Device.Close()
 
-	// remove control plane ip from default interface
-	_, parsedIPNet, err = net.ParseCIDR(d.ControlPlaneIP.String())
-	if err != nil {
-		log.Fatalf("failed to parse server IP into net.IPNet")
-	}
+	// clean up routes on default interface
+	d.deleteHostRoutes()
 
-	route = netlink.Route{
-		LinkIndex: d.DefaultIFaceLink.Attrs().Index,
-		Dst:       parsedIPNet,
-	}
-
-	if err := netlink.RouteDel(&route); err != nil {
-		log.Fatalf("failed to delete control plane IP from default interface: %v", err)
+	// delete firewall rules from server mode
+	err := d.netfilter.disableFirewall()
+	if err != nil {
+		log.Printf("failed to disable firewall: %v", err)
 	}
 
-	// if vpnServerMode is true, delete iptables rules for forwarding
-	if d.vpnServerMode {
+	// delete rules for forwarding from server mode or advertised subnets
+	if d.netfilter.forwarding() {
 		err = d.disableForwarding()
 		if err != nil {
 			log.Printf("failed to disable forwarding: %v", err)
@@ -566,4 +793,10 @@ func (d *WireguardDaemon) ShutdownDevice() {
 
 	// cleanup source routing rules and routes
 	d.stopSourceRouting()
+
+	// restore the resolver from before connecting
+	err = d.revertDNS()
+	if err != nil {
+		log.Printf("failed to revert DNS: %v", err)
+	}
 }
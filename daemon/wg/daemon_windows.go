@@ -31,12 +31,20 @@ type WireguardDaemon struct {
 	InterfaceName  string
 
 	// internal variables used for managing the daemon
-	prevRoutes []*winipcfg.RouteData
+	deviceConf            DeviceConfig // configuration the wireguard device is created with
+	prevRoutes            []*winipcfg.RouteData
+	serverPublicKey       wgtypes.Key // public key of the rVPN server peer
+	stagedServerPublicKey wgtypes.Key // rotated key of the rVPN server peer which has not been switched to yet
+	tunnelNet             net.IPNet   // network of the rvpn wireguard interface
+	mesh                  meshState   // peers connected to directly in mesh mode
+	bind                  *stunBind   // wireguard socket which is also used for endpoint discovery
+	relay                 *relayBind  // wireguard socket which relays packets over WebSocket if UDP is blocked
 }
 
-// NewWireguardDaemon returns a new WireguardDaemon NOTE: this is uninitialized
-func NewWireguardDaemon() *WireguardDaemon {
+// NewWireguardDaemon returns a new WireguardDaemon for the device config NOTE: this is uninitialized
+func NewWireguardDaemon(deviceConf DeviceConfig) *WireguardDaemon {
 	return &WireguardDaemon{
+		deviceConf: deviceConf,
 		prevRoutes: []*winipcfg.RouteData{},
 	}
 }
@@ -44,9 +52,9 @@ func NewWireguardDaemon() *WireguardDaemon {
 // StartDevice starts the wireguard networking interface used by rVPN
 func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	// use wireguard to create a new device
-	interfaceName := "rvpn0"
+	interfaceName := d.deviceConf.InterfaceName
 	family := winipcfg.AddressFamily(windows.AF_INET) // TODO: investigate how we differentiate between AF_INET and AF_INET6 for ipv4 vs ipv6
-	deviceMTU := 1420
+	deviceMTU := d.deviceConf.MTU
 
 	// open TUN device
 	tun, err := tun.CreateTUN(interfaceName, deviceMTU)
@@ -99,7 +107,9 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	)
 
 	// create wireguard device from TUN device
-	device := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	bind := newStunBind(conn.NewDefaultBind())
+	relay := newRelayBind(bind)
+	device := device.NewDevice(tun, relay, logger)
 	logger.Verbosef("created wireguard network interface")
 
 	// start wireguard interface now that routes and interface are set
@@ -132,6 +142,8 @@ func (d *WireguardDaemon) StartDevice(errs chan error) error {
 	logger.Verbosef("UAPI listener started")
 
 	d.Device = device
+	d.bind = bind
+	d.relay = relay
 	d.Uapi = uapi
 	d.InterfaceName = interfaceName
 
@@ -203,33 +215,32 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 		}
 	}
 
-	// set routes to be the de-duped peer allowed IPs # TODO: right now we just hardcode to all traffic
+	// parse routable subnets which become the peer allowed IPs
+	subnets, err := parseSubnets(wgConf.Subnets)
+	if err != nil {
+		log.Fatalf("failed to parse routable subnets: %v", err)
+	}
+
+	if len(wgConf.AdvertisedSubnets) > 0 {
+		// NOTE: forwarding to advertised subnets is only supported on Linux devices
+		log.Println("warn: advertising subnets is not supported on Windows, ignoring advertised subnets")
+	}
+
+	// set routes to be the routable subnets
 	interfaceIP := netip.MustParsePrefix(wgConf.ClientIp + wgConf.ClientCidr)
 	interfaceIPs := []netip.Prefix{interfaceIP}
-	peerAllowedIP := netip.MustParsePrefix("0.0.0.0/0") // TODO: this needs to actually be the de-duped peer allowed IPs
-	routes := []*winipcfg.RouteData{{
-		Destination: peerAllowedIP.Masked(),
-		NextHop:     netip.IPv4Unspecified(),
-		Metric:      0,
-	}}
 
-	// NOTE: LUID.FlushRoutes is broken, so we manually track previous routes and delete them
-	for _, prevRoute := range d.prevRoutes {
-		err = d.Adapter.LUID.DeleteRoute(prevRoute.Destination, prevRoute.NextHop)
-		if err != nil {
-			log.Fatalf("failed to delete route: %v", err)
-		}
+	_, tunnelNet, err := net.ParseCIDR(interfaceIP.String())
+	if err != nil {
+		log.Fatalf("failed to parse tunnel network: %v", err)
 	}
 
-	// add peer routes to the rvpn wireguard interface
-	for _, newRoute := range routes {
-		err = d.Adapter.LUID.AddRoute(newRoute.Destination, newRoute.NextHop, newRoute.Metric)
-		if err != nil {
-			log.Fatalf("failed to set routes on interface: %v", err)
-		}
-	}
+	d.tunnelNet = *tunnelNet
 
-	d.prevRoutes = routes
+	err = d.replaceRoutes(subnets)
+	if err != nil {
+		log.Fatalf("failed to set routes on interface: %v", err)
+	}
 
 	// set ip address of rvpn wireguard interface
 	err = d.Adapter.LUID.SetIPAddressesForFamily(family, interfaceIPs)
@@ -273,7 +284,15 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 		log.Fatalf("failed to parse public key: %v", err)
 	}
 
-	port := 51720
+	psk, err := parsePresharedKey(wgConf.PresharedKey)
+	if err != nil {
+		log.Fatalf("failed to parse preshared key: %v", err)
+	}
+
+	d.serverPublicKey = pub
+	d.stagedServerPublicKey = wgtypes.Key{}
+
+	port := d.deviceConf.ClientListenPort
 	ka := 20 * time.Second
 
 	conf := wgtypes.Config{
@@ -284,17 +303,14 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 			PublicKey:    pub,
 			Remove:       false,
 			UpdateOnly:   false,
-			PresharedKey: nil,
+			PresharedKey: psk,
 			Endpoint: &net.UDPAddr{
 				IP:   net.ParseIP(wgConf.ServerIp),
 				Port: wgConf.ServerPort,
 			},
 			PersistentKeepaliveInterval: &ka,
 			ReplaceAllowedIPs:           true,
-			AllowedIPs: []net.IPNet{{
-				IP:   net.ParseIP("0.0.0.0"),
-				Mask: net.IPv4Mask(0, 0, 0, 0),
-			}},
+			AllowedIPs:                  clientAllowedIPs(subnets, d.tunnelNet),
 		}},
 	}
 
@@ -307,6 +323,73 @@ func (d *WireguardDaemon) UpdateClientConf(wgConf ClientWgConfig, controlPlaneAd
 	}
 }
 
+// UpdateClientRoutes replaces the subnets routed through the rVPN server peer
+func (d *WireguardDaemon) UpdateClientRoutes(routableSubnets []string) error {
+	log.Println("updating routable subnets for rVPN server peer")
+
+	subnets, err := parseSubnets(routableSubnets)
+	if err != nil {
+		return err
+	}
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	conf := wgtypes.Config{
+		ReplacePeers: false,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         d.serverPublicKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        clientAllowedIPs(subnets, d.tunnelNet),
+		}},
+	}
+
+	if err := client.ConfigureDevice(d.InterfaceName, conf); err != nil {
+		return fmt.Errorf("failed to configure rVPN server peer: %w", err)
+	}
+
+	return d.replaceRoutes(subnets)
+}
+
+// replaceRoutes replaces the routes on the rvpn wireguard interface with routes to the subnets
+func (d *WireguardDaemon) replaceRoutes(subnets []net.IPNet) error {
+	routes := []*winipcfg.RouteData{}
+	for _, subnet := range subnets {
+		routes = append(routes, &winipcfg.RouteData{
+			Destination: netip.MustParsePrefix(subnet.String()).Masked(),
+			NextHop:     netip.IPv4Unspecified(),
+			Metric:      0,
+		})
+	}
+
+	// NOTE: LUID.FlushRoutes is broken, so we manually track previous routes and delete them
+	for _, prevRoute := range d.prevRoutes {
+		err := d.Adapter.LUID.DeleteRoute(prevRoute.Destination, prevRoute.NextHop)
+		if err != nil {
+			return fmt.Errorf("failed to delete route: %w", err)
+		}
+	}
+
+	d.prevRoutes = []*winipcfg.RouteData{}
+
+	// add peer routes to the rvpn wireguard interface
+	for _, newRoute := range routes {
+		err := d.Adapter.LUID.AddRoute(newRoute.Destination, newRoute.NextHop, newRoute.Metric)
+		if err != nil {
+			return err
+		}
+
+		d.prevRoutes = append(d.prevRoutes, newRoute)
+	}
+
+	return nil
+}
+
 // Disconnect instructs the wireguard daemon to disconnect from current connection
 func (d *WireguardDaemon) Disconnect() {
 	err := d.Device.Down()
@@ -323,6 +406,9 @@ func (d *WireguardDaemon) Disconnect() {
 	}
 
 	d.prevRoutes = []*winipcfg.RouteData{}
+
+	// forget mesh peers, they are replaced on the next connect
+	d.mesh.reset()
 }
 
 // ShutdownDevice shuts down the wireguard device
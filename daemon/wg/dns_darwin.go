@@ -0,0 +1,9 @@
+//go:build darwin
+
+package wg
+
+// DNSServer returns the DNS server of the target the system resolver points at, empty if none
+// NOTE: the DNS server of the target is not configured on macOS
+func (d *WireguardDaemon) DNSServer() string {
+	return ""
+}
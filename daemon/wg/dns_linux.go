@@ -0,0 +1,72 @@
+//go:build linux
+
+package wg
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+// dnsBackend points the system resolver at the DNS server of the target for the wireguard interface
+type dnsBackend interface {
+	setDNS(dnsServer net.IP, defaultRoute bool) error // NOTE: default route is whether the server answers all queries
+	revertDNS() error                                 // NOTE: reverting is a no-op if DNS was not set
+}
+
+// newDnsBackend picks the DNS backend, systemd-resolved is configured per link if it is running and otherwise
+// /etc/resolv.conf is managed directly
+func newDnsBackend(interfaceName string) (dnsBackend, error) {
+	if resolvedRunning() {
+		log.Println("using systemd-resolved DNS backend")
+		return newResolvedBackend(interfaceName)
+	}
+
+	log.Println("using resolv.conf DNS backend")
+	return newResolvConfBackend()
+}
+
+// setDNS resolves names through the DNS server of the target, an empty DNS ip reverts to the previous resolver, the
+// DNS server only answers all queries if it is reachable through the allowed IPs of the rVPN server peer
+func (d *WireguardDaemon) setDNS(dnsIp string, allowedIPs []net.IPNet) error {
+	if dnsIp == "" {
+		return d.revertDNS()
+	}
+
+	dnsServer := net.ParseIP(dnsIp)
+	if dnsServer == nil {
+		return fmt.Errorf("invalid DNS server ip %s", dnsIp)
+	}
+
+	d.dnsIp = dnsIp
+
+	err := d.dns.setDNS(dnsServer, routedIP(dnsServer, allowedIPs))
+	if err != nil {
+		d.dnsServer = nil
+		return err
+	}
+
+	d.dnsServer = dnsServer
+	return nil
+}
+
+// revertDNS reverts the resolver to the configuration from before the target's DNS server was set
+func (d *WireguardDaemon) revertDNS() error {
+	err := d.dns.revertDNS()
+	if err != nil {
+		return err
+	}
+
+	d.dnsIp = ""
+	d.dnsServer = nil
+	return nil
+}
+
+// DNSServer returns the DNS server of the target the system resolver points at, empty if none
+func (d *WireguardDaemon) DNSServer() string {
+	if d.dnsServer == nil {
+		return ""
+	}
+
+	return d.dnsServer.String()
+}
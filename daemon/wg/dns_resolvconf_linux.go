@@ -0,0 +1,91 @@
+//go:build linux
+
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+)
+
+const (
+	resolvConfPath       = "/etc/resolv.conf"
+	resolvConfBackupPath = "/etc/resolv.conf.rvpn-backup"
+)
+
+// resolvConfBackend manages /etc/resolv.conf directly, the original file is moved to a backup while DNS is set so
+// symlinks managed by other tools are restored as they were
+type resolvConfBackend struct{}
+
+// newResolvConfBackend returns a resolvConfBackend, a backup left behind by a previous run is restored
+func newResolvConfBackend() (*resolvConfBackend, error) {
+	b := &resolvConfBackend{}
+
+	err := b.revertDNS()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// setDNS replaces /etc/resolv.conf with a file which only lists the DNS server, resolv.conf applies to all queries
+// so the previous resolver is kept if the DNS server is not the default route
+func (b *resolvConfBackend) setDNS(dnsServer net.IP, defaultRoute bool) error {
+	if !defaultRoute {
+		err := b.revertDNS()
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("DNS server %s is not routed through the tunnel", dnsServer)
+	}
+
+	// NOTE: an existing backup is the original file, the current file was written by rVPN
+	_, err := os.Lstat(resolvConfBackupPath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(resolvConfPath, resolvConfBackupPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to back up %s: %w", resolvConfPath, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to check %s: %w", resolvConfBackupPath, err)
+	}
+
+	resolvConf := fmt.Sprintf("# generated by rVPN, the original file is restored on disconnect\nnameserver %s\n", dnsServer)
+
+	// write to a temporary file first so resolv.conf is never partially written
+	tmpPath := resolvConfPath + ".rvpn-tmp"
+	err = os.WriteFile(tmpPath, []byte(resolvConf), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+
+	err = os.Rename(tmpPath, resolvConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to replace %s: %w", resolvConfPath, err)
+	}
+
+	return nil
+}
+
+// revertDNS restores /etc/resolv.conf from the backup
+func (b *resolvConfBackend) revertDNS() error {
+	_, err := os.Lstat(resolvConfBackupPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check %s: %w", resolvConfBackupPath, err)
+	}
+
+	err = os.Rename(resolvConfBackupPath, resolvConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", resolvConfPath, err)
+	}
+
+	log.Printf("restored %s", resolvConfPath)
+
+	return nil
+}
@@ -0,0 +1,144 @@
+//go:build linux
+
+package wg
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	resolvedBusName    = "org.freedesktop.resolve1"
+	resolvedObjectPath = "/org/freedesktop/resolve1"
+	resolvedManager    = "org.freedesktop.resolve1.Manager"
+)
+
+// resolvedLinkDNS is a DNS server of a link in the systemd-resolved D-Bus API, (iay)
+type resolvedLinkDNS struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedLinkDomain is a search or routing domain of a link in the systemd-resolved D-Bus API, (sb)
+type resolvedLinkDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// resolvedBackend configures DNS of the wireguard interface through the D-Bus API of systemd-resolved, the
+// configuration of other links is left untouched
+// NOTE: systemd-resolved drops the configuration of the link once the interface is removed
+type resolvedBackend struct {
+	interfaceName string
+	bus           *dbus.Conn
+	configured    bool
+}
+
+// resolvedRunning returns whether systemd-resolved is reachable on the system bus
+func resolvedRunning() bool {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return false
+	}
+
+	var hasOwner bool
+	err = bus.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, resolvedBusName).Store(&hasOwner)
+	return err == nil && hasOwner
+}
+
+// newResolvedBackend returns a resolvedBackend for the wireguard interface
+func newResolvedBackend(interfaceName string) (*resolvedBackend, error) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+
+	return &resolvedBackend{
+		interfaceName: interfaceName,
+		bus:           bus,
+	}, nil
+}
+
+// setDNS sets the DNS server of the wireguard interface, all queries are routed to it if it is the default route
+func (b *resolvedBackend) setDNS(dnsServer net.IP, defaultRoute bool) error {
+	ifindex, err := b.ifindex()
+	if err != nil {
+		return err
+	}
+
+	linkDNS := resolvedLinkDNS{
+		Family:  unix.AF_INET,
+		Address: dnsServer.To4(),
+	}
+	if linkDNS.Address == nil {
+		linkDNS.Family = unix.AF_INET6
+		linkDNS.Address = dnsServer.To16()
+	}
+
+	resolved := b.bus.Object(resolvedBusName, resolvedObjectPath)
+	err = resolved.Call(resolvedManager+".SetLinkDNS", 0, ifindex, []resolvedLinkDNS{linkDNS}).Err
+	if err != nil {
+		return fmt.Errorf("failed to set DNS server of link: %w", err)
+	}
+
+	b.configured = true
+
+	// the root routing domain sends queries for every domain to the link instead of the ISP resolver, a DNS server
+	// outside the routed subnets is unreachable through the link so it must not take over the default route
+	linkDomains := []resolvedLinkDomain{}
+	if defaultRoute {
+		linkDomains = append(linkDomains, resolvedLinkDomain{
+			Domain:      ".",
+			RoutingOnly: true,
+		})
+	}
+
+	err = resolved.Call(resolvedManager+".SetLinkDomains", 0, ifindex, linkDomains).Err
+	if err != nil {
+		return fmt.Errorf("failed to set routing domain of link: %w", err)
+	}
+
+	// NOTE: a link with a DNS server and no routing domains is used as default route unless disabled explicitly
+	err = resolved.Call(resolvedManager+".SetLinkDefaultRoute", 0, ifindex, defaultRoute).Err
+	if err != nil {
+		return fmt.Errorf("failed to set default route of link: %w", err)
+	}
+
+	return nil
+}
+
+// revertDNS drops the DNS configuration of the wireguard interface
+func (b *resolvedBackend) revertDNS() error {
+	if !b.configured {
+		return nil
+	}
+
+	ifindex, err := b.ifindex()
+	if err != nil {
+		return err
+	}
+
+	resolved := b.bus.Object(resolvedBusName, resolvedObjectPath)
+	err = resolved.Call(resolvedManager+".RevertLink", 0, ifindex).Err
+	if err != nil {
+		return fmt.Errorf("failed to revert DNS configuration of link: %w", err)
+	}
+
+	b.configured = false
+
+	return nil
+}
+
+// ifindex returns the interface index of the wireguard interface
+func (b *resolvedBackend) ifindex() (int32, error) {
+	interfaceLink, err := netlink.LinkByName(b.interfaceName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rvpn wireguard interface link: %w", err)
+	}
+
+	return int32(interfaceLink.Attrs().Index), nil
+}
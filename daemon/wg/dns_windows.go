@@ -0,0 +1,9 @@
+//go:build windows
+
+package wg
+
+// DNSServer returns the DNS server of the target the system resolver points at, empty if none
+// NOTE: the DNS server of the target is not configured on Windows
+func (d *WireguardDaemon) DNSServer() string {
+	return ""
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package wg
+
+import (
+	"log"
+)
+
+// firewallRuleMatch is a single source, destination and port combination matched by a firewall rule, empty
+// values match any
+type firewallRuleMatch struct {
+	source      string
+	destination string
+	port        string
+}
+
+// UpdateFirewall enforces firewall rules on traffic forwarded from the rvpn wireguard interface in server mode, if
+// enabled only traffic which matches a rule is forwarded
+func (d *WireguardDaemon) UpdateFirewall(enabled bool, rules []FirewallRule) error {
+	if !enabled {
+		log.Println("disabling firewall")
+		return d.netfilter.disableFirewall()
+	}
+
+	log.Printf("enforcing %d firewall rules", len(rules))
+	return d.netfilter.updateFirewall(rules)
+}
+
+// firewallRuleMatches expands a firewall rule into every source, destination and port combination it matches
+func firewallRuleMatches(rule FirewallRule) []firewallRuleMatch {
+	// empty lists match any
+	sources := rule.Sources
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+
+	destinations := rule.Destinations
+	if len(destinations) == 0 {
+		destinations = []string{""}
+	}
+
+	ports := rule.Ports
+	if len(ports) == 0 {
+		ports = []string{""}
+	}
+
+	matches := []firewallRuleMatch{}
+	for _, source := range sources {
+		for _, destination := range destinations {
+			for _, port := range ports {
+				matches = append(matches, firewallRuleMatch{
+					source:      source,
+					destination: destination,
+					port:        port,
+				})
+			}
+		}
+	}
+
+	return matches
+}
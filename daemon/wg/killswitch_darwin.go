@@ -0,0 +1,17 @@
+//go:build darwin
+
+package wg
+
+import "errors"
+
+// EnableKillSwitch blocks all outgoing traffic except traffic through the tunnel
+// NOTE: the kill switch is not supported on macOS, this is just a stub
+func (d *WireguardDaemon) EnableKillSwitch() error {
+	return errors.New("kill switch is not supported on macOS")
+}
+
+// DisableKillSwitch removes the kill switch
+// NOTE: the kill switch is not supported on macOS, this is just a stub
+func (d *WireguardDaemon) DisableKillSwitch() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package wg
+
+import (
+	"fmt"
+	"net"
+)
+
+// killSwitchDhcpPort and killSwitchDhcpv6Port are the DHCP and DHCPv6 server ports, DHCP is allowed so the physical
+// interface keeps its lease
+const (
+	killSwitchDhcpPort   = 67
+	killSwitchDhcpv6Port = 547
+)
+
+// killSwitchNdpTypes are the ICMPv6 types of router and neighbor discovery, without them the IPv6 next hop of the
+// wireguard socket cannot be resolved
+var killSwitchNdpTypes = []uint8{133, 135, 136}
+
+// killSwitchConf is the traffic the kill switch allows besides traffic on the loopback and wireguard interfaces
+// NOTE: STUN discovery, hole punching and the traffic to the rVPN server and mesh peers are all sent by the stunBind on
+// the wireguard socket, which listens on ClientListenPort for both IPv4 and IPv6, so allowing the source port of the
+// wireguard socket allows them without opening any other port
+type killSwitchConf struct {
+	wireguardPort uint16      // source port of the wireguard socket, ClientListenPort
+	allowedHosts  []net.IPNet // hosts reachable outside of the tunnel, i.e the control plane
+}
+
+// EnableKillSwitch blocks all outgoing traffic except traffic through the tunnel, traffic of the wireguard socket
+// and traffic to the control plane so nothing leaks if the tunnel drops
+// NOTE: the kill switch stays enabled if the daemon stops until DisableKillSwitch is called
+func (d *WireguardDaemon) EnableKillSwitch() error {
+	if !d.ControlPlaneIP.IsValid() {
+		return fmt.Errorf("control plane ip is not known")
+	}
+
+	_, controlPlaneNet, err := net.ParseCIDR(d.ControlPlaneIP.String())
+	if err != nil {
+		return fmt.Errorf("failed to parse control plane IP: %w", err)
+	}
+
+	return d.netfilter.enableKillSwitch(killSwitchConf{
+		wireguardPort: uint16(d.deviceConf.ClientListenPort),
+		allowedHosts:  []net.IPNet{*controlPlaneNet},
+	})
+}
+
+// DisableKillSwitch removes the kill switch, including a kill switch left behind by a previous run of the daemon
+func (d *WireguardDaemon) DisableKillSwitch() error {
+	return d.netfilter.disableKillSwitch()
+}
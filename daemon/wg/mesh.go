@@ -0,0 +1,221 @@
+package wg
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	meshMonitorInterval  = 5 * time.Second
+	meshHandshakeTimeout = 3 * time.Minute // wireguard rejects sessions older than this
+)
+
+// meshPeer is another client which is connected to directly in mesh mode
+type meshPeer struct {
+	peer       WireGuardPeer
+	allowedIPs []net.IPNet
+	direct     bool // whether traffic to the peer is sent directly instead of through the rVPN server
+}
+
+// meshState holds the mesh peers of a wireguard daemon in client mode
+type meshState struct {
+	lock    sync.Mutex
+	peers   map[wgtypes.Key]*meshPeer
+	running bool // whether the mesh monitor is running
+}
+
+// updateMeshPeers replaces the mesh peers configured on the wireguard interface
+// NOTE: mesh peers start without allowed IPs so traffic falls back to the rVPN server until a direct
+// handshake succeeds, the mesh monitor then routes the peer tunnel ip directly
+func (m *meshState) updateMeshPeers(interfaceName string, toUpdatePeers []WireGuardPeer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.peers == nil {
+		m.peers = make(map[wgtypes.Key]*meshPeer)
+	}
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	ka := 20 * time.Second
+	peerConfigs := []wgtypes.PeerConfig{}
+	updatedPeers := make(map[wgtypes.Key]*meshPeer)
+
+	for _, toUpdatePeer := range toUpdatePeers {
+		parsedPubkey, err := wgtypes.ParseKey(toUpdatePeer.PublicKey)
+		if err != nil {
+			// log failure but continue
+			log.Printf("failed to parse mesh peer pubkey: %v", err)
+			continue
+		}
+
+		allowedIPs, err := peerAllowedIPs(WireGuardPeer{
+			AllowedIP:   toUpdatePeer.AllowedIP,
+			AllowedCidr: toUpdatePeer.AllowedCidr,
+		})
+		if err != nil {
+			log.Printf("failed to parse mesh peer allowed ips: %v", err)
+			continue
+		}
+
+		endpoint, err := net.ResolveUDPAddr("udp", toUpdatePeer.Endpoint)
+		if err != nil {
+			log.Printf("failed to parse mesh peer endpoint: %v", err)
+			continue
+		}
+
+		updatedPeer := &meshPeer{
+			peer:       toUpdatePeer,
+			allowedIPs: allowedIPs,
+		}
+
+		// keep routing directly to peers which are already direct and have not moved
+		if existingPeer, exists := m.peers[parsedPubkey]; exists && existingPeer.peer.Endpoint == toUpdatePeer.Endpoint {
+			updatedPeer.direct = existingPeer.direct
+		}
+
+		peerAllowedIPs := []net.IPNet{}
+		if updatedPeer.direct {
+			peerAllowedIPs = allowedIPs
+		}
+
+		peerConfigs = append(peerConfigs, wgtypes.PeerConfig{
+			PublicKey:                   parsedPubkey,
+			Endpoint:                    endpoint,
+			PersistentKeepaliveInterval: &ka,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  peerAllowedIPs,
+		})
+
+		updatedPeers[parsedPubkey] = updatedPeer
+	}
+
+	// remove mesh peers which are no longer part of the mesh
+	for pubkey := range m.peers {
+		if _, exists := updatedPeers[pubkey]; !exists {
+			peerConfigs = append(peerConfigs, wgtypes.PeerConfig{
+				PublicKey: pubkey,
+				Remove:    true,
+			})
+		}
+	}
+
+	conf := wgtypes.Config{
+		ReplacePeers: false,
+		Peers:        peerConfigs,
+	}
+
+	if err := client.ConfigureDevice(interfaceName, conf); err != nil {
+		return fmt.Errorf("failed to configure mesh peers: %w", err)
+	}
+
+	m.peers = updatedPeers
+
+	if len(m.peers) > 0 && !m.running {
+		m.running = true
+		go m.monitor(interfaceName)
+	}
+
+	return nil
+}
+
+// reset forgets all mesh peers, the wireguard peers themselves are removed when the interface is reconfigured
+func (m *meshState) reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.peers = make(map[wgtypes.Key]*meshPeer)
+}
+
+// monitor routes traffic directly to mesh peers with a recent handshake and through the rVPN server otherwise
+func (m *meshState) monitor(interfaceName string) {
+	ticker := time.NewTicker(meshMonitorInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !m.checkMeshPeers(interfaceName) {
+			return
+		}
+	}
+}
+
+// checkMeshPeers updates the allowed IPs of mesh peers by handshake, returns false once there are no mesh peers
+func (m *meshState) checkMeshPeers(interfaceName string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.peers) == 0 {
+		m.running = false
+		return false
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		log.Printf("failed to open client: %v", err)
+		return true
+	}
+	defer client.Close()
+
+	device, err := client.Device(interfaceName)
+	if err != nil {
+		log.Printf("failed to get wireguard device: %v", err)
+		return true
+	}
+
+	peerConfigs := []wgtypes.PeerConfig{}
+	for _, devicePeer := range device.Peers {
+		meshPeer, exists := m.peers[devicePeer.PublicKey]
+		if !exists {
+			continue
+		}
+
+		direct := time.Since(devicePeer.LastHandshakeTime) < meshHandshakeTimeout
+		if direct == meshPeer.direct {
+			continue
+		}
+
+		peerAllowedIPs := []net.IPNet{}
+		if direct {
+			log.Printf("mesh peer %s is reachable directly", meshPeer.peer.AllowedIP)
+			peerAllowedIPs = meshPeer.allowedIPs
+		} else {
+			log.Printf("mesh peer %s is not reachable directly, falling back to rVPN server", meshPeer.peer.AllowedIP)
+		}
+
+		peerConfigs = append(peerConfigs, wgtypes.PeerConfig{
+			PublicKey:         devicePeer.PublicKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        peerAllowedIPs,
+		})
+
+		meshPeer.direct = direct
+	}
+
+	if len(peerConfigs) > 0 {
+		err = client.ConfigureDevice(interfaceName, wgtypes.Config{Peers: peerConfigs})
+		if err != nil {
+			log.Printf("failed to update mesh peers: %v", err)
+		}
+	}
+
+	return true
+}
+
+// UpdateMeshPeers replaces the peers connected to directly in mesh mode
+func (d *WireguardDaemon) UpdateMeshPeers(peers []WireGuardPeer) error {
+	log.Printf("updating %d mesh peers", len(peers))
+
+	return d.mesh.updateMeshPeers(d.InterfaceName, peers)
+}
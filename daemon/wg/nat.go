@@ -0,0 +1,62 @@
+package wg
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+)
+
+// DiscoverEndpoint returns the endpoint candidates of the wireguard socket, the public mapping learned from the
+// STUN server is first followed by the local addresses of the device
+func (d *WireguardDaemon) DiscoverEndpoint(stunServer string) ([]string, error) {
+	candidates := []string{}
+
+	mappedAddr, err := d.bind.discoverEndpoint(stunServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover public endpoint: %w", err)
+	}
+
+	log.Printf("discovered public endpoint %s", mappedAddr)
+	candidates = append(candidates, mappedAddr.String())
+
+	// local addresses allow peers on the same network to connect directly
+	port := strconv.Itoa(int(d.bind.localPort()))
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("failed to list interfaces for local endpoint candidates: %v", err)
+		return candidates, nil
+	}
+
+	for _, iface := range ifaces {
+		if iface.Name == d.InterfaceName || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+
+			candidate := net.JoinHostPort(ipNet.IP.String(), port)
+			if candidate != mappedAddr.String() {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// PunchHole opens the NAT in front of the device for a peer endpoint so the peer can complete a handshake
+func (d *WireguardDaemon) PunchHole(endpoint string) error {
+	log.Printf("punching hole for peer endpoint %s", endpoint)
+
+	return d.bind.punchHole(endpoint)
+}
@@ -0,0 +1,459 @@
+//go:build linux
+
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// iptablesFirewallChainPrefix is the prefix of the dedicated iptables chains the firewall rules are enforced in, the
+// rules are built in a new chain which replaces the active chain so no traffic slips through during an update
+const iptablesFirewallChainPrefix = "RVPN-POLICY"
+
+// iptablesKillSwitchChainPrefix is the prefix of the iptables chains of the kill switch, like the firewall the kill
+// switch is built in a new chain which replaces the active chain, the names are kept across restarts of the daemon
+// so a kill switch left behind by a previous run can be removed
+const iptablesKillSwitchChainPrefix = "RVPN-KILLSWITCH"
+
+// iptablesKillSwitchChains are the chains the kill switch alternates between
+var iptablesKillSwitchChains = []string{iptablesKillSwitchChainPrefix + "-A", iptablesKillSwitchChainPrefix + "-B"}
+
+// iptablesRule is an iptables rule which was appended by the daemon
+type iptablesRule struct {
+	table    string
+	chain    string
+	rulespec []string
+}
+
+// iptablesBackend configures netfilter through iptables by appending rules to the global chains
+type iptablesBackend struct {
+	interfaceName    string
+	appendedFwdRules []iptablesRule // iptables rules for forwarding in server mode or for advertised subnets
+	firewallChain    string         // active iptables chain enforcing firewall rules, empty if disabled
+}
+
+// newIptablesBackend returns an iptablesBackend for the wireguard interface, the firewall chains left behind by a
+// previous run are removed
+func newIptablesBackend(interfaceName string) (*iptablesBackend, error) {
+	b := &iptablesBackend{
+		interfaceName: interfaceName,
+	}
+
+	err := b.deleteStaleFirewallChains()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// enableForwarding appends iptables rules which forward traffic from the wireguard interface to the subnets
+func (b *iptablesBackend) enableForwarding(subnets []net.IPNet, tunnelNet *net.IPNet, defaultIface string, masquerade bool) error {
+	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to create iptables interface: %v", err)
+		return errors.New(errMsg)
+	}
+
+	forwardRules := iptablesForwardingRules(b.interfaceName, subnets, tunnelNet, defaultIface, masquerade)
+
+	for _, forwardRule := range forwardRules {
+		err = iptableMan.AppendUnique(forwardRule.table, forwardRule.chain, forwardRule.rulespec...)
+		if err != nil {
+			return fmt.Errorf("iptables failed to append forwarding rule %v: %w", forwardRule.rulespec, err)
+		}
+
+		b.appendedFwdRules = append(b.appendedFwdRules, forwardRule)
+	}
+
+	return nil
+}
+
+// iptablesForwardingRules returns the iptables rules which forward traffic from the wireguard interface to the subnets
+func iptablesForwardingRules(interfaceName string, subnets []net.IPNet, tunnelNet *net.IPNet, defaultIface string, masquerade bool) []iptablesRule {
+	forwardRules := []iptablesRule{}
+	forwardAll := false
+	for _, subnet := range subnets {
+		if ones, _ := subnet.Mask.Size(); ones == 0 {
+			forwardAll = true
+
+			// accept and forward all traffic from rvpn wireguard interface
+			forwardRules = append(forwardRules, iptablesRule{
+				table:    "filter",
+				chain:    "FORWARD",
+				rulespec: []string{"-i", interfaceName, "-j", "ACCEPT"},
+			})
+
+			if masquerade {
+				// enable masquerading on default interface output
+				forwardRules = append(forwardRules, iptablesRule{
+					table:    "nat",
+					chain:    "POSTROUTING",
+					rulespec: []string{"-o", defaultIface, "-j", "MASQUERADE"},
+				})
+			}
+
+			continue
+		}
+
+		// accept and forward traffic from rvpn wireguard interface to the served subnet, including replies
+		forwardRules = append(forwardRules, iptablesRule{
+			table:    "filter",
+			chain:    "FORWARD",
+			rulespec: []string{"-i", interfaceName, "-d", subnet.String(), "-j", "ACCEPT"},
+		}, iptablesRule{
+			table:    "filter",
+			chain:    "FORWARD",
+			rulespec: []string{"-o", interfaceName, "-s", subnet.String(), "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		})
+
+		if masquerade {
+			// enable masquerading of client traffic into the served subnet
+			forwardRules = append(forwardRules, iptablesRule{
+				table:    "nat",
+				chain:    "POSTROUTING",
+				rulespec: []string{"-s", tunnelNet.String(), "-d", subnet.String(), "-j", "MASQUERADE"},
+			})
+		}
+	}
+
+	if !forwardAll {
+		// accept and forward traffic between peers so subnets routed through peers are reachable
+		forwardRules = append(forwardRules, iptablesRule{
+			table:    "filter",
+			chain:    "FORWARD",
+			rulespec: []string{"-i", interfaceName, "-o", interfaceName, "-j", "ACCEPT"},
+		})
+	}
+
+	return forwardRules
+}
+
+// disableForwarding deletes the iptables rules appended when forwarding was enabled
+func (b *iptablesBackend) disableForwarding() error {
+	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to create iptables interface: %v", err)
+		return errors.New(errMsg)
+	}
+
+	// delete all rules appended when forwarding was enabled
+	for _, forwardRule := range b.appendedFwdRules {
+		err = iptableMan.DeleteIfExists(forwardRule.table, forwardRule.chain, forwardRule.rulespec...)
+		if err != nil {
+			return fmt.Errorf("iptables failed to delete forwarding rule %v: %w", forwardRule.rulespec, err)
+		}
+	}
+
+	b.appendedFwdRules = []iptablesRule{}
+
+	return nil
+}
+
+// forwarding returns whether forwarding rules are appended
+func (b *iptablesBackend) forwarding() bool {
+	return len(b.appendedFwdRules) > 0
+}
+
+// updateFirewall builds the firewall rules in a dedicated chain which replaces the active firewall chain
+func (b *iptablesBackend) updateFirewall(rules []FirewallRule) error {
+	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		return fmt.Errorf("failed to create iptables interface: %w", err)
+	}
+
+	prevChain := b.firewallChain
+	chain := iptablesFirewallChainPrefix + "-A"
+	if prevChain == chain {
+		chain = iptablesFirewallChainPrefix + "-B"
+	}
+
+	// creates the chain or flushes rules left over from a previous run
+	err = iptableMan.ClearChain("filter", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to create firewall chain: %w", err)
+	}
+
+	// replies to allowed traffic are always allowed, RETURN continues with the forwarding rules
+	rulespecs := [][]string{{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"}}
+	for _, rule := range rules {
+		rulespecs = append(rulespecs, iptablesFirewallRulespecs(rule)...)
+	}
+	rulespecs = append(rulespecs, []string{"-j", "DROP"})
+
+	for _, rulespec := range rulespecs {
+		err = iptableMan.Append("filter", chain, rulespec...)
+		if err != nil {
+			iptableMan.ClearAndDeleteChain("filter", chain)
+			return fmt.Errorf("iptables failed to append firewall rule %v: %w", rulespec, err)
+		}
+	}
+
+	// the firewall chain must be evaluated before the forwarding rules accept traffic
+	err = iptableMan.Insert("filter", "FORWARD", 1, "-i", b.interfaceName, "-j", chain)
+	if err != nil {
+		iptableMan.ClearAndDeleteChain("filter", chain)
+		return fmt.Errorf("iptables failed to insert firewall chain: %w", err)
+	}
+
+	b.firewallChain = chain
+
+	if prevChain != "" {
+		err = b.deleteFirewallChain(iptableMan, prevChain)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disableFirewall removes the firewall chain so all traffic is forwarded again
+func (b *iptablesBackend) disableFirewall() error {
+	if b.firewallChain == "" {
+		return nil
+	}
+
+	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		return fmt.Errorf("failed to create iptables interface: %w", err)
+	}
+
+	err = b.deleteFirewallChain(iptableMan, b.firewallChain)
+	if err != nil {
+		return err
+	}
+
+	b.firewallChain = ""
+
+	return nil
+}
+
+// deleteFirewallChain removes the jump to a firewall chain and deletes the chain
+func (b *iptablesBackend) deleteFirewallChain(iptableMan *iptables.IPTables, chain string) error {
+	err := iptableMan.DeleteIfExists("filter", "FORWARD", "-i", b.interfaceName, "-j", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to delete firewall chain jump: %w", err)
+	}
+
+	err = iptableMan.ClearAndDeleteChain("filter", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to delete firewall chain: %w", err)
+	}
+
+	return nil
+}
+
+// deleteStaleFirewallChains removes every jump to the firewall chains and the chains themselves, a daemon which
+// crashed leaves them behind and its jumps may match an interface name which is no longer configured
+func (b *iptablesBackend) deleteStaleFirewallChains() error {
+	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		return fmt.Errorf("failed to create iptables interface: %w", err)
+	}
+
+	forwardRules, err := iptableMan.List("filter", "FORWARD")
+	if err != nil {
+		return fmt.Errorf("iptables failed to list forwarding rules: %w", err)
+	}
+
+	for _, chain := range []string{iptablesFirewallChainPrefix + "-A", iptablesFirewallChainPrefix + "-B"} {
+		for _, forwardRule := range forwardRules {
+			// rules are listed as "-A FORWARD <rulespec>"
+			rulespec := strings.Fields(forwardRule)
+			if len(rulespec) < 4 || rulespec[0] != "-A" || rulespec[len(rulespec)-2] != "-j" || rulespec[len(rulespec)-1] != chain {
+				continue
+			}
+
+			err = iptableMan.DeleteIfExists("filter", "FORWARD", rulespec[2:]...)
+			if err != nil {
+				return fmt.Errorf("iptables failed to delete stale firewall chain jump: %w", err)
+			}
+		}
+
+		chainExists, err := iptableMan.ChainExists("filter", chain)
+		if err != nil {
+			return fmt.Errorf("iptables failed to check stale firewall chain: %w", err)
+		}
+
+		if !chainExists {
+			continue
+		}
+
+		err = iptableMan.ClearAndDeleteChain("filter", chain)
+		if err != nil {
+			return fmt.Errorf("iptables failed to delete stale firewall chain: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// enableKillSwitch replaces the kill switch chains of iptables and ip6tables, outgoing traffic which is not allowed
+// by the kill switch is dropped
+func (b *iptablesBackend) enableKillSwitch(conf killSwitchConf) error {
+	allowRulespecs := [][]string{
+		{"-o", "lo", "-j", "ACCEPT"},
+		{"-o", b.interfaceName, "-j", "ACCEPT"},
+		{"-p", "udp", "--sport", strconv.Itoa(int(conf.wireguardPort)), "-j", "ACCEPT"},
+		{"-p", "udp", "--dport", strconv.Itoa(killSwitchDhcpPort), "-j", "ACCEPT"},
+	}
+
+	for _, allowedHost := range conf.allowedHosts {
+		allowRulespecs = append(allowRulespecs, []string{"-d", allowedHost.String(), "-j", "ACCEPT"})
+	}
+
+	err := b.replaceKillSwitchChain(iptables.ProtocolIPv4, allowRulespecs)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the allowed hosts are IPv4, the wireguard socket also sends to IPv6 endpoints of the server and mesh peers
+	allowRulespecs = [][]string{
+		allowRulespecs[0],
+		allowRulespecs[1],
+		allowRulespecs[2],
+		{"-p", "udp", "--dport", strconv.Itoa(killSwitchDhcpv6Port), "-j", "ACCEPT"},
+	}
+	for _, ndpType := range killSwitchNdpTypes {
+		allowRulespecs = append(allowRulespecs,
+			[]string{"-p", "ipv6-icmp", "--icmpv6-type", strconv.Itoa(int(ndpType)), "-j", "ACCEPT"})
+	}
+
+	return b.replaceKillSwitchChain(iptables.ProtocolIPv6, allowRulespecs)
+}
+
+// replaceKillSwitchChain builds the allow rules followed by a drop in a new kill switch chain, jumps to it from the
+// OUTPUT chain and deletes the previous kill switch chain, so traffic is never let through during an update
+func (b *iptablesBackend) replaceKillSwitchChain(proto iptables.Protocol, allowRulespecs [][]string) error {
+	iptableMan, err := iptables.NewWithProtocol(proto)
+	if err != nil {
+		return fmt.Errorf("failed to create iptables interface: %w", err)
+	}
+
+	// NOTE: the active chain is looked up instead of remembered as it may have been added by a previous run
+	prevChain := ""
+	for _, chain := range iptablesKillSwitchChains {
+		jumpExists, err := iptableMan.Exists("filter", "OUTPUT", "-j", chain)
+		if err != nil {
+			return fmt.Errorf("iptables failed to check kill switch chain jump: %w", err)
+		}
+
+		if jumpExists {
+			prevChain = chain
+			break
+		}
+	}
+
+	chain := iptablesKillSwitchChains[0]
+	if prevChain == chain {
+		chain = iptablesKillSwitchChains[1]
+	}
+
+	// creates the chain or flushes rules left over from a previous run
+	err = iptableMan.ClearChain("filter", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to create kill switch chain: %w", err)
+	}
+
+	rulespecs := append(append([][]string{}, allowRulespecs...), []string{"-j", "DROP"})
+	for _, rulespec := range rulespecs {
+		err = iptableMan.Append("filter", chain, rulespec...)
+		if err != nil {
+			iptableMan.ClearAndDeleteChain("filter", chain)
+			return fmt.Errorf("iptables failed to append kill switch rule %v: %w", rulespec, err)
+		}
+	}
+
+	err = iptableMan.Insert("filter", "OUTPUT", 1, "-j", chain)
+	if err != nil {
+		iptableMan.ClearAndDeleteChain("filter", chain)
+		return fmt.Errorf("iptables failed to insert kill switch chain jump: %w", err)
+	}
+
+	if prevChain != "" {
+		err = deleteKillSwitchChain(iptableMan, prevChain)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disableKillSwitch removes the kill switch chains whether or not they were added by this run of the daemon
+func (b *iptablesBackend) disableKillSwitch() error {
+	for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		iptableMan, err := iptables.NewWithProtocol(proto)
+		if err != nil {
+			return fmt.Errorf("failed to create iptables interface: %w", err)
+		}
+
+		for _, chain := range iptablesKillSwitchChains {
+			err = deleteKillSwitchChain(iptableMan, chain)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteKillSwitchChain removes the jump to a kill switch chain and deletes the chain if it exists
+func deleteKillSwitchChain(iptableMan *iptables.IPTables, chain string) error {
+	chainExists, err := iptableMan.ChainExists("filter", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to check kill switch chain: %w", err)
+	}
+
+	if !chainExists {
+		return nil
+	}
+
+	err = iptableMan.DeleteIfExists("filter", "OUTPUT", "-j", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to delete kill switch chain jump: %w", err)
+	}
+
+	err = iptableMan.ClearAndDeleteChain("filter", chain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to delete kill switch chain: %w", err)
+	}
+
+	return nil
+}
+
+// iptablesFirewallRulespecs returns the iptables rulespecs which allow the traffic matched by a firewall rule
+func iptablesFirewallRulespecs(rule FirewallRule) [][]string {
+	rulespecs := [][]string{}
+	for _, match := range firewallRuleMatches(rule) {
+		rulespec := []string{}
+		if match.source != "" {
+			rulespec = append(rulespec, "-s", match.source)
+		}
+
+		if match.destination != "" {
+			rulespec = append(rulespec, "-d", match.destination)
+		}
+
+		if rule.Protocol != "" {
+			rulespec = append(rulespec, "-p", rule.Protocol)
+		}
+
+		if match.port != "" {
+			// iptables port ranges are separated by a colon
+			rulespec = append(rulespec, "--dport", strings.Replace(match.port, "-", ":", 1))
+		}
+
+		rulespecs = append(rulespecs, append(rulespec, "-j", "RETURN"))
+	}
+
+	return rulespecs
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package wg
+
+import (
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/google/nftables"
+)
+
+// netfilterBackend configures forwarding, NAT and firewall rules of the rvpn wireguard interface
+type netfilterBackend interface {
+	enableForwarding(subnets []net.IPNet, tunnelNet *net.IPNet, defaultIface string, masquerade bool) error
+	disableForwarding() error
+	forwarding() bool // whether forwarding rules are configured
+	updateFirewall(rules []FirewallRule) error
+	disableFirewall() error
+	enableKillSwitch(conf killSwitchConf) error // NOTE: kill switch rules outlive the daemon
+	disableKillSwitch() error
+}
+
+// newNetfilterBackend picks the netfilter backend, nftables is used if the kernel supports it unless iptables runs
+// in legacy mode where administrators expect forwarding rules to be visible in iptables
+func newNetfilterBackend(interfaceName string) (netfilterBackend, error) {
+	if nftablesSupported() && !iptablesLegacy() {
+		log.Println("using nftables netfilter backend")
+		return newNftablesBackend(interfaceName)
+	}
+
+	log.Println("using iptables netfilter backend")
+	return newIptablesBackend(interfaceName)
+}
+
+// nftablesSupported returns whether nftables can be configured through netlink
+func nftablesSupported() bool {
+	nftConn, err := nftables.New()
+	if err != nil {
+		return false
+	}
+
+	_, err = nftConn.ListTables()
+	return err == nil
+}
+
+// iptablesLegacy returns whether the installed iptables uses the legacy kernel interface instead of nftables
+func iptablesLegacy() bool {
+	out, err := exec.Command("iptables", "--version").Output()
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(out), "legacy")
+}
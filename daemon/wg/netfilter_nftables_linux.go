@@ -0,0 +1,646 @@
+//go:build linux
+
+package wg
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// nftablesTableName is the nftables table owned by rVPN, all rules live in it so flushing it removes every rule
+const nftablesTableName = "rvpn"
+
+// nftablesKillSwitchTableName is the nftables table of the kill switch, it is separate from the rvpn table so it
+// survives restarts of the daemon
+const nftablesKillSwitchTableName = "rvpn_killswitch"
+
+// iptablesDockerUserChain is the iptables chain Docker evaluates before dropping forwarded traffic which is not for
+// its containers, rules in it are the supported way to let other forwarded traffic through
+const iptablesDockerUserChain = "DOCKER-USER"
+
+// nftablesForwarding is the forwarding configuration applied by the nftables backend
+type nftablesForwarding struct {
+	subnets      []net.IPNet
+	tunnelNet    *net.IPNet
+	defaultIface string
+	masquerade   bool
+}
+
+// nftablesBackend configures netfilter through nftables in a dedicated table, every change rebuilds the table in
+// a single netlink batch so it is applied atomically
+// NOTE: accepted traffic is still evaluated by the base chains of other tables such as those of firewalld or Docker,
+// the forwarded traffic is therefore also accepted in the DOCKER-USER chain of Docker if it exists
+type nftablesBackend struct {
+	interfaceName   string
+	fwd             *nftablesForwarding // forwarding configuration, nil if disabled
+	dockerUserRules []iptablesRule      // rules inserted into the DOCKER-USER chain of iptables
+	firewallRules   []FirewallRule      // firewall rules, nil if disabled
+}
+
+// newNftablesBackend returns a nftablesBackend for the wireguard interface, the rvpn table left behind by a
+// previous run is removed
+func newNftablesBackend(interfaceName string) (*nftablesBackend, error) {
+	b := &nftablesBackend{
+		interfaceName: interfaceName,
+	}
+
+	err := b.apply()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// enableForwarding forwards traffic from the wireguard interface to the subnets
+func (b *nftablesBackend) enableForwarding(subnets []net.IPNet, tunnelNet *net.IPNet, defaultIface string, masquerade bool) error {
+	prevFwd := b.fwd
+	b.fwd = &nftablesForwarding{
+		subnets:      subnets,
+		tunnelNet:    tunnelNet,
+		defaultIface: defaultIface,
+		masquerade:   masquerade,
+	}
+
+	err := b.apply()
+	if err != nil {
+		b.fwd = prevFwd
+		return err
+	}
+
+	err = b.replaceDockerUserRules()
+	if err != nil {
+		// forwarding still works unless Docker drops the traffic
+		log.Printf("failed to accept forwarded traffic in docker chain: %v", err)
+	}
+
+	return nil
+}
+
+// disableForwarding removes the forwarding rules
+func (b *nftablesBackend) disableForwarding() error {
+	prevFwd := b.fwd
+	b.fwd = nil
+
+	err := b.apply()
+	if err != nil {
+		b.fwd = prevFwd
+		return err
+	}
+
+	err = b.replaceDockerUserRules()
+	if err != nil {
+		log.Printf("failed to delete rules from docker chain: %v", err)
+	}
+
+	return nil
+}
+
+// replaceDockerUserRules replaces the rules inserted into the DOCKER-USER chain of iptables with rules accepting the
+// forwarded traffic, Docker sets the policy of the FORWARD chain to drop so an accept in the rvpn table is not enough
+// NOTE: the firewall rules still apply, a drop in the rvpn table is final whatever other tables accept
+func (b *nftablesBackend) replaceDockerUserRules() error {
+	if b.fwd == nil && len(b.dockerUserRules) == 0 {
+		return nil
+	}
+
+	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		// without iptables Docker cannot have added its chains either
+		return nil
+	}
+
+	for _, dockerUserRule := range b.dockerUserRules {
+		err = iptableMan.DeleteIfExists(dockerUserRule.table, dockerUserRule.chain, dockerUserRule.rulespec...)
+		if err != nil {
+			return fmt.Errorf("iptables failed to delete docker rule %v: %w", dockerUserRule.rulespec, err)
+		}
+	}
+
+	b.dockerUserRules = []iptablesRule{}
+
+	if b.fwd == nil {
+		return nil
+	}
+
+	chainExists, err := iptableMan.ChainExists("filter", iptablesDockerUserChain)
+	if err != nil {
+		return fmt.Errorf("iptables failed to check docker chain: %w", err)
+	}
+
+	if !chainExists {
+		return nil
+	}
+
+	// replies are accepted as well, Docker only accepts replies to its containers
+	dockerUserRules := []iptablesRule{{
+		table:    "filter",
+		chain:    iptablesDockerUserChain,
+		rulespec: []string{"-o", b.interfaceName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}}
+	for _, forwardRule := range iptablesForwardingRules(b.interfaceName, b.fwd.subnets, b.fwd.tunnelNet, b.fwd.defaultIface, b.fwd.masquerade) {
+		if forwardRule.table == "filter" {
+			forwardRule.chain = iptablesDockerUserChain
+			dockerUserRules = append(dockerUserRules, forwardRule)
+		}
+	}
+
+	// NOTE: rules left behind by a previous run are deleted first so they are not duplicated
+	for _, dockerUserRule := range dockerUserRules {
+		err = iptableMan.DeleteIfExists(dockerUserRule.table, dockerUserRule.chain, dockerUserRule.rulespec...)
+		if err != nil {
+			return fmt.Errorf("iptables failed to delete docker rule %v: %w", dockerUserRule.rulespec, err)
+		}
+
+		err = iptableMan.Insert(dockerUserRule.table, dockerUserRule.chain, 1, dockerUserRule.rulespec...)
+		if err != nil {
+			return fmt.Errorf("iptables failed to insert docker rule %v: %w", dockerUserRule.rulespec, err)
+		}
+
+		b.dockerUserRules = append(b.dockerUserRules, dockerUserRule)
+	}
+
+	return nil
+}
+
+// forwarding returns whether forwarding rules are configured
+func (b *nftablesBackend) forwarding() bool {
+	return b.fwd != nil
+}
+
+// updateFirewall replaces the firewall rules, only traffic which matches a rule is forwarded
+func (b *nftablesBackend) updateFirewall(rules []FirewallRule) error {
+	prevFirewallRules := b.firewallRules
+	b.firewallRules = append([]FirewallRule{}, rules...)
+
+	err := b.apply()
+	if err != nil {
+		b.firewallRules = prevFirewallRules
+		return err
+	}
+
+	return nil
+}
+
+// disableFirewall removes the firewall rules so all traffic is forwarded again
+func (b *nftablesBackend) disableFirewall() error {
+	if b.firewallRules == nil {
+		return nil
+	}
+
+	prevFirewallRules := b.firewallRules
+	b.firewallRules = nil
+
+	err := b.apply()
+	if err != nil {
+		b.firewallRules = prevFirewallRules
+		return err
+	}
+
+	return nil
+}
+
+// apply rebuilds the rvpn table from the configuration in a single batch, the table is removed if nothing is configured
+func (b *nftablesBackend) apply() error {
+	nftConn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+
+	// adding the table before deleting it ensures the delete succeeds whether or not the table exists
+	table := &nftables.Table{
+		Name:   nftablesTableName,
+		Family: nftables.TableFamilyIPv4,
+	}
+	nftConn.AddTable(table)
+	nftConn.DelTable(table)
+
+	if b.fwd != nil || b.firewallRules != nil {
+		nftConn.AddTable(table)
+
+		acceptPolicy := nftables.ChainPolicyAccept
+		forwardChain := nftConn.AddChain(&nftables.Chain{
+			Name:     "forward",
+			Table:    table,
+			Type:     nftables.ChainTypeFilter,
+			Hooknum:  nftables.ChainHookForward,
+			Priority: nftables.ChainPriorityFilter,
+			Policy:   &acceptPolicy,
+		})
+
+		if b.firewallRules != nil {
+			err = b.addFirewallRules(nftConn, table, forwardChain)
+			if err != nil {
+				return err
+			}
+		}
+
+		if b.fwd != nil {
+			b.addForwardingRules(nftConn, table, forwardChain)
+		}
+	}
+
+	err = nftConn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to apply nftables rules: %w", err)
+	}
+
+	return nil
+}
+
+// enableKillSwitch replaces the kill switch table, outgoing traffic which is not allowed by the kill switch is dropped
+func (b *nftablesBackend) enableKillSwitch(conf killSwitchConf) error {
+	nftConn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+
+	// NOTE: inet tables see both IPv4 and IPv6 traffic so IPv6 cannot leak
+	table := &nftables.Table{
+		Name:   nftablesKillSwitchTableName,
+		Family: nftables.TableFamilyINet,
+	}
+	nftConn.AddTable(table)
+	nftConn.DelTable(table)
+	nftConn.AddTable(table)
+
+	dropPolicy := nftables.ChainPolicyDrop
+	outputChain := nftConn.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &dropPolicy,
+	})
+
+	allowRules := [][]expr.Any{
+		nftMatchIface(expr.MetaKeyOIFNAME, "lo"),
+		nftMatchIface(expr.MetaKeyOIFNAME, b.interfaceName),
+		nftExprs(nftMatchProto(unix.IPPROTO_UDP), nftMatchSourcePort(conf.wireguardPort)),
+		nftExprs(nftMatchProto(unix.IPPROTO_UDP), nftMatchDestPort(killSwitchDhcpPort)),
+		nftExprs(nftMatchProto(unix.IPPROTO_UDP), nftMatchDestPort(killSwitchDhcpv6Port)),
+	}
+
+	for _, ndpType := range killSwitchNdpTypes {
+		allowRules = append(allowRules, nftExprs(nftMatchProto(unix.IPPROTO_ICMPV6), nftMatchICMPType(ndpType)))
+	}
+
+	for _, allowedHost := range conf.allowedHosts {
+		allowRules = append(allowRules, nftExprs(nftMatchIPv4(), nftMatchAddr(nftDaddrOffset, allowedHost)))
+	}
+
+	for _, allowRule := range allowRules {
+		nftConn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: outputChain,
+			Exprs: nftExprs(allowRule, nftVerdict(expr.VerdictAccept)),
+		})
+	}
+
+	err = nftConn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to apply nftables kill switch rules: %w", err)
+	}
+
+	return nil
+}
+
+// disableKillSwitch removes the kill switch table whether or not it was added by this run of the daemon
+func (b *nftablesBackend) disableKillSwitch() error {
+	nftConn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+
+	table := &nftables.Table{
+		Name:   nftablesKillSwitchTableName,
+		Family: nftables.TableFamilyINet,
+	}
+	nftConn.AddTable(table)
+	nftConn.DelTable(table)
+
+	err = nftConn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to remove nftables kill switch rules: %w", err)
+	}
+
+	return nil
+}
+
+// addForwardingRules adds the rules which forward traffic from the wireguard interface to the subnets
+func (b *nftablesBackend) addForwardingRules(nftConn *nftables.Conn, table *nftables.Table, forwardChain *nftables.Chain) {
+	masqRules := [][]expr.Any{}
+
+	forwardAll := false
+	for _, subnet := range b.fwd.subnets {
+		if ones, _ := subnet.Mask.Size(); ones == 0 {
+			forwardAll = true
+
+			// accept and forward all traffic from rvpn wireguard interface
+			nftConn.AddRule(&nftables.Rule{
+				Table: table,
+				Chain: forwardChain,
+				Exprs: nftExprs(nftMatchIface(expr.MetaKeyIIFNAME, b.interfaceName), nftVerdict(expr.VerdictAccept)),
+			})
+
+			if b.fwd.masquerade && b.fwd.defaultIface != "" {
+				// enable masquerading on default interface output
+				masqRules = append(masqRules, nftExprs(nftMatchIface(expr.MetaKeyOIFNAME, b.fwd.defaultIface), nftMasq()))
+			}
+
+			continue
+		}
+
+		// accept and forward traffic from rvpn wireguard interface to the served subnet, including replies
+		nftConn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: forwardChain,
+			Exprs: nftExprs(nftMatchIface(expr.MetaKeyIIFNAME, b.interfaceName), nftMatchAddr(nftDaddrOffset, subnet),
+				nftVerdict(expr.VerdictAccept)),
+		})
+		nftConn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: forwardChain,
+			Exprs: nftExprs(nftMatchIface(expr.MetaKeyOIFNAME, b.interfaceName), nftMatchAddr(nftSaddrOffset, subnet),
+				nftMatchEstablished(), nftVerdict(expr.VerdictAccept)),
+		})
+
+		if b.fwd.masquerade {
+			// enable masquerading of client traffic into the served subnet
+			masqRules = append(masqRules, nftExprs(nftMatchAddr(nftSaddrOffset, *b.fwd.tunnelNet),
+				nftMatchAddr(nftDaddrOffset, subnet), nftMasq()))
+		}
+	}
+
+	if !forwardAll {
+		// accept and forward traffic between peers so subnets routed through peers are reachable
+		nftConn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: forwardChain,
+			Exprs: nftExprs(nftMatchIface(expr.MetaKeyIIFNAME, b.interfaceName), nftMatchIface(expr.MetaKeyOIFNAME, b.interfaceName),
+				nftVerdict(expr.VerdictAccept)),
+		})
+	}
+
+	if len(masqRules) > 0 {
+		postroutingChain := nftConn.AddChain(&nftables.Chain{
+			Name:     "postrouting",
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPostrouting,
+			Priority: nftables.ChainPriorityNATSource,
+		})
+
+		for _, masqRule := range masqRules {
+			nftConn.AddRule(&nftables.Rule{
+				Table: table,
+				Chain: postroutingChain,
+				Exprs: masqRule,
+			})
+		}
+	}
+}
+
+// addFirewallRules adds a firewall chain which drops traffic from the wireguard interface unless it matches a rule
+func (b *nftablesBackend) addFirewallRules(nftConn *nftables.Conn, table *nftables.Table, forwardChain *nftables.Chain) error {
+	firewallChain := nftConn.AddChain(&nftables.Chain{
+		Name:  "firewall",
+		Table: table,
+	})
+
+	// the firewall chain must be evaluated before the forwarding rules accept traffic
+	nftConn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: forwardChain,
+		Exprs: nftExprs(nftMatchIface(expr.MetaKeyIIFNAME, b.interfaceName), nftJump(firewallChain.Name)),
+	})
+
+	// replies to allowed traffic are always allowed, return continues with the forwarding rules
+	nftConn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: firewallChain,
+		Exprs: nftExprs(nftMatchEstablished(), nftVerdict(expr.VerdictReturn)),
+	})
+
+	for _, rule := range b.firewallRules {
+		ruleExprs, err := nftFirewallRuleExprs(rule)
+		if err != nil {
+			return err
+		}
+
+		for _, exprs := range ruleExprs {
+			nftConn.AddRule(&nftables.Rule{
+				Table: table,
+				Chain: firewallChain,
+				Exprs: exprs,
+			})
+		}
+	}
+
+	nftConn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: firewallChain,
+		Exprs: nftVerdict(expr.VerdictDrop),
+	})
+
+	return nil
+}
+
+// nftFirewallRuleExprs returns the nftables rule expressions which allow the traffic matched by a firewall rule
+func nftFirewallRuleExprs(rule FirewallRule) ([][]expr.Any, error) {
+	var protoExprs []expr.Any
+	switch rule.Protocol {
+	case "":
+	case "tcp":
+		protoExprs = nftMatchProto(unix.IPPROTO_TCP)
+	case "udp":
+		protoExprs = nftMatchProto(unix.IPPROTO_UDP)
+	case "icmp":
+		protoExprs = nftMatchProto(unix.IPPROTO_ICMP)
+	default:
+		return nil, fmt.Errorf("invalid firewall rule protocol %s", rule.Protocol)
+	}
+
+	ruleExprs := [][]expr.Any{}
+	for _, match := range firewallRuleMatches(rule) {
+		exprs := []expr.Any{}
+		if match.source != "" {
+			_, source, err := net.ParseCIDR(match.source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid firewall rule source: %w", err)
+			}
+
+			exprs = append(exprs, nftMatchAddr(nftSaddrOffset, *source)...)
+		}
+
+		if match.destination != "" {
+			_, destination, err := net.ParseCIDR(match.destination)
+			if err != nil {
+				return nil, fmt.Errorf("invalid firewall rule destination: %w", err)
+			}
+
+			exprs = append(exprs, nftMatchAddr(nftDaddrOffset, *destination)...)
+		}
+
+		exprs = append(exprs, protoExprs...)
+
+		if match.port != "" {
+			portExprs, err := nftMatchPort(match.port)
+			if err != nil {
+				return nil, err
+			}
+
+			exprs = append(exprs, portExprs...)
+		}
+
+		ruleExprs = append(ruleExprs, append(exprs, nftVerdict(expr.VerdictReturn)...))
+	}
+
+	return ruleExprs, nil
+}
+
+// offsets of the source and destination address in the IPv4 header
+const (
+	nftSaddrOffset = 12
+	nftDaddrOffset = 16
+)
+
+// nftExprs concatenates rule expressions
+func nftExprs(exprs ...[]expr.Any) []expr.Any {
+	ret := []expr.Any{}
+	for _, e := range exprs {
+		ret = append(ret, e...)
+	}
+
+	return ret
+}
+
+// nftMatchIface matches the input or output interface name
+func nftMatchIface(key expr.MetaKey, ifaceName string) []expr.Any {
+	// interface names are compared as zero padded IFNAMSIZ buffers
+	ifname := make([]byte, unix.IFNAMSIZ)
+	copy(ifname, ifaceName)
+
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname},
+	}
+}
+
+// nftMatchAddr matches the source or destination address against a subnet
+func nftMatchAddr(offset uint32, subnet net.IPNet) []expr.Any {
+	mask := net.IP(subnet.Mask).To4()
+	if mask == nil {
+		// IPv4 mask in IPv6 form
+		mask = net.IP(subnet.Mask[len(subnet.Mask)-net.IPv4len:])
+	}
+
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: net.IPv4len},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: net.IPv4len, Mask: mask, Xor: make([]byte, net.IPv4len)},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: subnet.IP.To4().Mask(net.IPMask(mask))},
+	}
+}
+
+// nftMatchProto matches the transport protocol
+func nftMatchProto(proto byte) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+	}
+}
+
+// nftMatchPort matches the destination port against a port or port range, i.e "8000-8100"
+func nftMatchPort(port string) ([]expr.Any, error) {
+	portRange := strings.SplitN(port, "-", 2)
+	portRangeStart, err := strconv.ParseUint(portRange[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid firewall rule port %s: %w", port, err)
+	}
+
+	portRangeEnd := portRangeStart
+	if len(portRange) == 2 {
+		portRangeEnd, err = strconv.ParseUint(portRange[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid firewall rule port %s: %w", port, err)
+		}
+	}
+
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpGte, Register: 1, Data: binaryutil.BigEndian.PutUint16(uint16(portRangeStart))},
+		&expr.Cmp{Op: expr.CmpOpLte, Register: 1, Data: binaryutil.BigEndian.PutUint16(uint16(portRangeEnd))},
+	}, nil
+}
+
+// nftMatchIPv4 matches IPv4 packets in inet tables
+func nftMatchIPv4() []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.NFPROTO_IPV4}},
+	}
+}
+
+// nftMatchSourcePort matches the source port
+func nftMatchSourcePort(port uint16) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(port)},
+	}
+}
+
+// nftMatchDestPort matches the destination port
+func nftMatchDestPort(port uint16) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(port)},
+	}
+}
+
+// nftMatchICMPType matches the ICMP or ICMPv6 type
+func nftMatchICMPType(icmpType uint8) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{icmpType}},
+	}
+}
+
+// nftMatchEstablished matches packets of established or related connections
+func nftMatchEstablished() []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+			Xor:            make([]byte, 4),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: make([]byte, 4)},
+	}
+}
+
+// nftMasq masquerades matched packets
+func nftMasq() []expr.Any {
+	return []expr.Any{&expr.Masq{}}
+}
+
+// nftVerdict ends the rule with a verdict
+func nftVerdict(kind expr.VerdictKind) []expr.Any {
+	return []expr.Any{&expr.Verdict{Kind: kind}}
+}
+
+// nftJump ends the rule with a jump to a chain
+func nftJump(chain string) []expr.Any {
+	return []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: chain}}
+}
@@ -0,0 +1,158 @@
+//go:build linux
+
+package wg
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestNftMatchPort(t *testing.T) {
+	portExprs := func(start, end uint16) []expr.Any {
+		return []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpGte, Register: 1, Data: binaryutil.BigEndian.PutUint16(start)},
+			&expr.Cmp{Op: expr.CmpOpLte, Register: 1, Data: binaryutil.BigEndian.PutUint16(end)},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		port    string
+		want    []expr.Any
+		wantErr bool
+	}{
+		{name: "single port", port: "22", want: portExprs(22, 22)},
+		{name: "port range", port: "8000-8100", want: portExprs(8000, 8100)},
+		{name: "full range", port: "0-65535", want: portExprs(0, 65535)},
+		{name: "empty", port: "", wantErr: true},
+		{name: "not a number", port: "ssh", wantErr: true},
+		{name: "out of range", port: "65536", wantErr: true},
+		{name: "invalid range end", port: "80-http", wantErr: true},
+		{name: "open range", port: "80-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nftMatchPort(tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nftMatchPort(%q) error = %v, wantErr %v", tt.port, err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nftMatchPort(%q) = %v, want %v", tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNftFirewallRuleExprs(t *testing.T) {
+	mustCIDR := func(cidr string) net.IPNet {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return *subnet
+	}
+
+	mustPort := func(port string) []expr.Any {
+		portExprs, err := nftMatchPort(port)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return portExprs
+	}
+
+	allow := nftVerdict(expr.VerdictReturn)
+
+	tests := []struct {
+		name    string
+		rule    FirewallRule
+		want    [][]expr.Any
+		wantErr bool
+	}{
+		{
+			name: "match any",
+			rule: FirewallRule{},
+			want: [][]expr.Any{allow},
+		},
+		{
+			name: "source and destination",
+			rule: FirewallRule{Sources: []string{"10.8.0.2/32"}, Destinations: []string{"192.168.1.0/24"}},
+			want: [][]expr.Any{
+				nftExprs(nftMatchAddr(nftSaddrOffset, mustCIDR("10.8.0.2/32")),
+					nftMatchAddr(nftDaddrOffset, mustCIDR("192.168.1.0/24")), allow),
+			},
+		},
+		{
+			name: "protocol and port",
+			rule: FirewallRule{Protocol: "tcp", Ports: []string{"443"}},
+			want: [][]expr.Any{
+				nftExprs(nftMatchProto(unix.IPPROTO_TCP), mustPort("443"), allow),
+			},
+		},
+		{
+			name: "every combination",
+			rule: FirewallRule{
+				Sources:  []string{"10.8.0.2/32", "10.8.0.3/32"},
+				Protocol: "udp",
+				Ports:    []string{"53", "5000-5100"},
+			},
+			want: [][]expr.Any{
+				nftExprs(nftMatchAddr(nftSaddrOffset, mustCIDR("10.8.0.2/32")), nftMatchProto(unix.IPPROTO_UDP),
+					mustPort("53"), allow),
+				nftExprs(nftMatchAddr(nftSaddrOffset, mustCIDR("10.8.0.2/32")), nftMatchProto(unix.IPPROTO_UDP),
+					mustPort("5000-5100"), allow),
+				nftExprs(nftMatchAddr(nftSaddrOffset, mustCIDR("10.8.0.3/32")), nftMatchProto(unix.IPPROTO_UDP),
+					mustPort("53"), allow),
+				nftExprs(nftMatchAddr(nftSaddrOffset, mustCIDR("10.8.0.3/32")), nftMatchProto(unix.IPPROTO_UDP),
+					mustPort("5000-5100"), allow),
+			},
+		},
+		{
+			name: "icmp",
+			rule: FirewallRule{Protocol: "icmp"},
+			want: [][]expr.Any{nftExprs(nftMatchProto(unix.IPPROTO_ICMP), allow)},
+		},
+		{
+			name:    "invalid protocol",
+			rule:    FirewallRule{Protocol: "sctp"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid source",
+			rule:    FirewallRule{Sources: []string{"10.8.0.2"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid destination",
+			rule:    FirewallRule{Destinations: []string{"lan"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			rule:    FirewallRule{Protocol: "tcp", Ports: []string{"https"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nftFirewallRuleExprs(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nftFirewallRuleExprs(%+v) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nftFirewallRuleExprs(%+v) = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
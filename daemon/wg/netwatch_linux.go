@@ -0,0 +1,161 @@
+//go:build linux
+
+package wg
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// networkSettleTime is how long netlink updates have to stop before the network is inspected, switching networks
+// produces a burst of link, address and route updates
+const networkSettleTime = 2 * time.Second
+
+// watchNetwork subscribes to netlink route, link and address updates and follows changes of the default interface
+// until done is closed
+func (d *WireguardDaemon) watchNetwork(done chan struct{}) error {
+	routeUpdates := make(chan netlink.RouteUpdate)
+	if err := netlink.RouteSubscribe(routeUpdates, done); err != nil {
+		return fmt.Errorf("failed to subscribe to route updates: %w", err)
+	}
+
+	linkUpdates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(linkUpdates, done); err != nil {
+		return fmt.Errorf("failed to subscribe to link updates: %w", err)
+	}
+
+	addrUpdates := make(chan netlink.AddrUpdate)
+	if err := netlink.AddrSubscribe(addrUpdates, done); err != nil {
+		return fmt.Errorf("failed to subscribe to address updates: %w", err)
+	}
+
+	go func() {
+		var settled <-chan time.Time
+		for {
+			// NOTE: subscriptions close their channel once done is closed
+			var ok bool
+			select {
+			case _, ok = <-routeUpdates:
+			case _, ok = <-linkUpdates:
+			case _, ok = <-addrUpdates:
+			case <-settled:
+				settled = nil
+				d.handleNetworkChange()
+				continue
+			case <-done:
+				return
+			}
+
+			if !ok {
+				log.Printf("netlink subscription closed, no longer following network changes")
+				return
+			}
+
+			settled = time.After(networkSettleTime)
+		}
+	}()
+
+	return nil
+}
+
+// handleNetworkChange moves the host routes, source routing and masquerading of the daemon to the current default
+// interface if it changed
+func (d *WireguardDaemon) handleNetworkChange() {
+	d.networkMu.Lock()
+	defer d.networkMu.Unlock()
+
+	if d.DefaultIFaceLink == nil {
+		// the daemon has not connected or served yet so nothing depends on the default interface
+		return
+	}
+
+	currDefaultIFace, currDefaultGateway, err := findDefaultInterface()
+	if err != nil {
+		// i.e while switching networks, the next update picks up the new default interface
+		log.Printf("failed to find default interface after network change: %v", err)
+		return
+	}
+
+	defaultIFaceChanged := currDefaultIFace.Attrs().Index != d.DefaultIFaceLink.Attrs().Index ||
+		!currDefaultGateway.Equal(d.defaultGateway)
+
+	if !defaultIFaceChanged && !d.sourceRoutingStale(currDefaultIFace) {
+		return
+	}
+
+	log.Printf("default interface changed to %s via %s, updating routes", currDefaultIFace.Attrs().Name,
+		currDefaultGateway)
+
+	d.DefaultIFaceLink = currDefaultIFace
+	d.defaultGateway = currDefaultGateway
+
+	// keep traffic to the rVPN server and control plane off the tunnel
+	if len(d.hostRoutes) > 0 {
+		err = d.replaceHostRoutes()
+		if err != nil {
+			log.Printf("failed to move host routes to default interface: %v", err)
+		}
+	}
+
+	// reply traffic has to leave through the new default interface
+	if d.sourceRouting {
+		err = d.stopSourceRouting()
+		if err != nil {
+			log.Printf("failed to stop source routing: %v", err)
+		}
+
+		err = d.enableSourceRouting(currDefaultIFace, currDefaultGateway)
+		if err != nil {
+			log.Printf("failed to enable source routing: %v", err)
+		}
+	}
+
+	// masquerade forwarded traffic on the new default interface
+	if d.forwardingConf != nil {
+		conf := *d.forwardingConf
+
+		err = d.disableForwarding()
+		if err != nil {
+			log.Printf("failed to disable forwarding: %v", err)
+		}
+
+		err = d.enableForwarding(conf.subnets, conf.tunnelNet, conf.masquerade)
+		if err != nil {
+			log.Printf("failed to enable forwarding: %v", err)
+		}
+	}
+}
+
+// sourceRoutingStale returns whether source routing is enabled for other addresses than the ipv4 addresses of the
+// default interface, i.e after DHCP handed out a new address
+func (d *WireguardDaemon) sourceRoutingStale(defaultIFace netlink.Link) bool {
+	if !d.sourceRouting {
+		return false
+	}
+
+	addrList, err := netlink.AddrList(defaultIFace, netlink.FAMILY_V4)
+	if err != nil {
+		log.Printf("failed to list addresses of default interface: %v", err)
+		return false
+	}
+
+	if len(addrList) != len(d.appendedSrcRules) {
+		return true
+	}
+
+	routedSources := map[string]bool{}
+	for _, sourceRoutingRule := range d.appendedSrcRules {
+		routedSources[sourceRoutingRule.Src.IP.String()] = true
+	}
+
+	for _, ifaceAddr := range addrList {
+		if !routedSources[ifaceAddr.IP.String()] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,7 @@ package wg
 
 import (
 	"fmt"
+	"net"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -21,3 +22,139 @@ func printDevice(d *wgtypes.Device) {
 		d.PublicKey.String(),
 		d.ListenPort)
 }
+
+// parsePresharedKey parses a preshared key, returns nil if no preshared key is given
+func parsePresharedKey(presharedKey string) (*wgtypes.Key, error) {
+	if presharedKey == "" {
+		return nil, nil
+	}
+
+	psk, err := wgtypes.ParseKey(presharedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &psk, nil
+}
+
+// parseSubnets parses a list of subnets into networks, if no subnets are given all traffic is routed
+func parseSubnets(subnets []string) ([]net.IPNet, error) {
+	if len(subnets) == 0 {
+		subnets = []string{"0.0.0.0/0"}
+	}
+
+	parsedSubnets := []net.IPNet{}
+	for _, subnet := range subnets {
+		_, parsedSubnet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse subnet %s: %w", subnet, err)
+		}
+
+		parsedSubnets = append(parsedSubnets, *parsedSubnet)
+	}
+
+	return parsedSubnets, nil
+}
+
+// subnetRoutes returns the route destinations for subnets, a default route is split into two slightly
+// more specific routes so it takes precedence over the system default route without replacing it
+func subnetRoutes(subnets []net.IPNet) []net.IPNet {
+	routeDsts := []net.IPNet{}
+	for _, subnet := range subnets {
+		if ones, _ := subnet.Mask.Size(); ones == 0 {
+			_, lowerHalf, _ := net.ParseCIDR("0.0.0.0/1")
+			_, upperHalf, _ := net.ParseCIDR("128.0.0.0/1")
+			routeDsts = append(routeDsts, *lowerHalf, *upperHalf)
+			continue
+		}
+
+		routeDsts = append(routeDsts, subnet)
+	}
+
+	return routeDsts
+}
+
+// clientAllowedIPs returns the allowed IPs for the rVPN server peer of a client, the tunnel network is always
+// allowed so traffic from other peers which is routed through the rVPN server is accepted
+func clientAllowedIPs(subnets []net.IPNet, tunnelNet net.IPNet) []net.IPNet {
+	allowedIPs := []net.IPNet{tunnelNet}
+	for _, subnet := range subnets {
+		if subnet.Contains(tunnelNet.IP) {
+			// tunnel network is already covered by a routable subnet
+			return subnets
+		}
+	}
+
+	return append(allowedIPs, subnets...)
+}
+
+// routedIP returns whether traffic to the ip is routed through one of the networks
+func routedIP(ip net.IP, networks []net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerAllowedIPs returns the allowed IPs for a client peer of the rVPN server which are the peer tunnel ip
+// and any subnets routed through the peer
+func peerAllowedIPs(peer WireGuardPeer) ([]net.IPNet, error) {
+	allowedCidr := peer.AllowedCidr
+	if allowedCidr == "" {
+		allowedCidr = "/32"
+	}
+
+	_, peerIPNet, err := net.ParseCIDR(peer.AllowedIP + allowedCidr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer allowed ip: %w", err)
+	}
+
+	allowedIPs := []net.IPNet{*peerIPNet}
+	for _, subnet := range peer.Subnets {
+		_, parsedSubnet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse peer subnet %s: %w", subnet, err)
+		}
+
+		allowedIPs = append(allowedIPs, *parsedSubnet)
+	}
+
+	return allowedIPs, nil
+}
+
+// serverPeerConfig returns the config of a client peer of the rVPN server and the subnets routed through the peer, a
+// staged peer has no allowed IPs so it can handshake while the tunnel ip of its device stays on the peer for the
+// previous key until the key rotation completes
+func serverPeerConfig(peer WireGuardPeer) (wgtypes.PeerConfig, []net.IPNet, error) {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, nil, fmt.Errorf("failed to parse peer pubkey: %w", err)
+	}
+
+	psk, err := parsePresharedKey(peer.PresharedKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, nil, fmt.Errorf("failed to parse peer preshared key: %w", err)
+	}
+
+	allowedIPs := []net.IPNet{}
+	routeDsts := []net.IPNet{}
+	if !peer.Staged {
+		allowedIPs, err = peerAllowedIPs(peer)
+		if err != nil {
+			return wgtypes.PeerConfig{}, nil, err
+		}
+
+		routeDsts = allowedIPs[1:]
+	}
+
+	// NOTE: allowed IPs are replaced so updated peer subnets take effect
+	return wgtypes.PeerConfig{
+		PublicKey:         publicKey,
+		PresharedKey:      psk,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	}, routeDsts, nil
+}
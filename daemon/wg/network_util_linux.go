@@ -8,9 +8,9 @@ import (
 	"log"
 	"math"
 	"net"
+	"net/netip"
 	"os"
 
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
@@ -51,6 +51,13 @@ func assignInterfaceAddr(ifaceName, ipAddressPrefix string) error {
 	return err
 }
 
+// forwardingConf holds the arguments forwarding was enabled with
+type forwardingConf struct {
+	subnets    []net.IPNet
+	tunnelNet  *net.IPNet
+	masquerade bool
+}
+
 // findDefaultInterfaceName finds the name of the defualt interface on system
 func findDefaultInterface() (netlink.Link, net.IP, error) {
 	lowestMetric := math.MaxInt
@@ -153,6 +160,7 @@ func (d *WireguardDaemon) enableSourceRouting(sourceRouteIFace netlink.Link, sou
 	}
 
 	d.appendedSrcRoutes = sourceRoutingRoutes
+	d.sourceRouting = true
 
 	return nil
 }
@@ -174,51 +182,87 @@ func (d *WireguardDaemon) stopSourceRouting() error {
 			return err
 		}
 	}
+
+	d.appendedSrcRules = []*netlink.Rule{}
+	d.appendedSrcRoutes = []netlink.Route{}
+	d.sourceRouting = false
+
 	return nil
 }
 
-// enableForwarding enables ip forwarding for the specific wireguard daemon
-func (d *WireguardDaemon) enableForwarding() error {
-	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
-	if err != nil {
-		errMsg := fmt.Sprintf("failed to create iptables interface: %v", err)
-		return errors.New(errMsg)
-	}
+// replaceHostRoutes routes the server and control plane ips through the default interface so traffic to them is not
+// routed through the rvpn wireguard interface, previous host routes are replaced
+func (d *WireguardDaemon) replaceHostRoutes() error {
+	d.deleteHostRoutes()
 
-	// accept and forward from rvpn wireguard interface
-	err = iptableMan.Append("filter", "FORWARD", "-i", d.InterfaceName, "-j", "ACCEPT")
-	if err != nil {
-		return errors.New("iptables failed to accept from rvpn network interface")
+	hostIPs := []netip.Prefix{d.ServerIP}
+	if d.ControlPlaneIP != d.ServerIP {
+		hostIPs = append(hostIPs, d.ControlPlaneIP)
 	}
 
-	// enable masquerading on defualt interface output
-	err = iptableMan.Append("nat", "POSTROUTING", "-o", d.DefaultIFaceLink.Attrs().Name, "-j", "MASQUERADE")
-	if err != nil {
-		return errors.New("iptables failed to masquerade onto default interface")
+	for _, hostIP := range hostIPs {
+		if !hostIP.IsValid() {
+			continue
+		}
+
+		_, parsedIPNet, err := net.ParseCIDR(hostIP.String())
+		if err != nil {
+			return err
+		}
+
+		route := netlink.Route{
+			LinkIndex: d.DefaultIFaceLink.Attrs().Index,
+			Dst:       parsedIPNet,
+			Gw:        d.defaultGateway,
+		}
+
+		if err := netlink.RouteReplace(&route); err != nil {
+			return err
+		}
+
+		d.hostRoutes = append(d.hostRoutes, route)
 	}
 
 	return nil
 }
 
-// disableForwarding disables ip forwarding for the specific wireguard daemon
-func (d *WireguardDaemon) disableForwarding() error {
-	iptableMan, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
-	if err != nil {
-		errMsg := fmt.Sprintf("failed to create iptables interface: %v", err)
-		return errors.New(errMsg)
+// deleteHostRoutes deletes the routes of the server and control plane ips through the default interface
+func (d *WireguardDaemon) deleteHostRoutes() {
+	for _, hostRoute := range d.hostRoutes {
+		if err := netlink.RouteDel(&hostRoute); err != nil {
+			log.Printf("warn: failed to delete host route: %v", err)
+		}
 	}
 
-	// delete accept and forward from rvpn wireguard interface
-	err = iptableMan.Delete("filter", "FORWARD", "-i", d.InterfaceName, "-j", "ACCEPT")
+	d.hostRoutes = []netlink.Route{}
+}
+
+// enableForwarding enables ip forwarding for the specific wireguard daemon to the served subnets
+func (d *WireguardDaemon) enableForwarding(subnets []net.IPNet, tunnelNet *net.IPNet, masquerade bool) error {
+	// ensure the kernel forwards packets between interfaces
+	err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644)
 	if err != nil {
-		return errors.New("iptables failed to delete accept from rvpn network interface")
+		return fmt.Errorf("failed to enable kernel ip forwarding: %w", err)
 	}
 
-	// delete masquerading on defualt interface output
-	err = iptableMan.Delete("nat", "POSTROUTING", "-o", d.DefaultIFaceLink.Attrs().Name, "-j", "MASQUERADE")
-	if err != nil {
-		return errors.New("iptables failed to delete masquerade onto default interface")
+	defaultIface := ""
+	if d.DefaultIFaceLink != nil {
+		defaultIface = d.DefaultIFaceLink.Attrs().Name
 	}
 
-	return nil
+	// NOTE: forwarding is remembered so masquerading follows the default interface when it changes
+	d.forwardingConf = &forwardingConf{
+		subnets:    subnets,
+		tunnelNet:  tunnelNet,
+		masquerade: masquerade,
+	}
+
+	return d.netfilter.enableForwarding(subnets, tunnelNet, defaultIface, masquerade)
+}
+
+// disableForwarding disables ip forwarding for the specific wireguard daemon
+func (d *WireguardDaemon) disableForwarding() error {
+	d.forwardingConf = nil
+
+	return d.netfilter.disableForwarding()
 }
@@ -0,0 +1,158 @@
+package wg
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRoutedIP(t *testing.T) {
+	mustCIDRs := func(cidrs ...string) []net.IPNet {
+		networks := []net.IPNet{}
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			networks = append(networks, *network)
+		}
+
+		return networks
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		networks []net.IPNet
+		want     bool
+	}{
+		{name: "full tunnel", ip: "1.1.1.1", networks: mustCIDRs("10.8.0.0/24", "0.0.0.0/0"), want: true},
+		{name: "tunnel network", ip: "10.8.0.1", networks: mustCIDRs("10.8.0.0/24"), want: true},
+		{name: "routed subnet", ip: "192.168.1.53", networks: mustCIDRs("10.8.0.0/24", "192.168.1.0/24"), want: true},
+		{name: "outside routed subnets", ip: "8.8.8.8", networks: mustCIDRs("10.8.0.0/24", "192.168.1.0/24")},
+		{name: "ipv6 outside ipv4 full tunnel", ip: "2606:4700::1111", networks: mustCIDRs("0.0.0.0/0")},
+		{name: "no networks", ip: "10.8.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := routedIP(net.ParseIP(tt.ip), tt.networks); got != tt.want {
+				t.Errorf("routedIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerPeerConfig(t *testing.T) {
+	mustCIDR := func(cidr string) net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return *network
+	}
+
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := privateKey.PublicKey()
+
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		peer          WireGuardPeer
+		wantAllowed   []net.IPNet
+		wantRouteDsts []net.IPNet
+		wantErr       bool
+	}{
+		{
+			name:          "peer",
+			peer:          WireGuardPeer{PublicKey: publicKey.String(), PresharedKey: psk.String(), AllowedIP: "10.8.0.2"},
+			wantAllowed:   []net.IPNet{mustCIDR("10.8.0.2/32")},
+			wantRouteDsts: []net.IPNet{},
+		},
+		{
+			name: "peer with subnets",
+			peer: WireGuardPeer{
+				PublicKey:    publicKey.String(),
+				PresharedKey: psk.String(),
+				AllowedIP:    "10.8.0.2",
+				Subnets:      []string{"192.168.1.0/24"},
+			},
+			wantAllowed:   []net.IPNet{mustCIDR("10.8.0.2/32"), mustCIDR("192.168.1.0/24")},
+			wantRouteDsts: []net.IPNet{mustCIDR("192.168.1.0/24")},
+		},
+		{
+			name:          "staged peer without allowed ip",
+			peer:          WireGuardPeer{PublicKey: publicKey.String(), PresharedKey: psk.String(), Staged: true},
+			wantAllowed:   []net.IPNet{},
+			wantRouteDsts: []net.IPNet{},
+		},
+		{
+			name: "staged peer ignores subnets",
+			peer: WireGuardPeer{
+				PublicKey:    publicKey.String(),
+				PresharedKey: psk.String(),
+				AllowedIP:    "10.8.0.2",
+				Subnets:      []string{"192.168.1.0/24"},
+				Staged:       true,
+			},
+			wantAllowed:   []net.IPNet{},
+			wantRouteDsts: []net.IPNet{},
+		},
+		{
+			name:    "peer without allowed ip",
+			peer:    WireGuardPeer{PublicKey: publicKey.String(), PresharedKey: psk.String()},
+			wantErr: true,
+		},
+		{
+			name:    "invalid pubkey",
+			peer:    WireGuardPeer{PublicKey: "not a key", AllowedIP: "10.8.0.2", Staged: true},
+			wantErr: true,
+		},
+		{
+			name:    "invalid preshared key",
+			peer:    WireGuardPeer{PublicKey: publicKey.String(), PresharedKey: "not a key", Staged: true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peerConfig, routeDsts, err := serverPeerConfig(tt.peer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("serverPeerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if peerConfig.PublicKey != publicKey {
+				t.Errorf("serverPeerConfig() public key = %s, want %s", peerConfig.PublicKey, publicKey)
+			}
+
+			if peerConfig.PresharedKey == nil || *peerConfig.PresharedKey != psk {
+				t.Errorf("serverPeerConfig() preshared key = %v, want %s", peerConfig.PresharedKey, psk)
+			}
+
+			if !peerConfig.ReplaceAllowedIPs || !reflect.DeepEqual(peerConfig.AllowedIPs, tt.wantAllowed) {
+				t.Errorf("serverPeerConfig() allowed ips = %v (replace %v), want %v", peerConfig.AllowedIPs,
+					peerConfig.ReplaceAllowedIPs, tt.wantAllowed)
+			}
+
+			if !reflect.DeepEqual(routeDsts, tt.wantRouteDsts) {
+				t.Errorf("serverPeerConfig() route destinations = %v, want %v", routeDsts, tt.wantRouteDsts)
+			}
+		})
+	}
+}
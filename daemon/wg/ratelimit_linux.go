@@ -0,0 +1,273 @@
+//go:build linux
+
+package wg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// rateLimitIfbName is the ifb interface traffic received on the rvpn wireguard interface is redirected to, tc can
+// only shape egress traffic so uploads of clients are shaped on the egress of the ifb interface
+const rateLimitIfbName = "rvpn0-ifb"
+
+// peerRateLimit is the bandwidth limit of a peer in server mode
+type peerRateLimit struct {
+	ip           net.IP // tunnel ip of the peer
+	uploadMbps   int    // traffic from the peer, zero means unlimited
+	downloadMbps int    // traffic to the peer, zero means unlimited
+}
+
+// setPeerRateLimits records the bandwidth limits of peers, peers without limits are removed
+func (d *WireguardDaemon) setPeerRateLimits(peers []WireGuardPeer) {
+	if d.rateLimits == nil {
+		d.rateLimits = make(map[string]peerRateLimit)
+	}
+
+	for _, peer := range peers {
+		peerIp := net.ParseIP(peer.AllowedIP).To4()
+		if peerIp == nil || (peer.UploadMbps <= 0 && peer.DownloadMbps <= 0) {
+			delete(d.rateLimits, peer.PublicKey)
+			continue
+		}
+
+		d.rateLimits[peer.PublicKey] = peerRateLimit{
+			ip:           peerIp,
+			uploadMbps:   peer.UploadMbps,
+			downloadMbps: peer.DownloadMbps,
+		}
+	}
+}
+
+// DeleteRateLimits removes the bandwidth limits of deleted peers in server mode
+func (d *WireguardDaemon) DeleteRateLimits(peers []WireGuardPeer) error {
+	for _, peer := range peers {
+		delete(d.rateLimits, peer.PublicKey)
+	}
+
+	return d.applyRateLimits()
+}
+
+// applyRateLimits replaces the tc qdiscs of the rvpn wireguard interface with the recorded bandwidth limits, every
+// limited peer gets its own htb class keyed by its tunnel ip
+func (d *WireguardDaemon) applyRateLimits() error {
+	err := d.clearRateLimits()
+	if err != nil {
+		return err
+	}
+
+	// sort peers by tunnel ip so class ids are stable
+	rateLimits := make([]peerRateLimit, 0, len(d.rateLimits))
+	for _, rateLimit := range d.rateLimits {
+		rateLimits = append(rateLimits, rateLimit)
+	}
+
+	if len(rateLimits) == 0 {
+		return nil
+	}
+
+	sort.Slice(rateLimits, func(i, j int) bool {
+		return binary.BigEndian.Uint32(rateLimits[i].ip) < binary.BigEndian.Uint32(rateLimits[j].ip)
+	})
+
+	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get rvpn wireguard interface link: %w", err)
+	}
+
+	// downloads of clients leave through the rvpn wireguard interface, match on destination ip
+	downloadRates := make(map[string]int)
+	for _, rateLimit := range rateLimits {
+		if rateLimit.downloadMbps > 0 {
+			downloadRates[rateLimit.ip.String()] = rateLimit.downloadMbps
+		}
+	}
+
+	err = shapeLink(interfaceLink, rateLimits, downloadRates, 16)
+	if err != nil {
+		return fmt.Errorf("failed to shape downloads: %w", err)
+	}
+
+	// uploads of clients enter through the rvpn wireguard interface, redirect them to the ifb interface and match
+	// on source ip
+	uploadRates := make(map[string]int)
+	for _, rateLimit := range rateLimits {
+		if rateLimit.uploadMbps > 0 {
+			uploadRates[rateLimit.ip.String()] = rateLimit.uploadMbps
+		}
+	}
+
+	if len(uploadRates) == 0 {
+		return nil
+	}
+
+	err = netlink.LinkAdd(&netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: rateLimitIfbName}})
+	if err != nil {
+		return fmt.Errorf("failed to add ifb interface: %w", err)
+	}
+
+	ifbLink, err := netlink.LinkByName(rateLimitIfbName)
+	if err != nil {
+		return fmt.Errorf("failed to get ifb interface link: %w", err)
+	}
+
+	err = netlink.LinkSetUp(ifbLink)
+	if err != nil {
+		return fmt.Errorf("failed to bring ifb interface up: %w", err)
+	}
+
+	err = shapeLink(ifbLink, rateLimits, uploadRates, 12)
+	if err != nil {
+		return fmt.Errorf("failed to shape uploads: %w", err)
+	}
+
+	err = netlink.QdiscAdd(&netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: interfaceLink.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add ingress qdisc: %w", err)
+	}
+
+	// NOTE: only IPv4 packets are redirected, the limits match IPv4 tunnel ips so other packets are not shaped anyway
+	redirectAction := netlink.NewMirredAction(ifbLink.Attrs().Index)
+	err = netlink.FilterAdd(&netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: interfaceLink.Attrs().Index,
+			Parent:    netlink.MakeHandle(0xffff, 0),
+			Priority:  1,
+			Protocol:  unix.ETH_P_IP,
+		},
+		Actions: []netlink.Action{redirectAction},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to redirect ingress traffic to ifb interface: %w", err)
+	}
+
+	return nil
+}
+
+// shapeLink adds a htb qdisc to the link with a class for every peer with a rate, packets are matched against the
+// peer tunnel ip at the offset of the IPv4 header, unmatched packets are not shaped
+func shapeLink(link netlink.Link, rateLimits []peerRateLimit, ratesByIp map[string]int, ipOffset int32) error {
+	if len(ratesByIp) == 0 {
+		return nil
+	}
+
+	// NOTE: the default class 0 sends unclassified packets without shaping
+	err := netlink.QdiscAdd(netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    netlink.MakeHandle(1, 0),
+		Parent:    netlink.HANDLE_ROOT,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to add htb qdisc: %w", err)
+	}
+
+	classMinor := uint16(0)
+	for _, rateLimit := range rateLimits {
+		rateMbps, exists := ratesByIp[rateLimit.ip.String()]
+		if !exists {
+			continue
+		}
+
+		classMinor++
+		classId := netlink.MakeHandle(1, classMinor)
+		rate := uint64(rateMbps) * 1000 * 1000
+
+		err = netlink.ClassAdd(netlink.NewHtbClass(netlink.ClassAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    classId,
+			Parent:    netlink.MakeHandle(1, 0),
+		}, netlink.HtbClassAttrs{
+			Rate: rate,
+			Ceil: rate,
+		}))
+		if err != nil {
+			return fmt.Errorf("failed to add htb class for %s: %w", rateLimit.ip, err)
+		}
+
+		err = netlink.FilterAdd(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    netlink.MakeHandle(1, 0),
+				Priority:  1,
+				Protocol:  unix.ETH_P_IP, // the key matches the IPv4 header, IPv6 packets must not be matched by it
+			},
+			ClassId: classId,
+			Sel: &netlink.TcU32Sel{
+				Flags: nl.TC_U32_TERMINAL,
+				Keys: []netlink.TcU32Key{{
+					Mask: 0xffffffff,
+					Val:  binary.BigEndian.Uint32(rateLimit.ip),
+					Off:  ipOffset,
+				}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add filter for %s: %w", rateLimit.ip, err)
+		}
+	}
+
+	return nil
+}
+
+// clearRateLimits removes the qdiscs of the rvpn wireguard interface and the ifb interface
+func (d *WireguardDaemon) clearRateLimits() error {
+	ifbLink, err := netlink.LinkByName(rateLimitIfbName)
+	if err == nil {
+		err = netlink.LinkDel(ifbLink)
+		if err != nil {
+			return fmt.Errorf("failed to delete ifb interface: %w", err)
+		}
+	}
+
+	interfaceLink, err := netlink.LinkByName(d.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get rvpn wireguard interface link: %w", err)
+	}
+
+	qdiscs, err := netlink.QdiscList(interfaceLink)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs: %w", err)
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent != netlink.HANDLE_ROOT && qdisc.Attrs().Parent != netlink.HANDLE_INGRESS {
+			continue
+		}
+
+		if qdisc.Type() != "htb" && qdisc.Type() != "ingress" {
+			// the default qdisc of the interface is not managed by rVPN
+			continue
+		}
+
+		err = netlink.QdiscDel(qdisc)
+		if err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("failed to delete %s qdisc: %w", qdisc.Type(), err)
+		}
+	}
+
+	return nil
+}
+
+// resetRateLimits forgets the bandwidth limits of all peers and removes the qdiscs
+func (d *WireguardDaemon) resetRateLimits() {
+	d.rateLimits = nil
+
+	err := d.clearRateLimits()
+	if err != nil {
+		log.Printf("failed to clear rate limits: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+const relayPacketBuffer = 256
+
+// relayPeerAddrBase is the first synthetic address of relayed peers, 240.0.0.0/4 is reserved and never routed
+var relayPeerAddrBase = netip.MustParseAddr("240.0.0.1")
+
+// RelayConn is a WebSocket relay connection to the control plane which carries wireguard packets
+type RelayConn interface {
+	ReadFrame() (string, []byte, error)            // returns the peer id and the wireguard packet
+	WriteFrame(peerId string, packet []byte) error // writes the wireguard packet to the peer id
+}
+
+// relayEndpoint is the endpoint of a peer which is reached through the relay in server mode, each relayed peer
+// is assigned a synthetic address so wireguard can tell peers apart
+type relayEndpoint struct {
+	peerId string
+	addr   netip.AddrPort
+}
+
+func (e *relayEndpoint) ClearSrc()           {}
+func (e *relayEndpoint) SrcToString() string { return "" }
+func (e *relayEndpoint) DstToString() string { return e.addr.String() }
+func (e *relayEndpoint) DstToBytes() []byte  { b, _ := e.addr.MarshalBinary(); return b }
+func (e *relayEndpoint) DstIP() netip.Addr   { return e.addr.Addr() }
+func (e *relayEndpoint) SrcIP() netip.Addr   { return netip.Addr{} }
+
+// relayPacket is a wireguard packet received from the relay
+type relayPacket struct {
+	packet []byte
+	ep     conn.Endpoint
+}
+
+// relayBind wraps a wireguard bind so wireguard packets can be sent over the WebSocket relay of the control
+// plane when UDP is blocked
+type relayBind struct {
+	conn.Bind
+
+	lock       sync.Mutex
+	relay      RelayConn     // active relay connection, nil if packets are not relayed
+	relayDst   string        // endpoint which is reached through the relay in client mode, empty in server mode
+	relayDstEp conn.Endpoint // parsed endpoint which is reached through the relay in client mode
+	peers      map[string]*relayEndpoint
+	peerAddrs  map[netip.Addr]*relayEndpoint
+	nextAddr   netip.Addr
+	packets    chan relayPacket
+	closed     chan struct{} // closed when the bind is closed, nil if the bind is not open
+}
+
+// newRelayBind returns a relayBind wrapping the given bind
+func newRelayBind(bind conn.Bind) *relayBind {
+	return &relayBind{
+		Bind:      bind,
+		peers:     make(map[string]*relayEndpoint),
+		peerAddrs: make(map[netip.Addr]*relayEndpoint),
+		nextAddr:  relayPeerAddrBase,
+		packets:   make(chan relayPacket, relayPacketBuffer),
+	}
+}
+
+// Open opens the wrapped bind and adds a receive func for packets from the relay
+func (b *relayBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	fns, actualPort, err := b.Bind.Open(port)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	closed := make(chan struct{})
+
+	b.lock.Lock()
+	b.closed = closed
+	b.lock.Unlock()
+
+	receiveRelay := func(packet []byte) (int, conn.Endpoint, error) {
+		select {
+		case relayed := <-b.packets:
+			return copy(packet, relayed.packet), relayed.ep, nil
+		case <-closed:
+			return 0, nil, net.ErrClosed
+		}
+	}
+
+	return append(fns, receiveRelay), actualPort, nil
+}
+
+// Close closes the wrapped bind and the receive func for packets from the relay
+func (b *relayBind) Close() error {
+	b.lock.Lock()
+	if b.closed != nil {
+		close(b.closed)
+		b.closed = nil
+	}
+	b.lock.Unlock()
+
+	return b.Bind.Close()
+}
+
+// Send sends a packet through the relay if the endpoint is relayed, otherwise through the wrapped bind
+func (b *relayBind) Send(packet []byte, ep conn.Endpoint) error {
+	b.lock.Lock()
+	relay := b.relay
+	relayDst := b.relayDst
+	b.lock.Unlock()
+
+	if relayEp, ok := ep.(*relayEndpoint); ok {
+		if relay == nil {
+			return errors.New("relay is not connected")
+		}
+
+		return relay.WriteFrame(relayEp.peerId, packet)
+	}
+
+	if relay != nil && relayDst != "" && ep.DstToString() == relayDst {
+		return relay.WriteFrame("", packet)
+	}
+
+	return b.Bind.Send(packet, ep)
+}
+
+// ParseEndpoint parses the synthetic endpoints of relayed peers, other endpoints are parsed by the wrapped bind
+func (b *relayBind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	if addr, err := netip.ParseAddrPort(s); err == nil {
+		b.lock.Lock()
+		relayEp, exists := b.peerAddrs[addr.Addr()]
+		b.lock.Unlock()
+
+		if exists {
+			return relayEp, nil
+		}
+	}
+
+	return b.Bind.ParseEndpoint(s)
+}
+
+// peerEndpoint returns the synthetic endpoint of a relayed peer, must be called with the lock held
+func (b *relayBind) peerEndpoint(peerId string) *relayEndpoint {
+	if relayEp, exists := b.peers[peerId]; exists {
+		return relayEp
+	}
+
+	relayEp := &relayEndpoint{
+		peerId: peerId,
+		addr:   netip.AddrPortFrom(b.nextAddr, 0),
+	}
+	b.peers[peerId] = relayEp
+	b.peerAddrs[relayEp.addr.Addr()] = relayEp
+	b.nextAddr = b.nextAddr.Next()
+
+	return relayEp
+}
+
+// relayPackets sends and receives packets through the relay until the relay fails, in client mode all packets to
+// relayDst are relayed while in server mode packets to relayed peers are relayed
+func (b *relayBind) relayPackets(relay RelayConn, relayDst string) error {
+	var relayDstEp conn.Endpoint
+	if relayDst != "" {
+		var err error
+		relayDstEp, err = b.Bind.ParseEndpoint(relayDst)
+		if err != nil {
+			return fmt.Errorf("failed to parse relayed endpoint: %w", err)
+		}
+
+		// compare against the endpoint as formatted by the wrapped bind
+		relayDst = relayDstEp.DstToString()
+	}
+
+	b.lock.Lock()
+	b.relay = relay
+	b.relayDst = relayDst
+	b.relayDstEp = relayDstEp
+	b.lock.Unlock()
+
+	defer func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		if b.relay == relay {
+			b.relay = nil
+			b.relayDst = ""
+			b.relayDstEp = nil
+		}
+	}()
+
+	for {
+		peerId, packet, err := relay.ReadFrame()
+		if err != nil {
+			return err
+		}
+
+		var ep conn.Endpoint = relayDstEp
+		if relayDstEp == nil {
+			b.lock.Lock()
+			ep = b.peerEndpoint(peerId)
+			b.lock.Unlock()
+		}
+
+		// drop the packet if wireguard is not keeping up, like UDP would
+		select {
+		case b.packets <- relayPacket{packet: append([]byte{}, packet...), ep: ep}:
+		default:
+		}
+	}
+}
+
+// RelayPackets sends and receives wireguard packets through the relay until the relay fails, in client mode
+// serverEndpoint is the endpoint of the rVPN server which is reached through the relay, empty in server mode
+func (d *WireguardDaemon) RelayPackets(relay RelayConn, serverEndpoint string) error {
+	if serverEndpoint != "" {
+		log.Printf("relaying packets to rVPN server %s", serverEndpoint)
+	} else {
+		log.Println("relaying packets to clients")
+	}
+
+	return d.relay.relayPackets(relay, serverEndpoint)
+}
+
+// WaitForServerHandshake waits until there is a handshake with the rVPN server peer in client mode,
+// returns false if there was no handshake before the timeout
+func (d *WireguardDaemon) WaitForServerHandshake(timeout time.Duration) bool {
+	client, err := wgctrl.New()
+	if err != nil {
+		log.Printf("failed to open client: %v", err)
+		return false
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		device, err := client.Device(d.InterfaceName)
+		if err != nil {
+			log.Printf("failed to get wireguard device: %v", err)
+			return false
+		}
+
+		for _, devicePeer := range device.Peers {
+			if devicePeer.PublicKey == d.serverPublicKey && !devicePeer.LastHandshakeTime.IsZero() {
+				return true
+			}
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+
+	return false
+}
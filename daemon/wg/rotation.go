@@ -0,0 +1,206 @@
+package wg
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// UpdatePrivateKey switches the wireguard interface to a rotated private key
+// NOTE: wireguard expires all sessions when the private key changes, peers handshake again with the new key
+func (d *WireguardDaemon) UpdatePrivateKey(privateKey string) error {
+	log.Println("switching wireguard interface to rotated private key")
+
+	pri, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.ConfigureDevice(d.InterfaceName, wgtypes.Config{PrivateKey: &pri}); err != nil {
+		return fmt.Errorf("failed to configure private key: %w", err)
+	}
+
+	return nil
+}
+
+// StageServerPublicKey adds a peer for the rotated server public key next to the rVPN server peer in client mode, the
+// staged peer has the endpoint and preshared key of the server peer but no allowed IPs so traffic keeps using the
+// current server peer while the staged peer handshakes once the server has switched to its rotated key
+func (d *WireguardDaemon) StageServerPublicKey(serverPublicKey string) error {
+	log.Println("staging rVPN server peer for rotated server public key")
+
+	pub, err := wgtypes.ParseKey(serverPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse server public key: %w", err)
+	}
+
+	if pub == d.serverPublicKey || pub == d.stagedServerPublicKey {
+		// server peer already uses or has staged the rotated key
+		return nil
+	}
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(d.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get wireguard device: %w", err)
+	}
+
+	for _, devicePeer := range device.Peers {
+		if devicePeer.PublicKey != d.serverPublicKey {
+			continue
+		}
+
+		psk := devicePeer.PresharedKey
+		ka := devicePeer.PersistentKeepaliveInterval
+		peers := []wgtypes.PeerConfig{}
+		if d.stagedServerPublicKey != (wgtypes.Key{}) {
+			// a previous rotation of the server did not complete
+			peers = append(peers, wgtypes.PeerConfig{
+				PublicKey: d.stagedServerPublicKey,
+				Remove:    true,
+			})
+		}
+
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey:                   pub,
+			PresharedKey:                &psk,
+			Endpoint:                    devicePeer.Endpoint,
+			PersistentKeepaliveInterval: &ka,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  []net.IPNet{},
+		})
+
+		if err := client.ConfigureDevice(d.InterfaceName, wgtypes.Config{Peers: peers}); err != nil {
+			return fmt.Errorf("failed to configure staged server peer: %w", err)
+		}
+
+		d.stagedServerPublicKey = pub
+		return nil
+	}
+
+	return fmt.Errorf("rVPN server peer does not exist")
+}
+
+// UpdateServerPublicKey replaces the rVPN server peer with a peer for the rotated server public key in client mode,
+// the endpoint, preshared key and allowed IPs of the server peer are kept; a staged peer for the key keeps its session
+func (d *WireguardDaemon) UpdateServerPublicKey(serverPublicKey string) error {
+	log.Println("switching rVPN server peer to rotated server public key")
+
+	pub, err := wgtypes.ParseKey(serverPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse server public key: %w", err)
+	}
+
+	if pub == d.serverPublicKey {
+		// server peer already uses the rotated key
+		return nil
+	}
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(d.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get wireguard device: %w", err)
+	}
+
+	for _, devicePeer := range device.Peers {
+		if devicePeer.PublicKey != d.serverPublicKey {
+			continue
+		}
+
+		psk := devicePeer.PresharedKey
+		ka := devicePeer.PersistentKeepaliveInterval
+		peers := []wgtypes.PeerConfig{
+			{
+				PublicKey: d.serverPublicKey,
+				Remove:    true,
+			},
+		}
+
+		if d.stagedServerPublicKey != (wgtypes.Key{}) && d.stagedServerPublicKey != pub {
+			// the server switched to another key than the staged one
+			peers = append(peers, wgtypes.PeerConfig{
+				PublicKey: d.stagedServerPublicKey,
+				Remove:    true,
+			})
+		}
+
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey:                   pub,
+			PresharedKey:                &psk,
+			Endpoint:                    devicePeer.Endpoint,
+			PersistentKeepaliveInterval: &ka,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  devicePeer.AllowedIPs,
+		})
+
+		if err := client.ConfigureDevice(d.InterfaceName, wgtypes.Config{Peers: peers}); err != nil {
+			return fmt.Errorf("failed to configure server peer: %w", err)
+		}
+
+		d.serverPublicKey = pub
+		d.stagedServerPublicKey = wgtypes.Key{}
+		return nil
+	}
+
+	return fmt.Errorf("rVPN server peer does not exist")
+}
+
+// DeletePeers removes peers from the wireguard interface in server mode
+func (d *WireguardDaemon) DeletePeers(toDeletePeers []WireGuardPeer) error {
+	log.Printf("deleting peers from Wireguard Daemon")
+
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	peers := []wgtypes.PeerConfig{}
+	for _, toDeletePeer := range toDeletePeers {
+		parsedPubkey, err := wgtypes.ParseKey(toDeletePeer.PublicKey)
+		if err != nil {
+			// log failure but continue
+			log.Printf("failed to parse peer pubkey: %v", err)
+			continue
+		}
+
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey: parsedPubkey,
+			Remove:    true,
+		})
+	}
+
+	conf := wgtypes.Config{
+		ReplacePeers: false,
+		Peers:        peers,
+	}
+
+	if err := client.ConfigureDevice(d.InterfaceName, conf); err != nil {
+		return fmt.Errorf("failed to delete peers: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package wg
+
+import (
+	"fmt"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+// PeerStats holds traffic statistics of a wireguard peer
+type PeerStats struct {
+	PublicKey     string
+	Endpoint      string    // last endpoint of the peer, empty if unknown
+	LastHandshake time.Time // zero if the peer never completed a handshake
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// PeerStats returns the traffic statistics of all peers of the wireguard interface
+func (d *WireguardDaemon) PeerStats() ([]PeerStats, error) {
+	// create wgctrl client to control wireguard device
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open client: %w", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(d.InterfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+
+	peerStats := []PeerStats{}
+	for _, peer := range device.Peers {
+		endpoint := ""
+		if peer.Endpoint != nil {
+			endpoint = peer.Endpoint.String()
+		}
+
+		peerStats = append(peerStats, PeerStats{
+			PublicKey:     peer.PublicKey.String(),
+			Endpoint:      endpoint,
+			LastHandshake: peer.LastHandshakeTime,
+			ReceiveBytes:  peer.ReceiveBytes,
+			TransmitBytes: peer.TransmitBytes,
+		})
+	}
+
+	return peerStats, nil
+}
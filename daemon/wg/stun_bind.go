@@ -0,0 +1,182 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/redpwn/rvpn/common"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+const (
+	stunRequestAttempts = 3
+	stunRequestTimeout  = 1 * time.Second
+	holePunchPackets    = 5
+	holePunchInterval   = 200 * time.Millisecond
+)
+
+// stunBind wraps a wireguard bind so STUN messages can be exchanged on the wireguard socket, this discovers
+// the public mapping of the wireguard port itself which is required for NAT traversal
+type stunBind struct {
+	conn.Bind
+
+	lock       sync.Mutex
+	port       uint16                                           // local port the bind is listening on, 0 if closed
+	stunWaiter map[common.StunTransactionID]chan netip.AddrPort // pending STUN requests by transaction id
+}
+
+// newStunBind returns a stunBind wrapping the given bind
+func newStunBind(bind conn.Bind) *stunBind {
+	return &stunBind{
+		Bind:       bind,
+		stunWaiter: make(map[common.StunTransactionID]chan netip.AddrPort),
+	}
+}
+
+// Open opens the wrapped bind and filters STUN messages from the packets received by wireguard
+func (b *stunBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	fns, actualPort, err := b.Bind.Open(port)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b.lock.Lock()
+	b.port = actualPort
+	b.lock.Unlock()
+
+	wrappedFns := make([]conn.ReceiveFunc, 0, len(fns))
+	for _, fn := range fns {
+		wrappedFns = append(wrappedFns, b.wrapReceiveFunc(fn))
+	}
+
+	return wrappedFns, actualPort, nil
+}
+
+// Close closes the wrapped bind
+func (b *stunBind) Close() error {
+	b.lock.Lock()
+	b.port = 0
+	b.lock.Unlock()
+
+	return b.Bind.Close()
+}
+
+// wrapReceiveFunc returns a receive func which handles STUN messages and passes all other packets to wireguard
+func (b *stunBind) wrapReceiveFunc(fn conn.ReceiveFunc) conn.ReceiveFunc {
+	return func(packet []byte) (int, conn.Endpoint, error) {
+		for {
+			n, ep, err := fn(packet)
+			if err != nil || !common.IsStunMessage(packet[:n]) {
+				return n, ep, err
+			}
+
+			// NOTE: STUN binding requests are sent by peers to punch holes and are dropped
+			b.handleStunResponse(packet[:n])
+		}
+	}
+}
+
+// handleStunResponse delivers a STUN binding response to the pending request
+func (b *stunBind) handleStunResponse(packet []byte) {
+	txID, mappedAddr, err := common.ParseStunBindingResponse(packet)
+	if err != nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if waiter, exists := b.stunWaiter[txID]; exists {
+		delete(b.stunWaiter, txID)
+		waiter <- mappedAddr
+	}
+}
+
+// localPort returns the local port the bind is listening on, 0 if the bind is closed
+func (b *stunBind) localPort() uint16 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.port
+}
+
+// discoverEndpoint sends STUN binding requests to the STUN server from the wireguard socket and returns the
+// public mapping of the wireguard socket
+func (b *stunBind) discoverEndpoint(stunServer string) (netip.AddrPort, error) {
+	if b.localPort() == 0 {
+		return netip.AddrPort{}, errors.New("wireguard socket is not open")
+	}
+
+	stunServerAddr, err := net.ResolveUDPAddr("udp4", stunServer)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to resolve stun server: %w", err)
+	}
+
+	ep, err := b.ParseEndpoint(stunServerAddr.String())
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to parse stun server endpoint: %w", err)
+	}
+
+	for attempt := 0; attempt < stunRequestAttempts; attempt++ {
+		txID, err := common.NewStunTransactionID()
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("failed to create stun transaction id: %w", err)
+		}
+
+		waiter := make(chan netip.AddrPort, 1)
+		b.lock.Lock()
+		b.stunWaiter[txID] = waiter
+		b.lock.Unlock()
+
+		err = b.Send(common.MarshalStunBindingRequest(txID), ep)
+		if err != nil {
+			log.Printf("failed to send stun binding request: %v", err)
+		}
+
+		select {
+		case mappedAddr := <-waiter:
+			return mappedAddr, nil
+		case <-time.After(stunRequestTimeout):
+			b.lock.Lock()
+			delete(b.stunWaiter, txID)
+			b.lock.Unlock()
+		}
+	}
+
+	return netip.AddrPort{}, errors.New("stun server did not respond")
+}
+
+// punchHole sends packets to a peer endpoint from the wireguard socket so that NAT in front of this device
+// accepts the handshake of the peer
+func (b *stunBind) punchHole(endpoint string) error {
+	if b.localPort() == 0 {
+		return errors.New("wireguard socket is not open")
+	}
+
+	ep, err := b.ParseEndpoint(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to parse peer endpoint: %w", err)
+	}
+
+	// STUN binding requests are dropped by the peer so they do not interfere with wireguard
+	for i := 0; i < holePunchPackets; i++ {
+		txID, err := common.NewStunTransactionID()
+		if err != nil {
+			return fmt.Errorf("failed to create stun transaction id: %w", err)
+		}
+
+		err = b.Send(common.MarshalStunBindingRequest(txID), ep)
+		if err != nil {
+			return fmt.Errorf("failed to send hole punching packet: %w", err)
+		}
+
+		time.Sleep(holePunchInterval)
+	}
+
+	return nil
+}
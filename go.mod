@@ -3,12 +3,15 @@ module github.com/redpwn/rvpn
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/caarlos0/env/v6 v6.9.3
 	github.com/coreos/go-iptables v0.6.0
 	github.com/denisbrodbeck/machineid v1.0.1
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gofiber/fiber/v2 v2.35.0
 	github.com/gofiber/websocket/v2 v2.0.23
 	github.com/golang-jwt/jwt/v4 v4.4.2
+	github.com/google/nftables v0.0.0-20220808154552-2eca00135732
 	github.com/google/uuid v1.3.0
 	github.com/kirsle/configdir v0.0.0-20170128060238-e45d2f54772f
 	github.com/lib/pq v1.10.6
@@ -17,6 +20,7 @@ require (
 	github.com/vishvananda/netlink v1.1.0
 	github.com/wailsapp/wails/v2 v2.3.1
 	go.uber.org/zap v1.21.0
+	golang.org/x/net v0.5.0
 	golang.org/x/sys v0.4.0
 	golang.zx2c4.com/wireguard v0.0.0-20220407013110-ef5c587f782d
 	golang.zx2c4.com/wireguard/wgctrl v0.0.0-20220916014741-473347a5e6e3
@@ -60,7 +64,6 @@ require (
 	go.uber.org/multierr v1.8.0 // indirect
 	golang.org/x/crypto v0.5.0 // indirect
 	golang.org/x/exp v0.0.0-20230108222341-4b8118a2686a // indirect
-	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/text v0.6.0 // indirect
 	golang.zx2c4.com/wintun v0.0.0-20211104114900-415007cec224 // indirect
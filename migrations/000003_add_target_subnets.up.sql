@@ -0,0 +1,3 @@
+-- subnets are the routable subnets a target advertises to connecting clients (comma separated)
+
+ALTER TABLE targets ADD COLUMN subnets VARCHAR NOT NULL DEFAULT '0.0.0.0/0';
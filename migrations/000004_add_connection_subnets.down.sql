@@ -0,0 +1,2 @@
+ALTER TABLE connections DROP COLUMN approved_subnets;
+ALTER TABLE connections DROP COLUMN advertised_subnets;
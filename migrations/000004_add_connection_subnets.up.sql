@@ -0,0 +1,4 @@
+-- subnets a connection advertises to route for other peers and the subset approved by a target admin (comma separated)
+
+ALTER TABLE connections ADD COLUMN advertised_subnets VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE connections ADD COLUMN approved_subnets VARCHAR NOT NULL DEFAULT '';
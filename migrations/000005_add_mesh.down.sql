@@ -0,0 +1,2 @@
+ALTER TABLE connections DROP COLUMN endpoint;
+ALTER TABLE targets DROP COLUMN mesh;
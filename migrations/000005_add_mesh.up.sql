@@ -0,0 +1,4 @@
+-- whether clients of a target connect directly to each other and the observed public endpoint of a connection
+
+ALTER TABLE targets ADD COLUMN mesh BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE connections ADD COLUMN endpoint VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- preshared key of a connection which hardens the wireguard handshake
+
+ALTER TABLE connections ADD COLUMN preshared_key VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1,14 @@
+-- firewall policies of a target which restrict what clients may reach through the serving node, a target without
+-- policies allows all traffic (lists are comma separated, empty means any)
+
+CREATE TABLE target_policies (
+    id VARCHAR NOT NULL,
+    target VARCHAR NOT NULL,
+    source_users VARCHAR NOT NULL DEFAULT '',
+    source_devices VARCHAR NOT NULL DEFAULT '',
+    destination_subnets VARCHAR NOT NULL DEFAULT '',
+    destination_peers VARCHAR NOT NULL DEFAULT '',
+    protocol VARCHAR NOT NULL DEFAULT '',
+    ports VARCHAR NOT NULL DEFAULT '',
+    PRIMARY KEY (target, id)
+);
@@ -0,0 +1,12 @@
+-- bandwidth limits of a target which the serving node enforces on each matching client, limits of devices take
+-- precedence over limits of users (lists are comma separated, a zero rate means unlimited)
+
+CREATE TABLE target_rate_limits (
+    id VARCHAR NOT NULL,
+    target VARCHAR NOT NULL,
+    users VARCHAR NOT NULL DEFAULT '',
+    devices VARCHAR NOT NULL DEFAULT '',
+    upload_mbps INTEGER NOT NULL DEFAULT 0,
+    download_mbps INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (target, id)
+);
@@ -0,0 +1,14 @@
+-- traffic statistics of wireguard peers reported with device heartbeats, the serving node of a target reports as
+-- "server" and clients report as their connection id
+
+CREATE TABLE peer_stats (
+    target VARCHAR NOT NULL,
+    reporter VARCHAR NOT NULL,
+    pubkey VARCHAR NOT NULL,
+    endpoint VARCHAR NOT NULL DEFAULT '',
+    last_handshake BIGINT NOT NULL DEFAULT 0,
+    receive_bytes BIGINT NOT NULL DEFAULT 0,
+    transmit_bytes BIGINT NOT NULL DEFAULT 0,
+    reported_at BIGINT NOT NULL,
+    PRIMARY KEY (target, reporter, pubkey)
+);
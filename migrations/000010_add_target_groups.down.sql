@@ -0,0 +1,3 @@
+ALTER TABLE target_rate_limits DROP COLUMN groups;
+
+DROP TABLE target_groups;
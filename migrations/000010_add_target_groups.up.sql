@@ -0,0 +1,13 @@
+-- groups of a target which bandwidth limits apply to, a group has users whose devices are members and member devices
+-- (lists are comma separated); limits of devices take precedence over limits of groups, which take precedence over
+-- limits of users
+
+CREATE TABLE target_groups (
+    id VARCHAR NOT NULL,
+    target VARCHAR NOT NULL,
+    users VARCHAR NOT NULL DEFAULT '',
+    devices VARCHAR NOT NULL DEFAULT '',
+    PRIMARY KEY (target, id)
+);
+
+ALTER TABLE target_rate_limits ADD COLUMN groups VARCHAR NOT NULL DEFAULT '';
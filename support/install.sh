@@ -54,6 +54,12 @@ main() {
         done
     fi
 
+    # members of the rvpn group may control the daemon besides root
+    if ! getent group rvpn >/dev/null; then
+        $sudo groupadd --system rvpn
+    fi
+    echo "Add users which may control rVPN to the rvpn group (usermod -aG rvpn <user>)"
+
     # install rvpn and rvpn service
     $sudo install -Dm 644 -t /usr/local/lib/systemd/system/ rvpn_linux_$arch/systemd/systemd/rvpn.service
     $sudo install -m 755 -t /usr/local/bin/ rvpn_linux_$arch/bin/rvpn